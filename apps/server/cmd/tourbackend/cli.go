@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	db "tourbackend/internal/database"
+	"tourbackend/internal/users"
+	"tourbackend/internal/utils"
+)
+
+//* command line subcommands for managing the app without the running server, e.g.:
+//  tourbackend user create --email a@b.cz --password secret --admin
+//  tourbackend user set-password --email a@b.cz  (reads the password from stdin)
+
+const USAGE = `usage:
+  tourbackend                                   run the server
+  tourbackend user create --email EMAIL [--password PASSWORD] [--first-name NAME] [--last-name NAME] [--admin]
+  tourbackend user set-password --email EMAIL [--password PASSWORD]
+
+when --password is not given it is read from stdin`
+
+func runCommand(args []string) error {
+	if len(args) < 2 || args[0] != "user" {
+		return errors.New(USAGE)
+	}
+
+	// never reset the db from the cli, that would wipe the data of the running server
+	sqlDb, queries := db.Initialize(false)
+	defer sqlDb.Close()
+
+	us := users.NewService(queries)
+
+	switch args[1] {
+	case "create":
+		return userCreateCommand(us, args[2:])
+	case "set-password":
+		return userSetPasswordCommand(us, args[2:])
+	}
+	return errors.New(USAGE)
+}
+
+func userCreateCommand(us *users.Service, args []string) error {
+	flags := flag.NewFlagSet("user create", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the new user, used to log in")
+	password := flags.String("password", "", "password of the new user, read from stdin when empty")
+	firstName := flags.String("first-name", "", "first name of the new user")
+	lastName := flags.String("last-name", "", "last name of the new user")
+	isAdmin := flags.Bool("admin", false, "make the new user an admin")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	*email = utils.NormalizeEmail(*email)
+	if err := utils.ValidateEmail(*email); err != nil {
+		return err
+	}
+
+	pwd, err := passwordFromFlagOrStdin(*password)
+	if err != nil {
+		return err
+	}
+
+	err = utils.ValidatePassword(pwd, *email, *firstName, *lastName)
+	if err != nil {
+		return err
+	}
+
+	user, err := us.CreateUser(*firstName, *lastName, *email, pwd, *isAdmin, context.Background())
+	if err != nil {
+		return err
+	}
+
+	if user.IsAdmin {
+		fmt.Println("created admin", user.Email, "with id", user.ID)
+	} else {
+		fmt.Println("created user", user.Email, "with id", user.ID)
+	}
+	return nil
+}
+
+func userSetPasswordCommand(us *users.Service, args []string) error {
+	flags := flag.NewFlagSet("user set-password", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user")
+	password := flags.String("password", "", "the new password, read from stdin when empty")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+
+	ctx := context.Background()
+
+	user, err := us.GetUserByEmail(*email, ctx)
+	if err != nil {
+		if err == users.ErrUserNotFound {
+			return fmt.Errorf("no user with email %v", *email)
+		}
+		return err
+	}
+
+	pwd, err := passwordFromFlagOrStdin(*password)
+	if err != nil {
+		return err
+	}
+
+	err = utils.ValidatePassword(pwd, user.Email, user.FirstName, user.LastName)
+	if err != nil {
+		return err
+	}
+
+	err = us.SetPassword(int64(user.ID), pwd, false, ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("password of", user.Email, "changed, all of their sessions were ended")
+	return nil
+}
+
+// passwords passed as flags end up in the shell history, so reading them from stdin is the default
+func passwordFromFlagOrStdin(password string) (string, error) {
+	if password != "" {
+		return password, nil
+	}
+
+	fmt.Fprint(os.Stderr, "password: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given")
+	}
+
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("password can't be empty")
+	}
+	return password, nil
+}
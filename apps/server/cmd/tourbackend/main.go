@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
@@ -15,7 +16,13 @@ import (
 	"tourbackend/internal/courses/quizzes"
 	db "tourbackend/internal/database"
 	"tourbackend/internal/feeds"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/mail"
 	"tourbackend/internal/middlewares"
+	"tourbackend/internal/previews"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/users"
+	"tourbackend/internal/webhooks"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -38,6 +45,15 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// subcommands like `tourbackend user create` run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// try to read the port number from env, if fails default to 3000
 	PORT_STRING := os.Getenv("PORT")
 	_, err := strconv.Atoi(PORT_STRING)
@@ -55,6 +71,16 @@ func main() {
 	RESET_DB = strings.ToLower(os.Getenv("RESET_DB")) == "true"
 	SEED := strings.ToLower(os.Getenv("SEED")) == "true"
 
+	// base url of the links in emails - taken only from the config, never from the Host header of a request
+	APP_URL := os.Getenv("APP_URL")
+	if APP_URL == "" {
+		if IS_DEPLOYED {
+			log.Fatal("refusing to start without APP_URL while IS_DEPLOYED=true, it is the base of the links in emails")
+		}
+		// the local caddy in front of the web and the server
+		APP_URL = "http://localhost"
+	}
+
 	db, queries := db.Initialize(RESET_DB)
 	defer db.Close()
 	fmt.Println("initialized db")
@@ -80,22 +106,131 @@ func main() {
 	e.GET("/me", authHandler.Profile)
 	e.POST("/logout", authHandler.Logout)
 
+	//* Own profile
+	profileHandler := auth.NewProfileHandler(queries, IS_DEPLOYED, STATIC_PATH, mail.MailerFromEnv(), APP_URL)
+
+	me := e.Group("/me", auth.LoginRequired())
+	me.PATCH("", profileHandler.UpdateProfile)
+	me.POST("/password", profileHandler.ChangePassword)
+	me.PUT("/avatar", profileHandler.UploadAvatar)
+	me.DELETE("/avatar", profileHandler.DeleteAvatar)
+	me.GET("/feed-token", profileHandler.GetFeedToken)
+	me.POST("/feed-token", profileHandler.ResetFeedToken)
+
+	e.GET("/me/email/verify", profileHandler.VerifyEmailChange)
+
+	// login through the school identity provider, enabled only when OIDC_ISSUER_URL is set
+	oidcConfig := auth.OIDCConfigFromEnv()
+	e.GET("/auth/oidc", auth.OIDCStatus(oidcConfig))
+
+	if oidcConfig != nil {
+		oidcHandler := auth.NewOIDCHandler(queries, IS_DEPLOYED, oidcConfig)
+
+		e.GET("/auth/oidc/login", oidcHandler.Login)
+		e.GET("/auth/oidc/callback", oidcHandler.Callback)
+	}
+
+	//* User administration
+	usersService := users.NewService(queries)
+	usersHandler := users.NewHandler(usersService, queries, IS_DEPLOYED)
+
+	users := e.Group("/users", auth.AdminRequired())
+
+	users.GET("", usersHandler.ListUsers)
+	users.POST("", usersHandler.CreateUser)
+	users.GET("/:userId", usersHandler.GetUser)
+	users.PUT("/:userId", usersHandler.UpdateUser)
+	users.DELETE("/:userId", usersHandler.DeleteUser)
+
+	users.PUT("/:userId/admin", usersHandler.SetAdmin)
+	users.POST("/:userId/reset-password", usersHandler.ForcePasswordReset)
+	users.POST("/:userId/deactivate", usersHandler.DeactivateUser)
+	users.POST("/:userId/activate", usersHandler.ReactivateUser)
+
+	//* Uploaded files of courses, in the static folder or with STORAGE=s3 in a bucket
+	storage, err := uploads.StorageFromEnv(STATIC_PATH)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	//* Course Feeds
-	feedsService := feeds.NewService(queries, "./static")
+	// with FEED_BROKER=sqlite several instances of the server sharing the db file see each other's feed events
+	var feedsBroker feeds.Broker = feeds.NewMemoryBroker()
+	if strings.ToLower(os.Getenv("FEED_BROKER")) == "sqlite" {
+		feedsBroker = feeds.NewSQLiteBroker(queries)
+	}
+	defer feedsBroker.Close()
+
+	feedsService, err := feeds.NewService(queries, storage, feedsBroker)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// publishes the scheduled posts and hides the expired ones
+	feedsPublisher := feeds.NewPublisher(feedsService)
+	feedsPublisher.Start()
+	defer feedsPublisher.Close()
+
 	feedsHandler := feeds.NewHandler(STATIC_PATH, feedsService, queries, IS_DEPLOYED)
 
 	e.GET("/courses/:courseId/feed", feedsHandler.GetCourseFeed)
 	e.POST("/courses/:courseId/feed", feedsHandler.CreateFeedPost, auth.AdminRequired())
 	e.PUT("/courses/:courseId/feed/:postId", feedsHandler.UpdateFeedPost, auth.AdminRequired())
 	e.DELETE("/courses/:courseId/feed/:postId", feedsHandler.DeleteFeedPost, auth.AdminRequired())
+	e.POST("/courses/:courseId/feed/:postId/attachments", feedsHandler.AddAttachment, auth.AdminRequired())
+	e.DELETE("/courses/:courseId/feed/:postId/attachments/:attachmentId", feedsHandler.DeleteAttachment, auth.AdminRequired())
+	e.GET("/courses/:courseId/feed/:postId/attachments/:attachmentId/file", feedsHandler.DownloadAttachment)
+	e.HEAD("/courses/:courseId/feed/:postId/attachments/:attachmentId/file", feedsHandler.DownloadAttachment)
+
+	// comments and reactions of the users, lecturers moderate them
+	e.GET("/courses/:courseId/feed/:postId/comments", feedsHandler.ListComments)
+	e.POST("/courses/:courseId/feed/:postId/comments", feedsHandler.CreateComment, auth.LoginRequired())
+	e.PATCH("/courses/:courseId/feed/:postId/comments/:commentId", feedsHandler.ModerateComment, auth.AdminRequired())
+	e.DELETE("/courses/:courseId/feed/:postId/comments/:commentId", feedsHandler.DeleteComment, auth.LoginRequired())
+	e.PUT("/courses/:courseId/feed/:postId/reactions", feedsHandler.AddReaction, auth.LoginRequired())
+	e.DELETE("/courses/:courseId/feed/:postId/reactions", feedsHandler.RemoveReaction, auth.LoginRequired())
 
 	e.GET("/courses/:courseId/feed/stream", feedsHandler.StreamFeed)
 
+	// for feed readers, courses that are not open need the ?token= of a lecturer
+	e.GET("/courses/:courseId/feed.atom", feedsHandler.AtomFeed, auth.FeedTokenAuth(queries))
+	e.GET("/courses/:courseId/feed.rss", feedsHandler.RSSFeed, auth.FeedTokenAuth(queries))
+
+	// one websocket for the feeds, quiz activity and presence of several courses
+	e.GET("/ws", feedsHandler.WebSocket, auth.LoginRequired())
+
+	//* Webhooks - the feed events of a course sent to urls chosen by the lecturers,
+	// WEBHOOK_ALLOW_PRIVATE lets the deliveries reach local servers for testing
+	if allow, err := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE")); err == nil {
+		webhooks.ALLOW_PRIVATE_ADDRESSES = allow
+	}
+	webhooksService := webhooks.NewService(queries)
+	feedsService.AddEventHook(webhooksService.Enqueue)
+
+	webhooksDispatcher := webhooks.NewDispatcher(webhooksService)
+	webhooksDispatcher.Start()
+	defer webhooksDispatcher.Close()
+
+	webhooksHandler := webhooks.NewHandler(webhooksService, queries, IS_DEPLOYED)
+
+	hooks := e.Group("/courses/:courseId/webhooks", auth.AdminRequired())
+	hooks.GET("", webhooksHandler.ListWebhooks)
+	hooks.POST("", webhooksHandler.CreateWebhook)
+	hooks.PUT("/:webhookId", webhooksHandler.UpdateWebhook)
+	hooks.DELETE("/:webhookId", webhooksHandler.DeleteWebhook)
+	hooks.POST("/:webhookId/secret", webhooksHandler.RotateSecret)
+	hooks.GET("/:webhookId/deliveries", webhooksHandler.ListDeliveries)
+	hooks.POST("/:webhookId/test", webhooksHandler.SendTestEvent)
+
 	//* Courses and it's deps (materials and quizzes - TODO)
-	matsService := materials.NewService(queries, STATIC_PATH, feedsService)
+	matsService := materials.NewService(db, queries, storage, feedsService)
 	quizzesService := quizzes.NewService(queries, STATIC_PATH, feedsService)
 
-	courseService := courses.NewService(queries, matsService, quizzesService, feedsService)
+	courseService := courses.NewService(queries, storage, matsService, quizzesService, feedsService)
+
+	// the timers of the planned state changes (openTime) are only in memory, they are set again from the db
+	if err := courseService.RestoreSchedules(context.Background()); err != nil {
+		log.Fatal("failed to restore the course schedules: ", err)
+	}
 
 	coursesHandler := courses.NewCourseHandler(queries, IS_DEPLOYED, courseService)
 
@@ -109,6 +244,20 @@ func main() {
 
 	e.PUT("/courses/:courseId/state", coursesHandler.ChangeCourseState, auth.AdminRequired())
 
+	// space taken by the files of the course, STORAGE_QUOTA bytes for courses without their own quota (0 is unlimited)
+	if quota, err := strconv.ParseInt(os.Getenv("STORAGE_QUOTA"), 10, 64); err == nil {
+		uploads.STORAGE_QUOTA = quota
+	}
+	e.GET("/courses/:courseId/storage", coursesHandler.GetStorageUsage, auth.AdminRequired())
+	e.PUT("/courses/:courseId/storage", coursesHandler.SetStorageQuota, auth.AdminRequired())
+
+	// files in the storage without a row and rows without a file
+	storageReconciler := courses.NewStorageReconciler(courseService)
+	storageReconciler.Start()
+	defer storageReconciler.Close()
+
+	e.POST("/storage/reconcile", coursesHandler.ReconcileStorage, auth.AdminRequired())
+
 	// modules
 	e.POST("/courses/:courseId/modules", coursesHandler.CreateModule, auth.AdminRequired())
 	e.PUT("/courses/:courseId/modules/:moduleId", coursesHandler.UpdateModule, auth.AdminRequired())
@@ -119,9 +268,73 @@ func main() {
 
 	e.PUT("/courses/:courseId/modules/:moduleId/state", coursesHandler.ChangeModuleState, auth.AdminRequired())
 
+	// planned openings and quiz deadlines for calendar apps
+	e.GET("/courses/:courseId/calendar.ics", coursesHandler.GetCourseCalendar, auth.FeedTokenAuth(queries))
+
 	//* Course materials
 	materialsHandler := materials.NewHandler(STATIC_PATH, matsService, queries, IS_DEPLOYED)
 
+	// a new file of a material is a new version, the older ones are kept for a rollback
+	if kept, err := strconv.Atoi(os.Getenv("MATERIAL_VERSIONS_KEPT")); err == nil {
+		materials.MATERIAL_VERSIONS_KEPT = kept
+	}
+
+	// resumable uploads of big material files (tus), the material is created with the uploadId of the finished upload
+	if path := os.Getenv("PARTIAL_UPLOADS_PATH"); path != "" {
+		materials.PARTIAL_UPLOADS_PATH = path
+	}
+	uploadCleaner := materials.NewUploadCleaner(matsService)
+	uploadCleaner.Start()
+	defer uploadCleaner.Close()
+
+	resumable := e.Group("/courses/:courseId/uploads", auth.AdminRequired(), materials.TusResumable())
+	resumable.OPTIONS("", materialsHandler.UploadOptions)
+	resumable.POST("", materialsHandler.CreateUpload)
+	resumable.HEAD("/:uploadId", materialsHandler.UploadStatus)
+	resumable.PATCH("/:uploadId", materialsHandler.UploadChunk)
+	resumable.DELETE("/:uploadId", materialsHandler.CancelUpload)
+
+	e.GET("/courses/:courseId/upload-limit", materialsHandler.GetUploadLimit, auth.AdminRequired())
+	e.PUT("/courses/:courseId/upload-limit", materialsHandler.SetUploadLimit, auth.AdminRequired())
+
+	// previews of the files, made when a file is stored
+	if size, err := strconv.Atoi(os.Getenv("THUMBNAIL_SIZE")); err == nil && size > 0 {
+		previews.THUMBNAIL_SIZE = size
+	}
+
+	// previews of the pages of url materials, fetched in the background and refreshed after LINK_PREVIEW_MAX_AGE,
+	// LINK_PREVIEW_ALLOW_PRIVATE lets the fetcher reach local servers for testing
+	if allow, err := strconv.ParseBool(os.Getenv("LINK_PREVIEW_ALLOW_PRIVATE")); err == nil {
+		linkpreview.ALLOW_PRIVATE_ADDRESSES = allow
+	}
+	linkRefresher := materials.NewLinkRefresher(matsService)
+	linkRefresher.Start()
+	defer linkRefresher.Close()
+
+	// the files of materials, only for users who can see the material
+	e.GET("/courses/:courseId/materials/:materialId/file", materialsHandler.DownloadMaterialFile)
+	e.HEAD("/courses/:courseId/materials/:materialId/file", materialsHandler.DownloadMaterialFile)
+	e.GET("/courses/:courseId/materials/:materialId/thumbnail", materialsHandler.GetMaterialThumbnail)
+	e.HEAD("/courses/:courseId/materials/:materialId/thumbnail", materialsHandler.GetMaterialThumbnail)
+
+	// many file materials at once from a zip archive with an optional manifest.json, all of them or none
+	if size, err := strconv.ParseInt(os.Getenv("IMPORT_MAX_SIZE"), 10, 64); err == nil && size > 0 {
+		materials.IMPORT_MAX_SIZE = size
+	}
+	if size, err := strconv.ParseInt(os.Getenv("IMPORT_MAX_EXTRACTED_SIZE"), 10, 64); err == nil && size > 0 {
+		materials.IMPORT_MAX_EXTRACTED_SIZE = size
+	}
+	e.POST("/courses/:courseId/materials/import", materialsHandler.ImportMaterials, auth.AdminRequired())
+
+	// watch progress of video materials, every user has their own
+	e.GET("/courses/:courseId/materials/:materialId/progress", materialsHandler.GetVideoProgress, auth.LoginRequired())
+	e.PUT("/courses/:courseId/materials/:materialId/progress", materialsHandler.SaveVideoProgress, auth.LoginRequired())
+	e.GET("/courses/:courseId/video-progress", materialsHandler.ListVideoProgress, auth.LoginRequired())
+
+	// avatars are static files, the files of materials and post attachments are not
+	static := e.Group("/static", materials.HideStaticFiles())
+	static.Static("/", STATIC_PATH)
+
 	materials := e.Group("/courses/:courseId/modules/:moduleId/materials")
 
 	materials.GET("", materialsHandler.ListMaterials)
@@ -131,6 +344,15 @@ func main() {
 	materials.DELETE("/:materialId", materialsHandler.DeleteMaterial, auth.AdminRequired())
 
 	materials.POST("/:materialId/increment", materialsHandler.IncrementMaterialAccessedCounter)
+
+	// older files of the material
+	materials.GET("/:materialId/versions", materialsHandler.ListMaterialVersions, auth.AdminRequired())
+	materials.POST("/:materialId/versions/:version/restore", materialsHandler.RestoreMaterialVersion, auth.AdminRequired())
+	materials.DELETE("/:materialId/versions/:version", materialsHandler.DeleteMaterialVersion, auth.AdminRequired())
+
+	// fetches the page of a url material again now
+	materials.POST("/:materialId/link-preview", materialsHandler.RefreshLinkPreview, auth.AdminRequired())
+
 	materials.POST("/:materialId/:order", materialsHandler.ChangeMaterialInModuleOrder, auth.AdminRequired())
 
 	//* Course Quizes
@@ -150,8 +372,11 @@ func main() {
 
 	quizzes.POST("/:quizId/modules/:moduleId/:order", quizzesHandler.ChangeQuizInModuleOrder, auth.AdminRequired())
 
-	//* Static
-	e.Static("/static", STATIC_PATH)
+	// Make sure there is an admin account (the lecturer described in the 1. phase when running locally)
+	err = bootstrapAdmin(usersService, IS_DEPLOYED)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Seed the db with 3 courses
 	if SEED {
@@ -162,12 +387,6 @@ func main() {
 		fmt.Println("seeded")
 	}
 
-	// Create the admin account as described in the 1. phase
-	err = createAdmin(queries)
-	if err != nil {
-		panic(err)
-	}
-
 	fmt.Println("ready!")
 
 	e.Logger.Fatal(e.Start(":" + PORT_STRING))
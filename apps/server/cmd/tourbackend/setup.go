@@ -1,13 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 	"tourbackend/internal/courses"
 	"tourbackend/internal/courses/materials"
 	"tourbackend/internal/courses/quizzes"
 	"tourbackend/internal/feeds"
+	"tourbackend/internal/users"
 	"tourbackend/internal/utils"
 
 	db "tourbackend/internal/database/gen"
@@ -15,29 +19,90 @@ import (
 	"github.com/google/uuid"
 )
 
-func createAdmin(q *db.Queries) error {
+// credentials of the admin account described in the 1. phase, only allowed when running locally
+const DEFAULT_ADMIN_EMAIL = "lecturer"
+const DEFAULT_ADMIN_PASSWORD = "TdA26!"
+
+// makes sure there is at least one admin on the first run,
+// the admin is taken from ADMIN_EMAIL and ADMIN_PASSWORD (optionally ADMIN_FIRST_NAME, ADMIN_LAST_NAME),
+// when those are not set the default lecturer account is created, which is refused when the app is deployed
+func bootstrapAdmin(us *users.Service, isDeployed bool) error {
 	ctx := context.Background()
 
-	hash, err := utils.HashPassword("TdA26!")
+	email := os.Getenv("ADMIN_EMAIL")
+	password := os.Getenv("ADMIN_PASSWORD")
+
+	if email == "" && password == "" {
+		email = DEFAULT_ADMIN_EMAIL
+		password = DEFAULT_ADMIN_PASSWORD
+	}
+
+	if email == "" || password == "" {
+		return errors.New("ADMIN_EMAIL and ADMIN_PASSWORD must be set together")
+	}
+
+	if isDeployed {
+		if email == DEFAULT_ADMIN_EMAIL || password == DEFAULT_ADMIN_PASSWORD {
+			return errors.New("refusing to start with the default admin credentials while IS_DEPLOYED=true, set ADMIN_EMAIL and ADMIN_PASSWORD")
+		}
+
+		// an admin with the default credentials might also be left over in the db from a local run
+		defaultAdmin, err := us.GetUserByEmail(DEFAULT_ADMIN_EMAIL, ctx)
+		if err != nil && err != users.ErrUserNotFound {
+			return err
+		}
+		if err == nil && defaultAdmin.IsAdmin && us.CheckPassword(int64(defaultAdmin.ID), DEFAULT_ADMIN_PASSWORD, ctx) {
+			return errors.New("refusing to start, the db contains an admin with the default credentials while IS_DEPLOYED=true")
+		}
+
+		err = utils.ValidatePassword(password, email)
+		if err != nil {
+			return fmt.Errorf("ADMIN_PASSWORD doesn't meet the password policy: %w", err)
+		}
+	}
+
+	hasAdmin, err := us.HasAdmin(ctx)
 	if err != nil {
 		return err
 	}
 
-	user, err := q.CreateUser(ctx, db.CreateUserParams{
-		FirstName: "lecturer",
-		LastName:  "lecturer",
-		Email:     "lecturer",
-		Hash:      hash,
-	})
-	if err != nil {
+	if hasAdmin {
 		return nil
 	}
 
-	err = q.MakeUserAdmin(ctx, user.ID)
+	// the user might already exist, for example after registering through the app,
+	// registering doesn't verify the email, so the account only becomes admin with ADMIN_PASSWORD -
+	// a different password is replaced and has to be changed after the first login, which also ends its sessions
+	existing, err := us.GetUserByEmail(email, ctx)
+	if err == nil {
+		if !us.CheckPassword(int64(existing.ID), password, ctx) {
+			err = us.SetPassword(int64(existing.ID), password, true, ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Println("replaced the password of existing user", email, "with ADMIN_PASSWORD, it has to be changed after login")
+		}
+
+		_, err = us.SetAdmin(int64(existing.ID), true, ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("made existing user", email, "an admin")
+		return nil
+	}
+	if err != users.ErrUserNotFound {
+		return err
+	}
+
+	firstName := cmp.Or(os.Getenv("ADMIN_FIRST_NAME"), "lecturer")
+	lastName := cmp.Or(os.Getenv("ADMIN_LAST_NAME"), "lecturer")
+
+	_, err = us.CreateUser(firstName, lastName, email, password, true, ctx)
 	if err != nil {
-		fmt.Println("failed to make user an admin")
+		return err
 	}
 
+	fmt.Println("created admin", email)
 	return nil
 }
 
@@ -85,15 +150,15 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 
 	time.Sleep(time.Second)
 
-	m1, err := ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	m1, err := ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course1.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=FtES7Gd5gHE",
 		Name:        "Pottery intruduction video",
 		Description: "a short video to introduce students into the topic of pottery",
 	}, uuid.NewString(), ctx)
 	if err != nil {
-		fmt.Println("create course 1 url material 1 failed")
+		fmt.Println("create course 1 video material 1 failed")
 		return err
 	}
 
@@ -107,15 +172,15 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 
 	time.Sleep(time.Second)
 
-	m2, err := ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	m2, err := ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course1.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=2taUjbCb3N8",
 		Name:        "History of pottery",
 		Description: "a longer video  going through the history of pottery",
 	}, uuid.NewString(), ctx)
 	if err != nil {
-		fmt.Println("create course 1 url material 2 failed")
+		fmt.Println("create course 1 video material 2 failed")
 		return err
 	}
 
@@ -159,7 +224,7 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 
 	time.Sleep(time.Second)
 
-	_, err = fs.CreateManualPost(ctx, course1.Uuid, "Pottery Course Has Been Published!")
+	_, err = fs.CreateManualPost(ctx, course1.Uuid, feeds.NewPost{Message: "Pottery Course Has Been Published!"})
 	if err != nil {
 		fmt.Println("create course 1 manual post failed")
 		return err
@@ -183,6 +248,12 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 	time.Sleep(time.Second)
 
 	return nil
+}
+
+// courses 2 and 3 are currently not seeded, kept here for when we want a fuller catalog
+func seedMoreCourses(cs *courses.Service, ms *materials.Service, ctx context.Context) error {
+
+	now := time.Now().Unix()
 
 	//* Course 2
 
@@ -197,22 +268,22 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 		return err
 	}
 
-	module, err = cs.CreateModule(course2.Uuid, uuid.NewString(), "Module 1", "light introduction to the course", ctx)
+	_, err = cs.CreateModule(course2.Uuid, uuid.NewString(), "Module 1", "light introduction to the course", ctx)
 	if err != nil {
 		fmt.Println("create course 2 module failed")
 	}
 
-	_, err = ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	_, err = ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course2.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=ND-h-Qxym1M",
 		Name:        "Potions basics",
 		Description: "a short video to introduce students into the topic of pottery",
 	}, uuid.NewString(), ctx)
 
-	_, err = ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	_, err = ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course2.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=K0sIBsp-d6A",
 		Name:        "Potions in popular culture",
 		Description: "blah blah lorem ipsum etcetera casius belli",
@@ -231,17 +302,17 @@ func seed(q *db.Queries, cs *courses.Service, fs *feeds.Service, ms *materials.S
 		return err
 	}
 
-	_, err = ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	_, err = ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course3.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=Ph8Vag9VxRU",
 		Name:        "ZEBRAAAS in popular culture",
 		Description: "What will you learn",
 	}, uuid.NewString(), ctx)
 
-	_, err = ms.CreateUrlMaterial(materials.CreateUrlMaterialRequest{
+	_, err = ms.CreateVideoMaterial(materials.CreateUrlMaterialRequest{
 		CourseId:    course3.Uuid,
-		MatType:     "url",
+		MatType:     "video",
 		Url:         "https://www.youtube.com/watch?v=grjZPfCH6bs",
 		Name:        "eating in popular culture",
 		Description: "What i wish i had for dinner today",
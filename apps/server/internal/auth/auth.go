@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/handlers"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 
 	"github.com/labstack/echo/v4"
@@ -37,7 +39,7 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return r.Error(http.StatusBadRequest, "invalid request body")
 	}
 
-	user, err := r.Queries.GetUserByEmail(r.Ctx, req.Email)
+	user, err := r.Queries.GetUserByEmail(r.Ctx, utils.NormalizeEmail(req.Email))
 	if err != nil {
 		if utils.IsNoRowsError(err) {
 			return r.Error(http.StatusBadRequest, "Unknown email")
@@ -50,27 +52,24 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return r.Error(http.StatusUnauthorized, "invalid password")
 	}
 
-	newToken, err := utils.NewSessionToken()
-	if err != nil {
-		c.Logger().Errorf("failed to generate a session token: %v", err)
-		return r.Error(http.StatusInternalServerError, "internal server error")
+	if user.DeactivatedAt.Valid {
+		return r.Error(http.StatusForbidden, ErrUserDeactivated.Error())
 	}
 
-	cookie := h.createHttpCookie(newToken)
+	// old bcrypt hashes are upgraded now that we know the password, failing to do so doesn't block the login
+	if utils.NeedsRehash(user.Hash) {
+		err = h.rehashPassword(r, user.ID, req.Password)
+		if err != nil {
+			c.Logger().Errorf("failed to rehash the password of user %v: %v", user.ID, err)
+		}
+	}
 
-	_, err = r.Queries.CreateSession(r.Ctx, db.CreateSessionParams{
-		UserID:    user.ID,
-		Token:     newToken,
-		CreatedAt: time.Now().Unix(),
-		ExpiresAt: cookie.Expires.Unix(),
-	})
+	err = h.startSession(r, user.ID)
 	if err != nil {
-		c.Logger().Errorf("failed to create the session in the database: %v", err)
+		c.Logger().Errorf("failed to start a session: %v", err)
 		return r.Error(http.StatusInternalServerError, "internal server error")
 	}
 
-	c.SetCookie(cookie)
-
 	c.Logger().Infof("logged in a user: %v", user.Email)
 	return r.JSONMsg(http.StatusCreated, "logged in user")
 }
@@ -90,9 +89,18 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return r.Error(http.StatusBadRequest, "invalid request")
 	}
 
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.LastName = strings.TrimSpace(req.LastName)
+	req.Email = utils.NormalizeEmail(req.Email)
+
+	err := validateRegistration(req)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
 	hash, err := utils.HashPassword(req.Password)
 	if err != nil {
-		return r.Error(http.StatusBadRequest, "unhashable password")
+		return r.ServerError(err)
 	}
 
 	user, err := r.Queries.CreateUser(r.Ctx, db.CreateUserParams{
@@ -110,29 +118,39 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return r.Error(http.StatusInternalServerError, "internal server error")
 	}
 
-	newToken, err := utils.NewSessionToken()
+	err = h.startSession(r, user.ID)
 	if err != nil {
-		c.Logger().Errorf("failed to generate a session token: %v", err)
+		c.Logger().Errorf("failed to start a session: %v", err)
 		return r.Error(http.StatusInternalServerError, "internal server error")
 	}
 
-	cookie := h.createHttpCookie(newToken)
+	c.Logger().Infof("registered a user: %v", user.Email)
+	return r.JSONMsg(http.StatusCreated, "registered user")
+}
 
-	_, err = r.Queries.CreateSession(r.Ctx, db.CreateSessionParams{
-		UserID:    user.ID,
-		Token:     newToken,
-		CreatedAt: time.Now().Unix(),
-		ExpiresAt: cookie.Expires.Unix(),
-	})
-	if err != nil {
-		c.Logger().Errorf("failed to create the session in the database: %v", err)
-		return r.Error(http.StatusInternalServerError, "internal server error")
+func validateRegistration(req RegisterRequest) error {
+	if err := utils.ValidateName(req.FirstName, "first name"); err != nil {
+		return err
+	}
+	if err := utils.ValidateName(req.LastName, "last name"); err != nil {
+		return err
+	}
+	if err := utils.ValidateEmail(req.Email); err != nil {
+		return err
 	}
+	return utils.ValidatePassword(req.Password, req.Email, req.FirstName, req.LastName)
+}
 
-	c.SetCookie(cookie)
+func (h *AuthHandler) rehashPassword(r *handlers.RequestCtx, userID int64, password string) error {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
 
-	c.Logger().Infof("registered a user: %v", user.Email)
-	return r.JSONMsg(http.StatusCreated, "registered user")
+	return r.Queries.UpdateUserHash(r.Ctx, db.UpdateUserHashParams{
+		Hash: hash,
+		ID:   userID,
+	})
 }
 
 type PublicUser struct {
@@ -142,7 +160,11 @@ type PublicUser struct {
 	LastName  string `json:"lastName"`
 	Email     string `json:"email"`
 
-	IsAdmin bool `json:"isAdmin"`
+	IsAdmin            bool `json:"isAdmin"`
+	MustChangePassword bool `json:"mustChangePassword"`
+
+	AvatarUrl    *string `json:"avatarUrl"`
+	PendingEmail *string `json:"pendingEmail"` // the new email waiting for verification
 }
 
 func (h *AuthHandler) Profile(c echo.Context) error {
@@ -157,19 +179,72 @@ func (h *AuthHandler) Profile(c echo.Context) error {
 
 	// c.Logger().Infof("returned profile of user: %v", )
 
-	return c.JSON(http.StatusOK, PublicUser{
+	user, err := toPublicUser(r)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+func toPublicUser(r *handlers.RequestCtx) (PublicUser, error) {
+	user := PublicUser{
 		ID:        r.User.ID,
 		FirstName: r.User.FirstName,
 		LastName:  r.User.LastName,
 		Email:     r.User.Email,
 		IsAdmin:   r.User.IsAdmin,
+
+		MustChangePassword: r.User.MustChangePassword,
+	}
+
+	if r.User.AvatarPath != "" {
+		avatarUrl := uploads.FileUrl(r.Echo.Scheme(), r.Echo.Request().Host, r.User.AvatarPath)
+		user.AvatarUrl = &avatarUrl
+	}
+
+	change, err := r.Queries.GetEmailChangeOfUser(r.Ctx, db.GetEmailChangeOfUserParams{
+		UserID:    int64(r.User.ID),
+		ExpiresAt: time.Now().Unix(),
+	})
+	if err != nil && !utils.IsNoRowsError(err) {
+		return PublicUser{}, err
+	}
+	if err == nil {
+		user.PendingEmail = &change.NewEmail
+	}
+
+	return user, nil
+}
+
+// creates a new session for the user in the db and sets the session cookie on the response
+func (h *AuthHandler) startSession(r *handlers.RequestCtx, userID int64) error {
+	newToken, err := utils.NewSessionToken()
+	if err != nil {
+		return err
+	}
+
+	cookie := h.createHttpCookie(newToken)
+
+	_, err = r.Queries.CreateSession(r.Ctx, db.CreateSessionParams{
+		UserID:    userID,
+		Token:     newToken,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: cookie.Expires.Unix(),
 	})
+	if err != nil {
+		return err
+	}
+
+	r.Echo.SetCookie(cookie)
+	return nil
 }
 
 func (h *AuthHandler) createHttpCookie(tokenValue string) *http.Cookie {
 	return &http.Cookie{
 		Name:     "auth_token",
 		Value:    tokenValue,
+		Path:     "/", // without it the cookie would only be sent to the path the session was created on
 		Expires:  time.Now().Add(COOKIE_LIFETIME),
 		HttpOnly: true,
 		Secure:   h.IsDeployed,
@@ -215,5 +290,10 @@ func validateToken(token string, queries *db.Queries, ctx context.Context) (*han
 		Hash:      authInfo.Hash,
 		Email:     authInfo.Email,
 		IsAdmin:   authInfo.IsAdmin,
+
+		MustChangePassword: authInfo.MustChangePassword == 1,
+
+		AvatarPath: authInfo.AvatarPath.String,
+		LoggedInAt: authInfo.CreatedAt,
 	}, nil
 }
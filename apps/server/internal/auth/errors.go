@@ -0,0 +1,24 @@
+package auth
+
+import "errors"
+
+var (
+	ErrUserDeactivated = errors.New("this account has been deactivated")
+
+	ErrWrongPassword       = errors.New("current password is incorrect")
+	ErrMustChangePassword  = errors.New("the password has to be changed first")
+	ErrRecentLoginRequired = errors.New("log in through the identity provider again before setting a password")
+	ErrEmailInUse          = errors.New("email already in use")
+	ErrEmailChangeNotFound = errors.New("unknown or already used verification link")
+	ErrEmailChangeExpired  = errors.New("the verification link has expired, request the change again")
+	ErrAvatarTooBig        = errors.New("avatar is too big")
+	ErrAvatarTypeForbidden = errors.New("avatar must be a png, jpeg, gif or webp image")
+
+	ErrOIDCUnknownState        = errors.New("unknown or expired login attempt")
+	ErrOIDCStateMismatch       = errors.New("the login was not started in this browser, start it again")
+	ErrOIDCNonceMismatch       = errors.New("id token nonce does not match the login attempt")
+	ErrOIDCMissingIdToken      = errors.New("identity provider did not return an id token")
+	ErrOIDCMissingEmail        = errors.New("identity provider did not share an email address")
+	ErrOIDCEmailNotVerified    = errors.New("an account with this email already exists, but the identity provider did not verify the email")
+	ErrOIDCNewEmailNotVerified = errors.New("the identity provider did not verify the email, an account can't be created with it")
+)
@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"database/sql"
 	"net/http"
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
 
 	"github.com/labstack/echo/v4"
 )
@@ -40,6 +42,60 @@ func AuthMiddleware(queries *db.Queries) echo.MiddlewareFunc {
 
 			// fmt.Println("set user")
 
+			// after a reset forced by an admin the session is only good for changing the password
+			if user.MustChangePassword && !allowedBeforePasswordChange(c) {
+				return c.JSON(http.StatusForbidden, map[string]string{
+					"message": ErrMustChangePassword.Error(),
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// routes usable by a user who has to change their password, c.Path() is the route the request was matched to
+func allowedBeforePasswordChange(c echo.Context) bool {
+	switch c.Request().Method + " " + c.Path() {
+	case "GET /me", "POST /me/password", "POST /logout", "POST /login":
+		return true
+	}
+	return false
+}
+
+// Lets feed readers and calendar apps, which don't have the session cookie, in with the feed token of the user
+// in the token query parameter - for the read only feeds and calendars of courses
+func FeedTokenAuth(queries *db.Queries) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			if user, ok := c.Get("user").(*handlers.User); ok && user != nil {
+				return next(c)
+			}
+
+			token := c.QueryParam("token")
+			if token == "" {
+				return next(c)
+			}
+
+			u, err := queries.GetUserByFeedToken(c.Request().Context(), sql.NullString{String: token, Valid: true})
+			if err != nil {
+				if utils.IsNoRowsError(err) {
+					return c.JSON(http.StatusUnauthorized, map[string]string{
+						"message": "invalid feed token",
+					})
+				}
+				return err
+			}
+
+			c.Set("user", &handlers.User{
+				ID:        int(u.ID),
+				FirstName: u.FirstName,
+				LastName:  u.LastName,
+				Email:     u.Email,
+				IsAdmin:   u.IsAdmin,
+			})
+
 			return next(c)
 		}
 	}
@@ -69,3 +125,20 @@ func AdminRequired() echo.MiddlewareFunc {
 		}
 	}
 }
+
+func LoginRequired() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			user, ok := c.Get("user").(*handlers.User)
+
+			if !ok || user == nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"message": "authentication required",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+//* this file implements login through an external OpenID Connect provider (authorization code flow with PKCE)
+
+// how long the user has to finish the login at the identity provider
+var OIDC_LOGIN_TIMEOUT = time.Minute * 10
+
+// timeout for every request made to the identity provider
+var OIDC_HTTP_TIMEOUT = time.Second * 10
+
+// the state of the login is also kept in a cookie of the browser that started it, the callback is refused
+// in any other browser - otherwise anyone could log a victim into their own account by sending them a callback link
+const OIDC_STATE_COOKIE = "oidc_state"
+
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // must point to GET /auth/oidc/callback as seen by the identity provider
+	Scopes       []string
+
+	// name of the claim holding the groups of the user and the groups whose members become admins,
+	// if AdminGroups is empty admin status is never touched by the OIDC login
+	GroupsClaim string
+	AdminGroups []string
+
+	// where the user gets redirected after a login if the login request did not ask for anything else
+	PostLoginRedirect string
+}
+
+// reads the OIDC configuration from env variables, returns nil when OIDC_ISSUER_URL is not set
+func OIDCConfigFromEnv() *OIDCConfig {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return nil
+	}
+
+	config := &OIDCConfig{
+		IssuerURL:         issuer,
+		ClientID:          os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:      os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:       os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:            []string{oidc.ScopeOpenID, "email", "profile"},
+		GroupsClaim:       "groups",
+		AdminGroups:       splitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		PostLoginRedirect: "/",
+	}
+
+	if scopes := splitList(os.Getenv("OIDC_SCOPES")); len(scopes) > 0 {
+		if !slices.Contains(scopes, oidc.ScopeOpenID) {
+			scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+		}
+		config.Scopes = scopes
+	}
+
+	if claim := os.Getenv("OIDC_GROUPS_CLAIM"); claim != "" {
+		config.GroupsClaim = claim
+	}
+
+	if redirect := os.Getenv("OIDC_POST_LOGIN_REDIRECT"); redirect != "" {
+		config.PostLoginRedirect = redirect
+	}
+
+	return config
+}
+
+// splits a comma or space separated env value into its non empty parts
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+type OIDCHandler struct {
+	*AuthHandler
+	config *OIDCConfig
+	client *http.Client
+
+	// the provider is discovered lazily so that the server can boot even when the identity provider is down
+	providerMux sync.Mutex
+	provider    *oidc.Provider
+}
+
+func NewOIDCHandler(queries *db.Queries, isDeployed bool, config *OIDCConfig) *OIDCHandler {
+	return &OIDCHandler{
+		AuthHandler: NewAuthHandler(queries, isDeployed),
+		config:      config,
+		client:      &http.Client{Timeout: OIDC_HTTP_TIMEOUT},
+	}
+}
+
+func (h *OIDCHandler) getProvider() (*oidc.Provider, error) {
+	h.providerMux.Lock()
+	defer h.providerMux.Unlock()
+
+	if h.provider != nil {
+		return h.provider, nil
+	}
+
+	// the context is kept by the provider for refreshing the signing keys, so it must not be a request context
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), h.client), h.config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	h.provider = provider
+	return provider, nil
+}
+
+func (h *OIDCHandler) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     h.config.ClientID,
+		ClientSecret: h.config.ClientSecret,
+		RedirectURL:  h.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       h.config.Scopes,
+	}
+}
+
+func (h *OIDCHandler) stateCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   h.IsDeployed,
+		// sent with the redirect back from the identity provider, which is a top level navigation
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// only relative paths on our own domain are allowed as a redirect target after login
+func (h *OIDCHandler) safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.Contains(target, "\\") {
+		return h.config.PostLoginRedirect
+	}
+	return target
+}
+
+// GET /auth/oidc/login?redirect=/courses
+func (h *OIDCHandler) Login(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	provider, err := h.getProvider()
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	state, err := utils.NewSessionToken()
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	nonce, err := utils.NewSessionToken()
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+
+	// clean up logins that were never finished
+	err = r.Queries.DeleteExpiredOidcLoginStates(r.Ctx, now.Unix())
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = r.Queries.CreateOidcLoginState(r.Ctx, db.CreateOidcLoginStateParams{
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		RedirectTo:   h.safeRedirect(c.QueryParam("redirect")),
+		CreatedAt:    now.Unix(),
+		ExpiresAt:    now.Add(OIDC_LOGIN_TIMEOUT).Unix(),
+	})
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	c.SetCookie(h.stateCookie(state, int(OIDC_LOGIN_TIMEOUT.Seconds())))
+
+	url := h.oauth2Config(provider).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+
+	return c.Redirect(http.StatusFound, url)
+}
+
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+
+	Groups []string `json:"-"`
+}
+
+// GET /auth/oidc/callback?code=...&state=...
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	if idpErr := c.QueryParam("error"); idpErr != "" {
+		return r.Error(http.StatusBadRequest, "identity provider refused the login: "+idpErr+" "+c.QueryParam("error_description"))
+	}
+
+	code := c.QueryParam("code")
+	if code == "" {
+		return r.Error(http.StatusBadRequest, "missing authorization code")
+	}
+
+	state := c.QueryParam("state")
+
+	cookie, err := c.Cookie(OIDC_STATE_COOKIE)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return r.Error(http.StatusBadRequest, ErrOIDCStateMismatch.Error())
+	}
+	c.SetCookie(h.stateCookie("", -1))
+
+	loginState, err := r.Queries.ConsumeOidcLoginState(r.Ctx, state)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return r.Error(http.StatusBadRequest, ErrOIDCUnknownState.Error())
+		}
+		return r.ServerError(err)
+	}
+
+	if loginState.ExpiresAt <= time.Now().Unix() {
+		return r.Error(http.StatusBadRequest, ErrOIDCUnknownState.Error())
+	}
+
+	provider, err := h.getProvider()
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	ctx := oidc.ClientContext(r.Ctx, h.client)
+
+	token, err := h.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(loginState.CodeVerifier))
+	if err != nil {
+		return r.Error(http.StatusBadGateway, "failed to exchange the authorization code: "+err.Error())
+	}
+
+	subject, claims, err := h.verifyToken(ctx, provider, token, loginState.Nonce)
+	if err != nil {
+		return r.Error(http.StatusUnauthorized, err.Error())
+	}
+
+	user, err := h.provisionUser(r, subject, claims)
+	if err != nil {
+		if err == ErrOIDCMissingEmail || err == ErrOIDCNewEmailNotVerified {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+		if err == ErrOIDCEmailNotVerified {
+			return r.Error(http.StatusConflict, err.Error())
+		}
+		if err == ErrUserDeactivated {
+			return r.Error(http.StatusForbidden, err.Error())
+		}
+		return r.ServerError(err)
+	}
+
+	err = h.syncAdminStatus(r, user.ID, claims.Groups)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = h.startSession(r, user.ID)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	c.Logger().Infof("logged in a user through OIDC: %v", user.Email)
+	return c.Redirect(http.StatusFound, loginState.RedirectTo)
+}
+
+// verifies the id token returned by the provider and collects the claims we care about,
+// the userinfo endpoint is used as a fallback for providers that keep the email out of the id token
+func (h *OIDCHandler) verifyToken(ctx context.Context, provider *oidc.Provider, token *oauth2.Token, nonce string) (string, oidcClaims, error) {
+	var claims oidcClaims
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", claims, ErrOIDCMissingIdToken
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: h.config.ClientID}).Verify(ctx, rawIdToken)
+	if err != nil {
+		return "", claims, err
+	}
+
+	if idToken.Nonce != nonce {
+		return "", claims, ErrOIDCNonceMismatch
+	}
+
+	allClaims := map[string]any{}
+	if err := idToken.Claims(&allClaims); err != nil {
+		return "", claims, err
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", claims, err
+	}
+
+	if claims.Email == "" {
+		userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err == nil && userInfo.Subject == idToken.Subject {
+			userInfo.Claims(&allClaims)
+			userInfo.Claims(&claims)
+		}
+	}
+
+	claims.Groups = groupsFromClaim(allClaims[h.config.GroupsClaim])
+
+	return idToken.Subject, claims, nil
+}
+
+// providers send groups either as a list or as a single string
+func groupsFromClaim(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return splitList(v)
+	case []any:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+// finds the local user linked to the identity, links an existing user with the same verified email
+// or creates a brand new user with the verified email (just in time provisioning)
+func (h *OIDCHandler) provisionUser(r *handlers.RequestCtx, subject string, claims oidcClaims) (db.User, error) {
+
+	linked, err := r.Queries.GetUserByIdentity(r.Ctx, db.GetUserByIdentityParams{
+		Issuer:  h.config.IssuerURL,
+		Subject: subject,
+	})
+	if err == nil {
+		if linked.DeactivatedAt.Valid {
+			return db.User{}, ErrUserDeactivated
+		}
+
+		return db.User{
+			ID:        linked.ID,
+			FirstName: linked.FirstName,
+			LastName:  linked.LastName,
+			Hash:      linked.Hash,
+			Email:     linked.Email,
+
+			MustChangePassword: linked.MustChangePassword,
+			DeactivatedAt:      linked.DeactivatedAt,
+		}, nil
+	}
+	if !utils.IsNoRowsError(err) {
+		return db.User{}, err
+	}
+
+	// compared and stored like the emails of the password logins
+	email := utils.NormalizeEmail(claims.Email)
+	if email == "" {
+		return db.User{}, ErrOIDCMissingEmail
+	}
+
+	user, err := r.Queries.GetUserByEmail(r.Ctx, email)
+	if err != nil {
+		if !utils.IsNoRowsError(err) {
+			return db.User{}, err
+		}
+
+		// the account would hold an email nobody proved to own, taking it from its owner who registers later
+		if !claims.EmailVerified {
+			return db.User{}, ErrOIDCNewEmailNotVerified
+		}
+
+		firstName, lastName := claims.GivenName, claims.FamilyName
+		if firstName == "" && lastName == "" {
+			firstName, lastName, _ = strings.Cut(claims.Name, " ")
+		}
+
+		// users coming from the identity provider have no local password, an empty hash never matches any password
+		user, err = r.Queries.CreateUser(r.Ctx, db.CreateUserParams{
+			FirstName: firstName,
+			LastName:  lastName,
+			Email:     email,
+			Hash:      "",
+		})
+		if err != nil {
+			return db.User{}, err
+		}
+
+	} else if !claims.EmailVerified {
+		// linking on an unverified email would let anyone take over a local account
+		return db.User{}, ErrOIDCEmailNotVerified
+
+	} else if user.DeactivatedAt.Valid {
+		return db.User{}, ErrUserDeactivated
+	}
+
+	_, err = r.Queries.CreateUserIdentity(r.Ctx, db.CreateUserIdentityParams{
+		UserID:    user.ID,
+		Issuer:    h.config.IssuerURL,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return db.User{}, err
+	}
+
+	return user, nil
+}
+
+// grants or revokes admin based on the groups of the user, does nothing when no admin groups are configured
+func (h *OIDCHandler) syncAdminStatus(r *handlers.RequestCtx, userID int64, groups []string) error {
+	if len(h.config.AdminGroups) == 0 {
+		return nil
+	}
+
+	isAdmin := slices.ContainsFunc(groups, func(g string) bool {
+		return slices.Contains(h.config.AdminGroups, g)
+	})
+
+	if isAdmin {
+		return r.Queries.MakeUserAdmin(r.Ctx, userID)
+	}
+	return r.Queries.RemoveUserAdmin(r.Ctx, userID)
+}
+
+// GET /auth/oidc
+// lets the frontend know whether to show the "log in with school account" button
+func OIDCStatus(config *OIDCConfig) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]bool{"enabled": config != nil})
+	}
+}
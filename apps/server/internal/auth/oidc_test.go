@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tourbackend/internal/database"
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/mail"
+
+	"github.com/labstack/echo/v4"
+)
+
+// mockProvider is a minimal OpenID Connect provider - discovery, keys and a token endpoint issuing
+// id tokens for the identity set by the test
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	subject       string
+	email         string
+	emailVerified bool
+
+	// from the authorization url of the last login
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a verified email like most providers send, the tests of unverified emails turn it off
+	p := &mockProvider{t: t, key: key, emailVerified: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                p.server.URL,
+			"authorization_endpoint":                p.server.URL + "/authorize",
+			"token_endpoint":                        p.server.URL + "/token",
+			"jwks_uri":                              p.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	// PKCE - the verifier has to belong to the challenge of the login
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge || r.PostForm.Get("code") != "code" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	writeJSON(w, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token": p.sign(map[string]any{
+			"iss":            p.server.URL,
+			"sub":            p.subject,
+			"aud":            "tda",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          p.nonce,
+			"email":          p.email,
+			"email_verified": p.emailVerified,
+			"given_name":     "Jana",
+			"family_name":    "Nováková",
+		}),
+	})
+}
+
+// RS256 jwt
+func (p *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type oidcTest struct {
+	e        *echo.Echo
+	conn     *sql.DB
+	queries  *db.Queries
+	provider *mockProvider
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Setenv("PATH_TO_DB", filepath.Join(t.TempDir(), "test.db"))
+	conn, queries := database.Initialize(true)
+	t.Cleanup(func() { conn.Close() })
+
+	provider := newMockProvider(t)
+
+	h := NewOIDCHandler(queries, false, &OIDCConfig{
+		IssuerURL:         provider.server.URL,
+		ClientID:          "tda",
+		ClientSecret:      "secret",
+		RedirectURL:       "http://localhost/auth/oidc/callback",
+		Scopes:            []string{"openid", "email", "profile"},
+		GroupsClaim:       "groups",
+		PostLoginRedirect: "/",
+	})
+
+	profile := NewProfileHandler(queries, false, t.TempDir(), mail.LogMailer{}, "http://localhost")
+
+	e := echo.New()
+	e.Use(AuthMiddleware(queries))
+	e.GET("/auth/oidc/login", h.Login)
+	e.GET("/auth/oidc/callback", h.Callback)
+	e.POST("/me/password", profile.ChangePassword, LoginRequired())
+	return &oidcTest{e, conn, queries, provider}
+}
+
+// starts the login, returns the state and the state cookie set for the browser
+func startLogin(t *testing.T, e *echo.Echo, provider *mockProvider) (string, *http.Cookie) {
+	res := httptest.NewRecorder()
+	e.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/auth/oidc/login?redirect=/courses", nil))
+	if res.Code != http.StatusFound {
+		t.Fatalf("login: expected a redirect, got %d %s", res.Code, res.Body.String())
+	}
+
+	location, err := url.Parse(res.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), provider.server.URL+"/authorize") {
+		t.Fatalf("login: unexpected redirect %q", res.Header().Get("Location"))
+	}
+	query := location.Query()
+	provider.nonce = query.Get("nonce")
+	provider.challenge = query.Get("code_challenge")
+
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == OIDC_STATE_COOKIE {
+			return query.Get("state"), cookie
+		}
+	}
+	t.Fatal("login: no state cookie")
+	return "", nil
+}
+
+func callback(e *echo.Echo, state string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	res := httptest.NewRecorder()
+	e.ServeHTTP(res, req)
+	return res
+}
+
+func hasSessionCookie(res *httptest.ResponseRecorder) bool {
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == "auth_token" && cookie.Value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOIDCLoginProvisionsUserWithNormalizedEmail(t *testing.T) {
+	tt := newOIDCTest(t)
+	e, queries, provider := tt.e, tt.queries, tt.provider
+	provider.subject = "student-1"
+	provider.email = "  Jana.Novakova@School.CZ "
+
+	state, cookie := startLogin(t, e, provider)
+	res := callback(e, state, cookie)
+	if res.Code != http.StatusFound || res.Header().Get("Location") != "/courses" {
+		t.Fatalf("callback: expected a redirect to /courses, got %d %s", res.Code, res.Body.String())
+	}
+	if !hasSessionCookie(res) {
+		t.Fatal("callback: no session was started")
+	}
+
+	user, err := queries.GetUserByEmail(t.Context(), "jana.novakova@school.cz")
+	if err != nil {
+		t.Fatalf("user with the normalized email was not created: %v", err)
+	}
+	if user.Hash != "" {
+		t.Error("users of the identity provider must not get a password")
+	}
+
+	// the next login finds the user by the identity
+	state, cookie = startLogin(t, e, provider)
+	if res := callback(e, state, cookie); res.Code != http.StatusFound {
+		t.Fatalf("second login: got %d %s", res.Code, res.Body.String())
+	}
+	count, err := queries.CountUsers(t.Context(), db.CountUsersParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 user, got %d", count)
+	}
+}
+
+func TestOIDCLoginLinksExistingUserOfDifferentlyCasedEmail(t *testing.T) {
+	tt := newOIDCTest(t)
+	e, queries, provider := tt.e, tt.queries, tt.provider
+
+	existing, err := queries.CreateUser(t.Context(), db.CreateUserParams{
+		FirstName: "Jana",
+		LastName:  "Nováková",
+		Email:     "jana@school.cz",
+		Hash:      "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider.subject = "teacher-1"
+	provider.email = "Jana@School.cz"
+	provider.emailVerified = false
+
+	// an unverified email is not enough to link the account
+	state, cookie := startLogin(t, e, provider)
+	if res := callback(e, state, cookie); res.Code != http.StatusConflict {
+		t.Fatalf("unverified email: expected 409, got %d %s", res.Code, res.Body.String())
+	}
+
+	provider.emailVerified = true
+	state, cookie = startLogin(t, e, provider)
+	if res := callback(e, state, cookie); res.Code != http.StatusFound {
+		t.Fatalf("verified email: expected a redirect, got %d %s", res.Code, res.Body.String())
+	}
+
+	linked, err := queries.GetUserByIdentity(t.Context(), db.GetUserByIdentityParams{
+		Issuer:  provider.server.URL,
+		Subject: "teacher-1",
+	})
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if linked.ID != existing.ID {
+		t.Errorf("identity linked to user %d instead of the existing %d", linked.ID, existing.ID)
+	}
+}
+
+func TestOIDCLoginRefusesNewUserOfUnverifiedEmail(t *testing.T) {
+	tt := newOIDCTest(t)
+	e, queries, provider := tt.e, tt.queries, tt.provider
+	provider.subject = "student-3"
+	provider.email = "eva@school.cz"
+	provider.emailVerified = false
+
+	state, cookie := startLogin(t, e, provider)
+	res := callback(e, state, cookie)
+	if res.Code != http.StatusBadRequest || hasSessionCookie(res) {
+		t.Fatalf("unverified email: expected 400 without a session, got %d %s", res.Code, res.Body.String())
+	}
+	if _, err := queries.GetUserByEmail(t.Context(), "eva@school.cz"); err == nil {
+		t.Fatal("a user was created with an unverified email")
+	}
+
+	provider.emailVerified = true
+	state, cookie = startLogin(t, e, provider)
+	if res := callback(e, state, cookie); res.Code != http.StatusFound {
+		t.Fatalf("verified email: expected a redirect, got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	tt := newOIDCTest(t)
+	e, queries, provider := tt.e, tt.queries, tt.provider
+	provider.subject = "attacker"
+	provider.email = "attacker@school.cz"
+
+	// the attacker starts a login and sends the callback link to a victim, whose browser has no state cookie
+	state, cookie := startLogin(t, e, provider)
+
+	res := callback(e, state, nil)
+	if res.Code != http.StatusBadRequest || hasSessionCookie(res) {
+		t.Fatalf("callback without the cookie: expected 400 without a session, got %d", res.Code)
+	}
+
+	res = callback(e, state, &http.Cookie{Name: OIDC_STATE_COOKIE, Value: "other"})
+	if res.Code != http.StatusBadRequest || hasSessionCookie(res) {
+		t.Fatalf("callback with another state: expected 400 without a session, got %d", res.Code)
+	}
+
+	if _, err := queries.GetUserByEmail(t.Context(), "attacker@school.cz"); err == nil {
+		t.Fatal("a user was created by a refused callback")
+	}
+
+	// the browser that started the login can still finish it
+	if res := callback(e, state, cookie); res.Code != http.StatusFound {
+		t.Fatalf("callback with the cookie: expected a redirect, got %d %s", res.Code, res.Body.String())
+	}
+}
+
+func TestOIDCUserSetsFirstPasswordOnlyAfterRecentLogin(t *testing.T) {
+	tt := newOIDCTest(t)
+	e, queries, provider := tt.e, tt.queries, tt.provider
+	provider.subject = "student-2"
+	provider.email = "petr@school.cz"
+
+	state, cookie := startLogin(t, e, provider)
+	res := callback(e, state, cookie)
+
+	var session *http.Cookie
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == "auth_token" {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatalf("callback: no session was started, got %d %s", res.Code, res.Body.String())
+	}
+
+	setPassword := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/me/password", strings.NewReader(`{"newPassword":"Kohoutek#Modry42"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, req)
+		return res
+	}
+
+	// a session from a login long ago, e.g. a stolen one, can't set the password
+	_, err := tt.conn.ExecContext(t.Context(), "UPDATE session SET created_at = ? WHERE token = ?",
+		time.Now().Add(-PASSWORD_SETUP_LOGIN_AGE-time.Minute).Unix(), session.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := setPassword(); res.Code != http.StatusForbidden {
+		t.Fatalf("old session: expected 403, got %d %s", res.Code, res.Body.String())
+	}
+
+	// right after the login it can
+	_, err = tt.conn.ExecContext(t.Context(), "UPDATE session SET created_at = ? WHERE token = ?", time.Now().Unix(), session.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := setPassword(); res.Code != http.StatusOK {
+		t.Fatalf("fresh session: expected 200, got %d %s", res.Code, res.Body.String())
+	}
+
+	user, err := queries.GetUserByEmail(t.Context(), "petr@school.cz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Hash == "" {
+		t.Error("the password was not set")
+	}
+}
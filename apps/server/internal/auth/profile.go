@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/mail"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* endpoints for users managing their own account, all of them require a logged in user
+
+// how long the link for confirming a new email is valid
+var EMAIL_CHANGE_LIFETIME = time.Hour * 24
+
+// max avatar size in bytes
+var MAX_AVATAR_SIZE = int64(2 * 1024 * 1024)
+
+// users without a password (created through OIDC) can set the first one only this long after logging in,
+// as a proof that it's them and not someone with a stolen session
+var PASSWORD_SETUP_LOGIN_AGE = time.Minute * 5
+
+// the mime type of the avatar is detected from its content, not from the file name
+var AVATAR_TYPES = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpeg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// avatars are stored as <static>/uploads/avatars/<userId>-<unix time>.<ext>,
+// the time makes the url change with each upload so browsers don't show the cached old one
+var AVATARS_DIR = "uploads/avatars"
+
+type ProfileHandler struct {
+	*AuthHandler
+
+	staticPath string
+	mailer     mail.Mailer
+
+	// base url of the app used in the links of emails, e.g. https://app.tourdeapp.cz
+	appUrl string
+}
+
+func NewProfileHandler(queries *db.Queries, isDeployed bool, staticPath string, mailer mail.Mailer, appUrl string) *ProfileHandler {
+	return &ProfileHandler{
+		NewAuthHandler(queries, isDeployed),
+		staticPath,
+		mailer,
+		strings.TrimSuffix(appUrl, "/"),
+	}
+}
+
+type UpdateProfileRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Email     *string `json:"email"`
+}
+
+// PATCH /me
+// names change right away, a new email only after it's confirmed through the link sent to it
+func (h *ProfileHandler) UpdateProfile(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req UpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	if req.FirstName != nil {
+		*req.FirstName = strings.TrimSpace(*req.FirstName)
+		if err := utils.ValidateName(*req.FirstName, "first name"); err != nil {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	if req.LastName != nil {
+		*req.LastName = strings.TrimSpace(*req.LastName)
+		if err := utils.ValidateName(*req.LastName, "last name"); err != nil {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	if req.Email != nil {
+		*req.Email = utils.NormalizeEmail(*req.Email)
+		if err := utils.ValidateEmail(*req.Email); err != nil {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+
+		// nothing to verify when the email stays the same
+		if *req.Email == utils.NormalizeEmail(r.User.Email) {
+			req.Email = nil
+		}
+	}
+
+	if req.FirstName != nil || req.LastName != nil {
+		user, err := r.Queries.UpdateUserPartial(r.Ctx, db.UpdateUserPartialParams{
+			ID:        int64(r.User.ID),
+			FirstName: utils.ToSqlNullString(req.FirstName),
+			LastName:  utils.ToSqlNullString(req.LastName),
+		})
+		if err != nil {
+			return r.ServerError(err)
+		}
+
+		r.User.FirstName = user.FirstName
+		r.User.LastName = user.LastName
+	}
+
+	if req.Email != nil {
+		err := h.requestEmailChange(r, *req.Email)
+		if err != nil {
+			if err == ErrEmailInUse {
+				return r.Error(http.StatusBadRequest, err.Error())
+			}
+			return r.ServerError(err)
+		}
+	}
+
+	user, err := toPublicUser(r)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// stores the requested email and sends the confirmation link to it,
+// a previous unconfirmed request of the user is replaced
+func (h *ProfileHandler) requestEmailChange(r *handlers.RequestCtx, email string) error {
+
+	_, err := r.Queries.GetUserByEmail(r.Ctx, email)
+	if err == nil {
+		return ErrEmailInUse
+	}
+	if !utils.IsNoRowsError(err) {
+		return err
+	}
+
+	token, err := utils.NewSessionToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	_, err = r.Queries.CreateEmailChange(r.Ctx, db.CreateEmailChangeParams{
+		Token:     token,
+		UserID:    int64(r.User.ID),
+		NewEmail:  email,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(EMAIL_CHANGE_LIFETIME).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := h.appUrl + "/api/me/email/verify?token=" + url.QueryEscape(token)
+
+	body := fmt.Sprintf(
+		"Hello %v,\n\nopen the following link to confirm %v as the new email of your account:\n%v\n\nThe link is valid for %v hours. If you didn't ask for the change, ignore this email.",
+		r.User.FirstName, email, link, int(EMAIL_CHANGE_LIFETIME.Hours()),
+	)
+
+	return h.mailer.Send(email, "Confirm your new email", body)
+}
+
+// GET /me/email/verify?token=
+// opened from the link in the email, so it works without being logged in - the token is the proof
+func (h *ProfileHandler) VerifyEmailChange(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	token := c.QueryParam("token")
+	if token == "" {
+		return r.Error(http.StatusBadRequest, "token is required")
+	}
+
+	change, err := r.Queries.ConsumeEmailChange(r.Ctx, token)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return r.Error(http.StatusBadRequest, ErrEmailChangeNotFound.Error())
+		}
+		return r.ServerError(err)
+	}
+
+	if change.ExpiresAt <= time.Now().Unix() {
+		return r.Error(http.StatusBadRequest, ErrEmailChangeExpired.Error())
+	}
+
+	err = r.Queries.SetUserEmail(r.Ctx, db.SetUserEmailParams{
+		Email: change.NewEmail,
+		ID:    change.UserID,
+	})
+	if err != nil {
+		// someone registered the email in the meantime
+		if utils.IsUniqueConstraintError(err) {
+			return r.Error(http.StatusBadRequest, ErrEmailInUse.Error())
+		}
+		return r.ServerError(err)
+	}
+
+	c.Logger().Infof("user %v changed their email to %v", change.UserID, change.NewEmail)
+	return r.JSONMsg(http.StatusOK, "email changed")
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+// POST /me/password
+// all other sessions of the user are ended, the current one is replaced by a fresh session
+func (h *ProfileHandler) ChangePassword(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	if r.User.Hash != "" {
+		if !utils.CheckPasswordHash(req.CurrentPassword, r.User.Hash) {
+			return r.Error(http.StatusUnauthorized, ErrWrongPassword.Error())
+		}
+	} else {
+		// users created through OIDC have no password yet, instead of the current one they have to log in
+		// again, their sessions all come from OIDC logins as they can't log in any other way
+		loggedIn := time.Unix(r.User.LoggedInAt, 0)
+		if time.Since(loggedIn) > PASSWORD_SETUP_LOGIN_AGE {
+			return r.Error(http.StatusForbidden, ErrRecentLoginRequired.Error())
+		}
+	}
+
+	err := utils.ValidatePassword(req.NewPassword, r.User.Email, r.User.FirstName, r.User.LastName)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	hash, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = r.Queries.SetUserPassword(r.Ctx, db.SetUserPasswordParams{
+		ID:                 int64(r.User.ID),
+		Hash:               hash,
+		MustChangePassword: 0,
+	})
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = r.Queries.InvalidateSessionsOfUser(r.Ctx, int64(r.User.ID))
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = h.startSession(r, int64(r.User.ID))
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return r.JSONMsg(http.StatusOK, "password changed")
+}
+
+// PUT /me/avatar (multipart form with the image in the "avatar" field)
+func (h *ProfileHandler) UploadAvatar(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		return r.Error(http.StatusBadRequest, "avatar is required")
+	}
+
+	if fileHeader.Size > MAX_AVATAR_SIZE {
+		return r.Error(http.StatusBadRequest, ErrAvatarTooBig.Error())
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return r.ServerError(err)
+	}
+	defer src.Close()
+
+	mime, err := uploads.DetectMimeType(src)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	ext, ok := AVATAR_TYPES[mime]
+	if !ok {
+		return r.Error(http.StatusBadRequest, ErrAvatarTypeForbidden.Error())
+	}
+
+	prefix := strconv.Itoa(r.User.ID) + "-"
+
+	err = uploads.RemoveFilesWithPrefix(h.staticPath, AVATARS_DIR, prefix)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	name := prefix + strconv.FormatInt(time.Now().Unix(), 10) + ext
+
+	err = uploads.SaveFile(h.staticPath, AVATARS_DIR, name, src)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	path := AVATARS_DIR + "/" + name
+
+	err = r.Queries.SetUserAvatar(r.Ctx, db.SetUserAvatarParams{
+		AvatarPath: utils.ToSqlNullString(&path),
+		ID:         int64(r.User.ID),
+	})
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	r.User.AvatarPath = path
+
+	user, err := toPublicUser(r)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// DELETE /me/avatar
+func (h *ProfileHandler) DeleteAvatar(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	err := uploads.RemoveFilesWithPrefix(h.staticPath, AVATARS_DIR, strconv.Itoa(r.User.ID)+"-")
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = r.Queries.SetUserAvatar(r.Ctx, db.SetUserAvatarParams{
+		AvatarPath: utils.ToSqlNullString(nil),
+		ID:         int64(r.User.ID),
+	})
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type FeedTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// GET /me/feed-token
+// the token is created with the first request, feed readers send it in the token query parameter
+func (h *ProfileHandler) GetFeedToken(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	token, err := r.Queries.GetFeedTokenOfUser(r.Ctx, int64(r.User.ID))
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	if token.Valid {
+		return c.JSON(http.StatusOK, FeedTokenResponse{Token: token.String})
+	}
+
+	return h.newFeedToken(r)
+}
+
+// POST /me/feed-token
+// replaces the token, the links with the old one stop working
+func (h *ProfileHandler) ResetFeedToken(c echo.Context) error {
+	return h.newFeedToken(h.NewReqCtx(c))
+}
+
+func (h *ProfileHandler) newFeedToken(r *handlers.RequestCtx) error {
+	token, err := utils.NewSessionToken()
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	err = r.Queries.SetUserFeedToken(r.Ctx, db.SetUserFeedTokenParams{
+		FeedToken: sql.NullString{String: token, Valid: true},
+		ID:        int64(r.User.ID),
+	})
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return r.Echo.JSON(http.StatusOK, FeedTokenResponse{Token: token})
+}
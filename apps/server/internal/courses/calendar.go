@@ -0,0 +1,202 @@
+package courses
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* iCalendar feed of a course for calendar apps - the planned state changes of the course and its modules
+// (openTime of the state endpoints) and the deadlines of the quizzes,
+// like the atom feed it's public for open courses and needs the feed token of a lecturer for the others
+
+// calendar apps show the events as points in time, they get this length
+var CALENDAR_EVENT_LENGTH = 30 * time.Minute
+
+type CalendarEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+}
+
+type CourseCalendar struct {
+	CourseID   string
+	CourseName string
+	Events     []CalendarEvent
+}
+
+// Calendar returns the events of the course, students of courses that are not open get only the planned change of the course
+func (s *Service) Calendar(courseId string, isAdmin bool, ctx context.Context) (CourseCalendar, error) {
+
+	course, err := s.q.GetCourse(ctx, courseId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return CourseCalendar{}, ErrCourseNotFound
+		}
+		return CourseCalendar{}, err
+	}
+
+	calendar := CourseCalendar{
+		CourseID:   course.Uuid,
+		CourseName: course.Name,
+		Events:     []CalendarEvent{},
+	}
+
+	// planned changes are cleared once they are done, the ones left in the past were lost with a restart
+	now := time.Now().Unix()
+
+	if course.ScheduledAt.Valid && course.ScheduledAt.Int64 > now {
+		calendar.Events = append(calendar.Events, CalendarEvent{
+			UID:     "course-" + course.Uuid + "-" + fmt.Sprint(course.ScheduledAt.Int64),
+			Summary: course.Name + ": " + stateChangeSummary("Course", course.ScheduledState.String),
+			Start:   time.Unix(course.ScheduledAt.Int64, 0),
+		})
+	}
+
+	if !isAdmin && course.State != "open" {
+		return calendar, nil
+	}
+
+	modules, err := s.q.ListAllModules(ctx, courseId)
+	if err != nil {
+		return CourseCalendar{}, err
+	}
+
+	moduleStates := make(map[string]string, len(modules))
+	for _, module := range modules {
+		moduleStates[module.Uuid] = module.State
+
+		if module.ScheduledAt.Valid && module.ScheduledAt.Int64 > now {
+			calendar.Events = append(calendar.Events, CalendarEvent{
+				UID:         "module-" + module.Uuid + "-" + fmt.Sprint(module.ScheduledAt.Int64),
+				Summary:     course.Name + ": " + stateChangeSummary("Module "+module.Name, module.ScheduledState.String),
+				Description: module.Description,
+				Start:       time.Unix(module.ScheduledAt.Int64, 0),
+			})
+		}
+	}
+
+	quizzes, err := s.quizzesService.ListQuizes(courseId, ctx)
+	if err != nil {
+		return CourseCalendar{}, err
+	}
+
+	for _, quiz := range quizzes {
+		if quiz.Deadline == nil {
+			continue
+		}
+
+		// students don't see the quizzes of modules that are not open
+		if !isAdmin && moduleStates[quiz.ModuleId] != "open" {
+			continue
+		}
+
+		deadline, err := time.Parse(time.RFC3339, *quiz.Deadline)
+		if err != nil {
+			return CourseCalendar{}, err
+		}
+
+		calendar.Events = append(calendar.Events, CalendarEvent{
+			UID:     "quiz-" + quiz.Uuid,
+			Summary: course.Name + ": deadline of quiz " + quiz.Title,
+			Start:   deadline,
+		})
+	}
+
+	return calendar, nil
+}
+
+func stateChangeSummary(what string, state string) string {
+	switch state {
+	case "open":
+		return what + " opens"
+	case "closed":
+		return what + " closes"
+	case "preparation":
+		return what + " goes under construction"
+	}
+	return what + " changes to " + state
+}
+
+// GET /courses/{courseId}/calendar.ics?token=
+func (h *CourseHandler) GetCourseCalendar(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	calendar, err := h.service.Calendar(c.Param("courseId"), r.User != nil && r.User.IsAdmin, r.Ctx)
+	if err != nil {
+		if err == ErrCourseNotFound {
+			return r.Error(http.StatusNotFound, "Unknown courseId")
+		}
+		return r.ServerError(err)
+	}
+
+	courseUrl := c.Scheme() + "://" + c.Request().Host + "/courses/" + calendar.CourseID
+
+	return c.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(calendar.ICS(courseUrl, c.Request().Host)))
+}
+
+// ICS renders the calendar in the iCalendar format (RFC 5545)
+func (cal CourseCalendar) ICS(courseUrl string, host string) string {
+	var b strings.Builder
+
+	stamp := icsTime(time.Now())
+
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//Tour de App//Courses//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:"+icsText(cal.CourseName))
+
+	for _, event := range cal.Events {
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+event.UID+"@"+host)
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART:"+icsTime(event.Start))
+		writeICSLine(&b, "DTEND:"+icsTime(event.Start.Add(CALENDAR_EVENT_LENGTH)))
+		writeICSLine(&b, "SUMMARY:"+icsText(event.Summary))
+		if event.Description != "" {
+			writeICSLine(&b, "DESCRIPTION:"+icsText(event.Description))
+		}
+		writeICSLine(&b, "URL:"+courseUrl)
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+
+	return b.String()
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+func icsText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// lines are folded to 75 bytes, without splitting utf-8 characters, and end with CRLF
+func writeICSLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of the continuation line counts too
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -7,4 +7,5 @@ var (
 	ErrFailedToFetchCourse = errors.New("Failed to fetch course from db")
 	ErrBadCourseState      = errors.New("Invalid new course state")
 	ErrBadModuleState      = errors.New("Invalid new module state")
+	ErrBadOpenTime         = errors.New("openTime must be a local time (2006-01-02T15:04)")
 )
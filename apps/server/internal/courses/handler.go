@@ -241,6 +241,9 @@ func (h *CourseHandler) ChangeCourseState(c echo.Context) error {
 		if err == ErrBadCourseState {
 			return r.Error(http.StatusBadRequest, "Invalid course state")
 		}
+		if err == ErrBadOpenTime {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
 		return r.ServerError(err)
 	}
 
@@ -250,8 +253,9 @@ func (h *CourseHandler) ChangeCourseState(c echo.Context) error {
 //* Modules
 
 type ChangeModuleStateRequest struct {
-	State string `json:"state"`
-	Order *int   `json:"order"`
+	State    string  `json:"state"`
+	Order    *int    `json:"order"`
+	OpenTime *string `json:"openTime"` // the state is changed at this time (2006-01-02T15:04, local time of the server)
 }
 
 func (h *CourseHandler) ChangeModuleState(c echo.Context) error {
@@ -265,11 +269,14 @@ func (h *CourseHandler) ChangeModuleState(c echo.Context) error {
 	courseId := r.Echo.Param("courseId")
 	moduleId := r.Echo.Param("moduleId")
 
-	_, err := h.service.ChangeModuleState(courseId, moduleId, req.State, req.Order, r.Ctx)
+	_, err := h.service.ChangeModuleState(courseId, moduleId, req.State, req.Order, req.OpenTime, r.Ctx)
 	if err != nil {
 		if err == ErrBadModuleState {
 			return r.Error(http.StatusBadRequest, "Invalid module state")
 		}
+		if err == ErrBadOpenTime {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
 		return r.ServerError(err)
 	}
 
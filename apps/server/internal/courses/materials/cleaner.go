@@ -0,0 +1,60 @@
+package materials
+
+import (
+	"context"
+	"time"
+)
+
+//* the cleaner removes the resumable uploads abandoned by their clients - unfinished or never used by a material -
+// UPLOAD_EXPIRATION after their last chunk, and the partial files left behind by a crash
+
+// how often the cleaner looks for expired uploads
+var UPLOAD_CLEANUP_INTERVAL = 10 * time.Minute
+
+type UploadCleaner struct {
+	service *Service
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewUploadCleaner(service *Service) *UploadCleaner {
+	return &UploadCleaner{
+		service: service,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (u *UploadCleaner) Start() {
+	u.started = true
+	go u.run()
+}
+
+func (u *UploadCleaner) run() {
+	defer close(u.done)
+
+	ticker := time.NewTicker(UPLOAD_CLEANUP_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		u.service.removeExpiredUploads(context.Background())
+
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (u *UploadCleaner) Close() error {
+	if !u.started {
+		return nil
+	}
+
+	close(u.stop)
+	<-u.done
+	return nil
+}
@@ -0,0 +1,216 @@
+package materials
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* downloads of material files - the files are not served as static files, every download goes through the server
+// which checks that the user can see the material (admins always, the others when both the course and the module
+// of the material are open) and counts the access, storages like S3 get a redirect to a short-lived signed url
+
+// validity of the signed urls the downloads are redirected to
+var SIGNED_URL_EXPIRATION = 5 * time.Minute
+
+type DownloadFile struct {
+	Key      string
+	Filename string
+	MimeType string
+	ModTime  time.Time
+}
+
+// GetMaterialFile returns the stored file of the material when the user can see it,
+// version is 0 for the current file, the older versions are only for admins
+func (s *Service) GetMaterialFile(courseId string, materialId string, version int64, isAdmin bool, ctx context.Context) (DownloadFile, error) {
+	material, err := s.q.GetMaterialDownload(ctx, db.GetMaterialDownloadParams{
+		Uuid:       materialId,
+		CourseUuid: courseId,
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return DownloadFile{}, ErrMaterialNotFound
+		}
+		return DownloadFile{}, err
+	}
+
+	if material.Type != "file" {
+		return DownloadFile{}, ErrMaterialNotFound
+	}
+	if !isAdmin && !canSeeMaterial(material) {
+		return DownloadFile{}, ErrMaterialNotAccessible
+	}
+
+	key := uploads.StorageKey(material.Url)
+	mimeType := material.MimeType.String
+	modTime := time.Unix(material.UpdatedAt, 0)
+
+	if version != 0 {
+		if !isAdmin {
+			return DownloadFile{}, ErrMaterialNotAccessible
+		}
+
+		v, err := s.getMaterialVersion(courseId, materialId, version, ctx)
+		if err != nil {
+			return DownloadFile{}, err
+		}
+		key, mimeType, modTime = uploads.StorageKey(v.Url), v.MimeType, time.Unix(v.CreatedAt, 0)
+	}
+
+	// the name of the material with the extension of the file, the material name usually doesn't have it
+	filename := material.Name
+	if ext := path.Ext(key); !strings.HasSuffix(strings.ToLower(filename), ext) {
+		filename += ext
+	}
+
+	return DownloadFile{
+		Key:      key,
+		Filename: filename,
+		MimeType: mimeType,
+		ModTime:  modTime,
+	}, nil
+}
+
+// the same rules as for the course detail, closed modules show only their name
+func canSeeMaterial(material db.GetMaterialDownloadRow) bool {
+	return material.CourseState == "open" && material.ModuleState.String == "open"
+}
+
+// signedFileUrl returns the url to redirect the download to, ok is false when the storage doesn't sign urls
+func (s *Service) signedFileUrl(file DownloadFile, disposition string) (signed string, ok bool, err error) {
+	signer, ok := s.storage.(uploads.Signer)
+	if !ok {
+		return "", false, nil
+	}
+
+	signed, err = signer.SignedUrl(file.Key, SIGNED_URL_EXPIRATION, url.Values{
+		"response-content-disposition": {disposition},
+		"response-content-type":        {file.MimeType},
+	})
+	return signed, true, err
+}
+
+// GET /courses/{courseId}/materials/{materialId}/file
+// the file is shown in the browser, with ?download it's saved instead, Range requests are supported for seeking in videos,
+// admins can download the older versions with ?version
+func (h *Handler) DownloadMaterialFile(c echo.Context) error {
+	r := h.NewReqCtx(c)
+	req := c.Request()
+
+	courseId := c.Param("courseId")
+	materialId := c.Param("materialId")
+
+	isAdmin := r.User != nil && r.User.IsAdmin
+
+	var version int64
+	if c.QueryParam("version") != "" {
+		v, err := strconv.ParseInt(c.QueryParam("version"), 10, 64)
+		if err != nil || v <= 0 {
+			return r.Error(http.StatusBadRequest, "version must be a positive number")
+		}
+		version = v
+	}
+
+	file, err := h.service.GetMaterialFile(courseId, materialId, version, isAdmin, r.Ctx)
+	if err != nil {
+		switch err {
+		case ErrMaterialNotFound:
+			return r.Error(http.StatusNotFound, "Material not found")
+		case ErrVersionNotFound:
+			return r.Error(http.StatusNotFound, err.Error())
+		case ErrMaterialNotAccessible:
+			return r.Error(http.StatusForbidden, "The material is not open")
+		}
+		return r.ServerError(err)
+	}
+
+	disposition := "inline"
+	if c.QueryParams().Has("download") {
+		disposition = "attachment"
+	}
+	disposition = mime.FormatMediaType(disposition, map[string]string{"filename": file.Filename})
+
+	// players seeking in a video ask for ranges further in the file, only the request from the start counts,
+	// the lecturers checking their materials don't count
+	if !isAdmin && req.Method == http.MethodGet && isFirstRange(req.Header.Get("Range")) {
+		err := h.service.IncrementMaterialAccessedCounter(materialId, courseId, r.Ctx)
+		if err != nil {
+			slog.ErrorContext(r.Ctx, "failed to count the download", "material", materialId, "error", err)
+		}
+	}
+
+	header := c.Response().Header()
+	// the response depends on the user, it must not be kept by shared caches
+	header.Set("Cache-Control", "private, no-cache")
+
+	signed, ok, err := h.service.signedFileUrl(file, disposition)
+	if err != nil {
+		return r.ServerError(err)
+	}
+	if ok {
+		header.Set("Cache-Control", "no-store")
+		return c.Redirect(http.StatusFound, signed)
+	}
+
+	content, err := h.service.storage.Open(r.Ctx, file.Key)
+	if err != nil {
+		if err == uploads.ErrFileNotFound {
+			return r.Error(http.StatusNotFound, "The file of the material is missing")
+		}
+		return r.ServerError(err)
+	}
+	defer content.Close()
+
+	header.Set("Content-Type", file.MimeType)
+	header.Set("Content-Disposition", disposition)
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	// the local storage opens seekable files, ServeContent answers the ranges and conditional requests
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), req, "", file.ModTime, seeker)
+		return nil
+	}
+
+	header.Set("Accept-Ranges", "none")
+	return c.Stream(http.StatusOK, file.MimeType, content)
+}
+
+func isFirstRange(rangeHeader string) bool {
+	return rangeHeader == "" || strings.HasPrefix(strings.ReplaceAll(rangeHeader, " ", ""), "bytes=0-")
+}
+
+// HideStaticFiles keeps the material files and the files attached to feed posts in the static folder from being
+// served as static files, they are downloaded only through DownloadMaterialFile and the attachment download of feeds
+func HideStaticFiles() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// the same cleaning as the static handler does
+			p, err := url.PathUnescape(c.Param("*"))
+			if err != nil {
+				return next(c)
+			}
+			parts := strings.Split(path.Clean(strings.TrimPrefix(p, "/")), "/")
+
+			// uploads/<courseId>/materials/... and uploads/<courseId>/feed/...
+			if len(parts) > 3 && parts[0] == "uploads" && (parts[2] == "materials" || parts[2] == "feed") {
+				return c.JSON(http.StatusNotFound, map[string]string{
+					"message": "Not Found",
+				})
+			}
+			return next(c)
+		}
+	}
+}
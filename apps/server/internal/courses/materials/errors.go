@@ -1,9 +1,45 @@
 package materials
 
-import "errors"
+import (
+	"errors"
+
+	"tourbackend/internal/uploads"
+)
 
 var (
-	ErrFileTooBig        = errors.New("too big material file max is 30MB")
-	ErrFileTypeForbidden = errors.New("forbidden file type")
+	// the checks of uploaded files are shared with the attachments of feed posts
+	ErrFileTooBig        = uploads.ErrFileTooBig
+	ErrFileTypeForbidden = uploads.ErrFileTypeForbidden
+	ErrQuotaExceeded     = uploads.ErrQuotaExceeded
 	ErrCourseNotFound    = errors.New("unknown course id")
+
+	// downloads
+	ErrMaterialNotFound      = errors.New("unknown material id")
+	ErrMaterialNotAccessible = errors.New("material is not open")
+	ErrNoThumbnail           = errors.New("material has no thumbnail")
+
+	// previews of url materials
+	ErrPageNotLoaded = errors.New("the page of the url couldn't be loaded")
+
+	// video materials
+	ErrNotAVideo = errors.New("url is not a YouTube or Vimeo video or an mp4 file")
+
+	// zip import
+	ErrArchiveTooBig         = errors.New("the archive is too big")
+	ErrArchiveExtractsTooBig = errors.New("the files of the archive are too big together")
+	ErrBadArchive            = errors.New("the file is not a zip archive")
+	ErrModuleNotFound        = errors.New("unknown module id")
+
+	// versions
+	ErrVersionNotFound  = errors.New("unknown version of the material")
+	ErrVersionIsCurrent = errors.New("the current version of the material can't be removed")
+
+	// resumable uploads
+	ErrFileOverLimit     = errors.New("file is bigger than the upload limit of the course")
+	ErrUploadNotFound    = errors.New("unknown or expired upload")
+	ErrUploadNotFinished = errors.New("upload is not finished")
+	ErrUploadLocked      = errors.New("another chunk of the upload is being received")
+	ErrOffsetMismatch    = errors.New("Upload-Offset doesn't match the received bytes of the upload")
+	ErrBadChecksum       = errors.New("invalid checksum, it has to be sha1 or sha256 and the base64 digest")
+	ErrChecksumMismatch  = errors.New("checksum doesn't match the received bytes")
 )
@@ -1,11 +1,15 @@
 package materials
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -33,7 +37,7 @@ func (h *Handler) ListMaterials(c echo.Context) error {
 	courseId := c.Param("courseId")
 	req := c.Request()
 
-	mats, err := h.service.ListMaterials(courseId, req.Host, req.URL.Scheme, r.Ctx)
+	mats, err := h.service.ListMaterials(courseId, req.Host, c.Scheme(), r.Ctx)
 	if err != nil {
 		if err == ErrCourseNotFound {
 			return r.Error(http.StatusNotFound, "Unknown course id")
@@ -69,10 +73,33 @@ type CreateFileMaterialRequest struct {
 
 	Description string `form:"description"`
 
+	// id of a finished resumable upload, sent instead of the file
+	UploadId string `form:"uploadId"`
+
 	ModuleId    string `param:"moduleId"`
 	ModuleOrder int    `json:"moduleOrder"`
 }
 
+// translates the errors of the file of a material to responses
+func fileError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrFileTooBig:
+		return r.Error(http.StatusBadRequest, "file is too big")
+	case ErrFileOverLimit, ErrQuotaExceeded:
+		return r.Error(http.StatusRequestEntityTooLarge, err.Error())
+	case ErrUploadNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrUploadNotFinished:
+		return r.Error(http.StatusConflict, err.Error())
+	case ErrCourseNotFound:
+		return r.Error(http.StatusNotFound, "Unknown course id")
+	}
+	if errors.Is(err, ErrFileTypeForbidden) {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+	return r.ServerError(err)
+}
+
 func (h *Handler) createFileMaterial(r *handlers.RequestCtx) error {
 	var req CreateFileMaterialRequest
 	if err := r.Echo.Bind(&req); err != nil {
@@ -87,22 +114,20 @@ func (h *Handler) createFileMaterial(r *handlers.RequestCtx) error {
 		return r.Error(http.StatusBadRequest, "name is required")
 	}
 
-	file, err := r.Echo.FormFile("file")
-	if err != nil {
-		return r.Error(http.StatusBadRequest, "file is required")
+	file := MaterialFile{UploadId: req.UploadId}
+	if file.UploadId == "" {
+		header, err := r.Echo.FormFile("file")
+		if err != nil {
+			return r.Error(http.StatusBadRequest, "file or uploadId is required")
+		}
+		file.Header = header
 	}
 
 	httpReq := r.Echo.Request()
 
-	mat, err := h.service.CreateFileMaterial(&req, uuid.NewString(), file, r.Echo.Scheme(), httpReq.Host, r.Ctx)
+	mat, err := h.service.CreateFileMaterial(&req, uuid.NewString(), file, r.User.ID, r.Echo.Scheme(), httpReq.Host, r.Ctx)
 	if err != nil {
-		if err == ErrFileTooBig {
-			return r.Error(http.StatusBadRequest, "file is too big")
-		}
-		if err == ErrFileTypeForbidden {
-			return r.Error(http.StatusBadRequest, "file type forbidden")
-		}
-		return r.ServerError(err)
+		return fileError(r, err)
 	}
 
 	if req.ModuleId != "" {
@@ -130,6 +155,9 @@ type CreateUrlMaterialRequest struct {
 
 	Description string `json:"description"`
 
+	// only for video materials, when the page of the video doesn't have it
+	DurationSeconds *int `json:"durationSeconds"`
+
 	ModuleId    string `param:"moduleId"`
 	ModuleOrder int    `json:"moduleOrder"`
 }
@@ -140,17 +168,23 @@ func (h *Handler) createUrlMaterial(r *handlers.RequestCtx) error {
 		return r.Error(http.StatusBadRequest, err.Error())
 	}
 
-	if req.MatType != "url" {
-		return r.Error(http.StatusBadRequest, "only url material can be created through form")
+	if req.MatType != "url" && req.MatType != "video" {
+		return r.Error(http.StatusBadRequest, "only url and video materials can be created from json")
 	}
 
 	if req.Name == "" {
 		return r.Error(http.StatusBadRequest, "name is required")
 	}
 
-	mat, err := h.service.CreateUrlMaterial(req, uuid.NewString(), r.Ctx)
+	var mat Material
+	var err error
+	if req.MatType == "video" {
+		mat, err = h.service.CreateVideoMaterial(req, uuid.NewString(), r.Ctx)
+	} else {
+		mat, err = h.service.CreateUrlMaterial(req, uuid.NewString(), r.Ctx)
+	}
 	if err != nil {
-		return r.ServerError(err)
+		return videoError(r, err)
 	}
 
 	if req.ModuleId != "" {
@@ -192,6 +226,9 @@ type UpdateFileMaterialRequest struct {
 
 	Name        *string `form:"name"`
 	Description *string `form:"description"`
+
+	// id of a finished resumable upload with the new file
+	UploadId string `form:"uploadId"`
 }
 
 func (h *Handler) updateFileMaterial(r *handlers.RequestCtx) error {
@@ -200,22 +237,18 @@ func (h *Handler) updateFileMaterial(r *handlers.RequestCtx) error {
 		return r.Error(http.StatusBadRequest, err.Error())
 	}
 
-	file, err := r.Echo.FormFile("file")
-	if err != nil {
-		file = nil
+	var file *MaterialFile
+	if req.UploadId != "" {
+		file = &MaterialFile{UploadId: req.UploadId}
+	} else if header, err := r.Echo.FormFile("file"); err == nil {
+		file = &MaterialFile{Header: header}
 	}
 
 	httpReq := r.Echo.Request()
 
-	mat, err := h.service.UpdateFileMaterial(&req, file, r.Echo.Scheme(), httpReq.Host, r.Ctx)
+	mat, err := h.service.UpdateFileMaterial(&req, file, r.User.ID, r.Echo.Scheme(), httpReq.Host, r.Ctx)
 	if err != nil {
-		if err == ErrFileTooBig {
-			return r.Error(http.StatusBadRequest, "file is too big")
-		}
-		if err == ErrFileTypeForbidden {
-			return r.Error(http.StatusBadRequest, "file type forbidden")
-		}
-		return r.ServerError(err)
+		return fileError(r, err)
 	}
 
 	return r.Echo.JSON(http.StatusCreated, mat)
@@ -228,17 +261,31 @@ type UpdateUrlMaterialRequest struct {
 	Name        *string `json:"name"`
 	Url         *string `json:"url"`
 	Description *string `json:"description"`
+
+	// only for video materials, 0 removes it
+	DurationSeconds *int `json:"durationSeconds"`
 }
 
+// url and video materials
 func (h *Handler) updateUrlMaterial(r *handlers.RequestCtx) error {
 	var req UpdateUrlMaterialRequest
 	if err := r.Echo.Bind(&req); err != nil {
 		return r.Error(http.StatusBadRequest, err.Error())
 	}
 
-	mat, err := h.service.UpdateUrlMaterial(&req, r.Ctx)
+	matType, err := h.service.MaterialType(req.CourseId, req.MaterialId, r.Ctx)
 	if err != nil {
-		return r.ServerError(err)
+		return videoError(r, err)
+	}
+
+	var mat Material
+	if matType == "video" {
+		mat, err = h.service.UpdateVideoMaterial(&req, r.Ctx)
+	} else {
+		mat, err = h.service.UpdateUrlMaterial(&req, r.Ctx)
+	}
+	if err != nil {
+		return videoError(r, err)
 	}
 
 	return r.Echo.JSON(http.StatusCreated, mat)
@@ -292,3 +339,228 @@ func (h *Handler) ChangeMaterialInModuleOrder(c echo.Context) error {
 	}
 	return r.JSONMsg(http.StatusCreated, "changed the order")
 }
+
+// Resumable uploads (tus 1.0), the material is created with the uploadId once the upload is finished
+
+const TUS_VERSION = "1.0.0"
+
+// status of tus for a chunk that doesn't match its Upload-Checksum
+const StatusChecksumMismatch = 460
+
+// TusResumable adds the protocol version to the responses and refuses clients of other versions
+func TusResumable() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set("Tus-Resumable", TUS_VERSION)
+
+			if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != TUS_VERSION {
+				c.Response().Header().Set("Tus-Version", TUS_VERSION)
+				return c.JSON(http.StatusPreconditionFailed, map[string]string{
+					"message": "the Tus-Resumable: " + TUS_VERSION + " header is required",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func uploadError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrUploadLocked:
+		return r.Error(http.StatusLocked, err.Error())
+	case ErrOffsetMismatch:
+		return r.Error(http.StatusConflict, err.Error())
+	case ErrChecksumMismatch:
+		return r.Error(StatusChecksumMismatch, err.Error())
+	case ErrBadChecksum:
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+
+	return fileError(r, err)
+}
+
+// Upload-Metadata is a list of "key base64(value)" separated by commas
+func parseUploadMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
+
+func setUploadHeaders(c echo.Context, upload UploadResponse) {
+	header := c.Response().Header()
+	header.Set("Upload-Offset", strconv.FormatInt(upload.ReceivedBytes, 10))
+	header.Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	header.Set("Cache-Control", "no-store")
+
+	if expiresAt, err := time.Parse(time.RFC3339, upload.ExpiresAt); err == nil {
+		header.Set("Upload-Expires", expiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// OPTIONS /courses/{courseId}/uploads
+func (h *Handler) UploadOptions(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	limit, err := h.service.UploadLimit(c.Param("courseId"), r.Ctx)
+	if err != nil {
+		return fileError(r, err)
+	}
+
+	header := c.Response().Header()
+	header.Set("Tus-Version", TUS_VERSION)
+	header.Set("Tus-Extension", "creation,checksum,termination,expiration")
+	header.Set("Tus-Checksum-Algorithm", "sha1,sha256")
+	header.Set("Tus-Max-Size", strconv.FormatInt(limit, 10))
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// POST /courses/{courseId}/uploads
+// Upload-Length is the size of the file, Upload-Metadata has its filename and optionally
+// the checksum of the whole file ("sha256 <base64 digest>")
+func (h *Handler) CreateUpload(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	size, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, "Upload-Length is required")
+	}
+
+	metadata := parseUploadMetadata(c.Request().Header.Get("Upload-Metadata"))
+	if metadata["filename"] == "" {
+		return r.Error(http.StatusBadRequest, "filename is required in Upload-Metadata")
+	}
+
+	upload, err := h.service.CreateUpload(CreateUploadParams{
+		CourseId: c.Param("courseId"),
+		UserId:   r.User.ID,
+		Filename: metadata["filename"],
+		Size:     size,
+		Checksum: metadata["checksum"],
+	}, r.Ctx)
+	if err != nil {
+		return uploadError(r, err)
+	}
+
+	setUploadHeaders(c, upload)
+	// relative to the url of the request, so it works behind the proxy adding /api too
+	c.Response().Header().Set("Location", "uploads/"+upload.Uuid)
+
+	return c.JSON(http.StatusCreated, upload)
+}
+
+// HEAD /courses/{courseId}/uploads/{uploadId}
+func (h *Handler) UploadStatus(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	upload, err := h.service.GetUpload(c.Param("courseId"), c.Param("uploadId"), r.Ctx)
+	if err != nil {
+		if err == ErrUploadNotFound {
+			return c.NoContent(http.StatusNotFound)
+		}
+		return uploadError(r, err)
+	}
+
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusOK)
+}
+
+// PATCH /courses/{courseId}/uploads/{uploadId}
+// the body is the chunk starting at Upload-Offset, Upload-Checksum optionally has its checksum
+func (h *Handler) UploadChunk(c echo.Context) error {
+	r := h.NewReqCtx(c)
+	req := c.Request()
+
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return r.Error(http.StatusUnsupportedMediaType, "Content-Type has to be application/offset+octet-stream")
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return r.Error(http.StatusBadRequest, "Upload-Offset is required")
+	}
+
+	upload, err := h.service.UploadChunk(UploadChunkParams{
+		CourseId: c.Param("courseId"),
+		UploadId: c.Param("uploadId"),
+		Offset:   offset,
+		Length:   req.ContentLength,
+		Checksum: req.Header.Get("Upload-Checksum"),
+		Body:     req.Body,
+	}, r.Ctx)
+	if err != nil {
+		return uploadError(r, err)
+	}
+
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DELETE /courses/{courseId}/uploads/{uploadId}
+func (h *Handler) CancelUpload(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	err := h.service.CancelUpload(c.Param("courseId"), c.Param("uploadId"), r.Ctx)
+	if err != nil {
+		return uploadError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type UploadLimitRequest struct {
+	// nil sets the default limit
+	MaxUploadSize *int64 `json:"maxUploadSize"`
+}
+
+type UploadLimitResponse struct {
+	MaxUploadSize        int64 `json:"maxUploadSize"`
+	DefaultMaxUploadSize int64 `json:"defaultMaxUploadSize"`
+}
+
+// GET /courses/{courseId}/upload-limit
+func (h *Handler) GetUploadLimit(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	limit, err := h.service.UploadLimit(c.Param("courseId"), r.Ctx)
+	if err != nil {
+		return fileError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, UploadLimitResponse{MaxUploadSize: limit, DefaultMaxUploadSize: MAX_UPLOAD_SIZE})
+}
+
+// PUT /courses/{courseId}/upload-limit
+func (h *Handler) SetUploadLimit(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req UploadLimitRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	limit, err := h.service.SetUploadLimit(c.Param("courseId"), req.MaxUploadSize, r.Ctx)
+	if err != nil {
+		return uploadError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, UploadLimitResponse{MaxUploadSize: limit, DefaultMaxUploadSize: MAX_UPLOAD_SIZE})
+}
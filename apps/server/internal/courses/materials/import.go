@@ -0,0 +1,669 @@
+package materials
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+//* bulk import of file materials from a zip archive - every file of the archive becomes a file material, an optional
+// manifest.json at its root gives them names, descriptions and modules. The files are checked first like the ones
+// uploaded one by one, nothing is created when any of them is refused and the response says what's wrong with each
+
+// largest archive that can be imported
+var IMPORT_MAX_SIZE = int64(512 * 1024 * 1024)
+
+// most files in one archive
+var IMPORT_MAX_FILES = 200
+
+// most bytes extracted from one archive, a small archive can unpack to much more than IMPORT_MAX_SIZE,
+// the extraction also stops when the files don't fit into the free storage of the course
+var IMPORT_MAX_EXTRACTED_SIZE = int64(1024 * 1024 * 1024)
+
+// largest manifest.json
+const maxManifestSize = 1024 * 1024
+
+const importManifestName = "manifest.json"
+
+// ImportManifest describes the files of the archive, files it doesn't list are imported with their file name
+// into the module of the request
+type ImportManifest struct {
+	Materials []ImportManifestEntry `json:"materials"`
+}
+
+type ImportManifestEntry struct {
+	// path of the file in the archive
+	File        string `json:"file"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// id or name of the module of the course, the module of the request when empty
+	Module string `json:"module"`
+}
+
+// ImportedFile is the result of one file of the archive
+type ImportedFile struct {
+	File string `json:"file"`
+
+	// the created material
+	Material Material `json:"material,omitempty"`
+	// why the file can't be imported
+	Error string `json:"error,omitempty"`
+}
+
+// ImportError is returned when some files of the archive were refused, no material was created
+type ImportError struct {
+	Files []ImportedFile
+}
+
+func (e *ImportError) Error() string {
+	failed := 0
+	for _, file := range e.Files {
+		if file.Error != "" {
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d files of the archive can't be imported, nothing was imported", failed, len(e.Files))
+}
+
+// a file of the archive on its way to become a material
+type importEntry struct {
+	file     *zip.File
+	name     string
+	path     string
+	manifest *ImportManifestEntry
+
+	materialId  string
+	moduleId    string
+	moduleOrder int
+
+	// the checked file extracted to the temporary directory
+	extracted string
+	mime      string
+	size      int64
+
+	stored storedFile
+	err    string
+}
+
+type ImportMaterialsParams struct {
+	CourseId string
+	// module of the files the manifest doesn't put elsewhere
+	ModuleId string
+	Archive  *multipart.FileHeader
+
+	// the lecturer importing the files, kept with the versions
+	UserId int
+	Scheme string
+	Host   string
+}
+
+// paths in the archive are compared without ./ and the leading slash
+func archivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// folders and the files zip tools and systems add on their own
+func isArchiveJunk(f *zip.File) bool {
+	name := archivePath(f.Name)
+	return f.FileInfo().IsDir() || strings.HasSuffix(f.Name, "/") || name == "" ||
+		strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
+
+func readImportManifest(f *zip.File) (*ImportManifest, error) {
+	src, err := f.Open()
+	if err != nil {
+		return nil, &utils.ErrBadRequest{Message: "manifest.json can't be read: " + err.Error()}
+	}
+	defer src.Close()
+
+	decoder := json.NewDecoder(io.LimitReader(src, maxManifestSize))
+	decoder.DisallowUnknownFields()
+
+	var manifest ImportManifest
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, &utils.ErrBadRequest{Message: "invalid manifest.json: " + err.Error()}
+	}
+	return &manifest, nil
+}
+
+// importEntries pairs the files of the archive with the manifest - the listed files first in its order,
+// then the others in the order of the archive, entries of missing files are kept with their error
+func importEntries(archive *zip.Reader) ([]*importEntry, error) {
+	var manifest *ImportManifest
+	files := []*zip.File{}
+	byPath := map[string]*importEntry{}
+	duplicates := map[string]bool{}
+
+	for _, f := range archive.File {
+		if isArchiveJunk(f) {
+			continue
+		}
+		name := archivePath(f.Name)
+		if name == importManifestName {
+			m, err := readImportManifest(f)
+			if err != nil {
+				return nil, err
+			}
+			manifest = m
+			continue
+		}
+
+		if _, ok := byPath[name]; ok {
+			duplicates[name] = true
+			continue
+		}
+		files = append(files, f)
+		byPath[name] = &importEntry{file: f, path: name}
+	}
+
+	if len(files) == 0 {
+		return nil, &utils.ErrBadRequest{Message: "the archive has no files"}
+	}
+	if len(files) > IMPORT_MAX_FILES {
+		return nil, &utils.ErrBadRequest{Message: fmt.Sprintf("the archive can have at most %v files", IMPORT_MAX_FILES)}
+	}
+
+	entries := make([]*importEntry, 0, len(files))
+	listed := map[string]bool{}
+
+	if manifest != nil {
+		for i := range manifest.Materials {
+			m := &manifest.Materials[i]
+			name := archivePath(m.File)
+
+			entry, ok := byPath[name]
+			switch {
+			case m.File == "":
+				entry = &importEntry{path: fmt.Sprintf("%s #%d", importManifestName, i+1), err: "the file of the material is missing in the manifest"}
+			case listed[name]:
+				entry = &importEntry{path: name, err: "the file is listed in the manifest more than once"}
+			case !ok:
+				entry = &importEntry{path: name, err: "the file is not in the archive"}
+			default:
+				entry.manifest = m
+				listed[name] = true
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, f := range files {
+		if name := archivePath(f.Name); !listed[name] {
+			entries = append(entries, byPath[name])
+		}
+	}
+
+	for _, entry := range entries {
+		if duplicates[entry.path] && entry.err == "" {
+			entry.err = "the archive has more files with this path"
+		}
+	}
+	return entries, nil
+}
+
+// finds the module by its id or name, modules of the course only
+func findModule(modules []db.Module, ref string) (string, error) {
+	var found []db.Module
+	for _, module := range modules {
+		if module.Uuid == ref {
+			return module.Uuid, nil
+		}
+		if strings.EqualFold(strings.TrimSpace(module.Name), strings.TrimSpace(ref)) {
+			found = append(found, module)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("unknown module %q", ref)
+	case 1:
+		return found[0].Uuid, nil
+	default:
+		return "", fmt.Errorf("more modules are called %q, use the id of the module", ref)
+	}
+}
+
+// errors of reading the archive, the other ones are errors of the server
+func isArchiveError(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrAlgorithm) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt)
+}
+
+// the files extracted from the archive don't fit into the budget of the import
+var errImportBudget = errors.New("import budget exceeded")
+
+// extractImportFile copies the file of the entry to dir and checks it like storeMaterialFile,
+// a refused file sets the error of the entry. At most budget bytes are written, errImportBudget stops
+// the import when the file needs more, the written bytes are returned either way
+func extractImportFile(entry *importEntry, dir string, index int, limit int64, budget int64) (int64, error) {
+	f := entry.file
+
+	// the sizes in the archive can lie, the copy is limited too
+	maxSize := min(uploads.MAX_SIZE, limit)
+	if f.Flags&0x1 != 0 {
+		entry.err = "encrypted files are not supported"
+		return 0, nil
+	}
+	if f.UncompressedSize64 > uint64(uploads.MAX_SIZE) {
+		entry.err = ErrFileTooBig.Error()
+		return 0, nil
+	}
+	if f.UncompressedSize64 > uint64(limit) {
+		entry.err = ErrFileOverLimit.Error()
+		return 0, nil
+	}
+	if f.UncompressedSize64 > uint64(max(budget, 0)) {
+		return 0, errImportBudget
+	}
+
+	src, err := f.Open()
+	if err != nil {
+		if isArchiveError(err) {
+			entry.err = "the file is damaged in the archive"
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer src.Close()
+
+	entry.extracted = filepath.Join(dir, strconv.Itoa(index))
+	dst, err := os.Create(entry.extracted)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	copyLimit := min(maxSize, budget)
+	size, err := io.Copy(dst, io.LimitReader(src, copyLimit+1))
+	if err != nil {
+		if isArchiveError(err) {
+			entry.err = "the file is damaged in the archive"
+			return size, nil
+		}
+		return size, err
+	}
+	if size > copyLimit {
+		if copyLimit < maxSize {
+			return size, errImportBudget
+		}
+		entry.err = ErrFileTooBig.Error()
+		return size, nil
+	}
+
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		return size, err
+	}
+	mime, err := uploads.CheckContent(dst, size, entry.path)
+	if err != nil {
+		if errors.Is(err, ErrFileTypeForbidden) {
+			entry.err = err.Error()
+			return size, nil
+		}
+		return size, err
+	}
+
+	entry.mime = mime
+	entry.size = size
+	return size, nil
+}
+
+// saves the extracted file to the storage like storeMaterialFile
+func (s *Service) storeImportedFile(courseId string, entry *importEntry, ctx context.Context) error {
+	src, err := os.Open(entry.extracted)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	versionId := uuid.NewString()
+	stored := storedFile{
+		versionId: versionId,
+		key:       materialFileKey(courseId, entry.materialId, versionId, uploads.MIME_TO_EXT[entry.mime]),
+		mime:      entry.mime,
+		size:      entry.size,
+	}
+
+	err = s.storage.Save(ctx, stored.key, src, stored.size, stored.mime)
+	if err != nil {
+		return err
+	}
+
+	s.createPreview(&stored, src, ctx)
+	entry.stored = stored
+	return nil
+}
+
+// next free place at the end of the module
+func (s *Service) nextModuleOrder(courseId string, moduleId string, ctx context.Context) (int, error) {
+	contents, err := s.q.GetModuleContents(ctx, db.GetModuleContentsParams{
+		ModuleUuid:   moduleId,
+		CourseUuid:   courseId,
+		ModuleUuid_2: moduleId,
+		CourseUuid_2: courseId,
+		ModuleUuid_3: moduleId,
+		CourseUuid_3: courseId,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	next := 0
+	for _, item := range contents {
+		next = max(next, int(item.Order)+1)
+	}
+	return next, nil
+}
+
+// creates the materials of the stored files in one transaction
+func (s *Service) createImportedMaterials(courseId string, entries []*importEntry, userId int, ctx context.Context) error {
+	tx, err := s.database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := s.q.WithTx(tx)
+	now := time.Now().Unix()
+
+	for _, entry := range entries {
+		_, err := q.CreateMaterial(ctx, db.CreateMaterialParams{
+			Uuid:        entry.materialId,
+			CourseUuid:  courseId,
+			Name:        entry.name,
+			Description: entry.description(),
+			Url:         entry.stored.key,
+			Type:        "file",
+			MimeType:    sql.NullString{String: entry.stored.mime, Valid: true},
+			ByteSize:    sql.NullInt64{Int64: entry.stored.size, Valid: true},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.CreateMaterialVersion(ctx, materialVersionParams(entry.materialId, entry.stored, userId))
+		if err != nil {
+			return err
+		}
+
+		if entry.moduleId != "" {
+			_, err = q.AssignMaterialToModule(ctx, db.AssignMaterialToModuleParams{
+				ModuleUuid:   entry.moduleId,
+				MaterialUuid: entry.materialId,
+				Order:        int64(entry.moduleOrder),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (e *importEntry) description() string {
+	if e.manifest == nil {
+		return ""
+	}
+	return e.manifest.Description
+}
+
+func importResults(entries []*importEntry) []ImportedFile {
+	files := make([]ImportedFile, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, ImportedFile{File: entry.path, Error: entry.err})
+	}
+	return files
+}
+
+// ImportMaterials creates a file material of every file of the zip archive, either all of them or none
+func (s *Service) ImportMaterials(params ImportMaterialsParams, ctx context.Context) ([]ImportedFile, error) {
+	if params.Archive.Size > IMPORT_MAX_SIZE {
+		return nil, ErrArchiveTooBig
+	}
+
+	limit, err := s.UploadLimit(params.CourseId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the extraction stops as soon as the files can't fit, not after all of them were written
+	usage, err := uploads.CourseUsage(ctx, s.q, params.CourseId)
+	if err != nil {
+		return nil, err
+	}
+	budget := IMPORT_MAX_EXTRACTED_SIZE
+	quotaBound := usage.FreeBytes != nil && *usage.FreeBytes < budget
+	if quotaBound {
+		budget = *usage.FreeBytes
+	}
+
+	modules, err := s.q.ListAllModules(ctx, params.CourseId)
+	if err != nil {
+		return nil, err
+	}
+	if params.ModuleId != "" {
+		if !hasModule(modules, params.ModuleId) {
+			return nil, ErrModuleNotFound
+		}
+	}
+
+	src, err := params.Archive.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	archive, err := zip.NewReader(src, params.Archive.Size)
+	if err != nil {
+		return nil, ErrBadArchive
+	}
+
+	entries, err := importEntries(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "tda-import-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	failed := false
+	total := int64(0)
+	// all the written bytes, the refused files too
+	extracted := int64(0)
+	orders := map[string]int{}
+
+	for i, entry := range entries {
+		if entry.err == "" {
+			entry.name = strings.TrimSuffix(path.Base(entry.path), path.Ext(entry.path))
+			entry.moduleId = params.ModuleId
+
+			if entry.manifest != nil && strings.TrimSpace(entry.manifest.Name) != "" {
+				entry.name = strings.TrimSpace(entry.manifest.Name)
+			}
+			if entry.manifest != nil && entry.manifest.Module != "" {
+				moduleId, err := findModule(modules, entry.manifest.Module)
+				if err != nil {
+					entry.err = err.Error()
+				}
+				entry.moduleId = moduleId
+			}
+			if entry.err == "" && entry.moduleId == "" && !MATERIAL_CAN_EXIST_ALONE {
+				entry.err = "material must always be part of a module, set the moduleId or the module in the manifest"
+			}
+		}
+
+		if entry.err == "" {
+			written, err := extractImportFile(entry, dir, i, limit, budget-extracted)
+			extracted += written
+			if err == errImportBudget {
+				if quotaBound {
+					return nil, ErrQuotaExceeded
+				}
+				return nil, ErrArchiveExtractsTooBig
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if entry.err != "" {
+			failed = true
+			continue
+		}
+		total += entry.size
+
+		// after the materials already in the module, in the order of the archive
+		if entry.moduleId != "" {
+			order, ok := orders[entry.moduleId]
+			if !ok {
+				order, err = s.nextModuleOrder(params.CourseId, entry.moduleId, ctx)
+				if err != nil {
+					return nil, err
+				}
+			}
+			entry.moduleOrder = order
+			orders[entry.moduleId] = order + 1
+		}
+	}
+
+	if failed {
+		return nil, &ImportError{Files: importResults(entries)}
+	}
+
+	err = uploads.CheckQuota(ctx, s.q, params.CourseId, total)
+	if err != nil {
+		return nil, err
+	}
+
+	// the files are saved before the transaction, they are removed again when the materials can't be created
+	removeStored := func() {
+		for _, entry := range entries {
+			if entry.stored.key != "" {
+				s.removeStoredFile(entry.stored)
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		entry.materialId = uuid.NewString()
+		err := s.storeImportedFile(params.CourseId, entry, ctx)
+		if err != nil {
+			removeStored()
+			return nil, err
+		}
+	}
+
+	err = s.createImportedMaterials(params.CourseId, entries, params.UserId, ctx)
+	if err != nil {
+		removeStored()
+		return nil, err
+	}
+
+	files := importResults(entries)
+	for i, entry := range entries {
+		files[i].Material = FileMaterial{
+			Uuid:        entry.materialId,
+			Type:        "file",
+			Name:        entry.name,
+			Description: entry.description(),
+			FileUrl:     s.fileUrl(params.CourseId, entry.materialId, params.Scheme, params.Host),
+			MimeType:    entry.stored.mime,
+			SizeBytes:   int(entry.stored.size),
+
+			ThumbnailUrl: s.thumbnailUrl(params.CourseId, entry.materialId, sql.NullString{String: entry.stored.thumbnailKey, Valid: entry.stored.thumbnailKey != ""}, params.Scheme, params.Host),
+			PreviewText:  utils.FromSqlNullString(sql.NullString{String: entry.stored.previewText, Valid: entry.stored.previewText != ""}),
+
+			ModuleId:    entry.moduleId,
+			ModuleOrder: entry.moduleOrder,
+		}
+	}
+
+	s.feedsService.CreateAutomaticPost(fmt.Sprintf("%d new file materials published", len(entries)), params.CourseId, ctx)
+	s.feedsService.BroadcastCourseChanged(fmt.Sprintf("%d materials imported", len(entries)), params.CourseId)
+	return files, nil
+}
+
+func hasModule(modules []db.Module, moduleId string) bool {
+	for _, module := range modules {
+		if module.Uuid == moduleId {
+			return true
+		}
+	}
+	return false
+}
+
+type ImportMaterialsResponse struct {
+	Message string         `json:"message"`
+	Files   []ImportedFile `json:"files"`
+}
+
+// POST /courses/{courseId}/materials/import
+func (h *Handler) ImportMaterials(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	archive, err := c.FormFile("file")
+	if err != nil {
+		return r.Error(http.StatusBadRequest, "file with the zip archive is required")
+	}
+
+	files, err := h.service.ImportMaterials(ImportMaterialsParams{
+		CourseId: c.Param("courseId"),
+		ModuleId: c.FormValue("moduleId"),
+		Archive:  archive,
+		UserId:   r.User.ID,
+		Scheme:   c.Scheme(),
+		Host:     c.Request().Host,
+	}, r.Ctx)
+	if err != nil {
+		var importErr *ImportError
+		if errors.As(err, &importErr) {
+			return c.JSON(http.StatusBadRequest, ImportMaterialsResponse{Message: importErr.Error(), Files: importErr.Files})
+		}
+
+		switch err {
+		case ErrArchiveTooBig, ErrArchiveExtractsTooBig:
+			return r.Error(http.StatusRequestEntityTooLarge, err.Error())
+		case ErrBadArchive:
+			return r.Error(http.StatusBadRequest, err.Error())
+		case ErrModuleNotFound:
+			return r.Error(http.StatusNotFound, err.Error())
+		}
+
+		var ebr *utils.ErrBadRequest
+		if errors.As(err, &ebr) {
+			return r.Error(http.StatusBadRequest, ebr.Error())
+		}
+		return fileError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, ImportMaterialsResponse{
+		Message: fmt.Sprintf("%d materials imported", len(files)),
+		Files:   files,
+	})
+}
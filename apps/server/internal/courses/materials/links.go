@@ -0,0 +1,300 @@
+package materials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* previews of the pages of url and video materials - the title, description, image and favicon of the page are fetched
+// in the background by the link refresher (see internal/linkpreview), kept in link_preview by the url so the materials
+// with the same url share them and fetched again after LINK_PREVIEW_MAX_AGE, a failed fetch keeps the old preview
+// and is retried after LINK_PREVIEW_RETRY_DELAY, doubled with every failure
+
+var LINK_PREVIEW_MAX_AGE = 7 * 24 * time.Hour
+var LINK_PREVIEW_RETRY_DELAY = time.Hour
+
+// how often the refresher looks for due previews, new urls wake it up right away
+var LINK_REFRESHER_INTERVAL = time.Minute
+
+// how many pages are fetched at once
+var LINK_REFRESHER_BATCH_SIZE = 10
+
+// how often the previews of urls no material has are removed
+var LINK_REFRESHER_CLEANUP_INTERVAL = time.Hour
+
+// a claimed preview is not fetched by another instance for this long, a fetch is two requests (the page and the favicon)
+const linkFetchLease = 3 * time.Minute
+
+// the fields from the page, the favicon of the page replaces the guessed one
+func withLinkPreview(material UrlMaterial, preview db.LinkPreview) UrlMaterial {
+	material.PageTitle = utils.FromSqlNullString(preview.Title)
+	material.PageDescription = utils.FromSqlNullString(preview.Description)
+	material.ImageUrl = utils.FromSqlNullString(preview.ImageUrl)
+
+	if preview.FaviconUrl.Valid {
+		material.FaviconUrl = preview.FaviconUrl.String
+	}
+	if preview.FetchedAt.Valid {
+		fetchedAt := utils.UnixToIso(preview.FetchedAt.Int64)
+		material.PreviewFetchedAt = &fetchedAt
+	}
+	return material
+}
+
+// linkPreview returns the preview of the url, a new url is queued for the refresher and has an empty one
+func (s *Service) linkPreview(url string, ctx context.Context) db.LinkPreview {
+	err := s.q.QueueLinkPreview(ctx, db.QueueLinkPreviewParams{
+		Url:         url,
+		NextFetchAt: time.Now().Unix(),
+	})
+	if err != nil {
+		fmt.Println("failed to queue link preview", url, err)
+		return db.LinkPreview{}
+	}
+	s.wakeLinkRefresher()
+
+	preview, err := s.q.GetLinkPreview(ctx, url)
+	if err != nil {
+		fmt.Println("failed to get link preview", url, err)
+		return db.LinkPreview{}
+	}
+	return preview
+}
+
+func (s *Service) wakeLinkRefresher() {
+	select {
+	case s.linksWake <- struct{}{}:
+	default:
+	}
+}
+
+// refreshLinkPreview fetches the page and saves its metadata, the courses with the url are told to reload
+// when it changed, the error is the one of the fetch
+func (s *Service) refreshLinkPreview(preview db.LinkPreview, ctx context.Context) (db.LinkPreview, error) {
+	meta, fetchErr := s.fetcher.Fetch(ctx, preview.Url)
+	now := time.Now()
+
+	if fetchErr != nil {
+		// a stopped server doesn't count as a failure, the lease runs out and the page is fetched after the start
+		if ctx.Err() != nil {
+			return preview, fetchErr
+		}
+
+		delay := min(LINK_PREVIEW_RETRY_DELAY<<min(preview.Failures, 10), LINK_PREVIEW_MAX_AGE)
+		failed, err := s.q.FailLinkPreview(ctx, db.FailLinkPreviewParams{
+			NextFetchAt: now.Add(delay).Unix(),
+			Error:       sql.NullString{String: fetchErr.Error(), Valid: true},
+			Url:         preview.Url,
+		})
+		if err != nil {
+			return preview, err
+		}
+		return failed, fetchErr
+	}
+
+	saved, err := s.q.SaveLinkPreview(ctx, db.SaveLinkPreviewParams{
+		Title:       sql.NullString{String: meta.Title, Valid: meta.Title != ""},
+		Description: sql.NullString{String: meta.Description, Valid: meta.Description != ""},
+		ImageUrl:    sql.NullString{String: meta.ImageUrl, Valid: meta.ImageUrl != ""},
+		FaviconUrl:  sql.NullString{String: meta.FaviconUrl, Valid: meta.FaviconUrl != ""},
+		Duration:    sql.NullInt64{Int64: int64(meta.Duration), Valid: meta.Duration != 0},
+		FetchedAt:   sql.NullInt64{Int64: now.Unix(), Valid: true},
+		NextFetchAt: now.Add(LINK_PREVIEW_MAX_AGE).Unix(),
+		Url:         preview.Url,
+	})
+	if err != nil {
+		return preview, err
+	}
+
+	if saved.Title != preview.Title || saved.Description != preview.Description ||
+		saved.ImageUrl != preview.ImageUrl || saved.FaviconUrl != preview.FaviconUrl || saved.Duration != preview.Duration {
+		s.broadcastLinkChanged(saved.Url, ctx)
+	}
+	return saved, nil
+}
+
+func (s *Service) broadcastLinkChanged(url string, ctx context.Context) {
+	courses, err := s.q.ListCoursesOfLink(ctx, url)
+	if err != nil {
+		fmt.Println("failed to list courses of link", url, err)
+		return
+	}
+
+	for _, courseId := range courses {
+		s.feedsService.BroadcastCourseChanged("Preview of "+url+" updated", courseId)
+	}
+}
+
+// fetches the due previews in batches until there are none left
+func (s *Service) refreshDueLinkPreviews(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+
+		previews, err := s.q.ClaimDueLinkPreviews(ctx, db.ClaimDueLinkPreviewsParams{
+			LeaseUntil: now.Add(linkFetchLease).Unix(),
+			Now:        now.Unix(),
+			MaxCount:   int64(LINK_REFRESHER_BATCH_SIZE),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("failed to claim link previews:", err)
+			}
+			return
+		}
+
+		done := make(chan struct{}, len(previews))
+		for _, preview := range previews {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				s.refreshLinkPreview(preview, ctx)
+			}()
+		}
+		for range previews {
+			<-done
+		}
+
+		if len(previews) < LINK_REFRESHER_BATCH_SIZE {
+			return
+		}
+	}
+}
+
+// queues the url materials without a preview and removes the previews no material uses
+func (s *Service) cleanUpLinkPreviews(ctx context.Context) {
+	err := s.q.QueueMissingLinkPreviews(ctx, time.Now().Unix())
+	if err != nil {
+		fmt.Println("failed to queue missing link previews:", err)
+	}
+
+	err = s.q.DeleteUnusedLinkPreviews(ctx)
+	if err != nil {
+		fmt.Println("failed to delete unused link previews:", err)
+	}
+}
+
+// RefreshLinkPreview fetches the page of the url or video material right away
+func (s *Service) RefreshLinkPreview(courseId string, materialId string, ctx context.Context) (Material, error) {
+	material, err := s.q.GetMaterial(ctx, materialId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return nil, ErrMaterialNotFound
+		}
+		return nil, err
+	}
+	if material.CourseUuid != courseId || (material.Type != "url" && material.Type != "video") {
+		return nil, ErrMaterialNotFound
+	}
+
+	preview := s.linkPreview(material.Url, ctx)
+	if preview.Url == "" {
+		return nil, fmt.Errorf("no link preview of %v", material.Url)
+	}
+
+	preview, err = s.refreshLinkPreview(preview, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPageNotLoaded, err)
+	}
+
+	if material.Type == "video" {
+		return videoMaterial(material, preview), nil
+	}
+	return withLinkPreview(UrlMaterial{
+		Uuid:          material.Uuid,
+		Type:          material.Type,
+		Name:          material.Name,
+		Description:   material.Description,
+		TimesAccessed: int(material.TimesAccessed),
+		Url:           material.Url,
+		FaviconUrl:    material.FaviconUrl.String,
+	}, preview), nil
+}
+
+// POST /courses/{courseId}/modules/{moduleId}/materials/{materialId}/link-preview
+func (h *Handler) RefreshLinkPreview(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	mat, err := h.service.RefreshLinkPreview(c.Param("courseId"), c.Param("materialId"), r.Ctx)
+	if err != nil {
+		switch {
+		case err == ErrMaterialNotFound:
+			return r.Error(http.StatusNotFound, "Material not found")
+		case errors.Is(err, linkpreview.ErrBadUrl), errors.Is(err, linkpreview.ErrBlockedAddress):
+			return r.Error(http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrPageNotLoaded):
+			return r.Error(http.StatusBadGateway, err.Error())
+		}
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, mat)
+}
+
+type LinkRefresher struct {
+	service *Service
+
+	started bool
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+func NewLinkRefresher(service *Service) *LinkRefresher {
+	return &LinkRefresher{
+		service: service,
+		done:    make(chan struct{}),
+	}
+}
+
+func (l *LinkRefresher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l.started = true
+	l.stop = cancel
+	go l.run(ctx)
+}
+
+func (l *LinkRefresher) run(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(LINK_REFRESHER_INTERVAL)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		// the url materials from before the previews are queued by the first cleanup
+		if time.Since(lastCleanup) > LINK_REFRESHER_CLEANUP_INTERVAL {
+			l.service.cleanUpLinkPreviews(ctx)
+			lastCleanup = time.Now()
+		}
+
+		l.service.refreshDueLinkPreviews(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.service.linksWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the refresher, the pages being fetched are fetched again after the next start
+func (l *LinkRefresher) Close() error {
+	if !l.started {
+		return nil
+	}
+
+	l.stop()
+	<-l.done
+	return nil
+}
@@ -0,0 +1,130 @@
+package materials
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/previews"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* previews of material files - made when the file is stored and kept with its version, the thumbnail is a jpeg
+// in the storage next to the file and is served with the same access check as the file, the preview text is in the db
+
+// the thumbnail of uploads/<courseId>/materials/<materialId>/<versionId>.pdf is <versionId>.thumb.jpeg
+func thumbnailKey(fileKey string) string {
+	return strings.TrimSuffix(fileKey, path.Ext(fileKey)) + ".thumb.jpeg"
+}
+
+// url of the thumbnail, nil when the current file of the material has none
+func (s *Service) thumbnailUrl(courseId string, materialId string, key sql.NullString, scheme string, host string) *string {
+	if !key.Valid {
+		return nil
+	}
+	url := uploads.AbsoluteUrl(scheme, host, uploads.MaterialThumbnailUrl(courseId, materialId))
+	return &url
+}
+
+// createPreview generates the preview of the stored file and saves its thumbnail, the file is kept without
+// a preview when that fails
+func (s *Service) createPreview(stored *storedFile, src io.ReaderAt, ctx context.Context) {
+	preview, err := previews.Generate(src, stored.size, stored.mime)
+	if err != nil {
+		fmt.Println("failed to create preview of", stored.key, err)
+		return
+	}
+	stored.previewText = preview.Text
+
+	if preview.Thumbnail == nil {
+		return
+	}
+
+	key := thumbnailKey(stored.key)
+	err = s.storage.Save(ctx, key, bytes.NewReader(preview.Thumbnail), int64(len(preview.Thumbnail)), "image/jpeg")
+	if err != nil {
+		fmt.Println("failed to save thumbnail of", stored.key, err)
+		return
+	}
+	stored.thumbnailKey = key
+}
+
+// GetMaterialThumbnail returns the key of the thumbnail of the current file when the user can see the material
+func (s *Service) GetMaterialThumbnail(courseId string, materialId string, isAdmin bool, ctx context.Context) (string, error) {
+	material, err := s.q.GetMaterialDownload(ctx, db.GetMaterialDownloadParams{
+		Uuid:       materialId,
+		CourseUuid: courseId,
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return "", ErrMaterialNotFound
+		}
+		return "", err
+	}
+
+	if material.Type != "file" {
+		return "", ErrMaterialNotFound
+	}
+	if !isAdmin && !canSeeMaterial(material) {
+		return "", ErrMaterialNotAccessible
+	}
+	if !material.ThumbnailKey.Valid {
+		return "", ErrNoThumbnail
+	}
+	return uploads.StorageKey(material.ThumbnailKey.String), nil
+}
+
+// GET /courses/{courseId}/materials/{materialId}/thumbnail
+func (h *Handler) GetMaterialThumbnail(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	isAdmin := r.User != nil && r.User.IsAdmin
+
+	key, err := h.service.GetMaterialThumbnail(c.Param("courseId"), c.Param("materialId"), isAdmin, r.Ctx)
+	if err != nil {
+		switch err {
+		case ErrMaterialNotFound:
+			return r.Error(http.StatusNotFound, "Material not found")
+		case ErrNoThumbnail:
+			return r.Error(http.StatusNotFound, err.Error())
+		case ErrMaterialNotAccessible:
+			return r.Error(http.StatusForbidden, "The material is not open")
+		}
+		return r.ServerError(err)
+	}
+
+	content, err := h.service.storage.Open(r.Ctx, key)
+	if err != nil {
+		if err == uploads.ErrFileNotFound {
+			return r.Error(http.StatusNotFound, "The thumbnail of the material is missing")
+		}
+		return r.ServerError(err)
+	}
+	defer content.Close()
+
+	// thumbnails are small, reading them whole gives every storage the conditional requests of ServeContent
+	thumbnail, err := io.ReadAll(content)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", "private, no-cache")
+	header.Set("Content-Type", "image/jpeg")
+	header.Set("X-Content-Type-Options", "nosniff")
+	// every version has its own thumbnail, a new file of the material changes the key
+	header.Set("ETag", `"`+path.Base(key)+`"`)
+
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, bytes.NewReader(thumbnail))
+	return nil
+}
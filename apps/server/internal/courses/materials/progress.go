@@ -0,0 +1,260 @@
+package materials
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* watch progress of video materials - the player of each user reports where it is, the position is where
+// the video continues next time, the furthest position only grows and the video is completed once it reaches
+// VIDEO_COMPLETED_RATIO of the duration (or the player says it ended)
+
+// part of the video the user has to get to for it to count as watched
+var VIDEO_COMPLETED_RATIO = 0.9
+
+type VideoProgress struct {
+	MaterialId string `json:"materialId"`
+
+	PositionSeconds int `json:"positionSeconds"`
+	FurthestSeconds int `json:"furthestSeconds"`
+	// of the material, or as the player of the user reported it, null when unknown
+	DurationSeconds *int `json:"durationSeconds"`
+	Completed       bool `json:"completed"`
+
+	// null when the user hasn't started the video
+	UpdatedAt *string `json:"updatedAt"`
+}
+
+// duration is the one of the material, 0 when it's unknown
+func videoProgress(progress db.VideoProgress, duration int) VideoProgress {
+	updatedAt := utils.UnixToIso(progress.UpdatedAt)
+
+	res := VideoProgress{
+		MaterialId:      progress.MaterialUuid,
+		PositionSeconds: int(progress.PositionSeconds),
+		FurthestSeconds: int(progress.FurthestSeconds),
+		Completed:       progress.Completed,
+		UpdatedAt:       &updatedAt,
+	}
+	if duration == 0 {
+		duration = int(progress.DurationSeconds.Int64)
+	}
+	if duration > 0 {
+		res.DurationSeconds = &duration
+	}
+	return res
+}
+
+// the duration set by the lecturer or from the page of the video, 0 when unknown
+func (s *Service) materialDuration(material db.GetMaterialDownloadRow, ctx context.Context) (int, error) {
+	preview, err := s.q.GetLinkPreview(ctx, material.Url)
+	if err != nil && !utils.IsNoRowsError(err) {
+		return 0, err
+	}
+	return videoDuration(db.Material{DurationSeconds: material.DurationSeconds}, preview), nil
+}
+
+// the video material the user can see
+func (s *Service) getVideo(courseId string, materialId string, isAdmin bool, ctx context.Context) (db.GetMaterialDownloadRow, error) {
+	material, err := s.q.GetMaterialDownload(ctx, db.GetMaterialDownloadParams{
+		Uuid:       materialId,
+		CourseUuid: courseId,
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.GetMaterialDownloadRow{}, ErrMaterialNotFound
+		}
+		return db.GetMaterialDownloadRow{}, err
+	}
+
+	if material.Type != "video" {
+		return db.GetMaterialDownloadRow{}, ErrMaterialNotFound
+	}
+	if !isAdmin && !canSeeMaterial(material) {
+		return db.GetMaterialDownloadRow{}, ErrMaterialNotAccessible
+	}
+	return material, nil
+}
+
+func (s *Service) GetVideoProgress(courseId string, materialId string, userId int, isAdmin bool, ctx context.Context) (VideoProgress, error) {
+	material, err := s.getVideo(courseId, materialId, isAdmin, ctx)
+	if err != nil {
+		return VideoProgress{}, err
+	}
+
+	duration, err := s.materialDuration(material, ctx)
+	if err != nil {
+		return VideoProgress{}, err
+	}
+
+	progress, err := s.q.GetVideoProgress(ctx, db.GetVideoProgressParams{
+		MaterialUuid: materialId,
+		UserID:       int64(userId),
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			res := VideoProgress{MaterialId: materialId}
+			if duration > 0 {
+				res.DurationSeconds = &duration
+			}
+			return res, nil
+		}
+		return VideoProgress{}, err
+	}
+	return videoProgress(progress, duration), nil
+}
+
+type SaveVideoProgressParams struct {
+	CourseId   string
+	MaterialId string
+	UserId     int
+	IsAdmin    bool
+
+	PositionSeconds float64
+	// from the player, used when the material doesn't know its duration
+	DurationSeconds *float64
+	Ended           bool
+}
+
+func (s *Service) SaveVideoProgress(params SaveVideoProgressParams, ctx context.Context) (VideoProgress, error) {
+	if math.IsNaN(params.PositionSeconds) || params.PositionSeconds < 0 || params.PositionSeconds > maxVideoDuration {
+		return VideoProgress{}, &utils.ErrBadRequest{Message: fmt.Sprintf("positionSeconds must be between 0 and %v", maxVideoDuration)}
+	}
+
+	var reported sql.NullInt64
+	if params.DurationSeconds != nil {
+		d := *params.DurationSeconds
+		if math.IsNaN(d) || d <= 0 || d > maxVideoDuration {
+			return VideoProgress{}, &utils.ErrBadRequest{Message: fmt.Sprintf("durationSeconds must be between 0 and %v", maxVideoDuration)}
+		}
+		reported = sql.NullInt64{Int64: int64(math.Round(d)), Valid: true}
+	}
+
+	material, err := s.getVideo(params.CourseId, params.MaterialId, params.IsAdmin, ctx)
+	if err != nil {
+		return VideoProgress{}, err
+	}
+
+	materialDuration, err := s.materialDuration(material, ctx)
+	if err != nil {
+		return VideoProgress{}, err
+	}
+
+	previous, err := s.q.GetVideoProgress(ctx, db.GetVideoProgressParams{
+		MaterialUuid: params.MaterialId,
+		UserID:       int64(params.UserId),
+	})
+	if err != nil && !utils.IsNoRowsError(err) {
+		return VideoProgress{}, err
+	}
+
+	duration := int64(materialDuration)
+	if duration == 0 {
+		duration = max(reported.Int64, previous.DurationSeconds.Int64)
+	}
+
+	position := int64(params.PositionSeconds)
+	if duration > 0 {
+		position = min(position, duration)
+	}
+
+	furthest := max(previous.FurthestSeconds, position)
+	completed := params.Ended || (duration > 0 && float64(furthest) >= VIDEO_COMPLETED_RATIO*float64(duration))
+
+	progress, err := s.q.SaveVideoProgress(ctx, db.SaveVideoProgressParams{
+		MaterialUuid:    params.MaterialId,
+		UserID:          int64(params.UserId),
+		PositionSeconds: position,
+		DurationSeconds: reported,
+		Completed:       completed,
+		UpdatedAt:       time.Now().Unix(),
+	})
+	if err != nil {
+		return VideoProgress{}, err
+	}
+	return videoProgress(progress, materialDuration), nil
+}
+
+// ListVideoProgress returns the progress of the user in the videos of the course they started
+func (s *Service) ListVideoProgress(courseId string, userId int, ctx context.Context) ([]VideoProgress, error) {
+	rows, err := s.q.ListVideoProgressOfCourse(ctx, db.ListVideoProgressOfCourseParams{
+		CourseUuid: courseId,
+		UserID:     int64(userId),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	progress := make([]VideoProgress, 0, len(rows))
+	for _, row := range rows {
+		progress = append(progress, videoProgress(row.VideoProgress, int(row.MaterialDuration)))
+	}
+	return progress, nil
+}
+
+// GET /courses/{courseId}/materials/{materialId}/progress
+func (h *Handler) GetVideoProgress(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	progress, err := h.service.GetVideoProgress(c.Param("courseId"), c.Param("materialId"), r.User.ID, r.User.IsAdmin, r.Ctx)
+	if err != nil {
+		return videoError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, progress)
+}
+
+type SaveVideoProgressRequest struct {
+	PositionSeconds *float64 `json:"positionSeconds"`
+	DurationSeconds *float64 `json:"durationSeconds"`
+	Ended           bool     `json:"ended"`
+}
+
+// PUT /courses/{courseId}/materials/{materialId}/progress
+func (h *Handler) SaveVideoProgress(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req SaveVideoProgressRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+	if req.PositionSeconds == nil {
+		return r.Error(http.StatusBadRequest, "positionSeconds is required")
+	}
+
+	progress, err := h.service.SaveVideoProgress(SaveVideoProgressParams{
+		CourseId:        c.Param("courseId"),
+		MaterialId:      c.Param("materialId"),
+		UserId:          r.User.ID,
+		IsAdmin:         r.User.IsAdmin,
+		PositionSeconds: *req.PositionSeconds,
+		DurationSeconds: req.DurationSeconds,
+		Ended:           req.Ended,
+	}, r.Ctx)
+	if err != nil {
+		return videoError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, progress)
+}
+
+// GET /courses/{courseId}/video-progress
+func (h *Handler) ListVideoProgress(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	progress, err := h.service.ListVideoProgress(c.Param("courseId"), r.User.ID, r.Ctx)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, progress)
+}
@@ -0,0 +1,474 @@
+package materials
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+//* resumable uploads of big material files (tus 1.0 protocol with the creation, checksum, termination
+// and expiration extensions) - the client creates the upload with its size, sends the file in chunks with PATCH
+// and after an interruption asks for the offset with HEAD and continues from there,
+// the finished upload is checked like a file sent in one request and the material is created with its uploadId
+
+// default largest file of a material, courses can have their own limit
+var MAX_UPLOAD_SIZE = int64(2 * 1024 * 1024 * 1024)
+
+// folder of the partly received files, it's not served and with several instances of the server it has to be shared
+var PARTIAL_UPLOADS_PATH = filepath.Join(os.TempDir(), "tda-uploads")
+
+// how long an upload is kept after its last chunk, unfinished or not used by a material
+var UPLOAD_EXPIRATION = 24 * time.Hour
+
+// checksums of the chunks and of the whole file, '<algorithm> <base64 digest>'
+var CHECKSUM_ALGORITHMS = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+type UploadResponse struct {
+	Uuid          string  `json:"uuid"`
+	Filename      string  `json:"filename"`
+	Size          int64   `json:"size"`
+	ReceivedBytes int64   `json:"receivedBytes"`
+	Finished      bool    `json:"finished"`
+	MimeType      *string `json:"mimeType"`
+	ExpiresAt     string  `json:"expiresAt"`
+}
+
+func dbUploadToUpload(upload db.Upload) UploadResponse {
+	res := UploadResponse{
+		Uuid:          upload.Uuid,
+		Filename:      upload.Filename,
+		Size:          upload.Size,
+		ReceivedBytes: upload.ReceivedBytes,
+		Finished:      upload.FinishedAt.Valid,
+		ExpiresAt:     utils.UnixToIso(upload.ExpiresAt),
+	}
+	if upload.MimeType.Valid {
+		res.MimeType = &upload.MimeType.String
+	}
+	return res
+}
+
+func partialUploadPath(uploadId string) string {
+	return filepath.Join(PARTIAL_UPLOADS_PATH, uploadId)
+}
+
+// UploadLimit returns the largest file a material of the course can have
+func (s *Service) UploadLimit(courseId string, ctx context.Context) (int64, error) {
+	limit, err := s.q.GetCourseUploadLimit(ctx, courseId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return 0, ErrCourseNotFound
+		}
+		return 0, err
+	}
+
+	if !limit.Valid {
+		return MAX_UPLOAD_SIZE, nil
+	}
+	return limit.Int64, nil
+}
+
+// SetUploadLimit changes the largest file of the course, nil sets the default MAX_UPLOAD_SIZE
+func (s *Service) SetUploadLimit(courseId string, limit *int64, ctx context.Context) (int64, error) {
+	if limit != nil && *limit <= 0 {
+		return 0, &utils.ErrBadRequest{Message: "maxUploadSize has to be positive"}
+	}
+	if limit != nil && *limit > MAX_UPLOAD_SIZE {
+		return 0, &utils.ErrBadRequest{Message: fmt.Sprintf("maxUploadSize can be at most %v", MAX_UPLOAD_SIZE)}
+	}
+
+	n, err := s.q.SetCourseUploadLimit(ctx, db.SetCourseUploadLimitParams{
+		MaxUploadSize: utils.ToSqlNullInt64(limit),
+		UpdatedAt:     time.Now().Unix(),
+		Uuid:          courseId,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrCourseNotFound
+	}
+
+	return s.UploadLimit(courseId, ctx)
+}
+
+type CreateUploadParams struct {
+	CourseId string
+	UserId   int
+	Filename string
+	Size     int64
+	Checksum string // optional checksum of the whole file
+}
+
+// CreateUpload starts a resumable upload, the type of the file is checked by its extension right away
+// so that the client doesn't send a big file only to learn it's not allowed
+func (s *Service) CreateUpload(params CreateUploadParams, ctx context.Context) (UploadResponse, error) {
+	limit, err := s.UploadLimit(params.CourseId, ctx)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	if params.Size <= 0 {
+		return UploadResponse{}, &utils.ErrBadRequest{Message: "Upload-Length has to be positive"}
+	}
+	if params.Size > limit {
+		return UploadResponse{}, ErrFileOverLimit
+	}
+
+	// the space is taken by the upload from its start, so that it can't be finished only to not fit
+	err = uploads.CheckQuota(ctx, s.q, params.CourseId, params.Size)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	params.Filename = filepath.Base(strings.ReplaceAll(params.Filename, "\\", "/"))
+	if _, ok := uploads.EXT_TO_MIME[strings.ToLower(filepath.Ext(params.Filename))]; !ok {
+		return UploadResponse{}, &uploads.FileTypeError{Reason: "files of type " + filepath.Ext(params.Filename) + " are not allowed"}
+	}
+
+	if params.Checksum != "" {
+		if _, _, err := parseChecksum(params.Checksum); err != nil {
+			return UploadResponse{}, err
+		}
+	}
+
+	err = os.MkdirAll(PARTIAL_UPLOADS_PATH, 0700)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	now := time.Now()
+
+	upload, err := s.q.CreateUpload(ctx, db.CreateUploadParams{
+		Uuid:       uuid.NewString(),
+		CourseUuid: params.CourseId,
+		UserID:     int64(params.UserId),
+		Filename:   params.Filename,
+		Size:       params.Size,
+		Checksum:   sql.NullString{String: params.Checksum, Valid: params.Checksum != ""},
+		CreatedAt:  now.Unix(),
+		UpdatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(UPLOAD_EXPIRATION).Unix(),
+	})
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	return dbUploadToUpload(upload), nil
+}
+
+func (s *Service) getUploadOfCourse(courseId string, uploadId string, ctx context.Context) (db.Upload, error) {
+	upload, err := s.q.GetUpload(ctx, uploadId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.Upload{}, ErrUploadNotFound
+		}
+		return db.Upload{}, err
+	}
+
+	if upload.CourseUuid != courseId || upload.ExpiresAt < time.Now().Unix() {
+		return db.Upload{}, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (s *Service) GetUpload(courseId string, uploadId string, ctx context.Context) (UploadResponse, error) {
+	upload, err := s.getUploadOfCourse(courseId, uploadId, ctx)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	return dbUploadToUpload(upload), nil
+}
+
+type UploadChunkParams struct {
+	CourseId string
+	UploadId string
+	Offset   int64
+	Length   int64  // Content-Length of the chunk, -1 when unknown
+	Checksum string // optional checksum of the chunk
+	Body     io.Reader
+}
+
+// UploadChunk appends the chunk to the upload, without a checksum the part of the chunk received
+// before the connection broke is kept, with a checksum the chunk is kept whole or not at all,
+// after the last chunk the checksum and the content of the whole file are checked
+func (s *Service) UploadChunk(params UploadChunkParams, ctx context.Context) (UploadResponse, error) {
+	// one chunk of an upload at a time, another instance is stopped by the offset check of AdvanceUpload
+	if _, busy := s.uploading.LoadOrStore(params.UploadId, struct{}{}); busy {
+		return UploadResponse{}, ErrUploadLocked
+	}
+	defer s.uploading.Delete(params.UploadId)
+
+	upload, err := s.getUploadOfCourse(params.CourseId, params.UploadId, ctx)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if upload.FinishedAt.Valid || params.Offset != upload.ReceivedBytes {
+		return UploadResponse{}, ErrOffsetMismatch
+	}
+
+	remaining := upload.Size - upload.ReceivedBytes
+	if params.Length > remaining {
+		return UploadResponse{}, &utils.ErrBadRequest{Message: "chunk goes past the Upload-Length"}
+	}
+
+	var chunkHash hash.Hash
+	var expected []byte
+	if params.Checksum != "" {
+		newHash, digest, err := parseChecksum(params.Checksum)
+		if err != nil {
+			return UploadResponse{}, err
+		}
+		chunkHash, expected = newHash(), digest
+	}
+
+	file, err := os.OpenFile(partialUploadPath(upload.Uuid), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	defer file.Close()
+
+	// bytes written after the last recorded offset (the server stopped in the middle of a chunk) are dropped
+	err = file.Truncate(upload.ReceivedBytes)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+
+	var dst io.Writer = io.NewOffsetWriter(file, upload.ReceivedBytes)
+	if chunkHash != nil {
+		dst = io.MultiWriter(dst, chunkHash)
+	}
+
+	written, copyErr := io.Copy(dst, io.LimitReader(params.Body, remaining))
+
+	if chunkHash != nil && (copyErr != nil || !hashMatches(chunkHash, expected)) {
+		file.Truncate(upload.ReceivedBytes)
+		if copyErr != nil {
+			return UploadResponse{}, copyErr
+		}
+		return UploadResponse{}, ErrChecksumMismatch
+	}
+
+	now := time.Now()
+
+	upload, err = s.q.AdvanceUpload(ctx, db.AdvanceUploadParams{
+		ReceivedBytes: upload.ReceivedBytes + written,
+		UpdatedAt:     now.Unix(),
+		ExpiresAt:     now.Add(UPLOAD_EXPIRATION).Unix(),
+		Uuid:          upload.Uuid,
+		Offset:        upload.ReceivedBytes,
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return UploadResponse{}, ErrOffsetMismatch
+		}
+		return UploadResponse{}, err
+	}
+	if copyErr != nil {
+		return UploadResponse{}, copyErr
+	}
+
+	if upload.ReceivedBytes == upload.Size {
+		upload, err = s.finishUpload(upload, file, ctx)
+		if err != nil {
+			return UploadResponse{}, err
+		}
+	}
+
+	return dbUploadToUpload(upload), nil
+}
+
+// checks the whole file, an upload that fails the checks is removed - sending it again won't help
+func (s *Service) finishUpload(upload db.Upload, file *os.File, ctx context.Context) (db.Upload, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return db.Upload{}, err
+	}
+
+	if upload.Checksum.Valid {
+		newHash, expected, err := parseChecksum(upload.Checksum.String)
+		if err != nil {
+			return db.Upload{}, err
+		}
+
+		fileHash := newHash()
+		if _, err := io.Copy(fileHash, file); err != nil {
+			return db.Upload{}, err
+		}
+
+		if !hashMatches(fileHash, expected) {
+			s.removeUpload(upload.Uuid)
+			return db.Upload{}, ErrChecksumMismatch
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return db.Upload{}, err
+		}
+	}
+
+	mime, err := uploads.CheckContent(file, upload.Size, upload.Filename)
+	if err != nil {
+		if errors.Is(err, uploads.ErrFileTypeForbidden) {
+			s.removeUpload(upload.Uuid)
+		}
+		return db.Upload{}, err
+	}
+
+	now := time.Now().Unix()
+	return s.q.FinishUpload(ctx, db.FinishUploadParams{
+		MimeType:   sql.NullString{String: mime, Valid: true},
+		FinishedAt: sql.NullInt64{Int64: now, Valid: true},
+		UpdatedAt:  now,
+		Uuid:       upload.Uuid,
+	})
+}
+
+func (s *Service) CancelUpload(courseId string, uploadId string, ctx context.Context) error {
+	_, err := s.getUploadOfCourse(courseId, uploadId, ctx)
+	if err != nil {
+		return err
+	}
+	if _, busy := s.uploading.Load(uploadId); busy {
+		return ErrUploadLocked
+	}
+
+	s.removeUpload(uploadId)
+	return nil
+}
+
+// storeUploadedFile moves the finished upload to the storage under the key of the material file
+func (s *Service) storeUploadedFile(courseId string, materialId string, versionId string, uploadId string, ctx context.Context) (storedFile, error) {
+	upload, err := s.getUploadOfCourse(courseId, uploadId, ctx)
+	if err != nil {
+		return storedFile{}, err
+	}
+	if !upload.FinishedAt.Valid {
+		return storedFile{}, ErrUploadNotFinished
+	}
+
+	file, err := os.Open(partialUploadPath(upload.Uuid))
+	if err != nil {
+		return storedFile{}, err
+	}
+	defer file.Close()
+
+	stored := storedFile{
+		versionId: versionId,
+		key:       materialFileKey(courseId, materialId, versionId, uploads.MIME_TO_EXT[upload.MimeType.String]),
+		mime:      upload.MimeType.String,
+		size:      upload.Size,
+		uploadId:  upload.Uuid,
+	}
+
+	err = s.storage.Save(ctx, stored.key, file, upload.Size, stored.mime)
+	if err != nil {
+		return storedFile{}, err
+	}
+
+	s.createPreview(&stored, file, ctx)
+	return stored, nil
+}
+
+// removes the upload with its received bytes, failures are only logged, the cleaner tries again later
+func (s *Service) removeUpload(uploadId string) {
+	_, err := s.q.DeleteUpload(context.Background(), uploadId)
+	if err != nil {
+		fmt.Println("failed to delete upload", uploadId, err)
+		return
+	}
+
+	err = os.Remove(partialUploadPath(uploadId))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Println("failed to remove partial upload", uploadId, err)
+	}
+}
+
+// removes the expired uploads and the partial files left without an upload
+func (s *Service) removeExpiredUploads(ctx context.Context) {
+	expired, err := s.q.ListExpiredUploads(ctx, time.Now().Unix())
+	if err != nil {
+		fmt.Println("failed to list expired uploads:", err)
+		return
+	}
+	for _, uploadId := range expired {
+		if _, busy := s.uploading.Load(uploadId); !busy {
+			s.removeUpload(uploadId)
+		}
+	}
+
+	entries, err := os.ReadDir(PARTIAL_UPLOADS_PATH)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("failed to read partial uploads:", err)
+		}
+		return
+	}
+
+	ids, err := s.q.ListUploadIds(ctx)
+	if err != nil {
+		fmt.Println("failed to list uploads:", err)
+		return
+	}
+	known := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || known[entry.Name()] || time.Since(info.ModTime()) < UPLOAD_EXPIRATION {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(PARTIAL_UPLOADS_PATH, entry.Name()))
+		if err != nil {
+			fmt.Println("failed to remove partial upload", entry.Name(), err)
+		}
+	}
+}
+
+// parses '<algorithm> <base64 digest>', hex digests are accepted too
+func parseChecksum(checksum string) (func() hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(strings.TrimSpace(checksum), " ")
+
+	newHash, ok := CHECKSUM_ALGORITHMS[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, nil, ErrBadChecksum
+	}
+
+	size := newHash().Size()
+
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(digest) != size {
+		digest, err = hex.DecodeString(encoded)
+		if err != nil || len(digest) != size {
+			return nil, nil, ErrBadChecksum
+		}
+	}
+
+	return newHash, digest, nil
+}
+
+func hashMatches(h hash.Hash, expected []byte) bool {
+	return bytes.Equal(h.Sum(nil), expected)
+}
@@ -3,62 +3,23 @@ package materials
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"io"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/feeds"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 
-	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
 )
 
 // this variable controls whether a material can exist withouth being part of a module
 var MATERIAL_CAN_EXIST_ALONE = false
 
-// max file size in bytes
-var MAX_SIZE = int64(30 * 1024 * 1024)
-
-var ALLOWED_FILES = map[string]bool{
-	"application/pdf": true, // .pdf
-	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true, // .docx
-	"text/plain": true, // .txt
-	"image/png":  true, // .png
-	"image/jpeg": true, // .jpeg and .jpg
-	"image/gif":  true, // .gif
-	"video/mp4":  true, // .mp4
-	"audio/mpeg": true, // .mp3
-}
-
-var MIME_TO_EXT = map[string]string{
-	"application/pdf": ".pdf",
-	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
-	"text/plain": ".txt",
-	"image/png":  ".png",
-	"image/jpeg": ".jpeg",
-	"image/gif":  ".gif",
-	"video/mp4":  ".mp4",
-	"audio/mpeg": ".mp3",
-}
-
-var EXT_TO_MIME = map[string]string{
-	".pdf":  "application/pdf",
-	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
-	".txt":  "text/plain",
-	".png":  "image/png",
-	".jpg":  "image/jpeg",
-	".jpeg": "image/jpeg",
-	"gif":   "image/gif",
-	".mp4":  "video/mp4",
-	".mp3":  "audio/mpeg",
-}
-
 type Material interface {
 	GetType() string
 	GetUuid() string
@@ -78,6 +39,10 @@ type FileMaterial struct {
 	MimeType  string `json:"mimeType"`
 	SizeBytes int    `json:"sizeBytes"`
 
+	// generated from the file, null when it has no picture or text (see previews.Generate)
+	ThumbnailUrl *string `json:"thumbnailUrl"`
+	PreviewText  *string `json:"previewText"`
+
 	ModuleId    string `json:"moduleId"`
 	ModuleOrder int    `json:"moduleOrder"`
 }
@@ -109,6 +74,12 @@ type UrlMaterial struct {
 	Url        string `json:"url"`
 	FaviconUrl string `json:"faviconUrl"`
 
+	// from the page of the url, null until it's fetched or when the page doesn't have them (see links.go)
+	PageTitle        *string `json:"pageTitle"`
+	PageDescription  *string `json:"pageDescription"`
+	ImageUrl         *string `json:"imageUrl"`
+	PreviewFetchedAt *string `json:"previewFetchedAt"`
+
 	ModuleId    string `json:"moduleId"`
 	ModuleOrder int    `json:"moduleOrder"`
 }
@@ -130,31 +101,38 @@ func (u UrlMaterial) GetModuleId() string {
 }
 
 type Service struct {
+	// the materials of a zip import are created in one transaction
+	database     *sql.DB
 	q            *db.Queries
-	staticPath   string
+	storage      uploads.Storage
 	feedsService *feeds.Service
+	fetcher      *linkpreview.Fetcher
+
+	// ids of the resumable uploads receiving a chunk right now
+	uploading sync.Map
+
+	// wakes up the link refresher when a new url is queued
+	linksWake chan struct{}
 }
 
-func NewService(queries *db.Queries, staticPath string, feedsService *feeds.Service) *Service {
-	return &Service{queries, staticPath, feedsService}
+func NewService(database *sql.DB, queries *db.Queries, storage uploads.Storage, feedsService *feeds.Service) *Service {
+	return &Service{
+		database:     database,
+		q:            queries,
+		storage:      storage,
+		feedsService: feedsService,
+		fetcher:      linkpreview.NewFetcher(),
+		linksWake:    make(chan struct{}, 1),
+	}
 }
 
+// the usual place of the favicon until the real one is known from the page, empty for urls without a host
 func (s *Service) deriveFaviconUrl(url string) string {
-
-	if strings.HasPrefix(url, "http") {
-		parts := strings.Split(url, "//")
-
-		host := parts[1]
-
-		base := strings.Split(host, "/")[0]
-
-		faviconUrl := base + "/favicon.ico"
-		return faviconUrl
+	u, err := linkpreview.PageUrl(url)
+	if err != nil {
+		return ""
 	}
-
-	urlParts := strings.Split(url, "/")
-	faviconUrl := urlParts[0] + "/favicon.ico"
-	return faviconUrl
+	return u.Scheme + "://" + u.Host + "/favicon.ico"
 }
 
 func (s *Service) CheckMaterialExists(materialId string, ctx context.Context) bool {
@@ -201,16 +179,40 @@ func (s *Service) ListMaterials(courseId string, host string, scheme string, ctx
 
 				TimesAccessed: int(material.TimesAccessed),
 
-				FileUrl:   material.Url,
+				FileUrl:   s.fileUrl(courseId, material.Uuid, scheme, host),
 				MimeType:  material.MimeType.String,
 				SizeBytes: int(material.ByteSize.Int64),
 
+				ThumbnailUrl: s.thumbnailUrl(courseId, material.Uuid, material.ThumbnailKey, scheme, host),
+				PreviewText:  utils.FromSqlNullString(material.PreviewText),
+
 				ModuleId:    material.ModuleUuid,
 				ModuleOrder: int(material.Order),
 			})
+		} else if material.Type == "video" {
+
+			video := videoMaterial(db.Material{
+				Uuid:            material.Uuid,
+				Type:            material.Type,
+				Name:            material.Name,
+				Description:     material.Description,
+				TimesAccessed:   material.TimesAccessed,
+				Url:             material.Url,
+				VideoProvider:   material.VideoProvider,
+				VideoID:         material.VideoID,
+				DurationSeconds: material.DurationSeconds,
+			}, db.LinkPreview{
+				Title:    material.PageTitle,
+				ImageUrl: material.PageImageUrl,
+				Duration: material.PageDuration,
+			})
+			video.ModuleId = material.ModuleUuid
+			video.ModuleOrder = int(material.Order)
+
+			formattedMaterials = append(formattedMaterials, video)
 		} else {
 
-			formattedMaterials = append(formattedMaterials, UrlMaterial{
+			formattedMaterials = append(formattedMaterials, withLinkPreview(UrlMaterial{
 				Uuid:        material.Uuid,
 				Type:        "url",
 				Name:        material.Name,
@@ -223,167 +225,128 @@ func (s *Service) ListMaterials(courseId string, host string, scheme string, ctx
 
 				ModuleId:    material.ModuleUuid,
 				ModuleOrder: int(material.Order),
-			})
+			}, db.LinkPreview{
+				Title:       material.PageTitle,
+				Description: material.PageDescription,
+				ImageUrl:    material.PageImageUrl,
+				FaviconUrl:  material.PageFaviconUrl,
+				FetchedAt:   material.PageFetchedAt,
+			}))
 		}
 	}
 	return formattedMaterials, nil
 }
 
-func (s *Service) getMimeType(src multipart.File) (string, error) {
-
-	m, err := mimetype.DetectReader(src)
-	if err != nil {
-		return "", err
-	}
-
-	mimeType := m.String()
+// key of a version of the material file in the storage, the url column of the material has the key of the current one,
+// materials from before the versions have their file in uploads/<courseId>/materials/<materialId><ext>
+func materialFileKey(courseId string, materialId string, versionId string, ext string) string {
+	return "uploads/" + courseId + "/materials/" + materialId + "/" + versionId + ext
+}
 
-	if _, err := src.Seek(0, io.SeekStart); err != nil {
-		return "", nil
-	}
+// url of the file, it's downloaded through the server which checks who can see the material
+func (s *Service) fileUrl(courseId string, materialId string, scheme string, host string) string {
+	return uploads.AbsoluteUrl(scheme, host, uploads.MaterialFileUrl(courseId, materialId))
+}
 
-	return mimeType, nil
+// MaterialFile is the new file of a material, sent in the form or received before by a resumable upload
+type MaterialFile struct {
+	Header   *multipart.FileHeader
+	UploadId string
 }
 
-// checks if the file type is allowed, returns bool, mime and error
-func (s *Service) isFileAllowed(src multipart.File) (bool, string, error) {
+// file of a material saved in the storage, uploadId is set when it came from a resumable upload
+type storedFile struct {
+	versionId string
 
-	mime, err := s.getMimeType(src)
-	if err != nil {
-		return false, "", err
-	}
+	key      string
+	mime     string
+	size     int64
+	uploadId string
 
-	for key := range ALLOWED_FILES {
-		if strings.Contains(mime, key) {
-			return true, key, nil
-		}
-	}
-	return false, "", nil
+	// empty when the file has no preview
+	thumbnailKey string
+	previewText  string
 }
 
-func (s *Service) isFileAllowedHeader(file *multipart.FileHeader) (string, bool) {
-	if file == nil {
-		return "", false
-	}
+// checks the file and saves it to the storage as a new version of the material
+func (s *Service) storeMaterialFile(courseId string, materialId string, file MaterialFile, ctx context.Context) (storedFile, error) {
+	versionId := uuid.NewString()
 
-	filename := file.Filename
-	ext := strings.ToLower(filepath.Ext(filename))
-	mime, ok := EXT_TO_MIME[ext]
-	if ok {
-		return mime, ok
+	if file.UploadId != "" {
+		return s.storeUploadedFile(courseId, materialId, versionId, file.UploadId, ctx)
 	}
 
-	mime = file.Header.Get("Content-Type")
-	ok = ALLOWED_FILES[mime]
-	if ok {
-		return mime, true
+	mime, err := uploads.CheckFile(file.Header)
+	if err != nil {
+		return storedFile{}, err
 	}
-	return "", false
-}
-
-// removes old material file when a new one is uploaded
-func (s *Service) removeOldMaterialFile(materialId string, courseId string) error {
-
-	pathToMaterialsFolder := s.staticPath + "/uploads/" + courseId + "/materials"
 
-	entries, err := os.ReadDir(pathToMaterialsFolder)
+	limit, err := s.UploadLimit(courseId, ctx)
 	if err != nil {
-		return err
+		return storedFile{}, err
 	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		name := entry.Name()
-		if strings.HasPrefix(name, materialId) {
-			err := os.Remove(filepath.Join(pathToMaterialsFolder, name))
-			if err != nil {
-				return err
-			}
-		}
+	if file.Header.Size > limit {
+		return storedFile{}, ErrFileOverLimit
 	}
-	return nil
-}
-
-// saves given file to the static folder
-func (s *Service) saveFileToStatic(
-	materialId string,
-	courseId string,
-	src multipart.File,
-	ext string,
-) error {
-
-	pathToUploads := s.staticPath + "/uploads"
-	pathToCourseFolder := pathToUploads + "/" + courseId
-	pathToMaterialsFolder := pathToCourseFolder + "/materials"
 
-	pathToFile := pathToMaterialsFolder + "/" + materialId + ext
-
-	err := os.Mkdir(pathToUploads, 0755)
+	// the files of resumable uploads were counted in the quota when the upload was created
+	err = uploads.CheckQuota(ctx, s.q, courseId, file.Header.Size)
 	if err != nil {
-		if !errors.Is(err, os.ErrExist) {
-			fmt.Println("failed to create materials folder, wrong path", err)
-			return err
-		}
+		return storedFile{}, err
 	}
 
-	err = os.Mkdir(pathToCourseFolder, 0755)
+	src, err := file.Header.Open()
 	if err != nil {
-		if !errors.Is(err, os.ErrExist) {
-			fmt.Println("failed to create course folder, wrong path", err)
-			return err
-		}
+		return storedFile{}, err
 	}
+	defer src.Close()
 
-	err = os.Mkdir(pathToMaterialsFolder, 0755)
-	if err != nil {
-		if !errors.Is(err, os.ErrExist) {
-			fmt.Println("failed to create materials folder, wrong path", err)
-			return err
-		}
+	stored := storedFile{
+		versionId: versionId,
+		key:       materialFileKey(courseId, materialId, versionId, uploads.MIME_TO_EXT[mime]),
+		mime:      mime,
+		size:      file.Header.Size,
 	}
 
-	dst, err := os.Create(pathToFile)
+	err = s.storage.Save(ctx, stored.key, src, stored.size, mime)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return storedFile{}, err
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, src); err != nil {
-		return err
-	}
-	return nil
+	s.createPreview(&stored, src, ctx)
+	return stored, nil
 }
 
-func (s *Service) CreateFileMaterial(req *CreateFileMaterialRequest, materialId string, fileHeader *multipart.FileHeader, scheme string, host string, ctx context.Context) (Material, error) {
-
-	if fileHeader.Size > MAX_SIZE {
-		return nil, ErrFileTooBig
+// the resumable upload is no longer needed once the material has its file
+func (s *Service) materialFileSaved(stored storedFile) {
+	if stored.uploadId != "" {
+		s.removeUpload(stored.uploadId)
 	}
+}
 
-	src, err := fileHeader.Open()
+// a file that can't be removed is only logged, it's no longer used by the material either way
+func (s *Service) removeMaterialFile(key string) {
+	err := s.storage.Remove(context.Background(), uploads.StorageKey(key))
 	if err != nil {
-		return nil, err
+		fmt.Println("failed to remove material file:", err)
 	}
+}
 
-	// ok, mime, err := s.isFileAllowed(src)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	mime, ok := s.isFileAllowedHeader(fileHeader)
-
-	if !ok {
-		return nil, ErrFileTypeForbidden
+// removes the file saved by storeMaterialFile when its material or version couldn't be created
+func (s *Service) removeStoredFile(stored storedFile) {
+	s.removeMaterialFile(stored.key)
+	if stored.thumbnailKey != "" {
+		s.removeMaterialFile(stored.thumbnailKey)
 	}
+}
 
-	fileExt := MIME_TO_EXT[mime]
-
-	err = s.saveFileToStatic(materialId, req.CourseId, src, fileExt)
+// userId is the lecturer uploading the file, kept with the version
+func (s *Service) CreateFileMaterial(req *CreateFileMaterialRequest, materialId string, file MaterialFile, userId int, scheme string, host string, ctx context.Context) (Material, error) {
 
-	url := scheme + "://" + host + "/api/static/uploads/" + req.CourseId + "/materials/" + materialId + fileExt
+	stored, err := s.storeMaterialFile(req.CourseId, materialId, file, ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	now := time.Now().Unix()
 
@@ -392,26 +355,38 @@ func (s *Service) CreateFileMaterial(req *CreateFileMaterialRequest, materialId
 		CourseUuid:  req.CourseId,
 		Name:        req.Name,
 		Description: req.Description,
-		Url:         url,
+		Url:         stored.key,
 		Type:        "file",
-		MimeType:    sql.NullString{String: mime, Valid: true},
-		ByteSize:    sql.NullInt64{Int64: fileHeader.Size, Valid: true},
+		MimeType:    sql.NullString{String: stored.mime, Valid: true},
+		ByteSize:    sql.NullInt64{Int64: stored.size, Valid: true},
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	})
 	if err != nil {
+		s.removeStoredFile(stored)
 		return nil, err
 	}
 
+	version, err := s.addMaterialVersion(materialId, stored, userId, ctx)
+	if err != nil {
+		s.q.DeleteMaterial(ctx, materialId)
+		s.removeStoredFile(stored)
+		return nil, err
+	}
+	s.materialFileSaved(stored)
+
 	s.feedsService.CreateAutomaticPost("New file material: "+req.Name+" published", req.CourseId, ctx)
 	return FileMaterial{
 		Uuid:        dbMat.Uuid,
 		Type:        dbMat.Type,
 		Name:        dbMat.Name,
 		Description: dbMat.Description,
-		FileUrl:     dbMat.Url,
+		FileUrl:     s.fileUrl(dbMat.CourseUuid, dbMat.Uuid, scheme, host),
 		MimeType:    dbMat.MimeType.String,
 		SizeBytes:   int(dbMat.ByteSize.Int64),
+
+		ThumbnailUrl: s.thumbnailUrl(dbMat.CourseUuid, dbMat.Uuid, version.ThumbnailKey, scheme, host),
+		PreviewText:  utils.FromSqlNullString(version.PreviewText),
 	}, nil
 }
 
@@ -427,6 +402,7 @@ type CreateUrlMaterialParams struct {
 func (s *Service) CreateUrlMaterial(req CreateUrlMaterialRequest, materialId string, ctx context.Context) (Material, error) {
 
 	now := time.Now().Unix()
+	faviconUrl := s.deriveFaviconUrl(req.Url)
 
 	dbMat, err := s.q.CreateMaterial(ctx, db.CreateMaterialParams{
 		Uuid:        materialId,
@@ -435,7 +411,7 @@ func (s *Service) CreateUrlMaterial(req CreateUrlMaterialRequest, materialId str
 		Description: req.Description,
 		Url:         req.Url,
 		Type:        "url",
-		FaviconUrl:  sql.NullString{String: s.deriveFaviconUrl(req.Url), Valid: true},
+		FaviconUrl:  sql.NullString{String: faviconUrl, Valid: faviconUrl != ""},
 		UpdatedAt:   now,
 		CreatedAt:   now,
 	})
@@ -444,14 +420,14 @@ func (s *Service) CreateUrlMaterial(req CreateUrlMaterialRequest, materialId str
 	}
 
 	s.feedsService.CreateAutomaticPost("New url material: "+req.Name+" published", req.CourseId, ctx)
-	return UrlMaterial{
+	return withLinkPreview(UrlMaterial{
 		Uuid:        dbMat.Uuid,
 		Type:        dbMat.Type,
 		Name:        dbMat.Name,
 		Description: dbMat.Description,
 		Url:         dbMat.Url,
 		FaviconUrl:  dbMat.FaviconUrl.String,
-	}, nil
+	}, s.linkPreview(dbMat.Url, ctx)), nil
 }
 
 type UpdateFileMaterialParms struct {
@@ -468,50 +444,42 @@ type UpdateFileMaterialParms struct {
 	host   string
 }
 
-func (s *Service) UpdateFileMaterial(req *UpdateFileMaterialRequest, fileHeader *multipart.FileHeader, scheme string, host string, ctx context.Context) (Material, error) {
+// file is nil when only the name or description change, a new file becomes a new version of the material
+func (s *Service) UpdateFileMaterial(req *UpdateFileMaterialRequest, file *MaterialFile, userId int, scheme string, host string, ctx context.Context) (Material, error) {
 
 	var byteSize = sql.NullInt64{}
 	var mimeType *string
 	var url *string
+	var stored storedFile
+	var version db.MaterialVersion
 
-	if fileHeader != nil {
-
-		if fileHeader.Size > MAX_SIZE {
-			return nil, ErrFileTooBig
-		}
-		byteSize.Valid = true
-		byteSize.Int64 = fileHeader.Size
+	if file != nil {
 
-		src, err := fileHeader.Open()
+		// the file from before the versions stays in the history as the first version
+		err := s.q.CreateFirstMaterialVersion(ctx, db.CreateFirstMaterialVersionParams{
+			Uuid:         uuid.NewString(),
+			MaterialUuid: req.MaterialId,
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		// ok, mime, err := s.isFileAllowed(src)
-		// if err != nil {
-		// 	return nil, err
-		// }
-		mime, ok := s.isFileAllowedHeader(fileHeader)
-
-		if !ok {
-			return nil, ErrFileTypeForbidden
-		}
-
-		err = s.removeOldMaterialFile(req.MaterialId, req.CourseId)
+		stored, err = s.storeMaterialFile(req.CourseId, req.MaterialId, *file, ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		fileExt := MIME_TO_EXT[mime]
-
-		err = s.saveFileToStatic(req.MaterialId, req.CourseId, src, fileExt)
+		version, err = s.addMaterialVersion(req.MaterialId, stored, userId, ctx)
 		if err != nil {
+			s.removeStoredFile(stored)
 			return nil, err
 		}
-		newUrl := scheme + "://" + host + "/api/static/uploads/" + req.CourseId + "/materials/" + req.MaterialId + fileExt
-		url = &newUrl
 
-		mimeType = &mime
+		byteSize.Valid = true
+		byteSize.Int64 = stored.size
+
+		url = &stored.key
+		mimeType = &stored.mime
 	}
 
 	now := time.Now().Unix()
@@ -526,9 +494,24 @@ func (s *Service) UpdateFileMaterial(req *UpdateFileMaterialRequest, fileHeader
 		UpdatedAt:   now,
 	})
 	if err != nil {
+		if file != nil {
+			s.removeMaterialVersion(version)
+		}
 		return nil, err
 	}
 
+	if file != nil {
+		s.materialFileSaved(stored)
+		s.pruneMaterialVersions(dbMat.Uuid, dbMat.Url, ctx)
+	} else {
+		// the preview of the file the material already has
+		current, err := s.q.GetCurrentMaterialVersion(ctx, dbMat.Uuid)
+		if err != nil && !utils.IsNoRowsError(err) {
+			return nil, err
+		}
+		version = current
+	}
+
 	s.feedsService.CreateAutomaticPost("File material: "+*req.Name+" updated", req.CourseId, ctx)
 	return FileMaterial{
 		Uuid:          dbMat.Uuid,
@@ -536,9 +519,11 @@ func (s *Service) UpdateFileMaterial(req *UpdateFileMaterialRequest, fileHeader
 		Name:          dbMat.Name,
 		Description:   dbMat.Description,
 		TimesAccessed: int(dbMat.TimesAccessed),
-		FileUrl:       dbMat.Url,
+		FileUrl:       s.fileUrl(dbMat.CourseUuid, dbMat.Uuid, scheme, host),
 		MimeType:      dbMat.MimeType.String,
 		SizeBytes:     int(dbMat.ByteSize.Int64),
+		ThumbnailUrl:  s.thumbnailUrl(dbMat.CourseUuid, dbMat.Uuid, version.ThumbnailKey, scheme, host),
+		PreviewText:   utils.FromSqlNullString(version.PreviewText),
 	}, nil
 }
 
@@ -566,7 +551,7 @@ func (s *Service) UpdateUrlMaterial(req *UpdateUrlMaterialRequest, ctx context.C
 	}
 
 	s.feedsService.CreateAutomaticPost("Url material: "+*req.Name+" updated", req.CourseId, ctx)
-	return UrlMaterial{
+	return withLinkPreview(UrlMaterial{
 		Uuid:          dbMat.Uuid,
 		Type:          dbMat.Type,
 		Name:          dbMat.Name,
@@ -574,11 +559,18 @@ func (s *Service) UpdateUrlMaterial(req *UpdateUrlMaterialRequest, ctx context.C
 		Description:   dbMat.Description,
 		Url:           dbMat.Url,
 		FaviconUrl:    dbMat.FaviconUrl.String,
-	}, nil
+	}, s.linkPreview(dbMat.Url, ctx)), nil
 }
 
+// DeleteMaterial deletes the material with the files of all its versions
 func (s *Service) DeleteMaterial(materialId string, ctx context.Context) error {
 
+	// the versions are deleted with the material, their files have to be known before
+	keys, err := s.q.ListMaterialFileKeys(ctx, materialId)
+	if err != nil {
+		return err
+	}
+
 	res, err := s.q.DeleteMaterial(ctx, materialId)
 	if err != nil {
 		return err
@@ -593,6 +585,10 @@ func (s *Service) DeleteMaterial(materialId string, ctx context.Context) error {
 		return ErrCourseNotFound
 	}
 
+	for _, key := range keys {
+		s.removeMaterialFile(key)
+	}
+
 	return nil
 }
 
@@ -603,7 +599,7 @@ func (s *Service) IncrementMaterialAccessedCounter(materialId string, courseId s
 		return err
 	}
 
-	s.feedsService.CreateInfoPost("Material: "+materialId+" viewed", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Material: "+materialId+" viewed", courseId)
 	return nil
 }
 
@@ -620,7 +616,7 @@ func (s *Service) AssignMaterialToModule(materialId string, moduleId string, ord
 		return db.MaterialToModule{}, err
 	}
 
-	s.feedsService.CreateInfoPost("Material: "+materialId+" assigned to module "+moduleId, courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Material: "+materialId+" assigned to module "+moduleId, courseId)
 
 	return mm, nil
 }
@@ -636,7 +632,7 @@ func (s *Service) ChangeMaterialInModuleOrder(materialId string, moduleId string
 		return db.MaterialToModule{}, err
 	}
 
-	s.feedsService.CreateInfoPost("Material: "+materialId+" order changed "+moduleId, courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Material: "+materialId+" order changed "+moduleId, courseId)
 
 	return mm, nil
 }
@@ -651,7 +647,7 @@ func (s *Service) RemoveMaterialToModule(materialId string, moduleId string, ord
 		return err
 	}
 
-	s.feedsService.CreateInfoPost("Material: "+materialId+" removed from module "+moduleId, courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Material: "+materialId+" removed from module "+moduleId, courseId)
 
 	return nil
 }
@@ -0,0 +1,300 @@
+package materials
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+//* versions of material files - a new file of a material doesn't replace the old one, it's added as a new version
+// and the material points to it, the lecturers can see the history and restore an older version,
+// only the newest MATERIAL_VERSIONS_KEPT versions are kept (the current one always)
+
+// how many versions of a material file are kept, 0 keeps all of them
+var MATERIAL_VERSIONS_KEPT = 10
+
+type VersionAuthor struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type MaterialVersion struct {
+	Uuid    string `json:"uuid"`
+	Version int    `json:"version"`
+	Current bool   `json:"current"`
+
+	FileUrl   string `json:"fileUrl"`
+	MimeType  string `json:"mimeType"`
+	SizeBytes int    `json:"sizeBytes"`
+
+	// null when the user was deleted or the file is from before the versions
+	CreatedBy *VersionAuthor `json:"createdBy"`
+	CreatedAt string         `json:"createdAt"`
+}
+
+// addMaterialVersion records the stored file as the newest version of the material
+func (s *Service) addMaterialVersion(materialId string, stored storedFile, userId int, ctx context.Context) (db.MaterialVersion, error) {
+	return s.q.CreateMaterialVersion(ctx, materialVersionParams(materialId, stored, userId))
+}
+
+func materialVersionParams(materialId string, stored storedFile, userId int) db.CreateMaterialVersionParams {
+	createdBy := sql.NullInt64{Int64: int64(userId), Valid: userId != 0}
+
+	return db.CreateMaterialVersionParams{
+		Uuid:         stored.versionId,
+		MaterialUuid: materialId,
+		Url:          stored.key,
+		MimeType:     stored.mime,
+		ByteSize:     stored.size,
+		ThumbnailKey: sql.NullString{String: stored.thumbnailKey, Valid: stored.thumbnailKey != ""},
+		PreviewText:  sql.NullString{String: stored.previewText, Valid: stored.previewText != ""},
+		CreatedBy:    createdBy,
+		CreatedAt:    time.Now().Unix(),
+	}
+}
+
+// removes the version with its file and thumbnail, failures are only logged
+func (s *Service) removeMaterialVersion(version db.MaterialVersion) {
+	_, err := s.q.DeleteMaterialVersion(context.Background(), version.Uuid)
+	if err != nil {
+		fmt.Println("failed to delete material version", version.Uuid, err)
+		return
+	}
+	s.removeVersionFiles(version)
+}
+
+func (s *Service) removeVersionFiles(version db.MaterialVersion) {
+	s.removeMaterialFile(version.Url)
+	if version.ThumbnailKey.Valid {
+		s.removeMaterialFile(version.ThumbnailKey.String)
+	}
+}
+
+// removes the versions over MATERIAL_VERSIONS_KEPT, the oldest first, currentKey is the file of the material
+func (s *Service) pruneMaterialVersions(materialId string, currentKey string, ctx context.Context) {
+	if MATERIAL_VERSIONS_KEPT <= 0 {
+		return
+	}
+
+	versions, err := s.q.ListMaterialVersions(ctx, materialId)
+	if err != nil {
+		fmt.Println("failed to list material versions", materialId, err)
+		return
+	}
+
+	// sorted from the newest
+	for i, version := range versions {
+		if i < MATERIAL_VERSIONS_KEPT || version.Url == currentKey {
+			continue
+		}
+
+		s.removeMaterialVersion(db.MaterialVersion{Uuid: version.Uuid, Url: version.Url, ThumbnailKey: version.ThumbnailKey})
+	}
+}
+
+// the file material of the course, versions exist only for files
+func (s *Service) getFileMaterialOfCourse(courseId string, materialId string, ctx context.Context) (db.Material, error) {
+	material, err := s.q.GetMaterial(ctx, materialId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.Material{}, ErrMaterialNotFound
+		}
+		return db.Material{}, err
+	}
+
+	if material.CourseUuid != courseId || material.Type != "file" {
+		return db.Material{}, ErrMaterialNotFound
+	}
+	return material, nil
+}
+
+// url of an older version of the file, only admins can download it
+func versionFileUrl(courseId string, materialId string, version int64, scheme string, host string) string {
+	return uploads.AbsoluteUrl(scheme, host, uploads.MaterialFileUrl(courseId, materialId)+"?version="+strconv.FormatInt(version, 10))
+}
+
+func (s *Service) ListMaterialVersions(courseId string, materialId string, scheme string, host string, ctx context.Context) ([]MaterialVersion, error) {
+	material, err := s.getFileMaterialOfCourse(courseId, materialId, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// materials from before the versions get their file as the first version
+	err = s.q.CreateFirstMaterialVersion(ctx, db.CreateFirstMaterialVersionParams{
+		Uuid:         uuid.NewString(),
+		MaterialUuid: materialId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dbVersions, err := s.q.ListMaterialVersions(ctx, materialId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]MaterialVersion, 0, len(dbVersions))
+	for _, v := range dbVersions {
+		version := MaterialVersion{
+			Uuid:    v.Uuid,
+			Version: int(v.Version),
+			Current: v.Url == material.Url,
+
+			FileUrl:   versionFileUrl(courseId, materialId, v.Version, scheme, host),
+			MimeType:  v.MimeType,
+			SizeBytes: int(v.ByteSize),
+
+			CreatedAt: utils.UnixToIso(v.CreatedAt),
+		}
+
+		if v.CreatedBy.Valid && v.FirstName.Valid {
+			version.CreatedBy = &VersionAuthor{
+				ID:        int(v.CreatedBy.Int64),
+				FirstName: v.FirstName.String,
+				LastName:  v.LastName.String,
+			}
+		}
+
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (s *Service) getMaterialVersion(courseId string, materialId string, version int64, ctx context.Context) (db.MaterialVersion, error) {
+	v, err := s.q.GetMaterialVersion(ctx, db.GetMaterialVersionParams{
+		MaterialUuid: materialId,
+		Version:      version,
+		CourseUuid:   courseId,
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.MaterialVersion{}, ErrVersionNotFound
+		}
+		return db.MaterialVersion{}, err
+	}
+	return v, nil
+}
+
+// RestoreMaterialVersion makes the older version the current file of the material, the newer versions stay
+func (s *Service) RestoreMaterialVersion(courseId string, materialId string, version int64, scheme string, host string, ctx context.Context) (Material, error) {
+	v, err := s.getMaterialVersion(courseId, materialId, version, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbMat, err := s.q.UpdateMaterialPartial(ctx, db.UpdateMaterialPartialParams{
+		Uuid:      materialId,
+		Url:       sql.NullString{String: v.Url, Valid: true},
+		MimeType:  sql.NullString{String: v.MimeType, Valid: true},
+		ByteSize:  sql.NullInt64{Int64: v.ByteSize, Valid: true},
+		UpdatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.feedsService.CreateAutomaticPost(fmt.Sprintf("File material: %v restored to version %v", dbMat.Name, v.Version), courseId, ctx)
+	return FileMaterial{
+		Uuid:          dbMat.Uuid,
+		Type:          dbMat.Type,
+		Name:          dbMat.Name,
+		Description:   dbMat.Description,
+		TimesAccessed: int(dbMat.TimesAccessed),
+		FileUrl:       s.fileUrl(dbMat.CourseUuid, dbMat.Uuid, scheme, host),
+		MimeType:      dbMat.MimeType.String,
+		SizeBytes:     int(dbMat.ByteSize.Int64),
+		ThumbnailUrl:  s.thumbnailUrl(dbMat.CourseUuid, dbMat.Uuid, v.ThumbnailKey, scheme, host),
+		PreviewText:   utils.FromSqlNullString(v.PreviewText),
+	}, nil
+}
+
+// DeleteMaterialVersion removes an older version with its file, the current one can't be removed
+func (s *Service) DeleteMaterialVersion(courseId string, materialId string, version int64, ctx context.Context) error {
+	material, err := s.getFileMaterialOfCourse(courseId, materialId, ctx)
+	if err != nil {
+		return err
+	}
+
+	v, err := s.getMaterialVersion(courseId, materialId, version, ctx)
+	if err != nil {
+		return err
+	}
+	if v.Url == material.Url {
+		return ErrVersionIsCurrent
+	}
+
+	_, err = s.q.DeleteMaterialVersion(ctx, v.Uuid)
+	if err != nil {
+		return err
+	}
+	s.removeVersionFiles(v)
+	return nil
+}
+
+func versionError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrMaterialNotFound, ErrVersionNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrVersionIsCurrent:
+		return r.Error(http.StatusConflict, err.Error())
+	}
+	return r.ServerError(err)
+}
+
+// GET /courses/{courseId}/modules/{moduleId}/materials/{materialId}/versions
+func (h *Handler) ListMaterialVersions(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	versions, err := h.service.ListMaterialVersions(c.Param("courseId"), c.Param("materialId"), c.Scheme(), c.Request().Host, r.Ctx)
+	if err != nil {
+		return versionError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+// POST /courses/{courseId}/modules/{moduleId}/materials/{materialId}/versions/{version}/restore
+func (h *Handler) RestoreMaterialVersion(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, "version must be a number")
+	}
+
+	mat, err := h.service.RestoreMaterialVersion(c.Param("courseId"), c.Param("materialId"), version, c.Scheme(), c.Request().Host, r.Ctx)
+	if err != nil {
+		return versionError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, mat)
+}
+
+// DELETE /courses/{courseId}/modules/{moduleId}/materials/{materialId}/versions/{version}
+func (h *Handler) DeleteMaterialVersion(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, "version must be a number")
+	}
+
+	err = h.service.DeleteMaterialVersion(c.Param("courseId"), c.Param("materialId"), version, r.Ctx)
+	if err != nil {
+		return versionError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,359 @@
+package materials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/utils"
+)
+
+//* video materials - links to YouTube and Vimeo videos and to mp4 files anywhere on the web, the provider and the id
+// of the video are recognized from the url when the material is saved, the embed url for the player is made from them.
+// The page of the video is fetched by the link refresher like the pages of url materials (title, image, duration)
+
+const (
+	PROVIDER_YOUTUBE = "youtube"
+	PROVIDER_VIMEO   = "vimeo"
+	PROVIDER_MP4     = "mp4"
+)
+
+// longest duration a lecturer can set, in seconds
+const maxVideoDuration = 7 * 24 * 60 * 60
+
+var (
+	youtubeId = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	vimeoId   = regexp.MustCompile(`^[0-9]{1,12}$`)
+	vimeoHash = regexp.MustCompile(`^[0-9a-f]{6,20}$`)
+
+	// start of a YouTube link, 90, 90s or 1h2m3s
+	youtubeStart = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+)
+
+type VideoMaterial struct {
+	Uuid        string `json:"uuid"`
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	TimesAccessed int `json:"timesAccessed"`
+
+	Url      string  `json:"url"`
+	Provider string  `json:"provider"` // youtube | vimeo | mp4
+	VideoId  *string `json:"videoId"`  // null for mp4
+
+	// src of the iframe for YouTube and Vimeo, of the video element for mp4
+	EmbedUrl     string  `json:"embedUrl"`
+	ThumbnailUrl *string `json:"thumbnailUrl"`
+
+	// set by the lecturer or read from the page of the video, null when unknown
+	DurationSeconds *int `json:"durationSeconds"`
+
+	PageTitle *string `json:"pageTitle"`
+
+	ModuleId    string `json:"moduleId"`
+	ModuleOrder int    `json:"moduleOrder"`
+}
+
+func (v VideoMaterial) GetType() string {
+	return v.Type
+}
+
+func (v VideoMaterial) GetUuid() string {
+	return v.Uuid
+}
+
+func (v VideoMaterial) GetModuleOrder() int {
+	return v.ModuleOrder
+}
+
+func (v VideoMaterial) GetModuleId() string {
+	return v.ModuleId
+}
+
+// video recognized from a url
+type video struct {
+	provider string
+	id       string // empty for mp4
+
+	hash  string // of unlisted Vimeo videos
+	start int    // seconds, from the t parameter of YouTube links
+	url   string // the url with the scheme
+}
+
+// parseVideoUrl recognizes the links to YouTube videos (watch, youtu.be, shorts, embed and live), Vimeo videos
+// (vimeo.com/<id>, with the hash of unlisted videos, channels, groups and showcases, player.vimeo.com) and mp4 files
+func parseVideoUrl(raw string) (video, error) {
+	u, err := linkpreview.PageUrl(raw)
+	if err != nil {
+		return video{}, ErrNotAVideo
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "www."), "m.")
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	query := u.Query()
+
+	v := video{url: u.String()}
+
+	switch host {
+	case "youtube.com", "music.youtube.com", "youtube-nocookie.com":
+		switch {
+		case segments[0] == "watch":
+			v.id = query.Get("v")
+		case len(segments) > 1 && (segments[0] == "embed" || segments[0] == "shorts" || segments[0] == "live" || segments[0] == "v"):
+			v.id = segments[1]
+		}
+	case "youtu.be":
+		v.id = segments[0]
+	case "vimeo.com", "player.vimeo.com":
+		for i, segment := range segments {
+			if !vimeoId.MatchString(segment) {
+				continue
+			}
+			v.id = segment
+			if i+1 < len(segments) && vimeoHash.MatchString(segments[i+1]) {
+				v.hash = segments[i+1]
+			} else if vimeoHash.MatchString(query.Get("h")) {
+				v.hash = query.Get("h")
+			}
+			break
+		}
+		if v.id != "" {
+			v.provider = PROVIDER_VIMEO
+			return v, nil
+		}
+		return video{}, ErrNotAVideo
+	}
+
+	if v.id != "" {
+		if !youtubeId.MatchString(v.id) {
+			return video{}, ErrNotAVideo
+		}
+		v.provider = PROVIDER_YOUTUBE
+		v.start = youtubeStartSeconds(first(query.Get("t"), query.Get("start")))
+		return v, nil
+	}
+
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".mp4", ".m4v":
+		v.provider = PROVIDER_MP4
+		return v, nil
+	}
+	return video{}, ErrNotAVideo
+}
+
+func youtubeStartSeconds(t string) int {
+	match := youtubeStart.FindStringSubmatch(strings.ToLower(t))
+	if match == nil {
+		return 0
+	}
+
+	seconds := 0
+	for i, unit := range []int{60 * 60, 60, 1} {
+		n, _ := strconv.Atoi(match[i+1])
+		seconds += n * unit
+	}
+	return min(seconds, maxVideoDuration)
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// embedUrl is the src of the player, YouTube videos are embedded without the tracking cookies
+func (v video) embedUrl() string {
+	switch v.provider {
+	case PROVIDER_YOUTUBE:
+		embed := "https://www.youtube-nocookie.com/embed/" + v.id
+		if v.start > 0 {
+			embed += "?start=" + strconv.Itoa(v.start)
+		}
+		return embed
+	case PROVIDER_VIMEO:
+		embed := "https://player.vimeo.com/video/" + v.id
+		if v.hash != "" {
+			embed += "?h=" + v.hash
+		}
+		return embed
+	}
+	return v.url
+}
+
+// videoMaterial makes the response of a video material, the thumbnail of YouTube videos is known without the page
+func videoMaterial(material db.Material, preview db.LinkPreview) VideoMaterial {
+	mat := VideoMaterial{
+		Uuid:          material.Uuid,
+		Type:          material.Type,
+		Name:          material.Name,
+		Description:   material.Description,
+		TimesAccessed: int(material.TimesAccessed),
+		Url:           material.Url,
+		Provider:      material.VideoProvider.String,
+		VideoId:       utils.FromSqlNullString(material.VideoID),
+		ThumbnailUrl:  utils.FromSqlNullString(preview.ImageUrl),
+		PageTitle:     utils.FromSqlNullString(preview.Title),
+	}
+
+	v, err := parseVideoUrl(material.Url)
+	if err == nil {
+		mat.EmbedUrl = v.embedUrl()
+	}
+	if mat.Provider == PROVIDER_YOUTUBE && material.VideoID.Valid {
+		thumbnail := "https://i.ytimg.com/vi/" + material.VideoID.String + "/hqdefault.jpg"
+		mat.ThumbnailUrl = &thumbnail
+	}
+
+	if duration := videoDuration(material, preview); duration > 0 {
+		mat.DurationSeconds = &duration
+	}
+	return mat
+}
+
+// the duration set by the lecturer, otherwise the one from the page of the video, 0 when unknown
+func videoDuration(material db.Material, preview db.LinkPreview) int {
+	if material.DurationSeconds.Valid {
+		return int(material.DurationSeconds.Int64)
+	}
+	return int(preview.Duration.Int64)
+}
+
+func checkVideoDuration(duration *int) error {
+	if duration != nil && (*duration < 0 || *duration > maxVideoDuration) {
+		return &utils.ErrBadRequest{Message: fmt.Sprintf("durationSeconds must be between 0 and %v", maxVideoDuration)}
+	}
+	return nil
+}
+
+func (s *Service) CreateVideoMaterial(req CreateUrlMaterialRequest, materialId string, ctx context.Context) (Material, error) {
+	v, err := parseVideoUrl(req.Url)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVideoDuration(req.DurationSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	dbMat, err := s.q.CreateMaterial(ctx, db.CreateMaterialParams{
+		Uuid:            materialId,
+		CourseUuid:      req.CourseId,
+		Name:            req.Name,
+		Description:     req.Description,
+		Url:             req.Url,
+		Type:            "video",
+		VideoProvider:   sql.NullString{String: v.provider, Valid: true},
+		VideoID:         sql.NullString{String: v.id, Valid: v.id != ""},
+		DurationSeconds: videoDurationParam(req.DurationSeconds),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.feedsService.CreateAutomaticPost("New video material: "+req.Name+" published", req.CourseId, ctx)
+	return videoMaterial(dbMat, s.linkPreview(dbMat.Url, ctx)), nil
+}
+
+// 0 is no duration, nil keeps the one the material has
+func videoDurationParam(duration *int) sql.NullInt64 {
+	if duration == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*duration), Valid: *duration != 0}
+}
+
+// a new url has to be a video too, its provider and id replace the old ones, durationSeconds 0 removes the duration
+func (s *Service) UpdateVideoMaterial(req *UpdateUrlMaterialRequest, ctx context.Context) (Material, error) {
+	var provider, id sql.NullString
+
+	if req.Url != nil {
+		v, err := parseVideoUrl(*req.Url)
+		if err != nil {
+			return nil, err
+		}
+		provider = sql.NullString{String: v.provider, Valid: true}
+		id = sql.NullString{String: v.id, Valid: v.id != ""}
+	}
+
+	err := checkVideoDuration(req.DurationSeconds)
+	if err != nil {
+		return nil, err
+	}
+
+	var duration sql.NullInt64
+	if req.DurationSeconds != nil {
+		duration = sql.NullInt64{Int64: int64(*req.DurationSeconds), Valid: true}
+	}
+
+	dbMat, err := s.q.UpdateMaterialPartial(ctx, db.UpdateMaterialPartialParams{
+		Uuid:            req.MaterialId,
+		Name:            utils.ToSqlNullString(req.Name),
+		Description:     utils.ToSqlNullString(req.Description),
+		Url:             utils.ToSqlNullString(req.Url),
+		VideoProvider:   provider,
+		VideoID:         id,
+		DurationSeconds: duration,
+		UpdatedAt:       time.Now().Unix(),
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return nil, ErrMaterialNotFound
+		}
+		return nil, err
+	}
+
+	s.feedsService.CreateAutomaticPost("Video material: "+dbMat.Name+" updated", req.CourseId, ctx)
+	return videoMaterial(dbMat, s.linkPreview(dbMat.Url, ctx)), nil
+}
+
+// MaterialType returns file, url or video
+func (s *Service) MaterialType(courseId string, materialId string, ctx context.Context) (string, error) {
+	material, err := s.q.GetMaterial(ctx, materialId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return "", ErrMaterialNotFound
+		}
+		return "", err
+	}
+	if material.CourseUuid != courseId {
+		return "", ErrMaterialNotFound
+	}
+	return material.Type, nil
+}
+
+// translates the errors of video materials to responses
+func videoError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrMaterialNotFound:
+		return r.Error(http.StatusNotFound, "Material not found")
+	case ErrNotAVideo:
+		return r.Error(http.StatusBadRequest, err.Error())
+	case ErrMaterialNotAccessible:
+		return r.Error(http.StatusForbidden, "The material is not open")
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+	return r.ServerError(err)
+}
@@ -9,6 +9,7 @@ var (
 	ErrQuizNotFound       = errors.New("Quiz not found")
 	ErrBadQuestionType    = errors.New("Question type can be either singleChoice or multipleChoice")
 	ErrBadNumberOfAnswers = errors.New("Number of answers must match the number of questions")
+	ErrBadDeadline        = errors.New("Deadline must be a time (2006-01-02T15:04:05Z)")
 )
 
 type ErrQuestionBadFormat struct {
@@ -64,6 +64,10 @@ func (h *Handler) CreateQuiz(c echo.Context) error {
 
 	dbQuiz, err := h.service.CreateQuiz(quiz, courseId, r.Ctx)
 	if err != nil {
+		if err == ErrBadDeadline {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+
 		var eqbf *ErrQuestionBadFormat
 
 		if errors.As(err, &eqbf) {
@@ -127,6 +131,9 @@ func (h *Handler) UpdateQuiz(c echo.Context) error {
 		if err == ErrBadQuestionType {
 			return r.Error(http.StatusBadRequest, "invalid question type")
 		}
+		if err == ErrBadDeadline {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
 
 		var eqbf *ErrQuestionBadFormat
 
@@ -38,6 +38,9 @@ type Quiz struct {
 	AttemptsCount int        `json:"attemptsCount"`
 	Questions     []Question `json:"questions"`
 
+	// shown with the quiz and in the calendar of the course, null for none
+	Deadline *string `json:"deadline"`
+
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
 
@@ -103,6 +106,27 @@ func (s *Service) validateQuestions(questions []Question) error {
 	return nil
 }
 
+// the deadline is a time (RFC 3339), empty or missing for none
+func parseDeadline(deadline *string) (sql.NullInt64, error) {
+	if deadline == nil || *deadline == "" {
+		return sql.NullInt64{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, *deadline)
+	if err != nil {
+		return sql.NullInt64{}, ErrBadDeadline
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}, nil
+}
+
+func deadlineToIso(deadlineAt sql.NullInt64) *string {
+	if !deadlineAt.Valid {
+		return nil
+	}
+	deadline := utils.UnixToIso(deadlineAt.Int64)
+	return &deadline
+}
+
 func (s *Service) CreateQuiz(quiz Quiz, courseId string, ctx context.Context) (*Quiz, error) {
 
 	err := s.validateQuestions(quiz.Questions)
@@ -110,6 +134,11 @@ func (s *Service) CreateQuiz(quiz Quiz, courseId string, ctx context.Context) (*
 		return nil, err
 	}
 
+	deadlineAt, err := parseDeadline(quiz.Deadline)
+	if err != nil {
+		return nil, err
+	}
+
 	now := time.Now().Unix()
 
 	dbQuiz, err := s.q.CreateQuiz(ctx, db.CreateQuizParams{
@@ -117,6 +146,7 @@ func (s *Service) CreateQuiz(quiz Quiz, courseId string, ctx context.Context) (*
 		CourseUuid:    courseId,
 		Title:         quiz.Title,
 		AttemptsCount: 0,
+		DeadlineAt:    deadlineAt,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 	})
@@ -163,6 +193,7 @@ func (s *Service) dbQuizToQuiz(dbQuiz db.Quiz, questions []db.Question) (*Quiz,
 		Uuid:          dbQuiz.Uuid,
 		Title:         dbQuiz.Title,
 		AttemptsCount: int(dbQuiz.AttemptsCount),
+		Deadline:      deadlineToIso(dbQuiz.DeadlineAt),
 		CreatedAt:     utils.UnixToIso(dbQuiz.CreatedAt),
 	}
 	quiz.Questions = make([]Question, 0, len(questions))
@@ -221,6 +252,11 @@ func (s *Service) UpdateQuiz(quiz *Quiz, ctx context.Context) (*Quiz, error) {
 		return nil, err
 	}
 
+	deadlineAt, err := parseDeadline(quiz.Deadline)
+	if err != nil {
+		return nil, err
+	}
+
 	dbQuiz, err := s.q.UpdateQuiz(ctx, db.UpdateQuizParams{
 		Title:         utils.ToSqlNullString(&quiz.Title),
 		AttemptsCount: sql.NullInt64{Int64: 0, Valid: false},
@@ -231,6 +267,16 @@ func (s *Service) UpdateQuiz(quiz *Quiz, ctx context.Context) (*Quiz, error) {
 		return nil, err
 	}
 
+	// the quiz is sent whole, a missing deadline removes it
+	err = s.q.SetQuizDeadline(ctx, db.SetQuizDeadlineParams{
+		DeadlineAt: deadlineAt,
+		Uuid:       quiz.Uuid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	dbQuiz.DeadlineAt = deadlineAt
+
 	_, err = s.q.DeleteQuestionsOfQuiz(ctx, quiz.Uuid)
 	if err != nil {
 		return nil, err
@@ -286,6 +332,7 @@ func (s *Service) convertGetQuizRowsToQuiz(rows []db.GetQuizRow) (*Quiz, error)
 		Uuid:          r.QuizUuid,
 		Title:         r.QuizTitle,
 		AttemptsCount: int(r.QuizAttemptsCount),
+		Deadline:      deadlineToIso(r.QuizDeadlineAt),
 		Questions:     make([]Question, 0, len(rows)),
 	}
 
@@ -375,6 +422,7 @@ func (s *Service) convertListQuizRowsToQuizzes(rows []db.ListQuizesRow) ([]Quiz,
 				Uuid:          qr.QuizUuid,
 				Title:         qr.QuizTitle,
 				AttemptsCount: int(qr.QuizAttemptsCount),
+				Deadline:      deadlineToIso(qr.QuizDeadlineAt),
 				Questions:     make([]Question, 0, len(rows)),
 
 				CreatedAt: utils.UnixToIso(qr.QuizCreatedAt),
@@ -555,7 +603,7 @@ func (s *Service) SubmitQuizAnswers(quizId string, answers SubmitQuizAnswersRequ
 		return nil, err
 	}
 
-	s.feedsService.CreateInfoPost("Quiz "+quizId+" has had an attempt submited", courseId, ctx)
+	s.feedsService.BroadcastQuizSubmitted(quizId, outcome.Score, outcome.MaxScore, courseId)
 
 	return &outcome, nil
 }
@@ -617,7 +665,7 @@ func (s *Service) AssignQuizToModule(quizId string, moduleId string, order int,
 		return db.QuizToModule{}, err
 	}
 
-	s.feedsService.CreateInfoPost("Quiz "+quizId+" assigned to module", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Quiz "+quizId+" assigned to module", courseId)
 
 	return mm, nil
 }
@@ -633,7 +681,7 @@ func (s *Service) ChangeQuizInModuleOrder(quizId string, moduleId string, order
 		return db.QuizToModule{}, err
 	}
 
-	s.feedsService.CreateInfoPost("Quiz "+quizId+" changed order", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Quiz "+quizId+" changed order", courseId)
 
 	return mm, nil
 }
@@ -648,7 +696,7 @@ func (s *Service) RemoveQuizToModule(quizId string, moduleId string, order int,
 		return err
 	}
 
-	s.feedsService.CreateInfoPost("Quiz "+quizId+" removed from module", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Quiz "+quizId+" removed from module", courseId)
 
 	return nil
 }
@@ -0,0 +1,213 @@
+package courses
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* the reconciler compares the storage with the db - files no material, version or attachment points to
+// (left behind by a crash or a failed removal) are removed once they are ORPHANED_FILE_AGE old,
+// rows whose file is missing can't be fixed automatically, they are reported and logged for the lecturers
+
+// how often the reconciler checks the storage
+var STORAGE_RECONCILE_INTERVAL = 24 * time.Hour
+
+// younger files without a row are left alone, their material or attachment may be just being created
+var ORPHANED_FILE_AGE = time.Hour
+
+// all files of courses are under uploads/<courseId>/, the avatars in uploads/avatars are not checked
+const COURSE_FILES_PREFIX = "uploads/"
+
+type OrphanedFile struct {
+	Key        string `json:"key"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	ModifiedAt string `json:"modifiedAt"`
+	// false for dry runs, files younger than ORPHANED_FILE_AGE and failed removals
+	Removed bool `json:"removed"`
+}
+
+type MissingFile struct {
+	Key        string `json:"key"`
+	CourseUuid string `json:"courseUuid"`
+	Type       string `json:"type"` // "material" or "attachment"
+	Uuid       string `json:"uuid"` // of the material or of the post of the attachment
+}
+
+type ReconcileReport struct {
+	CheckedFiles  int            `json:"checkedFiles"`
+	OrphanedFiles []OrphanedFile `json:"orphanedFiles"`
+	FreedBytes    int64          `json:"freedBytes"`
+	MissingFiles  []MissingFile  `json:"missingFiles"`
+	DryRun        bool           `json:"dryRun"`
+	FinishedAt    string         `json:"finishedAt"`
+}
+
+// files of materials and post attachments, uploads/<courseId>/materials/... and uploads/<courseId>/feed/...
+func isCourseFile(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) > 3 && parts[0] == "uploads" && (parts[2] == "materials" || parts[2] == "feed")
+}
+
+// ReconcileStorage finds the files without rows and the rows without files, with dryRun nothing is removed
+func (s *Service) ReconcileStorage(dryRun bool, ctx context.Context) (ReconcileReport, error) {
+	// the rows are read first, a file saved in the meantime is too young to be removed
+	rows, err := s.q.ListStoredFiles(ctx)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	objects, err := s.storage.List(ctx, COURSE_FILES_PREFIX)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+
+	report := ReconcileReport{
+		OrphanedFiles: []OrphanedFile{},
+		MissingFiles:  []MissingFile{},
+		DryRun:        dryRun,
+	}
+
+	known := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		known[uploads.StorageKey(row.FileKey)] = true
+	}
+
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		if !isCourseFile(object.Key) {
+			continue
+		}
+		stored[object.Key] = true
+		report.CheckedFiles++
+
+		if known[object.Key] {
+			continue
+		}
+
+		orphan := OrphanedFile{
+			Key:        object.Key,
+			SizeBytes:  object.Size,
+			ModifiedAt: utils.UnixToIso(object.ModTime.Unix()),
+		}
+
+		if !dryRun && time.Since(object.ModTime) >= ORPHANED_FILE_AGE {
+			err := s.storage.Remove(ctx, object.Key)
+			if err != nil {
+				fmt.Println("failed to remove orphaned file:", err)
+			} else {
+				orphan.Removed = true
+				report.FreedBytes += object.Size
+			}
+		}
+		report.OrphanedFiles = append(report.OrphanedFiles, orphan)
+	}
+
+	for _, row := range rows {
+		key := uploads.StorageKey(row.FileKey)
+		if stored[key] {
+			continue
+		}
+
+		report.MissingFiles = append(report.MissingFiles, MissingFile{
+			Key:        key,
+			CourseUuid: row.CourseUuid,
+			Type:       row.OwnerType,
+			Uuid:       row.OwnerUuid,
+		})
+	}
+
+	report.FinishedAt = utils.UnixToIso(time.Now().Unix())
+	return report, nil
+}
+
+// runs the reconciliation and logs what it found
+func (s *Service) reconcileStorage(ctx context.Context) {
+	report, err := s.ReconcileStorage(false, ctx)
+	if err != nil {
+		fmt.Println("failed to reconcile the storage:", err)
+		return
+	}
+
+	removed := 0
+	for _, orphan := range report.OrphanedFiles {
+		if orphan.Removed {
+			removed++
+		}
+	}
+	if removed > 0 {
+		fmt.Printf("removed %v orphaned files (%v bytes)\n", removed, report.FreedBytes)
+	}
+
+	for _, missing := range report.MissingFiles {
+		fmt.Println("missing file", missing.Key, "of", missing.Type, missing.Uuid, "in course", missing.CourseUuid)
+	}
+}
+
+type StorageReconciler struct {
+	service *Service
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewStorageReconciler(service *Service) *StorageReconciler {
+	return &StorageReconciler{
+		service: service,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (r *StorageReconciler) Start() {
+	r.started = true
+	go r.run()
+}
+
+func (r *StorageReconciler) run() {
+	defer close(r.done)
+
+	// the first check is after the interval, restarts of the server don't list the whole storage every time
+	ticker := time.NewTicker(STORAGE_RECONCILE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.service.reconcileStorage(context.Background())
+		}
+	}
+}
+
+func (r *StorageReconciler) Close() error {
+	if !r.started {
+		return nil
+	}
+
+	close(r.stop)
+	<-r.done
+	return nil
+}
+
+// POST /storage/reconcile
+// with ?dryRun the orphaned files are only reported
+func (h *CourseHandler) ReconcileStorage(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	report, err := h.service.ReconcileStorage(c.QueryParams().Has("dryRun"), r.Ctx)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
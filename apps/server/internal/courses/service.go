@@ -13,6 +13,7 @@ import (
 	"tourbackend/internal/courses/quizzes"
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/feeds"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 
 	"github.com/google/uuid"
@@ -33,14 +34,16 @@ var ALLOWED_MODULE_STATES []string = []string{
 
 type Service struct {
 	q                *db.Queries
+	storage          uploads.Storage
 	materialsService *materials.Service
 	quizzesService   *quizzes.Service
 	feedsService     *feeds.Service
 }
 
-func NewService(queries *db.Queries, materialsService *materials.Service, quizzesService *quizzes.Service, feedsService *feeds.Service) *Service {
+func NewService(queries *db.Queries, storage uploads.Storage, materialsService *materials.Service, quizzesService *quizzes.Service, feedsService *feeds.Service) *Service {
 	return &Service{
 		queries,
+		storage,
 		materialsService,
 		quizzesService,
 		feedsService,
@@ -57,6 +60,9 @@ type Module struct {
 
 	Order int `json:"order"`
 
+	// state change planned with openTime, null when there is none
+	Schedule *Schedule `json:"schedule"`
+
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
 
@@ -64,6 +70,18 @@ type Module struct {
 	// NewItemOrder int    `json:"newItemOrder"`
 }
 
+type Schedule struct {
+	State string `json:"state"`
+	At    string `json:"at"`
+}
+
+func dbScheduleToSchedule(state sql.NullString, at sql.NullInt64) *Schedule {
+	if !state.Valid || !at.Valid {
+		return nil
+	}
+	return &Schedule{State: state.String, At: utils.UnixToIso(at.Int64)}
+}
+
 type FullModule struct {
 	Module
 	Items        []Item `json:"items"`
@@ -81,6 +99,8 @@ func (s *Service) dbModuleToModule(dbM db.Module) Module {
 
 		Order: int(dbM.ModuleOrder),
 
+		Schedule: dbScheduleToSchedule(dbM.ScheduledState, dbM.ScheduledAt),
+
 		CreatedAt: utils.UnixToIso(dbM.CreatedAt),
 		UpdatedAt: utils.UnixToIso(dbM.UpdatedAt),
 	}
@@ -110,7 +130,7 @@ func (s *Service) CreateCourse(params db.CreateCourseParams, ctx context.Context
 		return nil, err
 	}
 
-	_, err = s.ChangeModuleState(course.Uuid, module.Uuid, "open", nil, ctx)
+	_, err = s.ChangeModuleState(course.Uuid, module.Uuid, "open", nil, nil, ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -131,10 +151,15 @@ type GetCourseResponse struct {
 	HighligtedModuleId       *string `json:"highlightedModuleId"`
 	HighlightedModuleMessage *string `json:"highlightedModuleMessage"`
 
+	// state change planned with openTime, null when there is none
+	Schedule *Schedule `json:"schedule"`
+
 	Materials []materials.Material `json:"materials"`
 	Quizzes   []quizzes.Quiz       `json:"quizzes"`
 
-	Feed []feeds.FeedPostResponse `json:"feed"`
+	// only the latest page of the feed, the following pages are loaded from /courses/{courseId}/feed?cursor=
+	Feed           []feeds.FeedPostResponse `json:"feed"`
+	FeedNextCursor *string                  `json:"feedNextCursor,omitempty"`
 
 	Modules []FullModule `json:"modules"`
 }
@@ -159,6 +184,8 @@ func (s *Service) GetCourse(courseId string, host string, scheme string, isAdmin
 				State:       course.State,
 				Archived:    course.Archived == 1,
 
+				Schedule: dbScheduleToSchedule(course.ScheduledState, course.ScheduledAt),
+
 				Materials: []materials.Material{},
 				Quizzes:   []quizzes.Quiz{},
 
@@ -180,7 +207,7 @@ func (s *Service) GetCourse(courseId string, host string, scheme string, isAdmin
 		return nil, err
 	}
 
-	feed, err := s.feedsService.GetFeed(ctx, courseId)
+	feedPage, err := s.feedsService.ListFeed(ctx, courseId, feeds.FeedFilter{})
 	if err != nil {
 		fmt.Println(err)
 		return nil, err
@@ -232,10 +259,6 @@ func (s *Service) GetCourse(courseId string, host string, scheme string, isAdmin
 
 	}
 
-	if feed == nil {
-		feed = []feeds.FeedPostResponse{}
-	}
-
 	courseDetail := GetCourseResponse{
 		Uuid: course.Uuid,
 
@@ -244,14 +267,20 @@ func (s *Service) GetCourse(courseId string, host string, scheme string, isAdmin
 		State:       course.State,
 		Archived:    course.Archived == 1,
 
+		Schedule: dbScheduleToSchedule(course.ScheduledState, course.ScheduledAt),
+
 		Materials: mats,
 		Quizzes:   quizzes,
 
-		Feed: feed,
+		Feed: feedPage.Posts,
 
 		Modules: fullModules,
 	}
 
+	if feedPage.NextCursor != "" {
+		courseDetail.FeedNextCursor = &feedPage.NextCursor
+	}
+
 	if course.HighlightedModuleMessage.Valid {
 		courseDetail.HighlightedModuleMessage = &course.HighlightedModuleMessage.String
 	}
@@ -294,6 +323,9 @@ func (s *Service) DeleteCourse(courseId string, ctx context.Context) error {
 		return ErrCourseNotFound
 	}
 
+	// the rows of the files are deleted with the course
+	s.removeCourseFiles(courseId, ctx)
+
 	s.feedsService.CreateAutomaticPost("Course "+courseId+" deleted", courseId, ctx)
 
 	return nil
@@ -315,16 +347,38 @@ func (s *Service) ChangeCourseState(courseId string, state string, openTime *str
 
 	if openTime != nil {
 
-		openingTime, err := time.ParseInLocation(
-			"2006-01-02T15:04",
-			*openTime,
-			time.Local,
-		)
+		if !slices.Contains(ALLOWED_COURSE_STATES, state) {
+			return db.Course{}, ErrBadCourseState
+		}
+
+		openingTime, err := parseOpenTime(*openTime)
 		if err != nil {
 			return db.Course{}, err
 		}
 
-		until := time.Until(openingTime)
+		var mM sql.NullString
+		if hmM != nil {
+			mM.Valid = true
+			mM.String = *hmM
+		}
+
+		var mId sql.NullString
+		if hmId != nil {
+			mId.Valid = true
+			mId.String = *hmId
+		}
+
+		// kept in the db for the calendar of the course and to set the timer again after a restart
+		err = s.q.ScheduleCourseState(ctx, db.ScheduleCourseStateParams{
+			ScheduledState:         sql.NullString{String: state, Valid: true},
+			ScheduledAt:            sql.NullInt64{Int64: openingTime.Unix(), Valid: true},
+			ScheduledModuleUuid:    mId,
+			ScheduledModuleMessage: mM,
+			Uuid:                   courseId,
+		})
+		if err != nil {
+			return db.Course{}, err
+		}
 
 		var message string
 		switch state {
@@ -342,49 +396,7 @@ func (s *Service) ChangeCourseState(courseId string, state string, openTime *str
 
 		s.feedsService.CreateAutomaticPost(message, courseId, ctx)
 
-		// fmt.Println("changing in:", until)
-
-		time.AfterFunc(until, func() {
-			// fmt.Println("preping for change")
-
-			var mM sql.NullString
-			if hmM != nil {
-				mM.Valid = true
-				mM.String = *hmM
-			}
-
-			var mId sql.NullString
-			if hmId != nil {
-				mId.Valid = true
-				mId.String = *hmId
-			}
-
-			_, err = s.q.ChangeCourseState(context.Background(), db.ChangeCourseStateParams{
-				State:         state,
-				UpdatedAt:     time.Now().Unix(),
-				Uuid:          courseId,
-				ModuleMessage: mM,
-				ModuleUuid:    mId,
-			})
-			if err != nil {
-				fmt.Println(err)
-				fmt.Println("failed to update course", courseId, "at the given time")
-			}
-
-			var message string
-			switch state {
-			case "closed":
-				message = "Course is closed now"
-			case "preparation":
-				message = "Course is under construction now"
-			case "open":
-				message = "Course is open now"
-			}
-
-			s.feedsService.CreateAutomaticPost(message, courseId, context.Background())
-
-			// fmt.Println("updated satte after time!")
-		})
+		s.armCourseSchedule(courseId, state, openingTime.Unix(), mId, mM)
 
 		return db.Course{}, nil
 	}
@@ -435,9 +447,85 @@ func (s *Service) ChangeCourseState(courseId string, state string, openTime *str
 	return course, err
 }
 
+// changes the state of the course at the time of the schedule, the schedule is cleared first -
+// when it was replaced in the meantime (or another instance sharing the db carried it out) nothing happens
+func (s *Service) armCourseSchedule(courseId string, state string, at int64, mId sql.NullString, mM sql.NullString) {
+	time.AfterFunc(time.Until(time.Unix(at, 0)), func() {
+		ctx := context.Background()
+
+		n, err := s.q.ClearCourseSchedule(ctx, db.ClearCourseScheduleParams{
+			Uuid:        courseId,
+			ScheduledAt: sql.NullInt64{Int64: at, Valid: true},
+		})
+		if err != nil {
+			fmt.Println("failed to clear the schedule of course", courseId, err)
+			return
+		}
+		if n == 0 {
+			return
+		}
+
+		_, err = s.q.ChangeCourseState(ctx, db.ChangeCourseStateParams{
+			State:         state,
+			UpdatedAt:     time.Now().Unix(),
+			Uuid:          courseId,
+			ModuleMessage: mM,
+			ModuleUuid:    mId,
+		})
+		if err != nil {
+			fmt.Println(err)
+			fmt.Println("failed to update course", courseId, "at the given time")
+			return
+		}
+
+		var message string
+		switch state {
+		case "closed":
+			message = "Course is closed now"
+		case "preparation":
+			message = "Course is under construction now"
+		case "open":
+			message = "Course is open now"
+		}
+
+		s.feedsService.CreateAutomaticPost(message, courseId, ctx)
+	})
+}
+
+// RestoreSchedules sets the timers of the planned course and module state changes again, they are only kept
+// in memory - the changes that were due while the server was down are carried out right away
+func (s *Service) RestoreSchedules(ctx context.Context) error {
+	courses, err := s.q.ListScheduledCourses(ctx)
+	if err != nil {
+		return err
+	}
+	for _, course := range courses {
+		s.armCourseSchedule(course.Uuid, course.ScheduledState.String, course.ScheduledAt.Int64, course.ScheduledModuleUuid, course.ScheduledModuleMessage)
+	}
+
+	modules, err := s.q.ListScheduledModules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, module := range modules {
+		s.armModuleSchedule(module.CourseUuid, module.Uuid, module.ScheduledState.String, module.ScheduledAt.Int64)
+	}
+
+	return nil
+}
+
+// the time comes from a datetime-local input, it's in the local time of the server
+func parseOpenTime(openTime string) (time.Time, error) {
+	openingTime, err := time.ParseInLocation("2006-01-02T15:04", openTime, time.Local)
+	if err != nil {
+		return time.Time{}, ErrBadOpenTime
+	}
+	return openingTime, nil
+}
+
 func (s *Service) ArchiveCourse(courseId string, ctx context.Context) error {
 
-	s.feedsService.CreateInfoPost("Course is archived now", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Course is archived now", courseId)
 
 	return s.q.ArchiveCourse(ctx, courseId)
 }
@@ -461,18 +549,22 @@ func (s *Service) CreateModule(courseId string, moduleId string, name string, de
 		return Module{}, err
 	}
 
-	s.feedsService.CreateInfoPost("Module "+dbModule.Name+" has been created", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Module "+dbModule.Name+" has been created", courseId)
 
 	return s.dbModuleToModule(dbModule), nil
 
 }
 
-func (s *Service) ChangeModuleState(courseId string, moduleId string, state string, order *int, ctx context.Context) (Module, error) {
+func (s *Service) ChangeModuleState(courseId string, moduleId string, state string, order *int, openTime *string, ctx context.Context) (Module, error) {
 
 	if !slices.Contains(ALLOWED_MODULE_STATES, state) {
 		return Module{}, ErrBadModuleState
 	}
 
+	if openTime != nil {
+		return s.scheduleModuleState(courseId, moduleId, state, *openTime, ctx)
+	}
+
 	now := time.Now().Unix()
 
 	var moduleOrder sql.NullInt64
@@ -509,6 +601,71 @@ func (s *Service) ChangeModuleState(courseId string, moduleId string, state stri
 	return s.dbModuleToModule(dbModule), err
 }
 
+// changes the state of the module at openTime, the same way as for courses
+func (s *Service) scheduleModuleState(courseId string, moduleId string, state string, openTime string, ctx context.Context) (Module, error) {
+
+	openingTime, err := parseOpenTime(openTime)
+	if err != nil {
+		return Module{}, err
+	}
+
+	module, err := s.GetModule(courseId, moduleId, ctx)
+	if err != nil {
+		return Module{}, err
+	}
+
+	at := sql.NullInt64{Int64: openingTime.Unix(), Valid: true}
+
+	err = s.q.ScheduleModuleState(ctx, db.ScheduleModuleStateParams{
+		ScheduledState: sql.NullString{String: state, Valid: true},
+		ScheduledAt:    at,
+		Uuid:           moduleId,
+	})
+	if err != nil {
+		return Module{}, err
+	}
+
+	var message string
+	switch state {
+	case "closed":
+		message = "Module " + module.Name + " will be closed at " + openingTime.String()
+	case "preparation":
+		message = "Module " + module.Name + " will switch to under construction at " + openingTime.String()
+	case "open":
+		message = "Module " + module.Name + " will open at " + openingTime.String()
+	}
+	s.feedsService.CreateAutomaticPost(message, courseId, ctx)
+
+	s.armModuleSchedule(courseId, moduleId, state, openingTime.Unix())
+
+	module.Schedule = &Schedule{State: state, At: utils.UnixToIso(openingTime.Unix())}
+	return module, nil
+}
+
+// changes the state of the module at the time of the schedule, the same way as armCourseSchedule
+func (s *Service) armModuleSchedule(courseId string, moduleId string, state string, at int64) {
+	time.AfterFunc(time.Until(time.Unix(at, 0)), func() {
+		ctx := context.Background()
+
+		n, err := s.q.ClearModuleSchedule(ctx, db.ClearModuleScheduleParams{
+			Uuid:        moduleId,
+			ScheduledAt: sql.NullInt64{Int64: at, Valid: true},
+		})
+		if err != nil {
+			fmt.Println("failed to clear the schedule of module", moduleId, err)
+			return
+		}
+		if n == 0 {
+			return
+		}
+
+		_, err = s.ChangeModuleState(courseId, moduleId, state, nil, nil, ctx)
+		if err != nil {
+			fmt.Println("failed to update module", moduleId, "at the given time", err)
+		}
+	})
+}
+
 func (s *Service) GetModule(courseId string, moduleId string, ctx context.Context) (Module, error) {
 
 	module, err := s.q.GetModule(ctx, db.GetModuleParams{
@@ -566,7 +723,7 @@ func (s *Service) DeleteModule(courseId string, moduleId string, ctx context.Con
 		return err
 	}
 
-	s.feedsService.CreateInfoPost("Module "+moduleId+" deleted", courseId, ctx)
+	s.feedsService.BroadcastCourseChanged("Module "+moduleId+" deleted", courseId)
 
 	return nil
 }
@@ -0,0 +1,115 @@
+package courses
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* files of the courses in the storage - all of them are under uploads/<courseId>/ and are removed with the course,
+// the space they take is limited by the quota of the course (see uploads.CheckQuota)
+
+func courseFilesPrefix(courseId string) string {
+	return "uploads/" + courseId + "/"
+}
+
+// removes every file of the course, failures are only logged, the reconciler removes the files later
+func (s *Service) removeCourseFiles(courseId string, ctx context.Context) {
+	objects, err := s.storage.List(ctx, courseFilesPrefix(courseId))
+	if err != nil {
+		fmt.Println("failed to list files of course", courseId, err)
+		return
+	}
+
+	for _, object := range objects {
+		err := s.storage.Remove(ctx, object.Key)
+		if err != nil {
+			fmt.Println("failed to remove course file:", err)
+		}
+	}
+}
+
+func (s *Service) StorageUsage(courseId string, ctx context.Context) (uploads.StorageUsage, error) {
+	usage, err := uploads.CourseUsage(ctx, s.q, courseId)
+	if err == uploads.ErrUnknownCourse {
+		return uploads.StorageUsage{}, ErrCourseNotFound
+	}
+	return usage, err
+}
+
+// SetStorageQuota changes the space for the files of the course, nil sets the default STORAGE_QUOTA,
+// files over a lowered quota are kept, only new ones are refused
+func (s *Service) SetStorageQuota(courseId string, quota *int64, ctx context.Context) (uploads.StorageUsage, error) {
+	if quota != nil && *quota <= 0 {
+		return uploads.StorageUsage{}, &utils.ErrBadRequest{Message: "quotaBytes has to be positive"}
+	}
+
+	n, err := s.q.SetCourseStorageQuota(ctx, db.SetCourseStorageQuotaParams{
+		StorageQuota: utils.ToSqlNullInt64(quota),
+		UpdatedAt:    time.Now().Unix(),
+		Uuid:         courseId,
+	})
+	if err != nil {
+		return uploads.StorageUsage{}, err
+	}
+	if n == 0 {
+		return uploads.StorageUsage{}, ErrCourseNotFound
+	}
+
+	return s.StorageUsage(courseId, ctx)
+}
+
+type StorageQuotaRequest struct {
+	// nil sets the default quota
+	QuotaBytes *int64 `json:"quotaBytes"`
+}
+
+func storageError(r *handlers.RequestCtx, err error) error {
+	if err == ErrCourseNotFound {
+		return r.Error(http.StatusNotFound, "Unknown courseId")
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+	return r.ServerError(err)
+}
+
+// GET /courses/{courseId}/storage
+func (h *CourseHandler) GetStorageUsage(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	usage, err := h.service.StorageUsage(c.Param("courseId"), r.Ctx)
+	if err != nil {
+		return storageError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
+
+// PUT /courses/{courseId}/storage
+func (h *CourseHandler) SetStorageQuota(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req StorageQuotaRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	usage, err := h.service.SetStorageQuota(c.Param("courseId"), req.QuotaBytes, r.Ctx)
+	if err != nil {
+		return storageError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
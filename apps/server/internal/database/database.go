@@ -30,16 +30,39 @@ func Initialize(resetDB bool) (*sql.DB, *gen.Queries) {
 
 	ctx := context.Background()
 
-	db, err := sql.Open("sqlite", PATH_TO_DB)
+	// several instances of the server can share the db file (see the sqlite feed broker),
+	// the busy timeout makes a write wait for the other writer instead of failing right away,
+	// foreign keys are a setting of each connection, so the ON DELETE CASCADE of the schema needs them in the dsn
+	db, err := sql.Open("sqlite", PATH_TO_DB+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
 	if err != nil {
 		panic(err)
 	}
 
-	if resetDB {
-		// create tables
-		if _, err := db.ExecContext(ctx, ddl); err != nil {
+	// columns added to the tables of older dbs, before schema.sql which indexes some of them
+	if err := migrate(ctx, db); err != nil {
+		panic(err)
+	}
+
+	// the search index of an older db (or one dropped by a migration) is created below, empty
+	hasSearchIndex, err := tableExists(ctx, db, "feed_posts_fts")
+	if err != nil {
+		panic(err)
+	}
+
+	// create the missing tables, every statement of the schema is IF NOT EXISTS,
+	// which makes the first run without RESET_DB work too
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		panic(err)
+	}
+
+	// fill the new index with the posts that already exist
+	if !hasSearchIndex {
+		if _, err := db.ExecContext(ctx, `INSERT INTO feed_posts_fts (feed_posts_fts) VALUES ('rebuild')`); err != nil {
 			panic(err)
 		}
+	}
+
+	if resetDB {
 		fmt.Println("Reseted db")
 	}
 
@@ -32,16 +32,80 @@ type Course struct {
 	HighlightedModuleMessage sql.NullString `json:"highlighted_module_message"`
 	Archived                 int64          `json:"archived"`
 	State                    string         `json:"state"`
+	ScheduledState           sql.NullString `json:"scheduled_state"`
+	ScheduledAt              sql.NullInt64  `json:"scheduled_at"`
+	ScheduledModuleUuid      sql.NullString `json:"scheduled_module_uuid"`
+	ScheduledModuleMessage   sql.NullString `json:"scheduled_module_message"`
+	MaxUploadSize            sql.NullInt64  `json:"max_upload_size"`
+	StorageQuota             sql.NullInt64  `json:"storage_quota"`
 }
 
-type FeedPost struct {
-	Uuid       string `json:"uuid"`
+type EmailChange struct {
+	Token     string `json:"token"`
+	UserID    int64  `json:"user_id"`
+	NewEmail  string `json:"new_email"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type FeedAttachment struct {
+	Uuid         string         `json:"uuid"`
+	PostUuid     string         `json:"post_uuid"`
+	Type         string         `json:"type"`
+	Position     int64          `json:"position"`
+	Name         sql.NullString `json:"name"`
+	Path         sql.NullString `json:"path"`
+	MimeType     sql.NullString `json:"mime_type"`
+	ByteSize     sql.NullInt64  `json:"byte_size"`
+	MaterialUuid sql.NullString `json:"material_uuid"`
+	QuizUuid     sql.NullString `json:"quiz_uuid"`
+	CreatedAt    int64          `json:"created_at"`
+}
+
+type FeedComment struct {
+	Uuid       string         `json:"uuid"`
+	PostUuid   string         `json:"post_uuid"`
+	ParentUuid sql.NullString `json:"parent_uuid"`
+	UserID     int64          `json:"user_id"`
+	Message    string         `json:"message"`
+	IsHidden   bool           `json:"is_hidden"`
+	CreatedAt  int64          `json:"created_at"`
+}
+
+type FeedEvent struct {
+	ID         int64  `json:"id"`
 	CourseUuid string `json:"course_uuid"`
-	Type       string `json:"type"`
-	Message    string `json:"message"`
-	IsEdited   bool   `json:"is_edited"`
+	Name       string `json:"name"`
+	Data       string `json:"data"`
 	CreatedAt  int64  `json:"created_at"`
-	UpdatedAt  int64  `json:"updated_at"`
+}
+
+type FeedPost struct {
+	ID          int64         `json:"id"`
+	Uuid        string        `json:"uuid"`
+	CourseUuid  string        `json:"course_uuid"`
+	Type        string        `json:"type"`
+	Message     string        `json:"message"`
+	IsEdited    bool          `json:"is_edited"`
+	IsPinned    bool          `json:"is_pinned"`
+	IsLocked    bool          `json:"is_locked"`
+	CreatedAt   int64         `json:"created_at"`
+	UpdatedAt   int64         `json:"updated_at"`
+	PublishAt   int64         `json:"publish_at"`
+	IsPublished bool          `json:"is_published"`
+	ExpiresAt   sql.NullInt64 `json:"expires_at"`
+	IsExpired   bool          `json:"is_expired"`
+}
+
+type FeedPostsFt struct {
+	Message string `json:"message"`
+}
+
+type FeedReaction struct {
+	PostUuid  string `json:"post_uuid"`
+	UserID    int64  `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	CreatedAt int64  `json:"created_at"`
 }
 
 type Heading struct {
@@ -59,19 +123,35 @@ type HeadingToModule struct {
 	Order       int64  `json:"order"`
 }
 
+type LinkPreview struct {
+	Url         string         `json:"url"`
+	Title       sql.NullString `json:"title"`
+	Description sql.NullString `json:"description"`
+	ImageUrl    sql.NullString `json:"image_url"`
+	FaviconUrl  sql.NullString `json:"favicon_url"`
+	Duration    sql.NullInt64  `json:"duration"`
+	FetchedAt   sql.NullInt64  `json:"fetched_at"`
+	NextFetchAt int64          `json:"next_fetch_at"`
+	Failures    int64          `json:"failures"`
+	Error       sql.NullString `json:"error"`
+}
+
 type Material struct {
-	Uuid          string         `json:"uuid"`
-	CourseUuid    string         `json:"course_uuid"`
-	Name          string         `json:"name"`
-	Description   string         `json:"description"`
-	Url           string         `json:"url"`
-	Type          string         `json:"type"`
-	TimesAccessed int64          `json:"times_accessed"`
-	FaviconUrl    sql.NullString `json:"favicon_url"`
-	MimeType      sql.NullString `json:"mime_type"`
-	ByteSize      sql.NullInt64  `json:"byte_size"`
-	CreatedAt     int64          `json:"created_at"`
-	UpdatedAt     int64          `json:"updated_at"`
+	Uuid            string         `json:"uuid"`
+	CourseUuid      string         `json:"course_uuid"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Url             string         `json:"url"`
+	Type            string         `json:"type"`
+	TimesAccessed   int64          `json:"times_accessed"`
+	FaviconUrl      sql.NullString `json:"favicon_url"`
+	MimeType        sql.NullString `json:"mime_type"`
+	ByteSize        sql.NullInt64  `json:"byte_size"`
+	VideoProvider   sql.NullString `json:"video_provider"`
+	VideoID         sql.NullString `json:"video_id"`
+	DurationSeconds sql.NullInt64  `json:"duration_seconds"`
+	CreatedAt       int64          `json:"created_at"`
+	UpdatedAt       int64          `json:"updated_at"`
 }
 
 type MaterialToModule struct {
@@ -80,15 +160,39 @@ type MaterialToModule struct {
 	Order        int64  `json:"order"`
 }
 
+type MaterialVersion struct {
+	Uuid         string         `json:"uuid"`
+	MaterialUuid string         `json:"material_uuid"`
+	Version      int64          `json:"version"`
+	Url          string         `json:"url"`
+	MimeType     string         `json:"mime_type"`
+	ByteSize     int64          `json:"byte_size"`
+	ThumbnailKey sql.NullString `json:"thumbnail_key"`
+	PreviewText  sql.NullString `json:"preview_text"`
+	CreatedBy    sql.NullInt64  `json:"created_by"`
+	CreatedAt    int64          `json:"created_at"`
+}
+
 type Module struct {
-	Uuid        string `json:"uuid"`
-	CourseUuid  string `json:"course_uuid"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	State       string `json:"state"`
-	ModuleOrder int64  `json:"module_order"`
-	CreatedAt   int64  `json:"created_at"`
-	UpdatedAt   int64  `json:"updated_at"`
+	Uuid           string         `json:"uuid"`
+	CourseUuid     string         `json:"course_uuid"`
+	Name           string         `json:"name"`
+	Description    string         `json:"description"`
+	State          string         `json:"state"`
+	ModuleOrder    int64          `json:"module_order"`
+	ScheduledState sql.NullString `json:"scheduled_state"`
+	ScheduledAt    sql.NullInt64  `json:"scheduled_at"`
+	CreatedAt      int64          `json:"created_at"`
+	UpdatedAt      int64          `json:"updated_at"`
+}
+
+type OidcLoginState struct {
+	State        string `json:"state"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	RedirectTo   string `json:"redirect_to"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at"`
 }
 
 type Question struct {
@@ -102,12 +206,13 @@ type Question struct {
 }
 
 type Quiz struct {
-	Uuid          string `json:"uuid"`
-	CourseUuid    string `json:"course_uuid"`
-	Title         string `json:"title"`
-	AttemptsCount int64  `json:"attempts_count"`
-	CreatedAt     int64  `json:"created_at"`
-	UpdatedAt     int64  `json:"updated_at"`
+	Uuid          string        `json:"uuid"`
+	CourseUuid    string        `json:"course_uuid"`
+	Title         string        `json:"title"`
+	AttemptsCount int64         `json:"attempts_count"`
+	DeadlineAt    sql.NullInt64 `json:"deadline_at"`
+	CreatedAt     int64         `json:"created_at"`
+	UpdatedAt     int64         `json:"updated_at"`
 }
 
 type QuizToModule struct {
@@ -124,10 +229,76 @@ type Session struct {
 	ExpiresAt int64  `json:"expires_at"`
 }
 
+type Upload struct {
+	Uuid          string         `json:"uuid"`
+	CourseUuid    string         `json:"course_uuid"`
+	UserID        int64          `json:"user_id"`
+	Filename      string         `json:"filename"`
+	Size          int64          `json:"size"`
+	ReceivedBytes int64          `json:"received_bytes"`
+	Checksum      sql.NullString `json:"checksum"`
+	MimeType      sql.NullString `json:"mime_type"`
+	CreatedAt     int64          `json:"created_at"`
+	UpdatedAt     int64          `json:"updated_at"`
+	FinishedAt    sql.NullInt64  `json:"finished_at"`
+	ExpiresAt     int64          `json:"expires_at"`
+}
+
 type User struct {
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+}
+
+type UserIdentity struct {
 	ID        int64  `json:"id"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Hash      string `json:"hash"`
+	UserID    int64  `json:"user_id"`
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
 	Email     string `json:"email"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type VideoProgress struct {
+	MaterialUuid    string        `json:"material_uuid"`
+	UserID          int64         `json:"user_id"`
+	PositionSeconds int64         `json:"position_seconds"`
+	FurthestSeconds int64         `json:"furthest_seconds"`
+	DurationSeconds sql.NullInt64 `json:"duration_seconds"`
+	Completed       bool          `json:"completed"`
+	UpdatedAt       int64         `json:"updated_at"`
+}
+
+type Webhook struct {
+	Uuid       string `json:"uuid"`
+	CourseUuid string `json:"course_uuid"`
+	Url        string `json:"url"`
+	Secret     string `json:"secret"`
+	Events     string `json:"events"`
+	Format     string `json:"format"`
+	Active     int64  `json:"active"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+type WebhookDelivery struct {
+	Uuid           string         `json:"uuid"`
+	WebhookUuid    string         `json:"webhook_uuid"`
+	Event          string         `json:"event"`
+	Payload        string         `json:"payload"`
+	Status         string         `json:"status"`
+	Attempts       int64          `json:"attempts"`
+	NextAttemptAt  sql.NullInt64  `json:"next_attempt_at"`
+	ResponseStatus sql.NullInt64  `json:"response_status"`
+	ResponseBody   sql.NullString `json:"response_body"`
+	Error          sql.NullString `json:"error"`
+	DurationMs     sql.NullInt64  `json:"duration_ms"`
+	CreatedAt      int64          `json:"created_at"`
+	UpdatedAt      int64          `json:"updated_at"`
 }
@@ -8,8 +8,73 @@ package database
 import (
 	"context"
 	"database/sql"
+	"strings"
 )
 
+const addReaction = `-- name: AddReaction :exec
+INSERT OR IGNORE INTO feed_reaction (post_uuid, user_id, emoji, created_at)
+VALUES (?, ?, ?, ?)
+`
+
+type AddReactionParams struct {
+	PostUuid  string `json:"post_uuid"`
+	UserID    int64  `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (q *Queries) AddReaction(ctx context.Context, arg AddReactionParams) error {
+	_, err := q.db.ExecContext(ctx, addReaction,
+		arg.PostUuid,
+		arg.UserID,
+		arg.Emoji,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const advanceUpload = `-- name: AdvanceUpload :one
+UPDATE upload
+SET received_bytes = ?1, updated_at = ?2, expires_at = ?3
+WHERE uuid = ?4 AND received_bytes = ?5 AND finished_at IS NULL
+RETURNING uuid, course_uuid, user_id, filename, size, received_bytes, checksum, mime_type, created_at, updated_at, finished_at, expires_at
+`
+
+type AdvanceUploadParams struct {
+	ReceivedBytes int64  `json:"received_bytes"`
+	UpdatedAt     int64  `json:"updated_at"`
+	ExpiresAt     int64  `json:"expires_at"`
+	Uuid          string `json:"uuid"`
+	Offset        int64  `json:"offset"`
+}
+
+// moves the offset only when no other request moved it in the meantime
+func (q *Queries) AdvanceUpload(ctx context.Context, arg AdvanceUploadParams) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, advanceUpload,
+		arg.ReceivedBytes,
+		arg.UpdatedAt,
+		arg.ExpiresAt,
+		arg.Uuid,
+		arg.Offset,
+	)
+	var i Upload
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.UserID,
+		&i.Filename,
+		&i.Size,
+		&i.ReceivedBytes,
+		&i.Checksum,
+		&i.MimeType,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
 const archiveCourse = `-- name: ArchiveCourse :exec
 UPDATE course
 SET archived = 1
@@ -92,6 +157,23 @@ func (q *Queries) AssignQuizToModule(ctx context.Context, arg AssignQuizToModule
 	return i, err
 }
 
+const cancelWebhookDelivery = `-- name: CancelWebhookDelivery :exec
+UPDATE webhook_delivery
+SET status = 'failed', next_attempt_at = NULL, error = ?, updated_at = ?
+WHERE uuid = ?
+`
+
+type CancelWebhookDeliveryParams struct {
+	Error     sql.NullString `json:"error"`
+	UpdatedAt int64          `json:"updated_at"`
+	Uuid      string         `json:"uuid"`
+}
+
+func (q *Queries) CancelWebhookDelivery(ctx context.Context, arg CancelWebhookDeliveryParams) error {
+	_, err := q.db.ExecContext(ctx, cancelWebhookDelivery, arg.Error, arg.UpdatedAt, arg.Uuid)
+	return err
+}
+
 const changeCourseState = `-- name: ChangeCourseState :one
 UPDATE course
 SET
@@ -99,7 +181,7 @@ SET
     updated_at = ?2,
     highlighted_module_message = COALESCE(?3, highlighted_module_message),
     highlighted_module_uuid = COALESCE(?4, highlighted_module_uuid)
-WHERE uuid = ?5 RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state
+WHERE uuid = ?5 RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota
 `
 
 type ChangeCourseStateParams struct {
@@ -129,6 +211,12 @@ func (q *Queries) ChangeCourseState(ctx context.Context, arg ChangeCourseStatePa
 		&i.HighlightedModuleMessage,
 		&i.Archived,
 		&i.State,
+		&i.ScheduledState,
+		&i.ScheduledAt,
+		&i.ScheduledModuleUuid,
+		&i.ScheduledModuleMessage,
+		&i.MaxUploadSize,
+		&i.StorageQuota,
 	)
 	return i, err
 }
@@ -180,7 +268,7 @@ SET
     state = ?2,
     updated_at = ?3
 WHERE uuid = ?4
-RETURNING uuid, course_uuid, name, description, state, module_order, created_at, updated_at
+RETURNING uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at
 `
 
 type ChangeModuleStateParams struct {
@@ -205,6 +293,8 @@ func (q *Queries) ChangeModuleState(ctx context.Context, arg ChangeModuleStatePa
 		&i.Description,
 		&i.State,
 		&i.ModuleOrder,
+		&i.ScheduledState,
+		&i.ScheduledAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -253,13 +343,400 @@ func (q *Queries) CheckModuleExists(ctx context.Context, uuid string) (int64, er
 	return module_exists, err
 }
 
+const claimDueLinkPreviews = `-- name: ClaimDueLinkPreviews :many
+UPDATE link_preview
+SET next_fetch_at = CAST(?1 AS INTEGER)
+WHERE url IN (
+    SELECT url FROM link_preview
+    WHERE next_fetch_at <= CAST(?2 AS INTEGER)
+    ORDER BY next_fetch_at ASC
+    LIMIT ?3
+)
+RETURNING url, title, description, image_url, favicon_url, duration, fetched_at, next_fetch_at, failures, error
+`
+
+type ClaimDueLinkPreviewsParams struct {
+	LeaseUntil int64 `json:"lease_until"`
+	Now        int64 `json:"now"`
+	MaxCount   int64 `json:"max_count"`
+}
+
+// claims the due previews, the returned ones are not due again until the lease runs out
+func (q *Queries) ClaimDueLinkPreviews(ctx context.Context, arg ClaimDueLinkPreviewsParams) ([]LinkPreview, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueLinkPreviews, arg.LeaseUntil, arg.Now, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LinkPreview
+	for rows.Next() {
+		var i LinkPreview
+		if err := rows.Scan(
+			&i.Url,
+			&i.Title,
+			&i.Description,
+			&i.ImageUrl,
+			&i.FaviconUrl,
+			&i.Duration,
+			&i.FetchedAt,
+			&i.NextFetchAt,
+			&i.Failures,
+			&i.Error,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimDueWebhookDeliveries = `-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_delivery
+SET next_attempt_at = CAST(?1 AS INTEGER)
+WHERE uuid IN (
+    SELECT uuid FROM webhook_delivery
+    WHERE status = 'pending' AND next_attempt_at <= CAST(?2 AS INTEGER)
+    ORDER BY next_attempt_at ASC
+    LIMIT ?3
+)
+RETURNING uuid, webhook_uuid, event, payload, status, attempts, next_attempt_at, response_status, response_body, error, duration_ms, created_at, updated_at
+`
+
+type ClaimDueWebhookDeliveriesParams struct {
+	LeaseUntil int64 `json:"lease_until"`
+	Now        int64 `json:"now"`
+	MaxCount   int64 `json:"max_count"`
+}
+
+// claims the due deliveries, the returned ones are not due again until the lease runs out
+func (q *Queries) ClaimDueWebhookDeliveries(ctx context.Context, arg ClaimDueWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueWebhookDeliveries, arg.LeaseUntil, arg.Now, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.WebhookUuid,
+			&i.Event,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.ResponseStatus,
+			&i.ResponseBody,
+			&i.Error,
+			&i.DurationMs,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const clearCourseSchedule = `-- name: ClearCourseSchedule :execrows
+UPDATE course
+SET scheduled_state = NULL, scheduled_at = NULL, scheduled_module_uuid = NULL, scheduled_module_message = NULL
+WHERE uuid = ? AND scheduled_at = ?
+`
+
+type ClearCourseScheduleParams struct {
+	Uuid        string        `json:"uuid"`
+	ScheduledAt sql.NullInt64 `json:"scheduled_at"`
+}
+
+// only the schedule that is carried out is cleared, a newer one stays -
+// no affected row means the schedule was replaced or another instance carried it out
+func (q *Queries) ClearCourseSchedule(ctx context.Context, arg ClearCourseScheduleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, clearCourseSchedule, arg.Uuid, arg.ScheduledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const clearModuleSchedule = `-- name: ClearModuleSchedule :execrows
+UPDATE module SET scheduled_state = NULL, scheduled_at = NULL WHERE uuid = ? AND scheduled_at = ?
+`
+
+type ClearModuleScheduleParams struct {
+	Uuid        string        `json:"uuid"`
+	ScheduledAt sql.NullInt64 `json:"scheduled_at"`
+}
+
+func (q *Queries) ClearModuleSchedule(ctx context.Context, arg ClearModuleScheduleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, clearModuleSchedule, arg.Uuid, arg.ScheduledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const consumeEmailChange = `-- name: ConsumeEmailChange :one
+DELETE FROM email_change WHERE token = ? RETURNING token, user_id, new_email, created_at, expires_at
+`
+
+func (q *Queries) ConsumeEmailChange(ctx context.Context, token string) (EmailChange, error) {
+	row := q.db.QueryRowContext(ctx, consumeEmailChange, token)
+	var i EmailChange
+	err := row.Scan(
+		&i.Token,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const consumeOidcLoginState = `-- name: ConsumeOidcLoginState :one
+DELETE FROM oidc_login_state WHERE state = ? RETURNING state, code_verifier, nonce, redirect_to, created_at, expires_at
+`
+
+func (q *Queries) ConsumeOidcLoginState(ctx context.Context, state string) (OidcLoginState, error) {
+	row := q.db.QueryRowContext(ctx, consumeOidcLoginState, state)
+	var i OidcLoginState
+	err := row.Scan(
+		&i.State,
+		&i.CodeVerifier,
+		&i.Nonce,
+		&i.RedirectTo,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const countAdmins = `-- name: CountAdmins :one
+SELECT COUNT(*) FROM admin
+`
+
+func (q *Queries) CountAdmins(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAdmins)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countAttachmentsOfPost = `-- name: CountAttachmentsOfPost :one
+SELECT COUNT(*) FROM feed_attachment WHERE post_uuid = ?
+`
+
+func (q *Queries) CountAttachmentsOfPost(ctx context.Context, postUuid string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAttachmentsOfPost, postUuid)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCommentsOfPosts = `-- name: CountCommentsOfPosts :many
+SELECT post_uuid, COUNT(*) AS comment_count
+FROM feed_comment
+WHERE post_uuid IN (/*SLICE:post_uuids*/?)
+GROUP BY post_uuid
+`
+
+type CountCommentsOfPostsRow struct {
+	PostUuid     string `json:"post_uuid"`
+	CommentCount int64  `json:"comment_count"`
+}
+
+func (q *Queries) CountCommentsOfPosts(ctx context.Context, postUuids []string) ([]CountCommentsOfPostsRow, error) {
+	query := countCommentsOfPosts
+	var queryParams []interface{}
+	if len(postUuids) > 0 {
+		for _, v := range postUuids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", strings.Repeat(",?", len(postUuids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountCommentsOfPostsRow
+	for rows.Next() {
+		var i CountCommentsOfPostsRow
+		if err := rows.Scan(&i.PostUuid, &i.CommentCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countReactionsOfPosts = `-- name: CountReactionsOfPosts :many
+SELECT post_uuid, emoji, COUNT(*) AS reaction_count
+FROM feed_reaction
+WHERE post_uuid IN (/*SLICE:post_uuids*/?)
+GROUP BY post_uuid, emoji
+`
+
+type CountReactionsOfPostsRow struct {
+	PostUuid      string `json:"post_uuid"`
+	Emoji         string `json:"emoji"`
+	ReactionCount int64  `json:"reaction_count"`
+}
+
+func (q *Queries) CountReactionsOfPosts(ctx context.Context, postUuids []string) ([]CountReactionsOfPostsRow, error) {
+	query := countReactionsOfPosts
+	var queryParams []interface{}
+	if len(postUuids) > 0 {
+		for _, v := range postUuids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", strings.Repeat(",?", len(postUuids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountReactionsOfPostsRow
+	for rows.Next() {
+		var i CountReactionsOfPostsRow
+		if err := rows.Scan(&i.PostUuid, &i.Emoji, &i.ReactionCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*)
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE
+    (CAST(?1 AS TEXT) IS NULL
+        OR u.first_name LIKE '%' || CAST(?1 AS TEXT) || '%'
+        OR u.last_name LIKE '%' || CAST(?1 AS TEXT) || '%'
+        OR u.email LIKE '%' || CAST(?1 AS TEXT) || '%')
+    AND (CAST(?2 AS BOOLEAN) IS NULL OR (a.user_id IS NOT NULL) = CAST(?2 AS BOOLEAN))
+    AND (CAST(?3 AS BOOLEAN) IS NULL OR (u.deactivated_at IS NOT NULL) = CAST(?3 AS BOOLEAN))
+`
+
+type CountUsersParams struct {
+	Search      sql.NullString `json:"search"`
+	IsAdmin     sql.NullBool   `json:"is_admin"`
+	Deactivated sql.NullBool   `json:"deactivated"`
+}
+
+func (q *Queries) CountUsers(ctx context.Context, arg CountUsersParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers, arg.Search, arg.IsAdmin, arg.Deactivated)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAttachment = `-- name: CreateAttachment :exec
+INSERT INTO feed_attachment (
+    uuid, post_uuid, type, position, name, path, mime_type, byte_size, material_uuid, quiz_uuid, created_at
+) VALUES (
+    ?1, ?2, ?3,
+    (SELECT COALESCE(MAX(position), 0) + 1 FROM feed_attachment WHERE post_uuid = ?2),
+    ?4, ?5, ?6, ?7,
+    ?8, ?9, ?10
+)
+`
+
+type CreateAttachmentParams struct {
+	Uuid         string         `json:"uuid"`
+	PostUuid     string         `json:"post_uuid"`
+	Type         string         `json:"type"`
+	Name         sql.NullString `json:"name"`
+	Path         sql.NullString `json:"path"`
+	MimeType     sql.NullString `json:"mime_type"`
+	ByteSize     sql.NullInt64  `json:"byte_size"`
+	MaterialUuid sql.NullString `json:"material_uuid"`
+	QuizUuid     sql.NullString `json:"quiz_uuid"`
+	CreatedAt    int64          `json:"created_at"`
+}
+
+func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentParams) error {
+	_, err := q.db.ExecContext(ctx, createAttachment,
+		arg.Uuid,
+		arg.PostUuid,
+		arg.Type,
+		arg.Name,
+		arg.Path,
+		arg.MimeType,
+		arg.ByteSize,
+		arg.MaterialUuid,
+		arg.QuizUuid,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createComment = `-- name: CreateComment :exec
+INSERT INTO feed_comment (uuid, post_uuid, parent_uuid, user_id, message, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateCommentParams struct {
+	Uuid       string         `json:"uuid"`
+	PostUuid   string         `json:"post_uuid"`
+	ParentUuid sql.NullString `json:"parent_uuid"`
+	UserID     int64          `json:"user_id"`
+	Message    string         `json:"message"`
+	CreatedAt  int64          `json:"created_at"`
+}
+
+func (q *Queries) CreateComment(ctx context.Context, arg CreateCommentParams) error {
+	_, err := q.db.ExecContext(ctx, createComment,
+		arg.Uuid,
+		arg.PostUuid,
+		arg.ParentUuid,
+		arg.UserID,
+		arg.Message,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const createCourse = `-- name: CreateCourse :one
 
 INSERT INTO course (
     uuid, name, description, created_at, updated_at
 ) VALUES (
     ?, ?, ?, ?, ?
-) RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state
+) RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota
 `
 
 type CreateCourseParams struct {
@@ -290,37 +767,130 @@ func (q *Queries) CreateCourse(ctx context.Context, arg CreateCourseParams) (Cou
 		&i.HighlightedModuleMessage,
 		&i.Archived,
 		&i.State,
+		&i.ScheduledState,
+		&i.ScheduledAt,
+		&i.ScheduledModuleUuid,
+		&i.ScheduledModuleMessage,
+		&i.MaxUploadSize,
+		&i.StorageQuota,
 	)
 	return i, err
 }
 
-const createHeading = `-- name: CreateHeading :one
+const createEmailChange = `-- name: CreateEmailChange :one
 
-INSERT INTO heading (
-    uuid, content, created_at, updated_at
+INSERT OR REPLACE INTO email_change (
+    token, user_id, new_email, created_at, expires_at
 ) VALUES (
-    ?, ?, ?, ?
-) RETURNING uuid, course_uuid, content, variant, created_at, updated_at
+    ?, ?, ?, ?, ?
+) RETURNING token, user_id, new_email, created_at, expires_at
 `
 
-type CreateHeadingParams struct {
-	Uuid      string `json:"uuid"`
-	Content   string `json:"content"`
+type CreateEmailChangeParams struct {
+	Token     string `json:"token"`
+	UserID    int64  `json:"user_id"`
+	NewEmail  string `json:"new_email"`
 	CreatedAt int64  `json:"created_at"`
-	UpdatedAt int64  `json:"updated_at"`
+	ExpiresAt int64  `json:"expires_at"`
 }
 
-// * Heading
-func (q *Queries) CreateHeading(ctx context.Context, arg CreateHeadingParams) (Heading, error) {
-	row := q.db.QueryRowContext(ctx, createHeading,
-		arg.Uuid,
-		arg.Content,
+// * Email change
+func (q *Queries) CreateEmailChange(ctx context.Context, arg CreateEmailChangeParams) (EmailChange, error) {
+	row := q.db.QueryRowContext(ctx, createEmailChange,
+		arg.Token,
+		arg.UserID,
+		arg.NewEmail,
 		arg.CreatedAt,
-		arg.UpdatedAt,
+		arg.ExpiresAt,
 	)
-	var i Heading
+	var i EmailChange
 	err := row.Scan(
-		&i.Uuid,
+		&i.Token,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const createFeedEvent = `-- name: CreateFeedEvent :one
+
+INSERT INTO feed_event (
+    course_uuid, name, data, created_at
+) VALUES (
+    ?, ?, ?, ?
+) RETURNING id
+`
+
+type CreateFeedEventParams struct {
+	CourseUuid string `json:"course_uuid"`
+	Name       string `json:"name"`
+	Data       string `json:"data"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// * Feed events (sqlite broker)
+func (q *Queries) CreateFeedEvent(ctx context.Context, arg CreateFeedEventParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createFeedEvent,
+		arg.CourseUuid,
+		arg.Name,
+		arg.Data,
+		arg.CreatedAt,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createFirstMaterialVersion = `-- name: CreateFirstMaterialVersion :exec
+INSERT INTO material_version (
+    uuid, material_uuid, version, url, mime_type, byte_size, created_by, created_at
+)
+SELECT ?1, material.uuid, 1, material.url, COALESCE(material.mime_type, ''), COALESCE(material.byte_size, 0), NULL, material.updated_at
+FROM material
+WHERE material.uuid = ?2 AND material.type = 'file'
+    AND NOT EXISTS (SELECT 1 FROM material_version WHERE material_version.material_uuid = material.uuid)
+`
+
+type CreateFirstMaterialVersionParams struct {
+	Uuid         string `json:"uuid"`
+	MaterialUuid string `json:"material_uuid"`
+}
+
+// the file of a material from before the versions becomes its first version
+func (q *Queries) CreateFirstMaterialVersion(ctx context.Context, arg CreateFirstMaterialVersionParams) error {
+	_, err := q.db.ExecContext(ctx, createFirstMaterialVersion, arg.Uuid, arg.MaterialUuid)
+	return err
+}
+
+const createHeading = `-- name: CreateHeading :one
+
+INSERT INTO heading (
+    uuid, content, created_at, updated_at
+) VALUES (
+    ?, ?, ?, ?
+) RETURNING uuid, course_uuid, content, variant, created_at, updated_at
+`
+
+type CreateHeadingParams struct {
+	Uuid      string `json:"uuid"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// * Heading
+func (q *Queries) CreateHeading(ctx context.Context, arg CreateHeadingParams) (Heading, error) {
+	row := q.db.QueryRowContext(ctx, createHeading,
+		arg.Uuid,
+		arg.Content,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
+	var i Heading
+	err := row.Scan(
+		&i.Uuid,
 		&i.CourseUuid,
 		&i.Content,
 		&i.Variant,
@@ -333,24 +903,28 @@ func (q *Queries) CreateHeading(ctx context.Context, arg CreateHeadingParams) (H
 const createMaterial = `-- name: CreateMaterial :one
 
 INSERT INTO material (
-    uuid, course_uuid, name, description, url, type, favicon_url, byte_size, mime_type, created_at, updated_at
+    uuid, course_uuid, name, description, url, type, favicon_url, byte_size, mime_type,
+    video_provider, video_id, duration_seconds, created_at, updated_at
 ) VALUES (
-    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
-) RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, created_at, updated_at
+    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+) RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, video_provider, video_id, duration_seconds, created_at, updated_at
 `
 
 type CreateMaterialParams struct {
-	Uuid        string         `json:"uuid"`
-	CourseUuid  string         `json:"course_uuid"`
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	Url         string         `json:"url"`
-	Type        string         `json:"type"`
-	FaviconUrl  sql.NullString `json:"favicon_url"`
-	ByteSize    sql.NullInt64  `json:"byte_size"`
-	MimeType    sql.NullString `json:"mime_type"`
-	CreatedAt   int64          `json:"created_at"`
-	UpdatedAt   int64          `json:"updated_at"`
+	Uuid            string         `json:"uuid"`
+	CourseUuid      string         `json:"course_uuid"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Url             string         `json:"url"`
+	Type            string         `json:"type"`
+	FaviconUrl      sql.NullString `json:"favicon_url"`
+	ByteSize        sql.NullInt64  `json:"byte_size"`
+	MimeType        sql.NullString `json:"mime_type"`
+	VideoProvider   sql.NullString `json:"video_provider"`
+	VideoID         sql.NullString `json:"video_id"`
+	DurationSeconds sql.NullInt64  `json:"duration_seconds"`
+	CreatedAt       int64          `json:"created_at"`
+	UpdatedAt       int64          `json:"updated_at"`
 }
 
 // * Material
@@ -365,6 +939,9 @@ func (q *Queries) CreateMaterial(ctx context.Context, arg CreateMaterialParams)
 		arg.FaviconUrl,
 		arg.ByteSize,
 		arg.MimeType,
+		arg.VideoProvider,
+		arg.VideoID,
+		arg.DurationSeconds,
 		arg.CreatedAt,
 		arg.UpdatedAt,
 	)
@@ -380,12 +957,68 @@ func (q *Queries) CreateMaterial(ctx context.Context, arg CreateMaterialParams)
 		&i.FaviconUrl,
 		&i.MimeType,
 		&i.ByteSize,
+		&i.VideoProvider,
+		&i.VideoID,
+		&i.DurationSeconds,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
+const createMaterialVersion = `-- name: CreateMaterialVersion :one
+
+INSERT INTO material_version (
+    uuid, material_uuid, version, url, mime_type, byte_size, thumbnail_key, preview_text, created_by, created_at
+) VALUES (
+    ?1, ?2,
+    (SELECT COALESCE(MAX(version), 0) + 1 FROM material_version WHERE material_uuid = ?2),
+    ?3, ?4, ?5, ?6, ?7,
+    ?8, ?9
+) RETURNING uuid, material_uuid, version, url, mime_type, byte_size, thumbnail_key, preview_text, created_by, created_at
+`
+
+type CreateMaterialVersionParams struct {
+	Uuid         string         `json:"uuid"`
+	MaterialUuid string         `json:"material_uuid"`
+	Url          string         `json:"url"`
+	MimeType     string         `json:"mime_type"`
+	ByteSize     int64          `json:"byte_size"`
+	ThumbnailKey sql.NullString `json:"thumbnail_key"`
+	PreviewText  sql.NullString `json:"preview_text"`
+	CreatedBy    sql.NullInt64  `json:"created_by"`
+	CreatedAt    int64          `json:"created_at"`
+}
+
+// * Material versions
+func (q *Queries) CreateMaterialVersion(ctx context.Context, arg CreateMaterialVersionParams) (MaterialVersion, error) {
+	row := q.db.QueryRowContext(ctx, createMaterialVersion,
+		arg.Uuid,
+		arg.MaterialUuid,
+		arg.Url,
+		arg.MimeType,
+		arg.ByteSize,
+		arg.ThumbnailKey,
+		arg.PreviewText,
+		arg.CreatedBy,
+		arg.CreatedAt,
+	)
+	var i MaterialVersion
+	err := row.Scan(
+		&i.Uuid,
+		&i.MaterialUuid,
+		&i.Version,
+		&i.Url,
+		&i.MimeType,
+		&i.ByteSize,
+		&i.ThumbnailKey,
+		&i.PreviewText,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createModule = `-- name: CreateModule :one
 
 INSERT INTO module (
@@ -407,7 +1040,7 @@ SELECT
     ?
 FROM module
 WHERE module.course_uuid = ?
-RETURNING uuid, course_uuid, name, description, state, module_order, created_at, updated_at
+RETURNING uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at
 `
 
 type CreateModuleParams struct {
@@ -439,26 +1072,65 @@ func (q *Queries) CreateModule(ctx context.Context, arg CreateModuleParams) (Mod
 		&i.Description,
 		&i.State,
 		&i.ModuleOrder,
+		&i.ScheduledState,
+		&i.ScheduledAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
+const createOidcLoginState = `-- name: CreateOidcLoginState :exec
+INSERT INTO oidc_login_state (
+    state, code_verifier, nonce, redirect_to, created_at, expires_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?
+)
+`
+
+type CreateOidcLoginStateParams struct {
+	State        string `json:"state"`
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	RedirectTo   string `json:"redirect_to"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+func (q *Queries) CreateOidcLoginState(ctx context.Context, arg CreateOidcLoginStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOidcLoginState,
+		arg.State,
+		arg.CodeVerifier,
+		arg.Nonce,
+		arg.RedirectTo,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const createPost = `-- name: CreatePost :one
-INSERT INTO feed_posts (uuid, course_uuid, type, message, is_edited, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?, ?, ?)
-RETURNING uuid, course_uuid, type, message, is_edited, created_at, updated_at
+INSERT INTO feed_posts (
+    uuid, course_uuid, type, message, is_edited, is_pinned,
+    created_at, updated_at, publish_at, is_published, expires_at, is_expired
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING id, uuid, course_uuid, type, message, is_edited, is_pinned, is_locked, created_at, updated_at, publish_at, is_published, expires_at, is_expired
 `
 
 type CreatePostParams struct {
-	Uuid       string `json:"uuid"`
-	CourseUuid string `json:"course_uuid"`
-	Type       string `json:"type"`
-	Message    string `json:"message"`
-	IsEdited   bool   `json:"is_edited"`
-	CreatedAt  int64  `json:"created_at"`
-	UpdatedAt  int64  `json:"updated_at"`
+	Uuid        string        `json:"uuid"`
+	CourseUuid  string        `json:"course_uuid"`
+	Type        string        `json:"type"`
+	Message     string        `json:"message"`
+	IsEdited    bool          `json:"is_edited"`
+	IsPinned    bool          `json:"is_pinned"`
+	CreatedAt   int64         `json:"created_at"`
+	UpdatedAt   int64         `json:"updated_at"`
+	PublishAt   int64         `json:"publish_at"`
+	IsPublished bool          `json:"is_published"`
+	ExpiresAt   sql.NullInt64 `json:"expires_at"`
+	IsExpired   bool          `json:"is_expired"`
 }
 
 func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (FeedPost, error) {
@@ -468,18 +1140,30 @@ func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (FeedPos
 		arg.Type,
 		arg.Message,
 		arg.IsEdited,
+		arg.IsPinned,
 		arg.CreatedAt,
 		arg.UpdatedAt,
+		arg.PublishAt,
+		arg.IsPublished,
+		arg.ExpiresAt,
+		arg.IsExpired,
 	)
 	var i FeedPost
 	err := row.Scan(
+		&i.ID,
 		&i.Uuid,
 		&i.CourseUuid,
 		&i.Type,
 		&i.Message,
 		&i.IsEdited,
+		&i.IsPinned,
+		&i.IsLocked,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PublishAt,
+		&i.IsPublished,
+		&i.ExpiresAt,
+		&i.IsExpired,
 	)
 	return i, err
 }
@@ -536,19 +1220,20 @@ func (q *Queries) CreateQuestion(ctx context.Context, arg CreateQuestionParams)
 const createQuiz = `-- name: CreateQuiz :one
 
 INSERT INTO quiz (
-    uuid, course_uuid, title, attempts_count, created_at, updated_at
+    uuid, course_uuid, title, attempts_count, deadline_at, created_at, updated_at
 ) VALUES (
-    ?, ?, ?, ?, ?, ?
-) RETURNING uuid, course_uuid, title, attempts_count, created_at, updated_at
+    ?, ?, ?, ?, ?, ?, ?
+) RETURNING uuid, course_uuid, title, attempts_count, deadline_at, created_at, updated_at
 `
 
 type CreateQuizParams struct {
-	Uuid          string `json:"uuid"`
-	CourseUuid    string `json:"course_uuid"`
-	Title         string `json:"title"`
-	AttemptsCount int64  `json:"attempts_count"`
-	CreatedAt     int64  `json:"created_at"`
-	UpdatedAt     int64  `json:"updated_at"`
+	Uuid          string        `json:"uuid"`
+	CourseUuid    string        `json:"course_uuid"`
+	Title         string        `json:"title"`
+	AttemptsCount int64         `json:"attempts_count"`
+	DeadlineAt    sql.NullInt64 `json:"deadline_at"`
+	CreatedAt     int64         `json:"created_at"`
+	UpdatedAt     int64         `json:"updated_at"`
 }
 
 // * Quiz
@@ -558,6 +1243,7 @@ func (q *Queries) CreateQuiz(ctx context.Context, arg CreateQuizParams) (Quiz, e
 		arg.CourseUuid,
 		arg.Title,
 		arg.AttemptsCount,
+		arg.DeadlineAt,
 		arg.CreatedAt,
 		arg.UpdatedAt,
 	)
@@ -567,6 +1253,7 @@ func (q *Queries) CreateQuiz(ctx context.Context, arg CreateQuizParams) (Quiz, e
 		&i.CourseUuid,
 		&i.Title,
 		&i.AttemptsCount,
+		&i.DeadlineAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -608,8 +1295,56 @@ func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) (S
 	return i, err
 }
 
+const createUpload = `-- name: CreateUpload :one
+INSERT INTO upload (uuid, course_uuid, user_id, filename, size, checksum, created_at, updated_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING uuid, course_uuid, user_id, filename, size, received_bytes, checksum, mime_type, created_at, updated_at, finished_at, expires_at
+`
+
+type CreateUploadParams struct {
+	Uuid       string         `json:"uuid"`
+	CourseUuid string         `json:"course_uuid"`
+	UserID     int64          `json:"user_id"`
+	Filename   string         `json:"filename"`
+	Size       int64          `json:"size"`
+	Checksum   sql.NullString `json:"checksum"`
+	CreatedAt  int64          `json:"created_at"`
+	UpdatedAt  int64          `json:"updated_at"`
+	ExpiresAt  int64          `json:"expires_at"`
+}
+
+func (q *Queries) CreateUpload(ctx context.Context, arg CreateUploadParams) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, createUpload,
+		arg.Uuid,
+		arg.CourseUuid,
+		arg.UserID,
+		arg.Filename,
+		arg.Size,
+		arg.Checksum,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.ExpiresAt,
+	)
+	var i Upload
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.UserID,
+		&i.Filename,
+		&i.Size,
+		&i.ReceivedBytes,
+		&i.Checksum,
+		&i.MimeType,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO user (first_name, last_name, hash, email) VALUES (?, ?, ?, ?) RETURNING id, first_name, last_name, hash, email
+INSERT INTO user (first_name, last_name, hash, email) VALUES (?, ?, ?, ?) RETURNING id, first_name, last_name, hash, email, must_change_password, deactivated_at, avatar_path, feed_token
 `
 
 type CreateUserParams struct {
@@ -633,10 +1368,162 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.LastName,
 		&i.Hash,
 		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :one
+INSERT INTO user_identity (
+    user_id, issuer, subject, email, created_at
+) VALUES (
+    ?, ?, ?, ?, ?
+) RETURNING id, user_id, issuer, subject, email, created_at
+`
+
+type CreateUserIdentityParams struct {
+	UserID    int64  `json:"user_id"`
+	Issuer    string `json:"issuer"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error) {
+	row := q.db.QueryRowContext(ctx, createUserIdentity,
+		arg.UserID,
+		arg.Issuer,
+		arg.Subject,
+		arg.Email,
+		arg.CreatedAt,
+	)
+	var i UserIdentity
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Issuer,
+		&i.Subject,
+		&i.Email,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createWebhook = `-- name: CreateWebhook :one
+INSERT INTO webhook (
+    uuid, course_uuid, url, secret, events, format, active, created_at, updated_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?, ?
+) RETURNING uuid, course_uuid, url, secret, events, format, active, created_at, updated_at
+`
+
+type CreateWebhookParams struct {
+	Uuid       string `json:"uuid"`
+	CourseUuid string `json:"course_uuid"`
+	Url        string `json:"url"`
+	Secret     string `json:"secret"`
+	Events     string `json:"events"`
+	Format     string `json:"format"`
+	Active     int64  `json:"active"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+func (q *Queries) CreateWebhook(ctx context.Context, arg CreateWebhookParams) (Webhook, error) {
+	row := q.db.QueryRowContext(ctx, createWebhook,
+		arg.Uuid,
+		arg.CourseUuid,
+		arg.Url,
+		arg.Secret,
+		arg.Events,
+		arg.Format,
+		arg.Active,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Format,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createWebhookDelivery = `-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_delivery (
+    uuid, webhook_uuid, event, payload, status, next_attempt_at, created_at, updated_at
+) VALUES (
+    ?, ?, ?, ?, 'pending', ?, ?, ?
+) RETURNING uuid, webhook_uuid, event, payload, status, attempts, next_attempt_at, response_status, response_body, error, duration_ms, created_at, updated_at
+`
+
+type CreateWebhookDeliveryParams struct {
+	Uuid          string        `json:"uuid"`
+	WebhookUuid   string        `json:"webhook_uuid"`
+	Event         string        `json:"event"`
+	Payload       string        `json:"payload"`
+	NextAttemptAt sql.NullInt64 `json:"next_attempt_at"`
+	CreatedAt     int64         `json:"created_at"`
+	UpdatedAt     int64         `json:"updated_at"`
+}
+
+func (q *Queries) CreateWebhookDelivery(ctx context.Context, arg CreateWebhookDeliveryParams) (WebhookDelivery, error) {
+	row := q.db.QueryRowContext(ctx, createWebhookDelivery,
+		arg.Uuid,
+		arg.WebhookUuid,
+		arg.Event,
+		arg.Payload,
+		arg.NextAttemptAt,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+	)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.Uuid,
+		&i.WebhookUuid,
+		&i.Event,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.Error,
+		&i.DurationMs,
+		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
+const deleteAttachment = `-- name: DeleteAttachment :exec
+DELETE FROM feed_attachment WHERE uuid = ?
+`
+
+func (q *Queries) DeleteAttachment(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteAttachment, uuid)
+	return err
+}
+
+const deleteComment = `-- name: DeleteComment :exec
+DELETE FROM feed_comment WHERE uuid = ?
+`
+
+func (q *Queries) DeleteComment(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteComment, uuid)
+	return err
+}
+
 const deleteCourse = `-- name: DeleteCourse :execresult
 DELETE FROM course WHERE course.uuid = ?
 `
@@ -645,6 +1532,24 @@ func (q *Queries) DeleteCourse(ctx context.Context, uuid string) (sql.Result, er
 	return q.db.ExecContext(ctx, deleteCourse, uuid)
 }
 
+const deleteExpiredOidcLoginStates = `-- name: DeleteExpiredOidcLoginStates :exec
+DELETE FROM oidc_login_state WHERE expires_at <= ?
+`
+
+func (q *Queries) DeleteExpiredOidcLoginStates(ctx context.Context, expiresAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOidcLoginStates, expiresAt)
+	return err
+}
+
+const deleteFeedEventsBefore = `-- name: DeleteFeedEventsBefore :exec
+DELETE FROM feed_event WHERE created_at < ?
+`
+
+func (q *Queries) DeleteFeedEventsBefore(ctx context.Context, createdAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedEventsBefore, createdAt)
+	return err
+}
+
 const deleteHeading = `-- name: DeleteHeading :exec
 DELETE FROM heading WHERE uuid = ?
 `
@@ -662,6 +1567,18 @@ func (q *Queries) DeleteMaterial(ctx context.Context, uuid string) (sql.Result,
 	return q.db.ExecContext(ctx, deleteMaterial, uuid)
 }
 
+const deleteMaterialVersion = `-- name: DeleteMaterialVersion :execrows
+DELETE FROM material_version WHERE uuid = ?
+`
+
+func (q *Queries) DeleteMaterialVersion(ctx context.Context, uuid string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMaterialVersion, uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const deleteModule = `-- name: DeleteModule :exec
 DELETE FROM module WHERE uuid = ? AND course_uuid = ?
 `
@@ -702,39 +1619,255 @@ func (q *Queries) DeleteQuiz(ctx context.Context, uuid string) (sql.Result, erro
 	return q.db.ExecContext(ctx, deleteQuiz, uuid)
 }
 
-const getAnswersOfQuiz = `-- name: GetAnswersOfQuiz :many
-
-SELECT
-    answer.quiz_uuid, answer.comment, answer.score, answer.max_score, answer.user_id, answer.attempt_number, answer.submitted_at,
-    user.first_name,
-    user.last_name
-FROM answer
-LEFT JOIN user ON user.id = answer.user_id
-WHERE quiz_uuid = ?
-ORDER BY answer.submitted_at DESC
+const deleteUnusedLinkPreviews = `-- name: DeleteUnusedLinkPreviews :exec
+DELETE FROM link_preview
+WHERE url NOT IN (SELECT url FROM material WHERE type IN ('url', 'video'))
 `
 
-type GetAnswersOfQuizRow struct {
-	QuizUuid      string         `json:"quiz_uuid"`
-	Comment       sql.NullString `json:"comment"`
-	Score         int64          `json:"score"`
-	MaxScore      int64          `json:"max_score"`
-	UserID        sql.NullInt64  `json:"user_id"`
-	AttemptNumber int64          `json:"attempt_number"`
-	SubmittedAt   int64          `json:"submitted_at"`
-	FirstName     sql.NullString `json:"first_name"`
-	LastName      sql.NullString `json:"last_name"`
+func (q *Queries) DeleteUnusedLinkPreviews(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteUnusedLinkPreviews)
+	return err
 }
 
-// TODO RETURN ANSWERS AND SHOW THEM ON FRONTEND
-func (q *Queries) GetAnswersOfQuiz(ctx context.Context, quizUuid string) ([]GetAnswersOfQuizRow, error) {
-	rows, err := q.db.QueryContext(ctx, getAnswersOfQuiz, quizUuid)
+const deleteUpload = `-- name: DeleteUpload :execrows
+DELETE FROM upload WHERE uuid = ?
+`
+
+func (q *Queries) DeleteUpload(ctx context.Context, uuid string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUpload, uuid)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer rows.Close()
-	var items []GetAnswersOfQuizRow
-	for rows.Next() {
+	return result.RowsAffected()
+}
+
+const deleteUser = `-- name: DeleteUser :execresult
+DELETE FROM user WHERE id = ?
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteUser, id)
+}
+
+const deleteWebhook = `-- name: DeleteWebhook :exec
+DELETE FROM webhook WHERE uuid = ?
+`
+
+func (q *Queries) DeleteWebhook(ctx context.Context, uuid string) error {
+	_, err := q.db.ExecContext(ctx, deleteWebhook, uuid)
+	return err
+}
+
+const deleteWebhookDeliveriesBefore = `-- name: DeleteWebhookDeliveriesBefore :exec
+DELETE FROM webhook_delivery WHERE status != 'pending' AND created_at < ?
+`
+
+func (q *Queries) DeleteWebhookDeliveriesBefore(ctx context.Context, createdAt int64) error {
+	_, err := q.db.ExecContext(ctx, deleteWebhookDeliveriesBefore, createdAt)
+	return err
+}
+
+const expireDuePosts = `-- name: ExpireDuePosts :many
+UPDATE feed_posts
+SET is_expired = 1
+WHERE is_expired = 0 AND is_published = 1 AND expires_at IS NOT NULL AND expires_at <= CAST(?1 AS INTEGER)
+RETURNING id, uuid, course_uuid, type, message, is_edited, is_pinned, is_locked, created_at, updated_at, publish_at, is_published, expires_at, is_expired
+`
+
+func (q *Queries) ExpireDuePosts(ctx context.Context, now int64) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, expireDuePosts, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedPost
+	for rows.Next() {
+		var i FeedPost
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uuid,
+			&i.CourseUuid,
+			&i.Type,
+			&i.Message,
+			&i.IsEdited,
+			&i.IsPinned,
+			&i.IsLocked,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PublishAt,
+			&i.IsPublished,
+			&i.ExpiresAt,
+			&i.IsExpired,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const failLinkPreview = `-- name: FailLinkPreview :one
+UPDATE link_preview
+SET next_fetch_at = ?, failures = failures + 1, error = ?
+WHERE url = ?
+RETURNING url, title, description, image_url, favicon_url, duration, fetched_at, next_fetch_at, failures, error
+`
+
+type FailLinkPreviewParams struct {
+	NextFetchAt int64          `json:"next_fetch_at"`
+	Error       sql.NullString `json:"error"`
+	Url         string         `json:"url"`
+}
+
+// the metadata from the last successful fetch stays
+func (q *Queries) FailLinkPreview(ctx context.Context, arg FailLinkPreviewParams) (LinkPreview, error) {
+	row := q.db.QueryRowContext(ctx, failLinkPreview, arg.NextFetchAt, arg.Error, arg.Url)
+	var i LinkPreview
+	err := row.Scan(
+		&i.Url,
+		&i.Title,
+		&i.Description,
+		&i.ImageUrl,
+		&i.FaviconUrl,
+		&i.Duration,
+		&i.FetchedAt,
+		&i.NextFetchAt,
+		&i.Failures,
+		&i.Error,
+	)
+	return i, err
+}
+
+const finishUpload = `-- name: FinishUpload :one
+UPDATE upload SET mime_type = ?, finished_at = ?, updated_at = ? WHERE uuid = ? RETURNING uuid, course_uuid, user_id, filename, size, received_bytes, checksum, mime_type, created_at, updated_at, finished_at, expires_at
+`
+
+type FinishUploadParams struct {
+	MimeType   sql.NullString `json:"mime_type"`
+	FinishedAt sql.NullInt64  `json:"finished_at"`
+	UpdatedAt  int64          `json:"updated_at"`
+	Uuid       string         `json:"uuid"`
+}
+
+func (q *Queries) FinishUpload(ctx context.Context, arg FinishUploadParams) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, finishUpload,
+		arg.MimeType,
+		arg.FinishedAt,
+		arg.UpdatedAt,
+		arg.Uuid,
+	)
+	var i Upload
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.UserID,
+		&i.Filename,
+		&i.Size,
+		&i.ReceivedBytes,
+		&i.Checksum,
+		&i.MimeType,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const finishWebhookDeliveryAttempt = `-- name: FinishWebhookDeliveryAttempt :one
+UPDATE webhook_delivery
+SET status = ?,
+    attempts = attempts + 1,
+    next_attempt_at = ?,
+    response_status = ?,
+    response_body = ?,
+    error = ?,
+    duration_ms = ?,
+    updated_at = ?
+WHERE uuid = ?
+RETURNING uuid, webhook_uuid, event, payload, status, attempts, next_attempt_at, response_status, response_body, error, duration_ms, created_at, updated_at
+`
+
+type FinishWebhookDeliveryAttemptParams struct {
+	Status         string         `json:"status"`
+	NextAttemptAt  sql.NullInt64  `json:"next_attempt_at"`
+	ResponseStatus sql.NullInt64  `json:"response_status"`
+	ResponseBody   sql.NullString `json:"response_body"`
+	Error          sql.NullString `json:"error"`
+	DurationMs     sql.NullInt64  `json:"duration_ms"`
+	UpdatedAt      int64          `json:"updated_at"`
+	Uuid           string         `json:"uuid"`
+}
+
+func (q *Queries) FinishWebhookDeliveryAttempt(ctx context.Context, arg FinishWebhookDeliveryAttemptParams) (WebhookDelivery, error) {
+	row := q.db.QueryRowContext(ctx, finishWebhookDeliveryAttempt,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.ResponseStatus,
+		arg.ResponseBody,
+		arg.Error,
+		arg.DurationMs,
+		arg.UpdatedAt,
+		arg.Uuid,
+	)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.Uuid,
+		&i.WebhookUuid,
+		&i.Event,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.Error,
+		&i.DurationMs,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getAnswersOfQuiz = `-- name: GetAnswersOfQuiz :many
+
+SELECT
+    answer.quiz_uuid, answer.comment, answer.score, answer.max_score, answer.user_id, answer.attempt_number, answer.submitted_at,
+    user.first_name,
+    user.last_name
+FROM answer
+LEFT JOIN user ON user.id = answer.user_id
+WHERE quiz_uuid = ?
+ORDER BY answer.submitted_at DESC
+`
+
+type GetAnswersOfQuizRow struct {
+	QuizUuid      string         `json:"quiz_uuid"`
+	Comment       sql.NullString `json:"comment"`
+	Score         int64          `json:"score"`
+	MaxScore      int64          `json:"max_score"`
+	UserID        sql.NullInt64  `json:"user_id"`
+	AttemptNumber int64          `json:"attempt_number"`
+	SubmittedAt   int64          `json:"submitted_at"`
+	FirstName     sql.NullString `json:"first_name"`
+	LastName      sql.NullString `json:"last_name"`
+}
+
+// TODO RETURN ANSWERS AND SHOW THEM ON FRONTEND
+func (q *Queries) GetAnswersOfQuiz(ctx context.Context, quizUuid string) ([]GetAnswersOfQuizRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAnswersOfQuiz, quizUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAnswersOfQuizRow
+	for rows.Next() {
 		var i GetAnswersOfQuizRow
 		if err := rows.Scan(
 			&i.QuizUuid,
@@ -760,8 +1893,67 @@ func (q *Queries) GetAnswersOfQuiz(ctx context.Context, quizUuid string) ([]GetA
 	return items, nil
 }
 
+const getAttachment = `-- name: GetAttachment :one
+SELECT uuid, post_uuid, type, position, name, path, mime_type, byte_size, material_uuid, quiz_uuid, created_at FROM feed_attachment WHERE uuid = ?
+`
+
+func (q *Queries) GetAttachment(ctx context.Context, uuid string) (FeedAttachment, error) {
+	row := q.db.QueryRowContext(ctx, getAttachment, uuid)
+	var i FeedAttachment
+	err := row.Scan(
+		&i.Uuid,
+		&i.PostUuid,
+		&i.Type,
+		&i.Position,
+		&i.Name,
+		&i.Path,
+		&i.MimeType,
+		&i.ByteSize,
+		&i.MaterialUuid,
+		&i.QuizUuid,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getComment = `-- name: GetComment :one
+SELECT c.uuid, c.post_uuid, c.parent_uuid, c.user_id, c.message, c.is_hidden, c.created_at, u.first_name, u.last_name
+FROM feed_comment c
+JOIN user u ON u.id = c.user_id
+WHERE c.uuid = ?
+`
+
+type GetCommentRow struct {
+	Uuid       string         `json:"uuid"`
+	PostUuid   string         `json:"post_uuid"`
+	ParentUuid sql.NullString `json:"parent_uuid"`
+	UserID     int64          `json:"user_id"`
+	Message    string         `json:"message"`
+	IsHidden   bool           `json:"is_hidden"`
+	CreatedAt  int64          `json:"created_at"`
+	FirstName  string         `json:"first_name"`
+	LastName   string         `json:"last_name"`
+}
+
+func (q *Queries) GetComment(ctx context.Context, uuid string) (GetCommentRow, error) {
+	row := q.db.QueryRowContext(ctx, getComment, uuid)
+	var i GetCommentRow
+	err := row.Scan(
+		&i.Uuid,
+		&i.PostUuid,
+		&i.ParentUuid,
+		&i.UserID,
+		&i.Message,
+		&i.IsHidden,
+		&i.CreatedAt,
+		&i.FirstName,
+		&i.LastName,
+	)
+	return i, err
+}
+
 const getCourse = `-- name: GetCourse :one
-SELECT uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state FROM course WHERE course.uuid == ?
+SELECT uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota FROM course WHERE course.uuid == ?
 `
 
 func (q *Queries) GetCourse(ctx context.Context, uuid string) (Course, error) {
@@ -777,10 +1969,144 @@ func (q *Queries) GetCourse(ctx context.Context, uuid string) (Course, error) {
 		&i.HighlightedModuleMessage,
 		&i.Archived,
 		&i.State,
+		&i.ScheduledState,
+		&i.ScheduledAt,
+		&i.ScheduledModuleUuid,
+		&i.ScheduledModuleMessage,
+		&i.MaxUploadSize,
+		&i.StorageQuota,
+	)
+	return i, err
+}
+
+const getCourseOfQuiz = `-- name: GetCourseOfQuiz :one
+SELECT course_uuid FROM quiz WHERE uuid = ?
+`
+
+func (q *Queries) GetCourseOfQuiz(ctx context.Context, uuid string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getCourseOfQuiz, uuid)
+	var course_uuid string
+	err := row.Scan(&course_uuid)
+	return course_uuid, err
+}
+
+const getCourseStorageQuota = `-- name: GetCourseStorageQuota :one
+
+SELECT storage_quota FROM course WHERE uuid = ?
+`
+
+// * Storage of course files
+func (q *Queries) GetCourseStorageQuota(ctx context.Context, uuid string) (sql.NullInt64, error) {
+	row := q.db.QueryRowContext(ctx, getCourseStorageQuota, uuid)
+	var storage_quota sql.NullInt64
+	err := row.Scan(&storage_quota)
+	return storage_quota, err
+}
+
+const getCourseStorageUsage = `-- name: GetCourseStorageUsage :one
+SELECT
+    CAST((SELECT COALESCE(SUM(m.byte_size), 0) FROM material m
+        WHERE m.course_uuid = ?1 AND m.type = 'file') AS INTEGER) AS materials_bytes,
+    CAST((SELECT COALESCE(SUM(v.byte_size), 0) FROM material_version v
+        JOIN material m ON m.uuid = v.material_uuid
+        WHERE m.course_uuid = ?1 AND v.url != m.url) AS INTEGER) AS versions_bytes,
+    CAST((SELECT COALESCE(SUM(a.byte_size), 0) FROM feed_attachment a
+        JOIN feed_posts p ON p.uuid = a.post_uuid
+        WHERE p.course_uuid = ?1 AND a.type = 'file') AS INTEGER) AS attachments_bytes,
+    CAST((SELECT COALESCE(SUM(u.size), 0) FROM upload u
+        WHERE u.course_uuid = ?1) AS INTEGER) AS uploads_bytes
+`
+
+type GetCourseStorageUsageRow struct {
+	MaterialsBytes   int64 `json:"materials_bytes"`
+	VersionsBytes    int64 `json:"versions_bytes"`
+	AttachmentsBytes int64 `json:"attachments_bytes"`
+	UploadsBytes     int64 `json:"uploads_bytes"`
+}
+
+// the current files of materials, their older versions, files attached to posts and the space taken by resumable uploads
+func (q *Queries) GetCourseStorageUsage(ctx context.Context, courseUuid string) (GetCourseStorageUsageRow, error) {
+	row := q.db.QueryRowContext(ctx, getCourseStorageUsage, courseUuid)
+	var i GetCourseStorageUsageRow
+	err := row.Scan(
+		&i.MaterialsBytes,
+		&i.VersionsBytes,
+		&i.AttachmentsBytes,
+		&i.UploadsBytes,
+	)
+	return i, err
+}
+
+const getCourseUploadLimit = `-- name: GetCourseUploadLimit :one
+SELECT max_upload_size FROM course WHERE uuid = ?
+`
+
+func (q *Queries) GetCourseUploadLimit(ctx context.Context, uuid string) (sql.NullInt64, error) {
+	row := q.db.QueryRowContext(ctx, getCourseUploadLimit, uuid)
+	var max_upload_size sql.NullInt64
+	err := row.Scan(&max_upload_size)
+	return max_upload_size, err
+}
+
+const getCurrentMaterialVersion = `-- name: GetCurrentMaterialVersion :one
+SELECT material_version.uuid, material_version.material_uuid, material_version.version, material_version.url, material_version.mime_type, material_version.byte_size, material_version.thumbnail_key, material_version.preview_text, material_version.created_by, material_version.created_at
+FROM material_version
+JOIN material ON material.uuid = material_version.material_uuid AND material.url = material_version.url
+WHERE material.uuid = ?
+`
+
+// the version the material points to, missing for materials from before the versions
+func (q *Queries) GetCurrentMaterialVersion(ctx context.Context, uuid string) (MaterialVersion, error) {
+	row := q.db.QueryRowContext(ctx, getCurrentMaterialVersion, uuid)
+	var i MaterialVersion
+	err := row.Scan(
+		&i.Uuid,
+		&i.MaterialUuid,
+		&i.Version,
+		&i.Url,
+		&i.MimeType,
+		&i.ByteSize,
+		&i.ThumbnailKey,
+		&i.PreviewText,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getEmailChangeOfUser = `-- name: GetEmailChangeOfUser :one
+SELECT token, user_id, new_email, created_at, expires_at FROM email_change WHERE user_id = ? AND expires_at > ?
+`
+
+type GetEmailChangeOfUserParams struct {
+	UserID    int64 `json:"user_id"`
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (q *Queries) GetEmailChangeOfUser(ctx context.Context, arg GetEmailChangeOfUserParams) (EmailChange, error) {
+	row := q.db.QueryRowContext(ctx, getEmailChangeOfUser, arg.UserID, arg.ExpiresAt)
+	var i EmailChange
+	err := row.Scan(
+		&i.Token,
+		&i.UserID,
+		&i.NewEmail,
+		&i.CreatedAt,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
+const getFeedTokenOfUser = `-- name: GetFeedTokenOfUser :one
+SELECT feed_token FROM user WHERE id = ?
+`
+
+func (q *Queries) GetFeedTokenOfUser(ctx context.Context, id int64) (sql.NullString, error) {
+	row := q.db.QueryRowContext(ctx, getFeedTokenOfUser, id)
+	var feed_token sql.NullString
+	err := row.Scan(&feed_token)
+	return feed_token, err
+}
+
 const getHeading = `-- name: GetHeading :one
 SELECT uuid, course_uuid, content, variant, created_at, updated_at FROM heading WHERE uuid = ?
 `
@@ -799,8 +2125,41 @@ func (q *Queries) GetHeading(ctx context.Context, uuid string) (Heading, error)
 	return i, err
 }
 
+const getLastFeedEventId = `-- name: GetLastFeedEventId :one
+SELECT CAST(COALESCE(MAX(id), 0) AS INTEGER) FROM feed_event
+`
+
+func (q *Queries) GetLastFeedEventId(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getLastFeedEventId)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getLinkPreview = `-- name: GetLinkPreview :one
+SELECT url, title, description, image_url, favicon_url, duration, fetched_at, next_fetch_at, failures, error FROM link_preview WHERE url = ?
+`
+
+func (q *Queries) GetLinkPreview(ctx context.Context, url string) (LinkPreview, error) {
+	row := q.db.QueryRowContext(ctx, getLinkPreview, url)
+	var i LinkPreview
+	err := row.Scan(
+		&i.Url,
+		&i.Title,
+		&i.Description,
+		&i.ImageUrl,
+		&i.FaviconUrl,
+		&i.Duration,
+		&i.FetchedAt,
+		&i.NextFetchAt,
+		&i.Failures,
+		&i.Error,
+	)
+	return i, err
+}
+
 const getMaterial = `-- name: GetMaterial :one
-SELECT uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, created_at, updated_at FROM material WHERE material.uuid = ?
+SELECT uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, video_provider, video_id, duration_seconds, created_at, updated_at FROM material WHERE material.uuid = ?
 `
 
 func (q *Queries) GetMaterial(ctx context.Context, uuid string) (Material, error) {
@@ -817,48 +2176,153 @@ func (q *Queries) GetMaterial(ctx context.Context, uuid string) (Material, error
 		&i.FaviconUrl,
 		&i.MimeType,
 		&i.ByteSize,
+		&i.VideoProvider,
+		&i.VideoID,
+		&i.DurationSeconds,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getModule = `-- name: GetModule :one
-SELECT uuid, course_uuid, name, description, state, module_order, created_at, updated_at FROM module WHERE uuid = ? AND course_uuid = ?
+const getMaterialDownload = `-- name: GetMaterialDownload :one
+SELECT
+    material.uuid, material.course_uuid, material.name, material.description, material.url, material.type, material.times_accessed, material.favicon_url, material.mime_type, material.byte_size, material.video_provider, material.video_id, material.duration_seconds, material.created_at, material.updated_at,
+    course.state AS course_state,
+    module.state AS module_state,
+    material_version.thumbnail_key
+FROM material
+JOIN course ON course.uuid = material.course_uuid
+LEFT JOIN material_to_module ON material_to_module.material_uuid = material.uuid
+LEFT JOIN module ON module.uuid = material_to_module.module_uuid
+LEFT JOIN material_version ON material_version.material_uuid = material.uuid AND material_version.url = material.url
+WHERE material.uuid = ?1 AND material.course_uuid = ?2
+LIMIT 1
 `
 
-type GetModuleParams struct {
+type GetMaterialDownloadParams struct {
 	Uuid       string `json:"uuid"`
 	CourseUuid string `json:"course_uuid"`
 }
 
-func (q *Queries) GetModule(ctx context.Context, arg GetModuleParams) (Module, error) {
-	row := q.db.QueryRowContext(ctx, getModule, arg.Uuid, arg.CourseUuid)
-	var i Module
+type GetMaterialDownloadRow struct {
+	Uuid            string         `json:"uuid"`
+	CourseUuid      string         `json:"course_uuid"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Url             string         `json:"url"`
+	Type            string         `json:"type"`
+	TimesAccessed   int64          `json:"times_accessed"`
+	FaviconUrl      sql.NullString `json:"favicon_url"`
+	MimeType        sql.NullString `json:"mime_type"`
+	ByteSize        sql.NullInt64  `json:"byte_size"`
+	VideoProvider   sql.NullString `json:"video_provider"`
+	VideoID         sql.NullString `json:"video_id"`
+	DurationSeconds sql.NullInt64  `json:"duration_seconds"`
+	CreatedAt       int64          `json:"created_at"`
+	UpdatedAt       int64          `json:"updated_at"`
+	CourseState     string         `json:"course_state"`
+	ModuleState     sql.NullString `json:"module_state"`
+	ThumbnailKey    sql.NullString `json:"thumbnail_key"`
+}
+
+func (q *Queries) GetMaterialDownload(ctx context.Context, arg GetMaterialDownloadParams) (GetMaterialDownloadRow, error) {
+	row := q.db.QueryRowContext(ctx, getMaterialDownload, arg.Uuid, arg.CourseUuid)
+	var i GetMaterialDownloadRow
 	err := row.Scan(
 		&i.Uuid,
 		&i.CourseUuid,
 		&i.Name,
 		&i.Description,
-		&i.State,
-		&i.ModuleOrder,
+		&i.Url,
+		&i.Type,
+		&i.TimesAccessed,
+		&i.FaviconUrl,
+		&i.MimeType,
+		&i.ByteSize,
+		&i.VideoProvider,
+		&i.VideoID,
+		&i.DurationSeconds,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.CourseState,
+		&i.ModuleState,
+		&i.ThumbnailKey,
 	)
 	return i, err
 }
 
-const getModuleContents = `-- name: GetModuleContents :many
+const getMaterialVersion = `-- name: GetMaterialVersion :one
+SELECT material_version.uuid, material_version.material_uuid, material_version.version, material_version.url, material_version.mime_type, material_version.byte_size, material_version.thumbnail_key, material_version.preview_text, material_version.created_by, material_version.created_at
+FROM material_version
+JOIN material ON material.uuid = material_version.material_uuid
+WHERE material_version.material_uuid = ?1
+    AND material_version.version = ?2
+    AND material.course_uuid = ?3
+`
 
-SELECT 
-    'material' AS item_type,
-    m.uuid,
-    m.name AS display_name,
-    m.description,
-    mtm."order"
-FROM material_to_module mtm
-JOIN material m ON mtm.material_uuid = m.uuid
-WHERE mtm.module_uuid = ? AND m.course_uuid = ?
+type GetMaterialVersionParams struct {
+	MaterialUuid string `json:"material_uuid"`
+	Version      int64  `json:"version"`
+	CourseUuid   string `json:"course_uuid"`
+}
+
+func (q *Queries) GetMaterialVersion(ctx context.Context, arg GetMaterialVersionParams) (MaterialVersion, error) {
+	row := q.db.QueryRowContext(ctx, getMaterialVersion, arg.MaterialUuid, arg.Version, arg.CourseUuid)
+	var i MaterialVersion
+	err := row.Scan(
+		&i.Uuid,
+		&i.MaterialUuid,
+		&i.Version,
+		&i.Url,
+		&i.MimeType,
+		&i.ByteSize,
+		&i.ThumbnailKey,
+		&i.PreviewText,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getModule = `-- name: GetModule :one
+SELECT uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at FROM module WHERE uuid = ? AND course_uuid = ?
+`
+
+type GetModuleParams struct {
+	Uuid       string `json:"uuid"`
+	CourseUuid string `json:"course_uuid"`
+}
+
+func (q *Queries) GetModule(ctx context.Context, arg GetModuleParams) (Module, error) {
+	row := q.db.QueryRowContext(ctx, getModule, arg.Uuid, arg.CourseUuid)
+	var i Module
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.Name,
+		&i.Description,
+		&i.State,
+		&i.ModuleOrder,
+		&i.ScheduledState,
+		&i.ScheduledAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getModuleContents = `-- name: GetModuleContents :many
+
+SELECT 
+    'material' AS item_type,
+    m.uuid,
+    m.name AS display_name,
+    m.description,
+    mtm."order"
+FROM material_to_module mtm
+JOIN material m ON mtm.material_uuid = m.uuid
+WHERE mtm.module_uuid = ? AND m.course_uuid = ?
 
 UNION ALL
 
@@ -942,7 +2406,7 @@ func (q *Queries) GetModuleContents(ctx context.Context, arg GetModuleContentsPa
 }
 
 const getPost = `-- name: GetPost :one
-SELECT uuid, course_uuid, type, message, is_edited, created_at, updated_at FROM feed_posts
+SELECT id, uuid, course_uuid, type, message, is_edited, is_pinned, is_locked, created_at, updated_at, publish_at, is_published, expires_at, is_expired FROM feed_posts
 WHERE uuid = ?
 `
 
@@ -950,56 +2414,24 @@ func (q *Queries) GetPost(ctx context.Context, uuid string) (FeedPost, error) {
 	row := q.db.QueryRowContext(ctx, getPost, uuid)
 	var i FeedPost
 	err := row.Scan(
+		&i.ID,
 		&i.Uuid,
 		&i.CourseUuid,
 		&i.Type,
 		&i.Message,
 		&i.IsEdited,
+		&i.IsPinned,
+		&i.IsLocked,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PublishAt,
+		&i.IsPublished,
+		&i.ExpiresAt,
+		&i.IsExpired,
 	)
 	return i, err
 }
 
-const getPostsByCourse = `-- name: GetPostsByCourse :many
-
-SELECT uuid, course_uuid, type, message, is_edited, created_at, updated_at FROM feed_posts
-WHERE course_uuid = ?
-ORDER BY created_at DESC
-`
-
-// * Posts
-func (q *Queries) GetPostsByCourse(ctx context.Context, courseUuid string) ([]FeedPost, error) {
-	rows, err := q.db.QueryContext(ctx, getPostsByCourse, courseUuid)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []FeedPost
-	for rows.Next() {
-		var i FeedPost
-		if err := rows.Scan(
-			&i.Uuid,
-			&i.CourseUuid,
-			&i.Type,
-			&i.Message,
-			&i.IsEdited,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
-
 const getQuestionsOfQuiz = `-- name: GetQuestionsOfQuiz :many
 SELECT uuid, quiz_uuid, question_order, type, question_text, options, correct_indices FROM question WHERE quiz_uuid = ?
 `
@@ -1042,6 +2474,7 @@ SELECT
 
     qz.title AS quiz_title,
     qz.attempts_count AS quiz_attempts_count,
+    qz.deadline_at AS quiz_deadline_at,
     qz.created_at AS quiz_created_at,
     qz.updated_at AS quiz_updated_at,
 
@@ -1063,6 +2496,7 @@ type GetQuizRow struct {
 	CourseUuid             string         `json:"course_uuid"`
 	QuizTitle              string         `json:"quiz_title"`
 	QuizAttemptsCount      int64          `json:"quiz_attempts_count"`
+	QuizDeadlineAt         sql.NullInt64  `json:"quiz_deadline_at"`
 	QuizCreatedAt          int64          `json:"quiz_created_at"`
 	QuizUpdatedAt          int64          `json:"quiz_updated_at"`
 	QuestionUuid           sql.NullString `json:"question_uuid"`
@@ -1088,6 +2522,7 @@ func (q *Queries) GetQuiz(ctx context.Context, uuid string) ([]GetQuizRow, error
 			&i.CourseUuid,
 			&i.QuizTitle,
 			&i.QuizAttemptsCount,
+			&i.QuizDeadlineAt,
 			&i.QuizCreatedAt,
 			&i.QuizUpdatedAt,
 			&i.QuestionUuid,
@@ -1110,23 +2545,51 @@ func (q *Queries) GetQuiz(ctx context.Context, uuid string) ([]GetQuizRow, error
 	return items, nil
 }
 
+const getUpload = `-- name: GetUpload :one
+SELECT uuid, course_uuid, user_id, filename, size, received_bytes, checksum, mime_type, created_at, updated_at, finished_at, expires_at FROM upload WHERE uuid = ?
+`
+
+func (q *Queries) GetUpload(ctx context.Context, uuid string) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, getUpload, uuid)
+	var i Upload
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.UserID,
+		&i.Filename,
+		&i.Size,
+		&i.ReceivedBytes,
+		&i.Checksum,
+		&i.MimeType,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
 const getUser = `-- name: GetUser :one
 
 SELECT 
-    u.id, u.first_name, u.last_name, u.hash, u.email, 
-    (a.user_id IS NOT NULL) AS is_admin
+    u.id, u.first_name, u.last_name, u.hash, u.email, u.must_change_password, u.deactivated_at, u.avatar_path, u.feed_token, 
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
 FROM user u
 LEFT JOIN admin a ON u.id = a.user_id
 WHERE u.id = ?
 `
 
 type GetUserRow struct {
-	ID        int64       `json:"id"`
-	FirstName string      `json:"first_name"`
-	LastName  string      `json:"last_name"`
-	Hash      string      `json:"hash"`
-	Email     string      `json:"email"`
-	IsAdmin   interface{} `json:"is_admin"`
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+	IsAdmin            bool           `json:"is_admin"`
 }
 
 // * USER
@@ -1139,17 +2602,22 @@ func (q *Queries) GetUser(ctx context.Context, id int64) (GetUserRow, error) {
 		&i.LastName,
 		&i.Hash,
 		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
 		&i.IsAdmin,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, first_name, last_name, hash, email FROM user WHERE user.email = ?
+SELECT id, first_name, last_name, hash, email, must_change_password, deactivated_at, avatar_path, feed_token FROM user WHERE lower(user.email) = lower(?)
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+// emails are compared case-insensitively, older accounts may have been registered with upper case letters
+func (q *Queries) GetUserByEmail(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, lower)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -1157,33 +2625,129 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.LastName,
 		&i.Hash,
 		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
+	)
+	return i, err
+}
+
+const getUserByFeedToken = `-- name: GetUserByFeedToken :one
+SELECT
+    u.id, u.first_name, u.last_name, u.hash, u.email, u.must_change_password, u.deactivated_at, u.avatar_path, u.feed_token,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE u.feed_token = ? AND u.deactivated_at IS NULL
+`
+
+type GetUserByFeedTokenRow struct {
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+	IsAdmin            bool           `json:"is_admin"`
+}
+
+func (q *Queries) GetUserByFeedToken(ctx context.Context, feedToken sql.NullString) (GetUserByFeedTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByFeedToken, feedToken)
+	var i GetUserByFeedTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Hash,
+		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
+		&i.IsAdmin,
+	)
+	return i, err
+}
+
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+
+SELECT
+    u.id, u.first_name, u.last_name, u.hash, u.email, u.must_change_password, u.deactivated_at, u.avatar_path, u.feed_token,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user_identity ui
+JOIN user u ON u.id = ui.user_id
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE ui.issuer = ? AND ui.subject = ?
+`
+
+type GetUserByIdentityParams struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+type GetUserByIdentityRow struct {
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+	IsAdmin            bool           `json:"is_admin"`
+}
+
+// * User Identity (OIDC)
+func (q *Queries) GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (GetUserByIdentityRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByIdentity, arg.Issuer, arg.Subject)
+	var i GetUserByIdentityRow
+	err := row.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Hash,
+		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
+		&i.IsAdmin,
 	)
 	return i, err
 }
 
 const getUserBySessionToken = `-- name: GetUserBySessionToken :one
 SELECT 
-    u.id, first_name, last_name, hash, email, s.id, s.user_id, token, created_at, expires_at, a.user_id, 
+    u.id, first_name, last_name, hash, email, must_change_password, deactivated_at, avatar_path, feed_token, s.id, s.user_id, token, created_at, expires_at, a.user_id, 
     CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
 FROM user u
 JOIN session s ON u.id = s.user_id
 LEFT JOIN admin a ON u.id = a.user_id
-WHERE s.token = ?
+WHERE s.token = ? AND u.deactivated_at IS NULL
 `
 
 type GetUserBySessionTokenRow struct {
-	ID        int64         `json:"id"`
-	FirstName string        `json:"first_name"`
-	LastName  string        `json:"last_name"`
-	Hash      string        `json:"hash"`
-	Email     string        `json:"email"`
-	ID_2      int64         `json:"id_2"`
-	UserID    int64         `json:"user_id"`
-	Token     string        `json:"token"`
-	CreatedAt int64         `json:"created_at"`
-	ExpiresAt int64         `json:"expires_at"`
-	UserID_2  sql.NullInt64 `json:"user_id_2"`
-	IsAdmin   bool          `json:"is_admin"`
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+	ID_2               int64          `json:"id_2"`
+	UserID             int64          `json:"user_id"`
+	Token              string         `json:"token"`
+	CreatedAt          int64          `json:"created_at"`
+	ExpiresAt          int64          `json:"expires_at"`
+	UserID_2           sql.NullInt64  `json:"user_id_2"`
+	IsAdmin            bool           `json:"is_admin"`
 }
 
 func (q *Queries) GetUserBySessionToken(ctx context.Context, token string) (GetUserBySessionTokenRow, error) {
@@ -1195,6 +2759,10 @@ func (q *Queries) GetUserBySessionToken(ctx context.Context, token string) (GetU
 		&i.LastName,
 		&i.Hash,
 		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
 		&i.ID_2,
 		&i.UserID,
 		&i.Token,
@@ -1206,6 +2774,78 @@ func (q *Queries) GetUserBySessionToken(ctx context.Context, token string) (GetU
 	return i, err
 }
 
+const getVideoProgress = `-- name: GetVideoProgress :one
+
+SELECT material_uuid, user_id, position_seconds, furthest_seconds, duration_seconds, completed, updated_at FROM video_progress WHERE material_uuid = ? AND user_id = ?
+`
+
+type GetVideoProgressParams struct {
+	MaterialUuid string `json:"material_uuid"`
+	UserID       int64  `json:"user_id"`
+}
+
+// * Video progress
+func (q *Queries) GetVideoProgress(ctx context.Context, arg GetVideoProgressParams) (VideoProgress, error) {
+	row := q.db.QueryRowContext(ctx, getVideoProgress, arg.MaterialUuid, arg.UserID)
+	var i VideoProgress
+	err := row.Scan(
+		&i.MaterialUuid,
+		&i.UserID,
+		&i.PositionSeconds,
+		&i.FurthestSeconds,
+		&i.DurationSeconds,
+		&i.Completed,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getWebhook = `-- name: GetWebhook :one
+SELECT uuid, course_uuid, url, secret, events, format, active, created_at, updated_at FROM webhook WHERE uuid = ?
+`
+
+func (q *Queries) GetWebhook(ctx context.Context, uuid string) (Webhook, error) {
+	row := q.db.QueryRowContext(ctx, getWebhook, uuid)
+	var i Webhook
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Format,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getWebhookDelivery = `-- name: GetWebhookDelivery :one
+SELECT uuid, webhook_uuid, event, payload, status, attempts, next_attempt_at, response_status, response_body, error, duration_ms, created_at, updated_at FROM webhook_delivery WHERE uuid = ?
+`
+
+func (q *Queries) GetWebhookDelivery(ctx context.Context, uuid string) (WebhookDelivery, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookDelivery, uuid)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.Uuid,
+		&i.WebhookUuid,
+		&i.Event,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.ResponseStatus,
+		&i.ResponseBody,
+		&i.Error,
+		&i.DurationMs,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const incrementMaterialAccessedCount = `-- name: IncrementMaterialAccessedCount :exec
 UPDATE material
 SET
@@ -1297,8 +2937,54 @@ func (q *Queries) InvalidateSession(ctx context.Context, token string) error {
 	return err
 }
 
+const invalidateSessionsOfUser = `-- name: InvalidateSessionsOfUser :exec
+DELETE FROM session WHERE user_id = ?
+`
+
+func (q *Queries) InvalidateSessionsOfUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, invalidateSessionsOfUser, userID)
+	return err
+}
+
+const listActiveWebhooksOfCourse = `-- name: ListActiveWebhooksOfCourse :many
+SELECT uuid, course_uuid, url, secret, events, format, active, created_at, updated_at FROM webhook WHERE course_uuid = ? AND active = 1
+`
+
+func (q *Queries) ListActiveWebhooksOfCourse(ctx context.Context, courseUuid string) ([]Webhook, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveWebhooksOfCourse, courseUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.CourseUuid,
+			&i.Url,
+			&i.Secret,
+			&i.Events,
+			&i.Format,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listAllCourses = `-- name: ListAllCourses :many
-SELECT uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state FROM course WHERE archived = 0
+SELECT uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota FROM course WHERE archived = 0
 `
 
 func (q *Queries) ListAllCourses(ctx context.Context) ([]Course, error) {
@@ -1320,6 +3006,12 @@ func (q *Queries) ListAllCourses(ctx context.Context) ([]Course, error) {
 			&i.HighlightedModuleMessage,
 			&i.Archived,
 			&i.State,
+			&i.ScheduledState,
+			&i.ScheduledAt,
+			&i.ScheduledModuleUuid,
+			&i.ScheduledModuleMessage,
+			&i.MaxUploadSize,
+			&i.StorageQuota,
 		); err != nil {
 			return nil, err
 		}
@@ -1336,30 +3028,52 @@ func (q *Queries) ListAllCourses(ctx context.Context) ([]Course, error) {
 
 const listAllMaterialsOfCourse = `-- name: ListAllMaterialsOfCourse :many
 SELECT
-    uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, created_at, updated_at, module_uuid, material_uuid, "order"
+    material.uuid, material.course_uuid, material.name, material.description, material.url, material.type, material.times_accessed, material.favicon_url, material.mime_type, material.byte_size, material.video_provider, material.video_id, material.duration_seconds, material.created_at, material.updated_at,
+    material_to_module.module_uuid, material_to_module.material_uuid, material_to_module."order",
     -- material_to_module."order"
+    material_version.thumbnail_key,
+    material_version.preview_text,
+    link_preview.title AS page_title,
+    link_preview.description AS page_description,
+    link_preview.image_url AS page_image_url,
+    link_preview.favicon_url AS page_favicon_url,
+    link_preview.duration AS page_duration,
+    link_preview.fetched_at AS page_fetched_at
 FROM material
 JOIN material_to_module ON material_to_module.material_uuid = material.uuid
+LEFT JOIN material_version ON material_version.material_uuid = material.uuid AND material_version.url = material.url
+LEFT JOIN link_preview ON link_preview.url = material.url AND material.type IN ('url', 'video')
 WHERE material.course_uuid = ? 
-ORDER BY created_at DESC
+ORDER BY material.created_at DESC
 `
 
 type ListAllMaterialsOfCourseRow struct {
-	Uuid          string         `json:"uuid"`
-	CourseUuid    string         `json:"course_uuid"`
-	Name          string         `json:"name"`
-	Description   string         `json:"description"`
-	Url           string         `json:"url"`
-	Type          string         `json:"type"`
-	TimesAccessed int64          `json:"times_accessed"`
-	FaviconUrl    sql.NullString `json:"favicon_url"`
-	MimeType      sql.NullString `json:"mime_type"`
-	ByteSize      sql.NullInt64  `json:"byte_size"`
-	CreatedAt     int64          `json:"created_at"`
-	UpdatedAt     int64          `json:"updated_at"`
-	ModuleUuid    string         `json:"module_uuid"`
-	MaterialUuid  string         `json:"material_uuid"`
-	Order         int64          `json:"order"`
+	Uuid            string         `json:"uuid"`
+	CourseUuid      string         `json:"course_uuid"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Url             string         `json:"url"`
+	Type            string         `json:"type"`
+	TimesAccessed   int64          `json:"times_accessed"`
+	FaviconUrl      sql.NullString `json:"favicon_url"`
+	MimeType        sql.NullString `json:"mime_type"`
+	ByteSize        sql.NullInt64  `json:"byte_size"`
+	VideoProvider   sql.NullString `json:"video_provider"`
+	VideoID         sql.NullString `json:"video_id"`
+	DurationSeconds sql.NullInt64  `json:"duration_seconds"`
+	CreatedAt       int64          `json:"created_at"`
+	UpdatedAt       int64          `json:"updated_at"`
+	ModuleUuid      string         `json:"module_uuid"`
+	MaterialUuid    string         `json:"material_uuid"`
+	Order           int64          `json:"order"`
+	ThumbnailKey    sql.NullString `json:"thumbnail_key"`
+	PreviewText     sql.NullString `json:"preview_text"`
+	PageTitle       sql.NullString `json:"page_title"`
+	PageDescription sql.NullString `json:"page_description"`
+	PageImageUrl    sql.NullString `json:"page_image_url"`
+	PageFaviconUrl  sql.NullString `json:"page_favicon_url"`
+	PageDuration    sql.NullInt64  `json:"page_duration"`
+	PageFetchedAt   sql.NullInt64  `json:"page_fetched_at"`
 }
 
 func (q *Queries) ListAllMaterialsOfCourse(ctx context.Context, courseUuid string) ([]ListAllMaterialsOfCourseRow, error) {
@@ -1382,11 +3096,22 @@ func (q *Queries) ListAllMaterialsOfCourse(ctx context.Context, courseUuid strin
 			&i.FaviconUrl,
 			&i.MimeType,
 			&i.ByteSize,
+			&i.VideoProvider,
+			&i.VideoID,
+			&i.DurationSeconds,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.ModuleUuid,
 			&i.MaterialUuid,
 			&i.Order,
+			&i.ThumbnailKey,
+			&i.PreviewText,
+			&i.PageTitle,
+			&i.PageDescription,
+			&i.PageImageUrl,
+			&i.PageFaviconUrl,
+			&i.PageDuration,
+			&i.PageFetchedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1402,7 +3127,7 @@ func (q *Queries) ListAllMaterialsOfCourse(ctx context.Context, courseUuid strin
 }
 
 const listAllModules = `-- name: ListAllModules :many
-SELECT uuid, course_uuid, name, description, state, module_order, created_at, updated_at FROM module WHERE course_uuid = ?
+SELECT uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at FROM module WHERE course_uuid = ?
 `
 
 func (q *Queries) ListAllModules(ctx context.Context, courseUuid string) ([]Module, error) {
@@ -1421,6 +3146,8 @@ func (q *Queries) ListAllModules(ctx context.Context, courseUuid string) ([]Modu
 			&i.Description,
 			&i.State,
 			&i.ModuleOrder,
+			&i.ScheduledState,
+			&i.ScheduledAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -1437,76 +3164,1020 @@ func (q *Queries) ListAllModules(ctx context.Context, courseUuid string) ([]Modu
 	return items, nil
 }
 
-const listQuizes = `-- name: ListQuizes :many
-SELECT
-    qz.uuid AS quiz_uuid,
-    qz.course_uuid AS course_uuid,
-
-    qz.title AS quiz_title,
-    qz.attempts_count AS quiz_attempts_count,
-    qz.created_at AS quiz_created_at,
-    qz.updated_at AS quiz_updated_at,
+const listAttachmentPathsOfPost = `-- name: ListAttachmentPathsOfPost :many
+SELECT path FROM feed_attachment WHERE post_uuid = ? AND path IS NOT NULL
+`
 
-    qs.uuid AS question_uuid,
-    qs.question_order AS question_order,
-    qs.type AS question_type,
-    qs.question_text AS question_text,
-    qs.options AS question_options,
-    qs.correct_indices AS question_correct_indices,
+func (q *Queries) ListAttachmentPathsOfPost(ctx context.Context, postUuid string) ([]sql.NullString, error) {
+	rows, err := q.db.QueryContext(ctx, listAttachmentPathsOfPost, postUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullString
+	for rows.Next() {
+		var path sql.NullString
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		items = append(items, path)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
 
-    qm."order" AS module_order,
-    qm.module_uuid
+const listAttachmentsOfPosts = `-- name: ListAttachmentsOfPosts :many
 
-FROM quiz qz
-JOIN question qs
-    ON qs.quiz_uuid = qz.uuid
-JOIN quiz_to_module as qm
-    ON qm.quiz_uuid = qz.uuid
-WHERE qz.course_uuid = ?
-ORDER BY qz.uuid ASC, qs.question_order ASC
+SELECT
+    a.uuid, a.post_uuid, a.type, a.position, a.name, a.path, a.mime_type, a.byte_size, a.material_uuid, a.quiz_uuid, a.created_at,
+    m.name AS material_name,
+    m.url AS material_url,
+    m.type AS material_type,
+    m.course_uuid AS material_course_uuid,
+    q.title AS quiz_title,
+    p.course_uuid AS post_course_uuid
+FROM feed_attachment a
+JOIN feed_posts p ON p.uuid = a.post_uuid
+LEFT JOIN material m ON m.uuid = a.material_uuid
+LEFT JOIN quiz q ON q.uuid = a.quiz_uuid
+WHERE a.post_uuid IN (/*SLICE:post_uuids*/?)
+ORDER BY a.post_uuid, a.position
 `
 
-type ListQuizesRow struct {
-	QuizUuid               string `json:"quiz_uuid"`
-	CourseUuid             string `json:"course_uuid"`
-	QuizTitle              string `json:"quiz_title"`
-	QuizAttemptsCount      int64  `json:"quiz_attempts_count"`
-	QuizCreatedAt          int64  `json:"quiz_created_at"`
-	QuizUpdatedAt          int64  `json:"quiz_updated_at"`
-	QuestionUuid           string `json:"question_uuid"`
-	QuestionOrder          int64  `json:"question_order"`
-	QuestionType           string `json:"question_type"`
-	QuestionText           string `json:"question_text"`
-	QuestionOptions        string `json:"question_options"`
-	QuestionCorrectIndices string `json:"question_correct_indices"`
-	ModuleOrder            int64  `json:"module_order"`
-	ModuleUuid             string `json:"module_uuid"`
+type ListAttachmentsOfPostsRow struct {
+	Uuid               string         `json:"uuid"`
+	PostUuid           string         `json:"post_uuid"`
+	Type               string         `json:"type"`
+	Position           int64          `json:"position"`
+	Name               sql.NullString `json:"name"`
+	Path               sql.NullString `json:"path"`
+	MimeType           sql.NullString `json:"mime_type"`
+	ByteSize           sql.NullInt64  `json:"byte_size"`
+	MaterialUuid       sql.NullString `json:"material_uuid"`
+	QuizUuid           sql.NullString `json:"quiz_uuid"`
+	CreatedAt          int64          `json:"created_at"`
+	MaterialName       sql.NullString `json:"material_name"`
+	MaterialUrl        sql.NullString `json:"material_url"`
+	MaterialType       sql.NullString `json:"material_type"`
+	MaterialCourseUuid sql.NullString `json:"material_course_uuid"`
+	QuizTitle          sql.NullString `json:"quiz_title"`
+	PostCourseUuid     string         `json:"post_course_uuid"`
+}
+
+// * Feed attachments
+func (q *Queries) ListAttachmentsOfPosts(ctx context.Context, postUuids []string) ([]ListAttachmentsOfPostsRow, error) {
+	query := listAttachmentsOfPosts
+	var queryParams []interface{}
+	if len(postUuids) > 0 {
+		for _, v := range postUuids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", strings.Repeat(",?", len(postUuids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListAttachmentsOfPostsRow
+	for rows.Next() {
+		var i ListAttachmentsOfPostsRow
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.PostUuid,
+			&i.Type,
+			&i.Position,
+			&i.Name,
+			&i.Path,
+			&i.MimeType,
+			&i.ByteSize,
+			&i.MaterialUuid,
+			&i.QuizUuid,
+			&i.CreatedAt,
+			&i.MaterialName,
+			&i.MaterialUrl,
+			&i.MaterialType,
+			&i.MaterialCourseUuid,
+			&i.QuizTitle,
+			&i.PostCourseUuid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCommentsOfPost = `-- name: ListCommentsOfPost :many
+
+SELECT c.uuid, c.post_uuid, c.parent_uuid, c.user_id, c.message, c.is_hidden, c.created_at, u.first_name, u.last_name
+FROM feed_comment c
+JOIN user u ON u.id = c.user_id
+WHERE c.post_uuid = ?
+ORDER BY c.created_at ASC, c.rowid ASC
+`
+
+type ListCommentsOfPostRow struct {
+	Uuid       string         `json:"uuid"`
+	PostUuid   string         `json:"post_uuid"`
+	ParentUuid sql.NullString `json:"parent_uuid"`
+	UserID     int64          `json:"user_id"`
+	Message    string         `json:"message"`
+	IsHidden   bool           `json:"is_hidden"`
+	CreatedAt  int64          `json:"created_at"`
+	FirstName  string         `json:"first_name"`
+	LastName   string         `json:"last_name"`
+}
+
+// * Feed comments and reactions
+func (q *Queries) ListCommentsOfPost(ctx context.Context, postUuid string) ([]ListCommentsOfPostRow, error) {
+	rows, err := q.db.QueryContext(ctx, listCommentsOfPost, postUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListCommentsOfPostRow
+	for rows.Next() {
+		var i ListCommentsOfPostRow
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.PostUuid,
+			&i.ParentUuid,
+			&i.UserID,
+			&i.Message,
+			&i.IsHidden,
+			&i.CreatedAt,
+			&i.FirstName,
+			&i.LastName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCoursesOfLink = `-- name: ListCoursesOfLink :many
+SELECT DISTINCT course_uuid FROM material WHERE type IN ('url', 'video') AND url = ?
+`
+
+func (q *Queries) ListCoursesOfLink(ctx context.Context, url string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listCoursesOfLink, url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var course_uuid string
+		if err := rows.Scan(&course_uuid); err != nil {
+			return nil, err
+		}
+		items = append(items, course_uuid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeliveriesOfWebhook = `-- name: ListDeliveriesOfWebhook :many
+SELECT uuid, webhook_uuid, event, payload, status, attempts, next_attempt_at, response_status, response_body, error, duration_ms, created_at, updated_at FROM webhook_delivery
+WHERE webhook_uuid = ?
+ORDER BY created_at DESC, rowid DESC
+LIMIT ?
+`
+
+type ListDeliveriesOfWebhookParams struct {
+	WebhookUuid string `json:"webhook_uuid"`
+	Limit       int64  `json:"limit"`
+}
+
+func (q *Queries) ListDeliveriesOfWebhook(ctx context.Context, arg ListDeliveriesOfWebhookParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.QueryContext(ctx, listDeliveriesOfWebhook, arg.WebhookUuid, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.WebhookUuid,
+			&i.Event,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.ResponseStatus,
+			&i.ResponseBody,
+			&i.Error,
+			&i.DurationMs,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredUploads = `-- name: ListExpiredUploads :many
+SELECT uuid FROM upload WHERE expires_at < ?
+`
+
+func (q *Queries) ListExpiredUploads(ctx context.Context, expiresAt int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listExpiredUploads, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, err
+		}
+		items = append(items, uuid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFeedEventsAfter = `-- name: ListFeedEventsAfter :many
+SELECT id, course_uuid, name, data, created_at FROM feed_event
+WHERE id > ?
+ORDER BY id ASC
+LIMIT ?
+`
+
+type ListFeedEventsAfterParams struct {
+	ID    int64 `json:"id"`
+	Limit int64 `json:"limit"`
+}
+
+func (q *Queries) ListFeedEventsAfter(ctx context.Context, arg ListFeedEventsAfterParams) ([]FeedEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listFeedEventsAfter, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedEvent
+	for rows.Next() {
+		var i FeedEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CourseUuid,
+			&i.Name,
+			&i.Data,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMaterialFileKeys = `-- name: ListMaterialFileKeys :many
+SELECT url FROM material WHERE material.uuid = ?1 AND material.type = 'file'
+UNION
+SELECT url FROM material_version WHERE material_version.material_uuid = ?1
+UNION
+SELECT thumbnail_key FROM material_version
+WHERE material_version.material_uuid = ?1 AND thumbnail_key IS NOT NULL
+`
+
+// the stored files of the material, its versions and the file of a material from before the versions
+func (q *Queries) ListMaterialFileKeys(ctx context.Context, materialUuid string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listMaterialFileKeys, materialUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		items = append(items, url)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMaterialVersions = `-- name: ListMaterialVersions :many
+SELECT
+    material_version.uuid, material_version.material_uuid, material_version.version, material_version.url, material_version.mime_type, material_version.byte_size, material_version.thumbnail_key, material_version.preview_text, material_version.created_by, material_version.created_at,
+    user.first_name,
+    user.last_name
+FROM material_version
+LEFT JOIN user ON user.id = material_version.created_by
+WHERE material_version.material_uuid = ?
+ORDER BY material_version.version DESC
+`
+
+type ListMaterialVersionsRow struct {
+	Uuid         string         `json:"uuid"`
+	MaterialUuid string         `json:"material_uuid"`
+	Version      int64          `json:"version"`
+	Url          string         `json:"url"`
+	MimeType     string         `json:"mime_type"`
+	ByteSize     int64          `json:"byte_size"`
+	ThumbnailKey sql.NullString `json:"thumbnail_key"`
+	PreviewText  sql.NullString `json:"preview_text"`
+	CreatedBy    sql.NullInt64  `json:"created_by"`
+	CreatedAt    int64          `json:"created_at"`
+	FirstName    sql.NullString `json:"first_name"`
+	LastName     sql.NullString `json:"last_name"`
+}
+
+func (q *Queries) ListMaterialVersions(ctx context.Context, materialUuid string) ([]ListMaterialVersionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMaterialVersions, materialUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMaterialVersionsRow
+	for rows.Next() {
+		var i ListMaterialVersionsRow
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.MaterialUuid,
+			&i.Version,
+			&i.Url,
+			&i.MimeType,
+			&i.ByteSize,
+			&i.ThumbnailKey,
+			&i.PreviewText,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.FirstName,
+			&i.LastName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPostsOfCourse = `-- name: ListPostsOfCourse :many
+
+SELECT p.id, p.uuid, p.course_uuid, p.type, p.message, p.is_edited, p.is_pinned, p.is_locked, p.created_at, p.updated_at, p.publish_at, p.is_published, p.expires_at, p.is_expired FROM feed_posts p
+WHERE p.course_uuid = ?1
+    AND (CAST(?2 AS BOOLEAN)
+        OR (p.is_published = 1 AND (p.expires_at IS NULL OR p.expires_at > CAST(?3 AS INTEGER))))
+    AND (CAST(?4 AS TEXT) IS NULL OR p.type = CAST(?4 AS TEXT))
+    AND (CAST(?5 AS INTEGER) IS NULL OR p.publish_at >= CAST(?5 AS INTEGER))
+    AND (CAST(?6 AS INTEGER) IS NULL OR p.publish_at < CAST(?6 AS INTEGER))
+    AND (CAST(?7 AS TEXT) IS NULL
+        OR p.id IN (SELECT rowid FROM feed_posts_fts WHERE feed_posts_fts MATCH CAST(?7 AS TEXT)))
+    AND (CAST(?8 AS BOOLEAN) IS NULL
+        OR p.is_pinned < CAST(?8 AS BOOLEAN)
+        OR (p.is_pinned = CAST(?8 AS BOOLEAN) AND (
+            p.publish_at < CAST(?9 AS INTEGER)
+            OR (p.publish_at = CAST(?9 AS INTEGER) AND p.uuid < CAST(?10 AS TEXT)))))
+ORDER BY p.is_pinned DESC, p.publish_at DESC, p.uuid DESC
+LIMIT ?11
+`
+
+type ListPostsOfCourseParams struct {
+	CourseUuid      string         `json:"course_uuid"`
+	IncludeHidden   bool           `json:"include_hidden"`
+	Now             int64          `json:"now"`
+	Type            sql.NullString `json:"type"`
+	From            sql.NullInt64  `json:"from"`
+	To              sql.NullInt64  `json:"to"`
+	Search          sql.NullString `json:"search"`
+	CursorPinned    sql.NullBool   `json:"cursor_pinned"`
+	CursorPublishAt sql.NullInt64  `json:"cursor_publish_at"`
+	CursorUuid      sql.NullString `json:"cursor_uuid"`
+	Limit           int64          `json:"limit"`
+}
+
+// * Posts
+// one page of the feed, pinned posts first and then the newest, the cursor is the is_pinned, publish_at and uuid
+// of the last post of the previous page, search is an fts5 query over the messages,
+// scheduled and expired posts are left out unless include_hidden is set
+func (q *Queries) ListPostsOfCourse(ctx context.Context, arg ListPostsOfCourseParams) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, listPostsOfCourse,
+		arg.CourseUuid,
+		arg.IncludeHidden,
+		arg.Now,
+		arg.Type,
+		arg.From,
+		arg.To,
+		arg.Search,
+		arg.CursorPinned,
+		arg.CursorPublishAt,
+		arg.CursorUuid,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedPost
+	for rows.Next() {
+		var i FeedPost
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uuid,
+			&i.CourseUuid,
+			&i.Type,
+			&i.Message,
+			&i.IsEdited,
+			&i.IsPinned,
+			&i.IsLocked,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PublishAt,
+			&i.IsPublished,
+			&i.ExpiresAt,
+			&i.IsExpired,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listQuizes = `-- name: ListQuizes :many
+SELECT
+    qz.uuid AS quiz_uuid,
+    qz.course_uuid AS course_uuid,
+
+    qz.title AS quiz_title,
+    qz.attempts_count AS quiz_attempts_count,
+    qz.deadline_at AS quiz_deadline_at,
+    qz.created_at AS quiz_created_at,
+    qz.updated_at AS quiz_updated_at,
+
+    qs.uuid AS question_uuid,
+    qs.question_order AS question_order,
+    qs.type AS question_type,
+    qs.question_text AS question_text,
+    qs.options AS question_options,
+    qs.correct_indices AS question_correct_indices,
+
+    qm."order" AS module_order,
+    qm.module_uuid
+
+FROM quiz qz
+JOIN question qs
+    ON qs.quiz_uuid = qz.uuid
+JOIN quiz_to_module as qm
+    ON qm.quiz_uuid = qz.uuid
+WHERE qz.course_uuid = ?
+ORDER BY qz.uuid ASC, qs.question_order ASC
+`
+
+type ListQuizesRow struct {
+	QuizUuid               string        `json:"quiz_uuid"`
+	CourseUuid             string        `json:"course_uuid"`
+	QuizTitle              string        `json:"quiz_title"`
+	QuizAttemptsCount      int64         `json:"quiz_attempts_count"`
+	QuizDeadlineAt         sql.NullInt64 `json:"quiz_deadline_at"`
+	QuizCreatedAt          int64         `json:"quiz_created_at"`
+	QuizUpdatedAt          int64         `json:"quiz_updated_at"`
+	QuestionUuid           string        `json:"question_uuid"`
+	QuestionOrder          int64         `json:"question_order"`
+	QuestionType           string        `json:"question_type"`
+	QuestionText           string        `json:"question_text"`
+	QuestionOptions        string        `json:"question_options"`
+	QuestionCorrectIndices string        `json:"question_correct_indices"`
+	ModuleOrder            int64         `json:"module_order"`
+	ModuleUuid             string        `json:"module_uuid"`
+}
+
+func (q *Queries) ListQuizes(ctx context.Context, courseUuid string) ([]ListQuizesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listQuizes, courseUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListQuizesRow
+	for rows.Next() {
+		var i ListQuizesRow
+		if err := rows.Scan(
+			&i.QuizUuid,
+			&i.CourseUuid,
+			&i.QuizTitle,
+			&i.QuizAttemptsCount,
+			&i.QuizDeadlineAt,
+			&i.QuizCreatedAt,
+			&i.QuizUpdatedAt,
+			&i.QuestionUuid,
+			&i.QuestionOrder,
+			&i.QuestionType,
+			&i.QuestionText,
+			&i.QuestionOptions,
+			&i.QuestionCorrectIndices,
+			&i.ModuleOrder,
+			&i.ModuleUuid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReactionsOfUser = `-- name: ListReactionsOfUser :many
+SELECT post_uuid, emoji
+FROM feed_reaction
+WHERE user_id = ? AND post_uuid IN (/*SLICE:post_uuids*/?)
+`
+
+type ListReactionsOfUserParams struct {
+	UserID    int64    `json:"user_id"`
+	PostUuids []string `json:"post_uuids"`
+}
+
+type ListReactionsOfUserRow struct {
+	PostUuid string `json:"post_uuid"`
+	Emoji    string `json:"emoji"`
+}
+
+func (q *Queries) ListReactionsOfUser(ctx context.Context, arg ListReactionsOfUserParams) ([]ListReactionsOfUserRow, error) {
+	query := listReactionsOfUser
+	var queryParams []interface{}
+	queryParams = append(queryParams, arg.UserID)
+	if len(arg.PostUuids) > 0 {
+		for _, v := range arg.PostUuids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", strings.Repeat(",?", len(arg.PostUuids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:post_uuids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReactionsOfUserRow
+	for rows.Next() {
+		var i ListReactionsOfUserRow
+		if err := rows.Scan(&i.PostUuid, &i.Emoji); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledCourses = `-- name: ListScheduledCourses :many
+SELECT uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota FROM course WHERE scheduled_at IS NOT NULL
+`
+
+func (q *Queries) ListScheduledCourses(ctx context.Context) ([]Course, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledCourses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Course
+	for rows.Next() {
+		var i Course
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.Name,
+			&i.Description,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.HighlightedModuleUuid,
+			&i.HighlightedModuleMessage,
+			&i.Archived,
+			&i.State,
+			&i.ScheduledState,
+			&i.ScheduledAt,
+			&i.ScheduledModuleUuid,
+			&i.ScheduledModuleMessage,
+			&i.MaxUploadSize,
+			&i.StorageQuota,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listScheduledModules = `-- name: ListScheduledModules :many
+SELECT uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at FROM module WHERE scheduled_at IS NOT NULL
+`
+
+func (q *Queries) ListScheduledModules(ctx context.Context) ([]Module, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledModules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Module
+	for rows.Next() {
+		var i Module
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.CourseUuid,
+			&i.Name,
+			&i.Description,
+			&i.State,
+			&i.ModuleOrder,
+			&i.ScheduledState,
+			&i.ScheduledAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStoredFiles = `-- name: ListStoredFiles :many
+SELECT m.course_uuid, CAST('material' AS TEXT) AS owner_type, m.uuid AS owner_uuid, v.url AS file_key
+FROM material_version v
+JOIN material m ON m.uuid = v.material_uuid
+UNION ALL
+SELECT m.course_uuid, 'material', m.uuid, v.thumbnail_key
+FROM material_version v
+JOIN material m ON m.uuid = v.material_uuid
+WHERE v.thumbnail_key IS NOT NULL
+UNION ALL
+SELECT m.course_uuid, 'material', m.uuid, m.url
+FROM material m
+WHERE m.type = 'file'
+    AND NOT EXISTS (SELECT 1 FROM material_version v WHERE v.material_uuid = m.uuid AND v.url = m.url)
+UNION ALL
+SELECT p.course_uuid, 'attachment', p.uuid, a.path
+FROM feed_attachment a
+JOIN feed_posts p ON p.uuid = a.post_uuid
+WHERE a.type = 'file' AND a.path IS NOT NULL
+`
+
+type ListStoredFilesRow struct {
+	CourseUuid string `json:"course_uuid"`
+	OwnerType  string `json:"owner_type"`
+	OwnerUuid  string `json:"owner_uuid"`
+	FileKey    string `json:"file_key"`
+}
+
+// every file the db knows about with the material or post it belongs to, the current file of a material
+// is one of its versions unless it's from before the versions
+func (q *Queries) ListStoredFiles(ctx context.Context) ([]ListStoredFilesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listStoredFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListStoredFilesRow
+	for rows.Next() {
+		var i ListStoredFilesRow
+		if err := rows.Scan(
+			&i.CourseUuid,
+			&i.OwnerType,
+			&i.OwnerUuid,
+			&i.FileKey,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUploadIds = `-- name: ListUploadIds :many
+SELECT uuid FROM upload
+`
+
+func (q *Queries) ListUploadIds(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listUploadIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, err
+		}
+		items = append(items, uuid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT
+    u.id, u.first_name, u.last_name, u.hash, u.email, u.must_change_password, u.deactivated_at, u.avatar_path, u.feed_token,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE
+    (CAST(?1 AS TEXT) IS NULL
+        OR u.first_name LIKE '%' || CAST(?1 AS TEXT) || '%'
+        OR u.last_name LIKE '%' || CAST(?1 AS TEXT) || '%'
+        OR u.email LIKE '%' || CAST(?1 AS TEXT) || '%')
+    AND (CAST(?2 AS BOOLEAN) IS NULL OR (a.user_id IS NOT NULL) = CAST(?2 AS BOOLEAN))
+    AND (CAST(?3 AS BOOLEAN) IS NULL OR (u.deactivated_at IS NOT NULL) = CAST(?3 AS BOOLEAN))
+ORDER BY u.id ASC
+LIMIT ?5 OFFSET ?4
+`
+
+type ListUsersParams struct {
+	Search      sql.NullString `json:"search"`
+	IsAdmin     sql.NullBool   `json:"is_admin"`
+	Deactivated sql.NullBool   `json:"deactivated"`
+	Offset      int64          `json:"offset"`
+	Limit       int64          `json:"limit"`
+}
+
+type ListUsersRow struct {
+	ID                 int64          `json:"id"`
+	FirstName          string         `json:"first_name"`
+	LastName           string         `json:"last_name"`
+	Hash               string         `json:"hash"`
+	Email              string         `json:"email"`
+	MustChangePassword int64          `json:"must_change_password"`
+	DeactivatedAt      sql.NullInt64  `json:"deactivated_at"`
+	AvatarPath         sql.NullString `json:"avatar_path"`
+	FeedToken          sql.NullString `json:"feed_token"`
+	IsAdmin            bool           `json:"is_admin"`
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers,
+		arg.Search,
+		arg.IsAdmin,
+		arg.Deactivated,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Hash,
+			&i.Email,
+			&i.MustChangePassword,
+			&i.DeactivatedAt,
+			&i.AvatarPath,
+			&i.FeedToken,
+			&i.IsAdmin,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVideoProgressOfCourse = `-- name: ListVideoProgressOfCourse :many
+SELECT
+    video_progress.material_uuid, video_progress.user_id, video_progress.position_seconds, video_progress.furthest_seconds, video_progress.duration_seconds, video_progress.completed, video_progress.updated_at,
+    CAST(COALESCE(material.duration_seconds, link_preview.duration, 0) AS INTEGER) AS material_duration
+FROM video_progress
+JOIN material ON material.uuid = video_progress.material_uuid
+LEFT JOIN link_preview ON link_preview.url = material.url
+WHERE material.course_uuid = ? AND video_progress.user_id = ?
+`
+
+type ListVideoProgressOfCourseParams struct {
+	CourseUuid string `json:"course_uuid"`
+	UserID     int64  `json:"user_id"`
+}
+
+type ListVideoProgressOfCourseRow struct {
+	VideoProgress    VideoProgress `json:"video_progress"`
+	MaterialDuration int64         `json:"material_duration"`
+}
+
+func (q *Queries) ListVideoProgressOfCourse(ctx context.Context, arg ListVideoProgressOfCourseParams) ([]ListVideoProgressOfCourseRow, error) {
+	rows, err := q.db.QueryContext(ctx, listVideoProgressOfCourse, arg.CourseUuid, arg.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListVideoProgressOfCourseRow
+	for rows.Next() {
+		var i ListVideoProgressOfCourseRow
+		if err := rows.Scan(
+			&i.VideoProgress.MaterialUuid,
+			&i.VideoProgress.UserID,
+			&i.VideoProgress.PositionSeconds,
+			&i.VideoProgress.FurthestSeconds,
+			&i.VideoProgress.DurationSeconds,
+			&i.VideoProgress.Completed,
+			&i.VideoProgress.UpdatedAt,
+			&i.MaterialDuration,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhooksOfCourse = `-- name: ListWebhooksOfCourse :many
+
+SELECT uuid, course_uuid, url, secret, events, format, active, created_at, updated_at FROM webhook WHERE course_uuid = ? ORDER BY created_at ASC
+`
+
+// * Webhooks
+func (q *Queries) ListWebhooksOfCourse(ctx context.Context, courseUuid string) ([]Webhook, error) {
+	rows, err := q.db.QueryContext(ctx, listWebhooksOfCourse, courseUuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.Uuid,
+			&i.CourseUuid,
+			&i.Url,
+			&i.Secret,
+			&i.Events,
+			&i.Format,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const makeUserAdmin = `-- name: MakeUserAdmin :exec
+
+INSERT OR IGNORE INTO admin (user_id) VALUES (?)
+`
+
+// * Admin
+func (q *Queries) MakeUserAdmin(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, makeUserAdmin, userID)
+	return err
 }
 
-func (q *Queries) ListQuizes(ctx context.Context, courseUuid string) ([]ListQuizesRow, error) {
-	rows, err := q.db.QueryContext(ctx, listQuizes, courseUuid)
+const publishDuePosts = `-- name: PublishDuePosts :many
+UPDATE feed_posts
+SET is_published = 1
+WHERE is_published = 0 AND publish_at <= ?1
+RETURNING id, uuid, course_uuid, type, message, is_edited, is_pinned, is_locked, created_at, updated_at, publish_at, is_published, expires_at, is_expired
+`
+
+// marks the scheduled posts that are due as published, with several instances only one of them gets each post
+func (q *Queries) PublishDuePosts(ctx context.Context, now int64) ([]FeedPost, error) {
+	rows, err := q.db.QueryContext(ctx, publishDuePosts, now)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListQuizesRow
+	var items []FeedPost
 	for rows.Next() {
-		var i ListQuizesRow
+		var i FeedPost
 		if err := rows.Scan(
-			&i.QuizUuid,
+			&i.ID,
+			&i.Uuid,
 			&i.CourseUuid,
-			&i.QuizTitle,
-			&i.QuizAttemptsCount,
-			&i.QuizCreatedAt,
-			&i.QuizUpdatedAt,
-			&i.QuestionUuid,
-			&i.QuestionOrder,
-			&i.QuestionType,
-			&i.QuestionText,
-			&i.QuestionOptions,
-			&i.QuestionCorrectIndices,
-			&i.ModuleOrder,
-			&i.ModuleUuid,
+			&i.Type,
+			&i.Message,
+			&i.IsEdited,
+			&i.IsPinned,
+			&i.IsLocked,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PublishAt,
+			&i.IsPublished,
+			&i.ExpiresAt,
+			&i.IsExpired,
 		); err != nil {
 			return nil, err
 		}
@@ -1521,14 +4192,34 @@ func (q *Queries) ListQuizes(ctx context.Context, courseUuid string) ([]ListQuiz
 	return items, nil
 }
 
-const makeUserAdmin = `-- name: MakeUserAdmin :exec
+const queueLinkPreview = `-- name: QueueLinkPreview :exec
 
-INSERT INTO admin (user_id) VALUES (?)
+INSERT INTO link_preview (url, next_fetch_at) VALUES (?, ?)
+ON CONFLICT (url) DO NOTHING
 `
 
-// * Admin
-func (q *Queries) MakeUserAdmin(ctx context.Context, userID int64) error {
-	_, err := q.db.ExecContext(ctx, makeUserAdmin, userID)
+type QueueLinkPreviewParams struct {
+	Url         string `json:"url"`
+	NextFetchAt int64  `json:"next_fetch_at"`
+}
+
+// * Link previews
+// the preview is fetched by the refresher soon, an existing one is kept
+func (q *Queries) QueueLinkPreview(ctx context.Context, arg QueueLinkPreviewParams) error {
+	_, err := q.db.ExecContext(ctx, queueLinkPreview, arg.Url, arg.NextFetchAt)
+	return err
+}
+
+const queueMissingLinkPreviews = `-- name: QueueMissingLinkPreviews :exec
+INSERT INTO link_preview (url, next_fetch_at)
+SELECT DISTINCT material.url, CAST(?1 AS INTEGER) FROM material
+WHERE material.type IN ('url', 'video') AND material.url NOT IN (SELECT url FROM link_preview)
+ON CONFLICT (url) DO NOTHING
+`
+
+// url and video materials from before the previews and ones whose preview was removed
+func (q *Queries) QueueMissingLinkPreviews(ctx context.Context, nextFetchAt int64) error {
+	_, err := q.db.ExecContext(ctx, queueMissingLinkPreviews, nextFetchAt)
 	return err
 }
 
@@ -1574,6 +4265,338 @@ func (q *Queries) RemoveQuizFromModule(ctx context.Context, arg RemoveQuizFromMo
 	return err
 }
 
+const removeReaction = `-- name: RemoveReaction :exec
+DELETE FROM feed_reaction WHERE post_uuid = ? AND user_id = ? AND emoji = ?
+`
+
+type RemoveReactionParams struct {
+	PostUuid string `json:"post_uuid"`
+	UserID   int64  `json:"user_id"`
+	Emoji    string `json:"emoji"`
+}
+
+func (q *Queries) RemoveReaction(ctx context.Context, arg RemoveReactionParams) error {
+	_, err := q.db.ExecContext(ctx, removeReaction, arg.PostUuid, arg.UserID, arg.Emoji)
+	return err
+}
+
+const removeUserAdmin = `-- name: RemoveUserAdmin :exec
+DELETE FROM admin WHERE user_id = ?
+`
+
+func (q *Queries) RemoveUserAdmin(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, removeUserAdmin, userID)
+	return err
+}
+
+const saveLinkPreview = `-- name: SaveLinkPreview :one
+UPDATE link_preview
+SET title = ?,
+    description = ?,
+    image_url = ?,
+    favicon_url = ?,
+    duration = ?,
+    fetched_at = ?,
+    next_fetch_at = ?,
+    failures = 0,
+    error = NULL
+WHERE url = ?
+RETURNING url, title, description, image_url, favicon_url, duration, fetched_at, next_fetch_at, failures, error
+`
+
+type SaveLinkPreviewParams struct {
+	Title       sql.NullString `json:"title"`
+	Description sql.NullString `json:"description"`
+	ImageUrl    sql.NullString `json:"image_url"`
+	FaviconUrl  sql.NullString `json:"favicon_url"`
+	Duration    sql.NullInt64  `json:"duration"`
+	FetchedAt   sql.NullInt64  `json:"fetched_at"`
+	NextFetchAt int64          `json:"next_fetch_at"`
+	Url         string         `json:"url"`
+}
+
+func (q *Queries) SaveLinkPreview(ctx context.Context, arg SaveLinkPreviewParams) (LinkPreview, error) {
+	row := q.db.QueryRowContext(ctx, saveLinkPreview,
+		arg.Title,
+		arg.Description,
+		arg.ImageUrl,
+		arg.FaviconUrl,
+		arg.Duration,
+		arg.FetchedAt,
+		arg.NextFetchAt,
+		arg.Url,
+	)
+	var i LinkPreview
+	err := row.Scan(
+		&i.Url,
+		&i.Title,
+		&i.Description,
+		&i.ImageUrl,
+		&i.FaviconUrl,
+		&i.Duration,
+		&i.FetchedAt,
+		&i.NextFetchAt,
+		&i.Failures,
+		&i.Error,
+	)
+	return i, err
+}
+
+const saveVideoProgress = `-- name: SaveVideoProgress :one
+INSERT INTO video_progress (
+    material_uuid, user_id, position_seconds, furthest_seconds, duration_seconds, completed, updated_at
+) VALUES (
+    ?1, ?2, ?3, ?3,
+    ?4, ?5, ?6
+)
+ON CONFLICT (material_uuid, user_id) DO UPDATE SET
+    position_seconds = excluded.position_seconds,
+    furthest_seconds = MAX(video_progress.furthest_seconds, excluded.furthest_seconds),
+    duration_seconds = COALESCE(excluded.duration_seconds, video_progress.duration_seconds),
+    completed = MAX(video_progress.completed, excluded.completed),
+    updated_at = excluded.updated_at
+RETURNING material_uuid, user_id, position_seconds, furthest_seconds, duration_seconds, completed, updated_at
+`
+
+type SaveVideoProgressParams struct {
+	MaterialUuid    string        `json:"material_uuid"`
+	UserID          int64         `json:"user_id"`
+	PositionSeconds int64         `json:"position_seconds"`
+	DurationSeconds sql.NullInt64 `json:"duration_seconds"`
+	Completed       bool          `json:"completed"`
+	UpdatedAt       int64         `json:"updated_at"`
+}
+
+// the furthest position only grows and a completed video stays completed
+func (q *Queries) SaveVideoProgress(ctx context.Context, arg SaveVideoProgressParams) (VideoProgress, error) {
+	row := q.db.QueryRowContext(ctx, saveVideoProgress,
+		arg.MaterialUuid,
+		arg.UserID,
+		arg.PositionSeconds,
+		arg.DurationSeconds,
+		arg.Completed,
+		arg.UpdatedAt,
+	)
+	var i VideoProgress
+	err := row.Scan(
+		&i.MaterialUuid,
+		&i.UserID,
+		&i.PositionSeconds,
+		&i.FurthestSeconds,
+		&i.DurationSeconds,
+		&i.Completed,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const scheduleCourseState = `-- name: ScheduleCourseState :exec
+UPDATE course
+SET scheduled_state = ?, scheduled_at = ?, scheduled_module_uuid = ?, scheduled_module_message = ?
+WHERE uuid = ?
+`
+
+type ScheduleCourseStateParams struct {
+	ScheduledState         sql.NullString `json:"scheduled_state"`
+	ScheduledAt            sql.NullInt64  `json:"scheduled_at"`
+	ScheduledModuleUuid    sql.NullString `json:"scheduled_module_uuid"`
+	ScheduledModuleMessage sql.NullString `json:"scheduled_module_message"`
+	Uuid                   string         `json:"uuid"`
+}
+
+func (q *Queries) ScheduleCourseState(ctx context.Context, arg ScheduleCourseStateParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleCourseState,
+		arg.ScheduledState,
+		arg.ScheduledAt,
+		arg.ScheduledModuleUuid,
+		arg.ScheduledModuleMessage,
+		arg.Uuid,
+	)
+	return err
+}
+
+const scheduleModuleState = `-- name: ScheduleModuleState :exec
+UPDATE module SET scheduled_state = ?, scheduled_at = ? WHERE uuid = ?
+`
+
+type ScheduleModuleStateParams struct {
+	ScheduledState sql.NullString `json:"scheduled_state"`
+	ScheduledAt    sql.NullInt64  `json:"scheduled_at"`
+	Uuid           string         `json:"uuid"`
+}
+
+func (q *Queries) ScheduleModuleState(ctx context.Context, arg ScheduleModuleStateParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleModuleState, arg.ScheduledState, arg.ScheduledAt, arg.Uuid)
+	return err
+}
+
+const setCommentHidden = `-- name: SetCommentHidden :exec
+UPDATE feed_comment SET is_hidden = ? WHERE uuid = ?
+`
+
+type SetCommentHiddenParams struct {
+	IsHidden bool   `json:"is_hidden"`
+	Uuid     string `json:"uuid"`
+}
+
+func (q *Queries) SetCommentHidden(ctx context.Context, arg SetCommentHiddenParams) error {
+	_, err := q.db.ExecContext(ctx, setCommentHidden, arg.IsHidden, arg.Uuid)
+	return err
+}
+
+const setCourseStorageQuota = `-- name: SetCourseStorageQuota :execrows
+UPDATE course SET storage_quota = ?, updated_at = ? WHERE uuid = ?
+`
+
+type SetCourseStorageQuotaParams struct {
+	StorageQuota sql.NullInt64 `json:"storage_quota"`
+	UpdatedAt    int64         `json:"updated_at"`
+	Uuid         string        `json:"uuid"`
+}
+
+func (q *Queries) SetCourseStorageQuota(ctx context.Context, arg SetCourseStorageQuotaParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setCourseStorageQuota, arg.StorageQuota, arg.UpdatedAt, arg.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setCourseUploadLimit = `-- name: SetCourseUploadLimit :execrows
+UPDATE course SET max_upload_size = ?, updated_at = ? WHERE uuid = ?
+`
+
+type SetCourseUploadLimitParams struct {
+	MaxUploadSize sql.NullInt64 `json:"max_upload_size"`
+	UpdatedAt     int64         `json:"updated_at"`
+	Uuid          string        `json:"uuid"`
+}
+
+func (q *Queries) SetCourseUploadLimit(ctx context.Context, arg SetCourseUploadLimitParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setCourseUploadLimit, arg.MaxUploadSize, arg.UpdatedAt, arg.Uuid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setQuizDeadline = `-- name: SetQuizDeadline :exec
+UPDATE quiz SET deadline_at = ? WHERE uuid = ?
+`
+
+type SetQuizDeadlineParams struct {
+	DeadlineAt sql.NullInt64 `json:"deadline_at"`
+	Uuid       string        `json:"uuid"`
+}
+
+func (q *Queries) SetQuizDeadline(ctx context.Context, arg SetQuizDeadlineParams) error {
+	_, err := q.db.ExecContext(ctx, setQuizDeadline, arg.DeadlineAt, arg.Uuid)
+	return err
+}
+
+const setUserAvatar = `-- name: SetUserAvatar :exec
+UPDATE user SET avatar_path = ?1 WHERE id = ?2
+`
+
+type SetUserAvatarParams struct {
+	AvatarPath sql.NullString `json:"avatar_path"`
+	ID         int64          `json:"id"`
+}
+
+func (q *Queries) SetUserAvatar(ctx context.Context, arg SetUserAvatarParams) error {
+	_, err := q.db.ExecContext(ctx, setUserAvatar, arg.AvatarPath, arg.ID)
+	return err
+}
+
+const setUserDeactivated = `-- name: SetUserDeactivated :execresult
+UPDATE user
+SET deactivated_at = ?1
+WHERE id = ?2
+`
+
+type SetUserDeactivatedParams struct {
+	DeactivatedAt sql.NullInt64 `json:"deactivated_at"`
+	ID            int64         `json:"id"`
+}
+
+func (q *Queries) SetUserDeactivated(ctx context.Context, arg SetUserDeactivatedParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, setUserDeactivated, arg.DeactivatedAt, arg.ID)
+}
+
+const setUserEmail = `-- name: SetUserEmail :exec
+UPDATE user SET email = ? WHERE id = ?
+`
+
+type SetUserEmailParams struct {
+	Email string `json:"email"`
+	ID    int64  `json:"id"`
+}
+
+func (q *Queries) SetUserEmail(ctx context.Context, arg SetUserEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setUserEmail, arg.Email, arg.ID)
+	return err
+}
+
+const setUserFeedToken = `-- name: SetUserFeedToken :exec
+UPDATE user SET feed_token = ? WHERE id = ?
+`
+
+type SetUserFeedTokenParams struct {
+	FeedToken sql.NullString `json:"feed_token"`
+	ID        int64          `json:"id"`
+}
+
+func (q *Queries) SetUserFeedToken(ctx context.Context, arg SetUserFeedTokenParams) error {
+	_, err := q.db.ExecContext(ctx, setUserFeedToken, arg.FeedToken, arg.ID)
+	return err
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE user
+SET
+    hash = ?,
+    must_change_password = ?
+WHERE id = ?
+`
+
+type SetUserPasswordParams struct {
+	Hash               string `json:"hash"`
+	MustChangePassword int64  `json:"must_change_password"`
+	ID                 int64  `json:"id"`
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.Hash, arg.MustChangePassword, arg.ID)
+	return err
+}
+
+const setWebhookSecret = `-- name: SetWebhookSecret :one
+UPDATE webhook SET secret = ?, updated_at = ? WHERE uuid = ? RETURNING uuid, course_uuid, url, secret, events, format, active, created_at, updated_at
+`
+
+type SetWebhookSecretParams struct {
+	Secret    string `json:"secret"`
+	UpdatedAt int64  `json:"updated_at"`
+	Uuid      string `json:"uuid"`
+}
+
+func (q *Queries) SetWebhookSecret(ctx context.Context, arg SetWebhookSecretParams) (Webhook, error) {
+	row := q.db.QueryRowContext(ctx, setWebhookSecret, arg.Secret, arg.UpdatedAt, arg.Uuid)
+	var i Webhook
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Format,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const updateCourse = `-- name: UpdateCourse :one
 UPDATE course
 SET
@@ -1581,7 +4604,7 @@ SET
     name = ?,
     description = ?,
     updated_at = ?
-WHERE course.uuid = ? RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state
+WHERE course.uuid = ? RETURNING uuid, name, description, created_at, updated_at, highlighted_module_uuid, highlighted_module_message, archived, state, scheduled_state, scheduled_at, scheduled_module_uuid, scheduled_module_message, max_upload_size, storage_quota
 `
 
 type UpdateCourseParams struct {
@@ -1611,6 +4634,12 @@ func (q *Queries) UpdateCourse(ctx context.Context, arg UpdateCourseParams) (Cou
 		&i.HighlightedModuleMessage,
 		&i.Archived,
 		&i.State,
+		&i.ScheduledState,
+		&i.ScheduledAt,
+		&i.ScheduledModuleUuid,
+		&i.ScheduledModuleMessage,
+		&i.MaxUploadSize,
+		&i.StorageQuota,
 	)
 	return i, err
 }
@@ -1650,7 +4679,7 @@ SET
     name = ?,
     description = ?,
     url = ?
-WHERE material.uuid = ? RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, created_at, updated_at
+WHERE material.uuid = ? RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, video_provider, video_id, duration_seconds, created_at, updated_at
 `
 
 type UpdateMaterialParams struct {
@@ -1679,6 +4708,9 @@ func (q *Queries) UpdateMaterial(ctx context.Context, arg UpdateMaterialParams)
 		&i.FaviconUrl,
 		&i.MimeType,
 		&i.ByteSize,
+		&i.VideoProvider,
+		&i.VideoID,
+		&i.DurationSeconds,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -1694,19 +4726,29 @@ SET
     favicon_url = COALESCE(?4, favicon_url),
     byte_size   = COALESCE(?5, byte_size),
     mime_type   = COALESCE(?6, mime_type),
-    updated_at  = ?7
-WHERE uuid = ?8 RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, created_at, updated_at
+    video_provider = COALESCE(?7, video_provider),
+    video_id       = CASE WHEN ?7 IS NULL THEN video_id ELSE ?8 END,
+    -- 0 clears the duration
+    duration_seconds = CASE
+        WHEN CAST(?9 AS INTEGER) IS NULL THEN duration_seconds
+        ELSE NULLIF(CAST(?9 AS INTEGER), 0)
+    END,
+    updated_at  = ?10
+WHERE uuid = ?11 RETURNING uuid, course_uuid, name, description, url, type, times_accessed, favicon_url, mime_type, byte_size, video_provider, video_id, duration_seconds, created_at, updated_at
 `
 
 type UpdateMaterialPartialParams struct {
-	Name        sql.NullString `json:"name"`
-	Description sql.NullString `json:"description"`
-	Url         sql.NullString `json:"url"`
-	FaviconUrl  sql.NullString `json:"favicon_url"`
-	ByteSize    sql.NullInt64  `json:"byte_size"`
-	MimeType    sql.NullString `json:"mime_type"`
-	UpdatedAt   int64          `json:"updated_at"`
-	Uuid        string         `json:"uuid"`
+	Name            sql.NullString `json:"name"`
+	Description     sql.NullString `json:"description"`
+	Url             sql.NullString `json:"url"`
+	FaviconUrl      sql.NullString `json:"favicon_url"`
+	ByteSize        sql.NullInt64  `json:"byte_size"`
+	MimeType        sql.NullString `json:"mime_type"`
+	VideoProvider   sql.NullString `json:"video_provider"`
+	VideoID         sql.NullString `json:"video_id"`
+	DurationSeconds sql.NullInt64  `json:"duration_seconds"`
+	UpdatedAt       int64          `json:"updated_at"`
+	Uuid            string         `json:"uuid"`
 }
 
 func (q *Queries) UpdateMaterialPartial(ctx context.Context, arg UpdateMaterialPartialParams) (Material, error) {
@@ -1717,6 +4759,9 @@ func (q *Queries) UpdateMaterialPartial(ctx context.Context, arg UpdateMaterialP
 		arg.FaviconUrl,
 		arg.ByteSize,
 		arg.MimeType,
+		arg.VideoProvider,
+		arg.VideoID,
+		arg.DurationSeconds,
 		arg.UpdatedAt,
 		arg.Uuid,
 	)
@@ -1732,6 +4777,9 @@ func (q *Queries) UpdateMaterialPartial(ctx context.Context, arg UpdateMaterialP
 		&i.FaviconUrl,
 		&i.MimeType,
 		&i.ByteSize,
+		&i.VideoProvider,
+		&i.VideoID,
+		&i.DurationSeconds,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -1746,7 +4794,7 @@ SET
     state = ?
 WHERE
     uuid = ? and course_uuid = ?
-RETURNING uuid, course_uuid, name, description, state, module_order, created_at, updated_at
+RETURNING uuid, course_uuid, name, description, state, module_order, scheduled_state, scheduled_at, created_at, updated_at
 `
 
 type UpdateModuleParams struct {
@@ -1773,6 +4821,8 @@ func (q *Queries) UpdateModule(ctx context.Context, arg UpdateModuleParams) (Mod
 		&i.Description,
 		&i.State,
 		&i.ModuleOrder,
+		&i.ScheduledState,
+		&i.ScheduledAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -1781,28 +4831,54 @@ func (q *Queries) UpdateModule(ctx context.Context, arg UpdateModuleParams) (Mod
 
 const updatePost = `-- name: UpdatePost :one
 UPDATE feed_posts
-SET message = ?, is_edited = 1, updated_at = ?
+SET message = ?, is_edited = ?, is_pinned = ?, is_locked = ?, updated_at = ?,
+    publish_at = ?, is_published = ?, expires_at = ?, is_expired = ?
 WHERE uuid = ?
-RETURNING uuid, course_uuid, type, message, is_edited, created_at, updated_at
+RETURNING id, uuid, course_uuid, type, message, is_edited, is_pinned, is_locked, created_at, updated_at, publish_at, is_published, expires_at, is_expired
 `
 
 type UpdatePostParams struct {
-	Message   string `json:"message"`
-	UpdatedAt int64  `json:"updated_at"`
-	Uuid      string `json:"uuid"`
+	Message     string        `json:"message"`
+	IsEdited    bool          `json:"is_edited"`
+	IsPinned    bool          `json:"is_pinned"`
+	IsLocked    bool          `json:"is_locked"`
+	UpdatedAt   int64         `json:"updated_at"`
+	PublishAt   int64         `json:"publish_at"`
+	IsPublished bool          `json:"is_published"`
+	ExpiresAt   sql.NullInt64 `json:"expires_at"`
+	IsExpired   bool          `json:"is_expired"`
+	Uuid        string        `json:"uuid"`
 }
 
 func (q *Queries) UpdatePost(ctx context.Context, arg UpdatePostParams) (FeedPost, error) {
-	row := q.db.QueryRowContext(ctx, updatePost, arg.Message, arg.UpdatedAt, arg.Uuid)
+	row := q.db.QueryRowContext(ctx, updatePost,
+		arg.Message,
+		arg.IsEdited,
+		arg.IsPinned,
+		arg.IsLocked,
+		arg.UpdatedAt,
+		arg.PublishAt,
+		arg.IsPublished,
+		arg.ExpiresAt,
+		arg.IsExpired,
+		arg.Uuid,
+	)
 	var i FeedPost
 	err := row.Scan(
+		&i.ID,
 		&i.Uuid,
 		&i.CourseUuid,
 		&i.Type,
 		&i.Message,
 		&i.IsEdited,
+		&i.IsPinned,
+		&i.IsLocked,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PublishAt,
+		&i.IsPublished,
+		&i.ExpiresAt,
+		&i.IsExpired,
 	)
 	return i, err
 }
@@ -1844,7 +4920,7 @@ SET
     attempts_count =    COALESCE(?2, attempts_count),
     updated_at =        COALESCE(?3, updated_at)
 WHERE uuid = ?4
-RETURNING uuid, course_uuid, title, attempts_count, created_at, updated_at
+RETURNING uuid, course_uuid, title, attempts_count, deadline_at, created_at, updated_at
 `
 
 type UpdateQuizParams struct {
@@ -1867,6 +4943,100 @@ func (q *Queries) UpdateQuiz(ctx context.Context, arg UpdateQuizParams) (Quiz, e
 		&i.CourseUuid,
 		&i.Title,
 		&i.AttemptsCount,
+		&i.DeadlineAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateUserHash = `-- name: UpdateUserHash :exec
+UPDATE user SET hash = ? WHERE id = ?
+`
+
+type UpdateUserHashParams struct {
+	Hash string `json:"hash"`
+	ID   int64  `json:"id"`
+}
+
+// replaces the hash only, used to upgrade old hashes on login
+func (q *Queries) UpdateUserHash(ctx context.Context, arg UpdateUserHashParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserHash, arg.Hash, arg.ID)
+	return err
+}
+
+const updateUserPartial = `-- name: UpdateUserPartial :one
+UPDATE user
+SET
+    first_name = COALESCE(?1, first_name),
+    last_name  = COALESCE(?2, last_name),
+    email      = COALESCE(?3, email)
+WHERE id = ?4 RETURNING id, first_name, last_name, hash, email, must_change_password, deactivated_at, avatar_path, feed_token
+`
+
+type UpdateUserPartialParams struct {
+	FirstName sql.NullString `json:"first_name"`
+	LastName  sql.NullString `json:"last_name"`
+	Email     sql.NullString `json:"email"`
+	ID        int64          `json:"id"`
+}
+
+func (q *Queries) UpdateUserPartial(ctx context.Context, arg UpdateUserPartialParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserPartial,
+		arg.FirstName,
+		arg.LastName,
+		arg.Email,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.FirstName,
+		&i.LastName,
+		&i.Hash,
+		&i.Email,
+		&i.MustChangePassword,
+		&i.DeactivatedAt,
+		&i.AvatarPath,
+		&i.FeedToken,
+	)
+	return i, err
+}
+
+const updateWebhook = `-- name: UpdateWebhook :one
+UPDATE webhook
+SET url = ?, events = ?, format = ?, active = ?, updated_at = ?
+WHERE uuid = ?
+RETURNING uuid, course_uuid, url, secret, events, format, active, created_at, updated_at
+`
+
+type UpdateWebhookParams struct {
+	Url       string `json:"url"`
+	Events    string `json:"events"`
+	Format    string `json:"format"`
+	Active    int64  `json:"active"`
+	UpdatedAt int64  `json:"updated_at"`
+	Uuid      string `json:"uuid"`
+}
+
+func (q *Queries) UpdateWebhook(ctx context.Context, arg UpdateWebhookParams) (Webhook, error) {
+	row := q.db.QueryRowContext(ctx, updateWebhook,
+		arg.Url,
+		arg.Events,
+		arg.Format,
+		arg.Active,
+		arg.UpdatedAt,
+		arg.Uuid,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.Uuid,
+		&i.CourseUuid,
+		&i.Url,
+		&i.Secret,
+		&i.Events,
+		&i.Format,
+		&i.Active,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
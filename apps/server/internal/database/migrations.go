@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+//* migrations of dbs created by older versions - schema.sql only creates what's missing (IF NOT EXISTS), it can't add
+// columns to the tables that already exist, so every change of an existing table is a step here. The number of
+// applied steps is kept in PRAGMA user_version, a new db gets all the columns from schema.sql and starts at the last step
+
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+type migration struct {
+	// added only when the table exists and doesn't have the column yet, so the steps can run on dbs
+	// that already got some of the columns from schema.sql
+	columns []column
+	// run after the columns were added
+	sql string
+}
+
+// append only, the index of the step is its version
+var migrations = []migration{
+	// users, course and module schedules, upload limits, video materials, quiz deadlines and locked posts
+	{
+		columns: []column{
+			{"user", "must_change_password", "INTEGER NOT NULL DEFAULT 0"},
+			{"user", "deactivated_at", "INTEGER"},
+			{"user", "avatar_path", "TEXT"},
+			// columns added by ALTER TABLE can't be UNIQUE, the index below does the same
+			{"user", "feed_token", "TEXT"},
+
+			{"course", "scheduled_state", "TEXT"},
+			{"course", "scheduled_at", "INTEGER"},
+			{"course", "max_upload_size", "INTEGER"},
+			{"course", "storage_quota", "INTEGER"},
+
+			{"module", "scheduled_state", "TEXT"},
+			{"module", "scheduled_at", "INTEGER"},
+
+			{"material", "video_provider", "TEXT"},
+			{"material", "video_id", "TEXT"},
+			{"material", "duration_seconds", "INTEGER"},
+
+			{"quiz", "deadline_at", "INTEGER"},
+
+			{"feed_posts", "is_locked", "BOOLEAN NOT NULL DEFAULT 0"},
+
+			{"link_preview", "duration", "INTEGER"},
+		},
+		sql: `CREATE UNIQUE INDEX IF NOT EXISTS user_feed_token ON user (feed_token);`,
+	},
+	// scheduled, pinned and expiring feed posts, the posts from before were published when they were created
+	{
+		columns: []column{
+			{"feed_posts", "is_pinned", "BOOLEAN NOT NULL DEFAULT 0"},
+			{"feed_posts", "publish_at", "INTEGER NOT NULL DEFAULT 0"},
+			{"feed_posts", "is_published", "BOOLEAN NOT NULL DEFAULT 1"},
+			{"feed_posts", "expires_at", "INTEGER"},
+			{"feed_posts", "is_expired", "BOOLEAN NOT NULL DEFAULT 0"},
+		},
+		sql: `UPDATE feed_posts SET publish_at = created_at WHERE publish_at = 0;`,
+	},
+	// feed_posts get an INTEGER PRIMARY KEY for the search index - sqlite can't change the primary key
+	// of a table, so it's rebuilt. The old index and its triggers are dropped, schema.sql creates them
+	// again and the index is filled from the posts in Initialize
+	{
+		sql: `
+DROP TRIGGER IF EXISTS feed_posts_fts_insert;
+DROP TRIGGER IF EXISTS feed_posts_fts_delete;
+DROP TRIGGER IF EXISTS feed_posts_fts_update;
+DROP TABLE IF EXISTS feed_posts_fts;
+
+CREATE TABLE feed_posts_new (
+    id INTEGER PRIMARY KEY,
+    uuid TEXT NOT NULL UNIQUE,
+    course_uuid TEXT NOT NULL,
+
+    type TEXT NOT NULL,
+    message TEXT NOT NULL,
+    is_edited BOOLEAN NOT NULL DEFAULT 0,
+    is_pinned BOOLEAN NOT NULL DEFAULT 0,
+    is_locked BOOLEAN NOT NULL DEFAULT 0,
+
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+
+    publish_at INTEGER NOT NULL,
+    is_published BOOLEAN NOT NULL DEFAULT 1,
+
+    expires_at INTEGER,
+    is_expired BOOLEAN NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (course_uuid) REFERENCES course(uuid) ON DELETE CASCADE
+);
+
+INSERT INTO feed_posts_new (
+    uuid, course_uuid, type, message, is_edited, is_pinned, is_locked,
+    created_at, updated_at, publish_at, is_published, expires_at, is_expired
+)
+SELECT
+    uuid, course_uuid, type, message, is_edited, is_pinned, is_locked,
+    created_at, updated_at, publish_at, is_published, expires_at, is_expired
+FROM feed_posts
+ORDER BY created_at, rowid;
+
+DROP TABLE feed_posts;
+ALTER TABLE feed_posts_new RENAME TO feed_posts;`,
+	},
+	// the highlight of a planned course state change, so the schedule can be set again after a restart
+	{
+		columns: []column{
+			{"course", "scheduled_module_uuid", "TEXT"},
+			{"course", "scheduled_module_message", "TEXT"},
+		},
+	},
+}
+
+// a connection or a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func tableExists(ctx context.Context, q queryer, table string) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = ?)", table).Scan(&exists)
+	return exists, err
+}
+
+func hasColumn(ctx context.Context, q queryer, table string, name string) (bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, name).Scan(&exists)
+	return exists, err
+}
+
+func (m migration) apply(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range m.columns {
+		exists, err := tableExists(ctx, tx, c.table)
+		if err != nil {
+			return err
+		}
+		// tables created after the step get the column from schema.sql
+		if !exists {
+			continue
+		}
+
+		exists, err = hasColumn(ctx, tx, c.table, c.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, c.table, c.name, c.definition))
+		if err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+
+	if m.sql != "" {
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate brings the db to the last step, the tables of schema.sql are created afterwards,
+// each step runs in its own transaction with the foreign keys off so the steps can rebuild tables
+func migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	// a new db has everything from schema.sql
+	existing, err := tableExists(ctx, conn, "user")
+	if err != nil {
+		return err
+	}
+	if !existing {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
+		return err
+	}
+
+	if version >= len(migrations) {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	for ; version < len(migrations); version++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		err = migrations[version].apply(ctx, tx)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		fmt.Println("migrated db to version", version+1)
+	}
+	return nil
+}
@@ -3,13 +3,14 @@
 -- name: GetUser :one
 SELECT 
     u.*, 
-    (a.user_id IS NOT NULL) AS is_admin
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
 FROM user u
 LEFT JOIN admin a ON u.id = a.user_id
 WHERE u.id = ?;
 
+-- emails are compared case-insensitively, older accounts may have been registered with upper case letters
 -- name: GetUserByEmail :one
-SELECT * FROM user WHERE user.email = ?;
+SELECT * FROM user WHERE lower(user.email) = lower(?);
 
 -- name: GetUserBySessionToken :one
 SELECT 
@@ -18,15 +19,142 @@ SELECT
 FROM user u
 JOIN session s ON u.id = s.user_id
 LEFT JOIN admin a ON u.id = a.user_id
-WHERE s.token = ?;
+WHERE s.token = ? AND u.deactivated_at IS NULL;
+
+-- name: GetUserByFeedToken :one
+SELECT
+    u.*,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE u.feed_token = ? AND u.deactivated_at IS NULL;
+
+-- name: GetFeedTokenOfUser :one
+SELECT feed_token FROM user WHERE id = ?;
+
+-- name: SetUserFeedToken :exec
+UPDATE user SET feed_token = ? WHERE id = ?;
 
 -- name: CreateUser :one
 INSERT INTO user (first_name, last_name, hash, email) VALUES (?, ?, ?, ?) RETURNING *;
 
+-- name: ListUsers :many
+SELECT
+    u.*,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE
+    (CAST(sqlc.narg(search) AS TEXT) IS NULL
+        OR u.first_name LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%'
+        OR u.last_name LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%'
+        OR u.email LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%')
+    AND (CAST(sqlc.narg(is_admin) AS BOOLEAN) IS NULL OR (a.user_id IS NOT NULL) = CAST(sqlc.narg(is_admin) AS BOOLEAN))
+    AND (CAST(sqlc.narg(deactivated) AS BOOLEAN) IS NULL OR (u.deactivated_at IS NOT NULL) = CAST(sqlc.narg(deactivated) AS BOOLEAN))
+ORDER BY u.id ASC
+LIMIT sqlc.arg(limit) OFFSET sqlc.arg(offset);
+
+-- name: CountUsers :one
+SELECT COUNT(*)
+FROM user u
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE
+    (CAST(sqlc.narg(search) AS TEXT) IS NULL
+        OR u.first_name LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%'
+        OR u.last_name LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%'
+        OR u.email LIKE '%' || CAST(sqlc.narg(search) AS TEXT) || '%')
+    AND (CAST(sqlc.narg(is_admin) AS BOOLEAN) IS NULL OR (a.user_id IS NOT NULL) = CAST(sqlc.narg(is_admin) AS BOOLEAN))
+    AND (CAST(sqlc.narg(deactivated) AS BOOLEAN) IS NULL OR (u.deactivated_at IS NOT NULL) = CAST(sqlc.narg(deactivated) AS BOOLEAN));
+
+-- name: UpdateUserPartial :one
+UPDATE user
+SET
+    first_name = COALESCE(sqlc.narg(first_name), first_name),
+    last_name  = COALESCE(sqlc.narg(last_name), last_name),
+    email      = COALESCE(sqlc.narg(email), email)
+WHERE id = sqlc.arg(id) RETURNING *;
+
+-- name: SetUserPassword :exec
+UPDATE user
+SET
+    hash = ?,
+    must_change_password = ?
+WHERE id = ?;
+
+-- replaces the hash only, used to upgrade old hashes on login
+-- name: UpdateUserHash :exec
+UPDATE user SET hash = ? WHERE id = ?;
+
+-- name: SetUserDeactivated :execresult
+UPDATE user
+SET deactivated_at = sqlc.narg(deactivated_at)
+WHERE id = sqlc.arg(id);
+
+-- name: SetUserAvatar :exec
+UPDATE user SET avatar_path = sqlc.narg(avatar_path) WHERE id = sqlc.arg(id);
+
+-- name: DeleteUser :execresult
+DELETE FROM user WHERE id = ?;
+
 -- * Admin
 
 -- name: MakeUserAdmin :exec
-INSERT INTO admin (user_id) VALUES (?);
+INSERT OR IGNORE INTO admin (user_id) VALUES (?);
+
+-- name: CountAdmins :one
+SELECT COUNT(*) FROM admin;
+
+-- name: RemoveUserAdmin :exec
+DELETE FROM admin WHERE user_id = ?;
+
+--* Email change
+
+-- name: CreateEmailChange :one
+INSERT OR REPLACE INTO email_change (
+    token, user_id, new_email, created_at, expires_at
+) VALUES (
+    ?, ?, ?, ?, ?
+) RETURNING *;
+
+-- name: GetEmailChangeOfUser :one
+SELECT * FROM email_change WHERE user_id = ? AND expires_at > ?;
+
+-- name: ConsumeEmailChange :one
+DELETE FROM email_change WHERE token = ? RETURNING *;
+
+-- name: SetUserEmail :exec
+UPDATE user SET email = ? WHERE id = ?;
+
+--* User Identity (OIDC)
+
+-- name: GetUserByIdentity :one
+SELECT
+    u.*,
+    CAST(a.user_id IS NOT NULL AS BOOLEAN) AS is_admin
+FROM user_identity ui
+JOIN user u ON u.id = ui.user_id
+LEFT JOIN admin a ON u.id = a.user_id
+WHERE ui.issuer = ? AND ui.subject = ?;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identity (
+    user_id, issuer, subject, email, created_at
+) VALUES (
+    ?, ?, ?, ?, ?
+) RETURNING *;
+
+-- name: CreateOidcLoginState :exec
+INSERT INTO oidc_login_state (
+    state, code_verifier, nonce, redirect_to, created_at, expires_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?
+);
+
+-- name: ConsumeOidcLoginState :one
+DELETE FROM oidc_login_state WHERE state = ? RETURNING *;
+
+-- name: DeleteExpiredOidcLoginStates :exec
+DELETE FROM oidc_login_state WHERE expires_at <= ?;
 
 --* Session
 
@@ -40,6 +168,9 @@ INSERT INTO session (
 -- name: InvalidateSession :exec
 DELETE FROM session WHERE token = ?;
 
+-- name: InvalidateSessionsOfUser :exec
+DELETE FROM session WHERE user_id = ?;
+
 --* Course
 
 -- name: CreateCourse :one
@@ -79,6 +210,21 @@ SET
     highlighted_module_uuid = COALESCE(sqlc.narg(module_uuid), highlighted_module_uuid)
 WHERE uuid = sqlc.arg(uuid) RETURNING *;
 
+-- name: ScheduleCourseState :exec
+UPDATE course
+SET scheduled_state = ?, scheduled_at = ?, scheduled_module_uuid = ?, scheduled_module_message = ?
+WHERE uuid = ?;
+
+-- only the schedule that is carried out is cleared, a newer one stays -
+-- no affected row means the schedule was replaced or another instance carried it out
+-- name: ClearCourseSchedule :execrows
+UPDATE course
+SET scheduled_state = NULL, scheduled_at = NULL, scheduled_module_uuid = NULL, scheduled_module_message = NULL
+WHERE uuid = ? AND scheduled_at = ?;
+
+-- name: ListScheduledCourses :many
+SELECT * FROM course WHERE scheduled_at IS NOT NULL;
+
 -- name: ArchiveCourse :exec
 UPDATE course
 SET archived = 1
@@ -118,6 +264,15 @@ SET
 WHERE uuid = sqlc.arg(uuid)
 RETURNING *;
 
+-- name: ScheduleModuleState :exec
+UPDATE module SET scheduled_state = ?, scheduled_at = ? WHERE uuid = ?;
+
+-- name: ClearModuleSchedule :execrows
+UPDATE module SET scheduled_state = NULL, scheduled_at = NULL WHERE uuid = ? AND scheduled_at = ?;
+
+-- name: ListScheduledModules :many
+SELECT * FROM module WHERE scheduled_at IS NOT NULL;
+
 -- name: CheckModuleExists :one
 SELECT EXISTS (SELECT 1 FROM module WHERE uuid = ?) AS module_exists;
 
@@ -276,9 +431,10 @@ ORDER BY "order" ASC;
 
 -- name: CreateMaterial :one
 INSERT INTO material (
-    uuid, course_uuid, name, description, url, type, favicon_url, byte_size, mime_type, created_at, updated_at
+    uuid, course_uuid, name, description, url, type, favicon_url, byte_size, mime_type,
+    video_provider, video_id, duration_seconds, created_at, updated_at
 ) VALUES (
-    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 ) RETURNING *;
 
 -- name: UpdateMaterial :one
@@ -297,12 +453,23 @@ SELECT * FROM material WHERE material.uuid = ?;
 
 -- name: ListAllMaterialsOfCourse :many
 SELECT
-    *
+    material.*,
+    material_to_module.*,
     -- material_to_module."order"
+    material_version.thumbnail_key,
+    material_version.preview_text,
+    link_preview.title AS page_title,
+    link_preview.description AS page_description,
+    link_preview.image_url AS page_image_url,
+    link_preview.favicon_url AS page_favicon_url,
+    link_preview.duration AS page_duration,
+    link_preview.fetched_at AS page_fetched_at
 FROM material
 JOIN material_to_module ON material_to_module.material_uuid = material.uuid
+LEFT JOIN material_version ON material_version.material_uuid = material.uuid AND material_version.url = material.url
+LEFT JOIN link_preview ON link_preview.url = material.url AND material.type IN ('url', 'video')
 WHERE material.course_uuid = ? 
-ORDER BY created_at DESC;
+ORDER BY material.created_at DESC;
 
 -- name: UpdateMaterialPartial :one
 UPDATE material
@@ -313,18 +480,188 @@ SET
     favicon_url = COALESCE(sqlc.narg(favicon_url), favicon_url),
     byte_size   = COALESCE(sqlc.narg(byte_size), byte_size),
     mime_type   = COALESCE(sqlc.narg(mime_type), mime_type),
+    video_provider = COALESCE(sqlc.narg(video_provider), video_provider),
+    video_id       = CASE WHEN sqlc.narg(video_provider) IS NULL THEN video_id ELSE sqlc.narg(video_id) END,
+    -- 0 clears the duration
+    duration_seconds = CASE
+        WHEN CAST(sqlc.narg(duration_seconds) AS INTEGER) IS NULL THEN duration_seconds
+        ELSE NULLIF(CAST(sqlc.narg(duration_seconds) AS INTEGER), 0)
+    END,
     updated_at  = sqlc.arg(updated_at)
 WHERE uuid = sqlc.arg(uuid) RETURNING *;
 
+-- name: GetMaterialDownload :one
+SELECT
+    material.*,
+    course.state AS course_state,
+    module.state AS module_state,
+    material_version.thumbnail_key
+FROM material
+JOIN course ON course.uuid = material.course_uuid
+LEFT JOIN material_to_module ON material_to_module.material_uuid = material.uuid
+LEFT JOIN module ON module.uuid = material_to_module.module_uuid
+LEFT JOIN material_version ON material_version.material_uuid = material.uuid AND material_version.url = material.url
+WHERE material.uuid = sqlc.arg(uuid) AND material.course_uuid = sqlc.arg(course_uuid)
+LIMIT 1;
+
+--* Material versions
+
+-- name: CreateMaterialVersion :one
+INSERT INTO material_version (
+    uuid, material_uuid, version, url, mime_type, byte_size, thumbnail_key, preview_text, created_by, created_at
+) VALUES (
+    sqlc.arg(uuid), sqlc.arg(material_uuid),
+    (SELECT COALESCE(MAX(version), 0) + 1 FROM material_version WHERE material_uuid = sqlc.arg(material_uuid)),
+    sqlc.arg(url), sqlc.arg(mime_type), sqlc.arg(byte_size), sqlc.narg(thumbnail_key), sqlc.narg(preview_text),
+    sqlc.narg(created_by), sqlc.arg(created_at)
+) RETURNING *;
+
+-- the file of a material from before the versions becomes its first version
+-- name: CreateFirstMaterialVersion :exec
+INSERT INTO material_version (
+    uuid, material_uuid, version, url, mime_type, byte_size, created_by, created_at
+)
+SELECT sqlc.arg(uuid), material.uuid, 1, material.url, COALESCE(material.mime_type, ''), COALESCE(material.byte_size, 0), NULL, material.updated_at
+FROM material
+WHERE material.uuid = sqlc.arg(material_uuid) AND material.type = 'file'
+    AND NOT EXISTS (SELECT 1 FROM material_version WHERE material_version.material_uuid = material.uuid);
+
+-- name: ListMaterialVersions :many
+SELECT
+    material_version.*,
+    user.first_name,
+    user.last_name
+FROM material_version
+LEFT JOIN user ON user.id = material_version.created_by
+WHERE material_version.material_uuid = ?
+ORDER BY material_version.version DESC;
+
+-- name: GetMaterialVersion :one
+SELECT material_version.*
+FROM material_version
+JOIN material ON material.uuid = material_version.material_uuid
+WHERE material_version.material_uuid = sqlc.arg(material_uuid)
+    AND material_version.version = sqlc.arg(version)
+    AND material.course_uuid = sqlc.arg(course_uuid);
+
+-- the version the material points to, missing for materials from before the versions
+-- name: GetCurrentMaterialVersion :one
+SELECT material_version.*
+FROM material_version
+JOIN material ON material.uuid = material_version.material_uuid AND material.url = material_version.url
+WHERE material.uuid = ?;
+
+-- name: DeleteMaterialVersion :execrows
+DELETE FROM material_version WHERE uuid = ?;
+
+-- the stored files of the material, its versions and the file of a material from before the versions
+-- name: ListMaterialFileKeys :many
+SELECT url FROM material WHERE material.uuid = sqlc.arg(material_uuid) AND material.type = 'file'
+UNION
+SELECT url FROM material_version WHERE material_version.material_uuid = sqlc.arg(material_uuid)
+UNION
+SELECT thumbnail_key FROM material_version
+WHERE material_version.material_uuid = sqlc.arg(material_uuid) AND thumbnail_key IS NOT NULL;
+
+--* Link previews
+
+-- the preview is fetched by the refresher soon, an existing one is kept
+-- name: QueueLinkPreview :exec
+INSERT INTO link_preview (url, next_fetch_at) VALUES (?, ?)
+ON CONFLICT (url) DO NOTHING;
+
+-- url and video materials from before the previews and ones whose preview was removed
+-- name: QueueMissingLinkPreviews :exec
+INSERT INTO link_preview (url, next_fetch_at)
+SELECT DISTINCT material.url, CAST(sqlc.arg(next_fetch_at) AS INTEGER) FROM material
+WHERE material.type IN ('url', 'video') AND material.url NOT IN (SELECT url FROM link_preview)
+ON CONFLICT (url) DO NOTHING;
+
+-- name: GetLinkPreview :one
+SELECT * FROM link_preview WHERE url = ?;
+
+-- claims the due previews, the returned ones are not due again until the lease runs out
+-- name: ClaimDueLinkPreviews :many
+UPDATE link_preview
+SET next_fetch_at = CAST(sqlc.arg(lease_until) AS INTEGER)
+WHERE url IN (
+    SELECT url FROM link_preview
+    WHERE next_fetch_at <= CAST(sqlc.arg(now) AS INTEGER)
+    ORDER BY next_fetch_at ASC
+    LIMIT sqlc.arg(max_count)
+)
+RETURNING *;
+
+-- name: SaveLinkPreview :one
+UPDATE link_preview
+SET title = ?,
+    description = ?,
+    image_url = ?,
+    favicon_url = ?,
+    duration = ?,
+    fetched_at = ?,
+    next_fetch_at = ?,
+    failures = 0,
+    error = NULL
+WHERE url = ?
+RETURNING *;
+
+-- the metadata from the last successful fetch stays
+-- name: FailLinkPreview :one
+UPDATE link_preview
+SET next_fetch_at = ?, failures = failures + 1, error = ?
+WHERE url = ?
+RETURNING *;
+
+-- name: DeleteUnusedLinkPreviews :exec
+DELETE FROM link_preview
+WHERE url NOT IN (SELECT url FROM material WHERE type IN ('url', 'video'));
+
+-- name: ListCoursesOfLink :many
+SELECT DISTINCT course_uuid FROM material WHERE type IN ('url', 'video') AND url = ?;
+
+--* Video progress
+
+-- name: GetVideoProgress :one
+SELECT * FROM video_progress WHERE material_uuid = ? AND user_id = ?;
+
+-- the furthest position only grows and a completed video stays completed
+-- name: SaveVideoProgress :one
+INSERT INTO video_progress (
+    material_uuid, user_id, position_seconds, furthest_seconds, duration_seconds, completed, updated_at
+) VALUES (
+    sqlc.arg(material_uuid), sqlc.arg(user_id), sqlc.arg(position_seconds), sqlc.arg(position_seconds),
+    sqlc.narg(duration_seconds), sqlc.arg(completed), sqlc.arg(updated_at)
+)
+ON CONFLICT (material_uuid, user_id) DO UPDATE SET
+    position_seconds = excluded.position_seconds,
+    furthest_seconds = MAX(video_progress.furthest_seconds, excluded.furthest_seconds),
+    duration_seconds = COALESCE(excluded.duration_seconds, video_progress.duration_seconds),
+    completed = MAX(video_progress.completed, excluded.completed),
+    updated_at = excluded.updated_at
+RETURNING *;
+
+-- name: ListVideoProgressOfCourse :many
+SELECT
+    sqlc.embed(video_progress),
+    CAST(COALESCE(material.duration_seconds, link_preview.duration, 0) AS INTEGER) AS material_duration
+FROM video_progress
+JOIN material ON material.uuid = video_progress.material_uuid
+LEFT JOIN link_preview ON link_preview.url = material.url
+WHERE material.course_uuid = ? AND video_progress.user_id = ?;
+
 --* Quiz
 
 -- name: CreateQuiz :one
 INSERT INTO quiz (
-    uuid, course_uuid, title, attempts_count, created_at, updated_at
+    uuid, course_uuid, title, attempts_count, deadline_at, created_at, updated_at
 ) VALUES (
-    ?, ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?, ?
 ) RETURNING *;
 
+-- name: SetQuizDeadline :exec
+UPDATE quiz SET deadline_at = ? WHERE uuid = ?;
+
 -- name: UpdateQuiz :one
 UPDATE quiz
 SET
@@ -350,6 +687,7 @@ SELECT
 
     qz.title AS quiz_title,
     qz.attempts_count AS quiz_attempts_count,
+    qz.deadline_at AS quiz_deadline_at,
     qz.created_at AS quiz_created_at,
     qz.updated_at AS quiz_updated_at,
 
@@ -373,6 +711,7 @@ SELECT
 
     qz.title AS quiz_title,
     qz.attempts_count AS quiz_attempts_count,
+    qz.deadline_at AS quiz_deadline_at,
     qz.created_at AS quiz_created_at,
     qz.updated_at AS quiz_updated_at,
 
@@ -465,26 +804,327 @@ ORDER BY answer.submitted_at DESC;
 
 --* Posts
 
--- name: GetPostsByCourse :many
-SELECT * FROM feed_posts
-WHERE course_uuid = ?
-ORDER BY created_at DESC;
+-- one page of the feed, pinned posts first and then the newest, the cursor is the is_pinned, publish_at and uuid
+-- of the last post of the previous page, search is an fts5 query over the messages,
+-- scheduled and expired posts are left out unless include_hidden is set
+-- name: ListPostsOfCourse :many
+SELECT p.* FROM feed_posts p
+WHERE p.course_uuid = sqlc.arg(course_uuid)
+    AND (CAST(sqlc.arg(include_hidden) AS BOOLEAN)
+        OR (p.is_published = 1 AND (p.expires_at IS NULL OR p.expires_at > CAST(sqlc.arg(now) AS INTEGER))))
+    AND (CAST(sqlc.narg(type) AS TEXT) IS NULL OR p.type = CAST(sqlc.narg(type) AS TEXT))
+    AND (CAST(sqlc.narg(from) AS INTEGER) IS NULL OR p.publish_at >= CAST(sqlc.narg(from) AS INTEGER))
+    AND (CAST(sqlc.narg(to) AS INTEGER) IS NULL OR p.publish_at < CAST(sqlc.narg(to) AS INTEGER))
+    AND (CAST(sqlc.narg(search) AS TEXT) IS NULL
+        OR p.id IN (SELECT rowid FROM feed_posts_fts WHERE feed_posts_fts MATCH CAST(sqlc.narg(search) AS TEXT)))
+    AND (CAST(sqlc.narg(cursor_pinned) AS BOOLEAN) IS NULL
+        OR p.is_pinned < CAST(sqlc.narg(cursor_pinned) AS BOOLEAN)
+        OR (p.is_pinned = CAST(sqlc.narg(cursor_pinned) AS BOOLEAN) AND (
+            p.publish_at < CAST(sqlc.narg(cursor_publish_at) AS INTEGER)
+            OR (p.publish_at = CAST(sqlc.narg(cursor_publish_at) AS INTEGER) AND p.uuid < CAST(sqlc.narg(cursor_uuid) AS TEXT)))))
+ORDER BY p.is_pinned DESC, p.publish_at DESC, p.uuid DESC
+LIMIT sqlc.arg(limit);
 
 -- name: GetPost :one
 SELECT * FROM feed_posts
 WHERE uuid = ?;
 
 -- name: CreatePost :one
-INSERT INTO feed_posts (uuid, course_uuid, type, message, is_edited, created_at, updated_at)
-VALUES (?, ?, ?, ?, ?, ?, ?)
+INSERT INTO feed_posts (
+    uuid, course_uuid, type, message, is_edited, is_pinned,
+    created_at, updated_at, publish_at, is_published, expires_at, is_expired
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 RETURNING *;
 
 -- name: UpdatePost :one
 UPDATE feed_posts
-SET message = ?, is_edited = 1, updated_at = ?
+SET message = ?, is_edited = ?, is_pinned = ?, is_locked = ?, updated_at = ?,
+    publish_at = ?, is_published = ?, expires_at = ?, is_expired = ?
 WHERE uuid = ?
 RETURNING *;
 
+-- marks the scheduled posts that are due as published, with several instances only one of them gets each post
+-- name: PublishDuePosts :many
+UPDATE feed_posts
+SET is_published = 1
+WHERE is_published = 0 AND publish_at <= sqlc.arg(now)
+RETURNING *;
+
+-- name: ExpireDuePosts :many
+UPDATE feed_posts
+SET is_expired = 1
+WHERE is_expired = 0 AND is_published = 1 AND expires_at IS NOT NULL AND expires_at <= CAST(sqlc.arg(now) AS INTEGER)
+RETURNING *;
+
 -- name: DeletePost :exec
 DELETE FROM feed_posts
-WHERE uuid = ?;
\ No newline at end of file
+WHERE uuid = ?;
+
+--* Feed comments and reactions
+
+-- name: ListCommentsOfPost :many
+SELECT c.*, u.first_name, u.last_name
+FROM feed_comment c
+JOIN user u ON u.id = c.user_id
+WHERE c.post_uuid = ?
+ORDER BY c.created_at ASC, c.rowid ASC;
+
+-- name: GetComment :one
+SELECT c.*, u.first_name, u.last_name
+FROM feed_comment c
+JOIN user u ON u.id = c.user_id
+WHERE c.uuid = ?;
+
+-- name: CreateComment :exec
+INSERT INTO feed_comment (uuid, post_uuid, parent_uuid, user_id, message, created_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: SetCommentHidden :exec
+UPDATE feed_comment SET is_hidden = ? WHERE uuid = ?;
+
+-- name: DeleteComment :exec
+DELETE FROM feed_comment WHERE uuid = ?;
+
+-- name: CountCommentsOfPosts :many
+SELECT post_uuid, COUNT(*) AS comment_count
+FROM feed_comment
+WHERE post_uuid IN (sqlc.slice(post_uuids))
+GROUP BY post_uuid;
+
+-- name: AddReaction :exec
+INSERT OR IGNORE INTO feed_reaction (post_uuid, user_id, emoji, created_at)
+VALUES (?, ?, ?, ?);
+
+-- name: RemoveReaction :exec
+DELETE FROM feed_reaction WHERE post_uuid = ? AND user_id = ? AND emoji = ?;
+
+-- name: CountReactionsOfPosts :many
+SELECT post_uuid, emoji, COUNT(*) AS reaction_count
+FROM feed_reaction
+WHERE post_uuid IN (sqlc.slice(post_uuids))
+GROUP BY post_uuid, emoji;
+
+-- name: ListReactionsOfUser :many
+SELECT post_uuid, emoji
+FROM feed_reaction
+WHERE user_id = ? AND post_uuid IN (sqlc.slice(post_uuids));
+
+--* Feed attachments
+
+-- name: ListAttachmentsOfPosts :many
+SELECT
+    a.*,
+    m.name AS material_name,
+    m.url AS material_url,
+    m.type AS material_type,
+    m.course_uuid AS material_course_uuid,
+    q.title AS quiz_title,
+    p.course_uuid AS post_course_uuid
+FROM feed_attachment a
+JOIN feed_posts p ON p.uuid = a.post_uuid
+LEFT JOIN material m ON m.uuid = a.material_uuid
+LEFT JOIN quiz q ON q.uuid = a.quiz_uuid
+WHERE a.post_uuid IN (sqlc.slice(post_uuids))
+ORDER BY a.post_uuid, a.position;
+
+-- name: GetAttachment :one
+SELECT * FROM feed_attachment WHERE uuid = ?;
+
+-- name: CountAttachmentsOfPost :one
+SELECT COUNT(*) FROM feed_attachment WHERE post_uuid = ?;
+
+-- name: CreateAttachment :exec
+INSERT INTO feed_attachment (
+    uuid, post_uuid, type, position, name, path, mime_type, byte_size, material_uuid, quiz_uuid, created_at
+) VALUES (
+    sqlc.arg(uuid), sqlc.arg(post_uuid), sqlc.arg(type),
+    (SELECT COALESCE(MAX(position), 0) + 1 FROM feed_attachment WHERE post_uuid = sqlc.arg(post_uuid)),
+    sqlc.narg(name), sqlc.narg(path), sqlc.narg(mime_type), sqlc.narg(byte_size),
+    sqlc.narg(material_uuid), sqlc.narg(quiz_uuid), sqlc.arg(created_at)
+);
+
+-- name: DeleteAttachment :exec
+DELETE FROM feed_attachment WHERE uuid = ?;
+
+-- name: ListAttachmentPathsOfPost :many
+SELECT path FROM feed_attachment WHERE post_uuid = ? AND path IS NOT NULL;
+
+-- name: GetCourseOfQuiz :one
+SELECT course_uuid FROM quiz WHERE uuid = ?;
+
+--* Feed events (sqlite broker)
+
+-- name: CreateFeedEvent :one
+INSERT INTO feed_event (
+    course_uuid, name, data, created_at
+) VALUES (
+    ?, ?, ?, ?
+) RETURNING id;
+
+-- name: ListFeedEventsAfter :many
+SELECT * FROM feed_event
+WHERE id > ?
+ORDER BY id ASC
+LIMIT ?;
+
+-- name: GetLastFeedEventId :one
+SELECT CAST(COALESCE(MAX(id), 0) AS INTEGER) FROM feed_event;
+
+-- name: DeleteFeedEventsBefore :exec
+DELETE FROM feed_event WHERE created_at < ?;
+--* Webhooks
+
+-- name: ListWebhooksOfCourse :many
+SELECT * FROM webhook WHERE course_uuid = ? ORDER BY created_at ASC;
+
+-- name: ListActiveWebhooksOfCourse :many
+SELECT * FROM webhook WHERE course_uuid = ? AND active = 1;
+
+-- name: GetWebhook :one
+SELECT * FROM webhook WHERE uuid = ?;
+
+-- name: CreateWebhook :one
+INSERT INTO webhook (
+    uuid, course_uuid, url, secret, events, format, active, created_at, updated_at
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?, ?, ?
+) RETURNING *;
+
+-- name: UpdateWebhook :one
+UPDATE webhook
+SET url = ?, events = ?, format = ?, active = ?, updated_at = ?
+WHERE uuid = ?
+RETURNING *;
+
+-- name: SetWebhookSecret :one
+UPDATE webhook SET secret = ?, updated_at = ? WHERE uuid = ? RETURNING *;
+
+-- name: DeleteWebhook :exec
+DELETE FROM webhook WHERE uuid = ?;
+
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_delivery (
+    uuid, webhook_uuid, event, payload, status, next_attempt_at, created_at, updated_at
+) VALUES (
+    ?, ?, ?, ?, 'pending', ?, ?, ?
+) RETURNING *;
+
+-- claims the due deliveries, the returned ones are not due again until the lease runs out
+-- name: ClaimDueWebhookDeliveries :many
+UPDATE webhook_delivery
+SET next_attempt_at = CAST(sqlc.arg(lease_until) AS INTEGER)
+WHERE uuid IN (
+    SELECT uuid FROM webhook_delivery
+    WHERE status = 'pending' AND next_attempt_at <= CAST(sqlc.arg(now) AS INTEGER)
+    ORDER BY next_attempt_at ASC
+    LIMIT sqlc.arg(max_count)
+)
+RETURNING *;
+
+-- name: FinishWebhookDeliveryAttempt :one
+UPDATE webhook_delivery
+SET status = ?,
+    attempts = attempts + 1,
+    next_attempt_at = ?,
+    response_status = ?,
+    response_body = ?,
+    error = ?,
+    duration_ms = ?,
+    updated_at = ?
+WHERE uuid = ?
+RETURNING *;
+
+-- name: CancelWebhookDelivery :exec
+UPDATE webhook_delivery
+SET status = 'failed', next_attempt_at = NULL, error = ?, updated_at = ?
+WHERE uuid = ?;
+
+-- name: ListDeliveriesOfWebhook :many
+SELECT * FROM webhook_delivery
+WHERE webhook_uuid = ?
+ORDER BY created_at DESC, rowid DESC
+LIMIT ?;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_delivery WHERE uuid = ?;
+
+-- name: DeleteWebhookDeliveriesBefore :exec
+DELETE FROM webhook_delivery WHERE status != 'pending' AND created_at < ?;
+
+-- name: GetCourseUploadLimit :one
+SELECT max_upload_size FROM course WHERE uuid = ?;
+
+-- name: SetCourseUploadLimit :execrows
+UPDATE course SET max_upload_size = ?, updated_at = ? WHERE uuid = ?;
+
+-- name: CreateUpload :one
+INSERT INTO upload (uuid, course_uuid, user_id, filename, size, checksum, created_at, updated_at, expires_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING *;
+
+-- name: GetUpload :one
+SELECT * FROM upload WHERE uuid = ?;
+
+-- moves the offset only when no other request moved it in the meantime
+-- name: AdvanceUpload :one
+UPDATE upload
+SET received_bytes = sqlc.arg(received_bytes), updated_at = sqlc.arg(updated_at), expires_at = sqlc.arg(expires_at)
+WHERE uuid = sqlc.arg(uuid) AND received_bytes = sqlc.arg(offset) AND finished_at IS NULL
+RETURNING *;
+
+-- name: FinishUpload :one
+UPDATE upload SET mime_type = ?, finished_at = ?, updated_at = ? WHERE uuid = ? RETURNING *;
+
+-- name: DeleteUpload :execrows
+DELETE FROM upload WHERE uuid = ?;
+
+-- name: ListExpiredUploads :many
+SELECT uuid FROM upload WHERE expires_at < ?;
+
+-- name: ListUploadIds :many
+SELECT uuid FROM upload;
+
+--* Storage of course files
+
+-- name: GetCourseStorageQuota :one
+SELECT storage_quota FROM course WHERE uuid = ?;
+
+-- name: SetCourseStorageQuota :execrows
+UPDATE course SET storage_quota = ?, updated_at = ? WHERE uuid = ?;
+
+-- the current files of materials, their older versions, files attached to posts and the space taken by resumable uploads
+-- name: GetCourseStorageUsage :one
+SELECT
+    CAST((SELECT COALESCE(SUM(m.byte_size), 0) FROM material m
+        WHERE m.course_uuid = sqlc.arg(course_uuid) AND m.type = 'file') AS INTEGER) AS materials_bytes,
+    CAST((SELECT COALESCE(SUM(v.byte_size), 0) FROM material_version v
+        JOIN material m ON m.uuid = v.material_uuid
+        WHERE m.course_uuid = sqlc.arg(course_uuid) AND v.url != m.url) AS INTEGER) AS versions_bytes,
+    CAST((SELECT COALESCE(SUM(a.byte_size), 0) FROM feed_attachment a
+        JOIN feed_posts p ON p.uuid = a.post_uuid
+        WHERE p.course_uuid = sqlc.arg(course_uuid) AND a.type = 'file') AS INTEGER) AS attachments_bytes,
+    CAST((SELECT COALESCE(SUM(u.size), 0) FROM upload u
+        WHERE u.course_uuid = sqlc.arg(course_uuid)) AS INTEGER) AS uploads_bytes;
+
+-- every file the db knows about with the material or post it belongs to, the current file of a material
+-- is one of its versions unless it's from before the versions
+-- name: ListStoredFiles :many
+SELECT m.course_uuid, CAST('material' AS TEXT) AS owner_type, m.uuid AS owner_uuid, v.url AS file_key
+FROM material_version v
+JOIN material m ON m.uuid = v.material_uuid
+UNION ALL
+SELECT m.course_uuid, 'material', m.uuid, v.thumbnail_key
+FROM material_version v
+JOIN material m ON m.uuid = v.material_uuid
+WHERE v.thumbnail_key IS NOT NULL
+UNION ALL
+SELECT m.course_uuid, 'material', m.uuid, m.url
+FROM material m
+WHERE m.type = 'file'
+    AND NOT EXISTS (SELECT 1 FROM material_version v WHERE v.material_uuid = m.uuid AND v.url = m.url)
+UNION ALL
+SELECT p.course_uuid, 'attachment', p.uuid, a.path
+FROM feed_attachment a
+JOIN feed_posts p ON p.uuid = a.post_uuid
+WHERE a.type = 'file' AND a.path IS NOT NULL;
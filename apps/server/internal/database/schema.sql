@@ -8,7 +8,14 @@ CREATE TABLE IF NOT EXISTS user (
     first_name  TEXT NOT NULL,
     last_name   TEXT NOT NULL,
     hash        TEXT NOT NULL,
-    email       TEXT NOT NULL UNIQUE
+    email       TEXT NOT NULL UNIQUE,
+
+    must_change_password INTEGER NOT NULL DEFAULT 0, -- set when an admin forces a password reset
+    deactivated_at       INTEGER, -- soft deactivation, deactivated users can't log in
+
+    avatar_path TEXT, -- relative to the static folder
+
+    feed_token  TEXT UNIQUE -- secret of the atom/rss and calendar links of the user, for courses that are not open
 );
 
 CREATE TABLE IF NOT EXISTS admin (
@@ -42,9 +49,19 @@ CREATE TABLE IF NOT EXISTS course (
     highlighted_module_message TEXT,
 
     archived INTEGER NOT NULL DEFAULT 0,
-    state TEXT NOT NULL DEFAULT 'preparation' -- preparation | open | closed
-
-
+    state TEXT NOT NULL DEFAULT 'preparation', -- preparation | open | closed
+
+    -- state change planned with openTime, cleared once it's done
+    scheduled_state TEXT,
+    scheduled_at INTEGER,
+    -- the module highlighted by the planned change
+    scheduled_module_uuid TEXT,
+    scheduled_module_message TEXT,
+
+    -- largest file of a material in bytes, the default MAX_UPLOAD_SIZE when NULL
+    max_upload_size INTEGER,
+    -- space for all files of the course in bytes, the default STORAGE_QUOTA when NULL
+    storage_quota INTEGER
 );
 
 CREATE TABLE IF NOT EXISTS module (
@@ -57,6 +74,10 @@ CREATE TABLE IF NOT EXISTS module (
     
     module_order INTEGER NOT NULL,
 
+    -- state change planned with openTime, cleared once it's done
+    scheduled_state TEXT,
+    scheduled_at INTEGER,
+
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL,
 
@@ -128,6 +149,12 @@ CREATE TABLE IF NOT EXISTS material (
     mime_type TEXT,
     byte_size INTEGER,
 
+    -- video materials: youtube | vimeo | mp4, the id of the video on youtube and vimeo (NULL for mp4)
+    -- and the duration in seconds set by the lecturer, NULL when unknown (then the one from link_preview)
+    video_provider TEXT,
+    video_id TEXT,
+    duration_seconds INTEGER,
+
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL,
     
@@ -141,6 +168,8 @@ CREATE TABLE IF NOT EXISTS quiz (
 
     title TEXT NOT NULL,
     attempts_count INTEGER NOT NULL,
+
+    deadline_at INTEGER, -- no answers are accepted after it
     
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL,
@@ -179,15 +208,287 @@ CREATE TABLE IF NOT EXISTS answer (
 );
 
 CREATE TABLE IF NOT EXISTS feed_posts (
-    uuid TEXT PRIMARY KEY,
+    id INTEGER PRIMARY KEY, -- the rowid of the search index, unlike an implicit rowid it's kept by VACUUM
+    uuid TEXT NOT NULL UNIQUE,
     course_uuid TEXT NOT NULL,
 
     type TEXT NOT NULL, -- 'manual' or 'system'
     message TEXT NOT NULL,
     is_edited BOOLEAN NOT NULL DEFAULT 0,
+    is_pinned BOOLEAN NOT NULL DEFAULT 0, -- pinned posts are listed before all others
+    is_locked BOOLEAN NOT NULL DEFAULT 0, -- no new comments from students
     
     created_at INTEGER NOT NULL,
     updated_at INTEGER NOT NULL,
 
+    -- a scheduled post is stored right away but stays hidden until the publisher publishes it at publish_at,
+    -- posts published right away have publish_at = created_at, the feed is ordered by it
+    publish_at INTEGER NOT NULL,
+    is_published BOOLEAN NOT NULL DEFAULT 1,
+
+    -- the post is hidden after expires_at, is_expired is set once the clients were told about it
+    expires_at INTEGER,
+    is_expired BOOLEAN NOT NULL DEFAULT 0,
+
     FOREIGN KEY (course_uuid) REFERENCES course(uuid) ON DELETE CASCADE
-);
\ No newline at end of file
+);
+
+CREATE INDEX IF NOT EXISTS feed_posts_course_order ON feed_posts (course_uuid, is_pinned DESC, publish_at DESC, uuid DESC);
+CREATE INDEX IF NOT EXISTS feed_posts_unpublished ON feed_posts (publish_at) WHERE is_published = 0;
+CREATE INDEX IF NOT EXISTS feed_posts_unexpired ON feed_posts (expires_at) WHERE is_expired = 0 AND expires_at IS NOT NULL;
+
+-- comments of students (and lecturers) on manual posts, threads have one level - a reply points to a top level comment
+CREATE TABLE IF NOT EXISTS feed_comment (
+    uuid        TEXT PRIMARY KEY,
+    post_uuid   TEXT NOT NULL,
+    parent_uuid TEXT,
+    user_id     INTEGER NOT NULL,
+
+    message     TEXT NOT NULL,
+    is_hidden   BOOLEAN NOT NULL DEFAULT 0, -- hidden by a lecturer, students don't see the message
+
+    created_at  INTEGER NOT NULL,
+
+    FOREIGN KEY (post_uuid) REFERENCES feed_posts(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (parent_uuid) REFERENCES feed_comment(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS feed_comment_post ON feed_comment (post_uuid, created_at);
+
+-- one row per user and emoji, a user can react to a post with several emojis
+CREATE TABLE IF NOT EXISTS feed_reaction (
+    post_uuid  TEXT NOT NULL,
+    user_id    INTEGER NOT NULL,
+    emoji      TEXT NOT NULL,
+
+    created_at INTEGER NOT NULL,
+
+    PRIMARY KEY (post_uuid, user_id, emoji),
+    FOREIGN KEY (post_uuid) REFERENCES feed_posts(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+-- files uploaded to a manual post and links to materials and quizzes of the same course,
+-- path of files is relative to the static folder, names of the links are read from the linked material or quiz
+CREATE TABLE IF NOT EXISTS feed_attachment (
+    uuid          TEXT PRIMARY KEY,
+    post_uuid     TEXT NOT NULL,
+    type          TEXT NOT NULL, -- 'file', 'material' or 'quiz'
+    position      INTEGER NOT NULL,
+
+    name          TEXT,
+    path          TEXT,
+    mime_type     TEXT,
+    byte_size     INTEGER,
+
+    material_uuid TEXT,
+    quiz_uuid     TEXT,
+
+    created_at    INTEGER NOT NULL,
+
+    FOREIGN KEY (post_uuid) REFERENCES feed_posts(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (material_uuid) REFERENCES material(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (quiz_uuid) REFERENCES quiz(uuid) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS feed_attachment_post ON feed_attachment (post_uuid, position);
+
+-- full-text index of the post messages, it's an external content table - it only keeps the index and reads
+-- the messages from feed_posts, the triggers below keep it in sync
+CREATE VIRTUAL TABLE IF NOT EXISTS feed_posts_fts USING fts5(
+    message,
+    content='feed_posts',
+    content_rowid='id'
+);
+
+CREATE TRIGGER IF NOT EXISTS feed_posts_fts_insert AFTER INSERT ON feed_posts BEGIN
+    INSERT INTO feed_posts_fts (rowid, message) VALUES (new.id, new.message);
+END;
+
+CREATE TRIGGER IF NOT EXISTS feed_posts_fts_delete AFTER DELETE ON feed_posts BEGIN
+    INSERT INTO feed_posts_fts (feed_posts_fts, rowid, message) VALUES ('delete', old.id, old.message);
+END;
+
+CREATE TRIGGER IF NOT EXISTS feed_posts_fts_update AFTER UPDATE OF message ON feed_posts BEGIN
+    INSERT INTO feed_posts_fts (feed_posts_fts, rowid, message) VALUES ('delete', old.id, old.message);
+    INSERT INTO feed_posts_fts (rowid, message) VALUES (new.id, new.message);
+END;
+
+-- events of the feed streams published by the sqlite broker, every instance of the server polls the new ones,
+-- the autoincrement id is the id of the event sent to the clients, rows are deleted after a while
+CREATE TABLE IF NOT EXISTS feed_event (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    course_uuid TEXT NOT NULL,
+
+    name        TEXT NOT NULL,
+    data        TEXT NOT NULL, -- json payload
+
+    created_at  INTEGER NOT NULL
+);
+
+-- links a local user to an account at an external OpenID Connect provider
+CREATE TABLE IF NOT EXISTS user_identity (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER NOT NULL,
+
+    issuer      TEXT NOT NULL,
+    subject     TEXT NOT NULL,
+    email       TEXT NOT NULL,
+
+    created_at  INTEGER NOT NULL,
+
+    UNIQUE (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+-- a requested change of email, the email changes only after the link sent to the new address is opened
+CREATE TABLE IF NOT EXISTS email_change (
+    token       TEXT PRIMARY KEY,
+    user_id     INTEGER NOT NULL UNIQUE, -- only the latest request of a user is kept
+    new_email   TEXT NOT NULL,
+
+    created_at  INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
+
+-- pending OpenID Connect logins, a row lives from the redirect to the provider until the callback
+CREATE TABLE IF NOT EXISTS oidc_login_state (
+    state           TEXT PRIMARY KEY,
+
+    code_verifier   TEXT NOT NULL,
+    nonce           TEXT NOT NULL,
+    redirect_to     TEXT NOT NULL,
+
+    created_at      INTEGER NOT NULL,
+    expires_at      INTEGER NOT NULL
+);
+
+-- outgoing webhooks of a course, events is a comma separated list of the feed event names sent to the url
+CREATE TABLE IF NOT EXISTS webhook (
+    uuid        TEXT PRIMARY KEY,
+    course_uuid TEXT NOT NULL,
+
+    url         TEXT NOT NULL,
+    secret      TEXT NOT NULL, -- key of the HMAC signature of the payloads
+    events      TEXT NOT NULL,
+    format      TEXT NOT NULL DEFAULT 'json', -- 'json', 'discord' or 'slack'
+    active      INTEGER NOT NULL DEFAULT 1,
+
+    created_at  INTEGER NOT NULL,
+    updated_at  INTEGER NOT NULL,
+
+    FOREIGN KEY (course_uuid) REFERENCES course(uuid) ON DELETE CASCADE
+);
+
+-- deliveries of the events to the webhooks, they are the queue of the dispatcher and the delivery log at once,
+-- a pending delivery is sent at next_attempt_at, the dispatcher sending it moves next_attempt_at forward
+-- so that other instances leave it alone
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    uuid            TEXT PRIMARY KEY,
+    webhook_uuid    TEXT NOT NULL,
+
+    event           TEXT NOT NULL,
+    payload         TEXT NOT NULL, -- the signed body
+
+    status          TEXT NOT NULL, -- 'pending', 'succeeded' or 'failed'
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER,
+
+    response_status INTEGER,
+    response_body   TEXT, -- start of the body of the last response
+    error           TEXT, -- why the last attempt failed
+    duration_ms     INTEGER,
+
+    created_at      INTEGER NOT NULL,
+    updated_at      INTEGER NOT NULL,
+
+    FOREIGN KEY (webhook_uuid) REFERENCES webhook(uuid) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_due ON webhook_delivery (status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS webhook_delivery_webhook ON webhook_delivery (webhook_uuid, created_at);
+
+-- files of the file materials, every new file is a new version and the old ones stay for a rollback,
+-- the url of the material is the key of its current version
+CREATE TABLE IF NOT EXISTS material_version (
+    uuid          TEXT PRIMARY KEY,
+    material_uuid TEXT NOT NULL,
+    version       INTEGER NOT NULL,
+
+    url           TEXT NOT NULL, -- key of the file in the storage
+    mime_type     TEXT NOT NULL,
+    byte_size     INTEGER NOT NULL,
+
+    -- generated from the file when it's uploaded, NULL for files without a picture or text
+    thumbnail_key TEXT, -- jpeg in the storage next to the file
+    preview_text  TEXT, -- beginning of the text of the document
+
+    created_by    INTEGER, -- NULL when the user was deleted or for files uploaded before the versions
+    created_at    INTEGER NOT NULL,
+
+    UNIQUE (material_uuid, version),
+    FOREIGN KEY (material_uuid) REFERENCES material(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES user(id) ON DELETE SET NULL
+);
+
+-- resumable uploads of material files (tus protocol), the received bytes are in a file in PARTIAL_UPLOADS_PATH,
+-- a finished upload becomes the file of a material, the unfinished ones are removed after expires_at
+CREATE TABLE IF NOT EXISTS upload (
+    uuid           TEXT PRIMARY KEY,
+    course_uuid    TEXT NOT NULL,
+    user_id        INTEGER NOT NULL,
+
+    filename       TEXT NOT NULL,
+    size           INTEGER NOT NULL,
+    received_bytes INTEGER NOT NULL DEFAULT 0,
+    checksum       TEXT, -- '<algorithm> <base64 digest>' of the whole file, checked when the last byte arrives
+    mime_type      TEXT, -- set once the upload is finished and its content checked
+
+    created_at     INTEGER NOT NULL,
+    updated_at     INTEGER NOT NULL,
+    finished_at    INTEGER,
+    expires_at     INTEGER NOT NULL,
+
+    FOREIGN KEY (course_uuid) REFERENCES course(uuid) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS upload_expires ON upload (expires_at);
+
+-- metadata of the pages of url materials, kept by the url so the materials with the same url share it,
+-- fetched in the background and again after next_fetch_at
+CREATE TABLE IF NOT EXISTS link_preview (
+    url           TEXT PRIMARY KEY, -- as the material has it
+
+    title         TEXT,
+    description   TEXT,
+    image_url     TEXT, -- og:image
+    favicon_url   TEXT,
+    duration      INTEGER, -- seconds, from the video tags of video pages
+
+    fetched_at    INTEGER, -- last successful fetch, NULL until the first one
+    next_fetch_at INTEGER NOT NULL,
+    failures      INTEGER NOT NULL DEFAULT 0, -- failed fetches in a row
+    error         TEXT -- why the last fetch failed
+);
+
+CREATE INDEX IF NOT EXISTS link_preview_due ON link_preview (next_fetch_at);
+
+-- how far each user got in a video material, position is where the player continues
+CREATE TABLE IF NOT EXISTS video_progress (
+    material_uuid    TEXT NOT NULL,
+    user_id          INTEGER NOT NULL,
+
+    position_seconds INTEGER NOT NULL,
+    furthest_seconds INTEGER NOT NULL, -- the furthest position ever reached
+    duration_seconds INTEGER, -- as the player of the user reported it
+    completed        BOOLEAN NOT NULL DEFAULT 0, -- stays once the video was watched to the end
+
+    updated_at       INTEGER NOT NULL,
+
+    PRIMARY KEY (material_uuid, user_id),
+    FOREIGN KEY (material_uuid) REFERENCES material(uuid) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+);
@@ -0,0 +1,347 @@
+package feeds
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"mime/multipart"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+//* attachments of the manual posts - files uploaded by the lecturer (checked like the files of materials)
+// and links to materials and quizzes of the same course. The files are not served as static files, they are
+// downloaded through the server which checks that the user can see the post, like the files of materials
+
+const (
+	ATTACHMENT_FILE     = "file"
+	ATTACHMENT_MATERIAL = "material"
+	ATTACHMENT_QUIZ     = "quiz"
+)
+
+// max number of attachments of one post
+var MAX_POST_ATTACHMENTS = 10
+
+// validity of the signed urls the downloads are redirected to
+var SIGNED_URL_EXPIRATION = 5 * time.Minute
+
+// folder of the attached files of the course, relative to the static folder
+func attachmentsDir(courseID string) string {
+	return "uploads/" + courseID + "/feed"
+}
+
+type AttachmentFile struct {
+	Key      string
+	Filename string
+	MimeType string
+	ModTime  time.Time
+}
+
+// GetAttachmentFile returns the attached file when the user can see the post - admins always,
+// the others when the course is open and the post is published and not expired
+func (s *Service) GetAttachmentFile(ctx context.Context, courseID, postID, attachmentID string, isAdmin bool) (AttachmentFile, error) {
+	post, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return AttachmentFile{}, err
+	}
+
+	if !isAdmin {
+		if err := s.CheckCourseVisible(ctx, courseID, isAdmin); err != nil {
+			return AttachmentFile{}, err
+		}
+		// scheduled and expired posts don't exist for the students
+		if !isPostVisible(post, time.Now().Unix()) {
+			return AttachmentFile{}, ErrPostNotFound
+		}
+	}
+
+	attachment, err := s.q.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return AttachmentFile{}, ErrAttachmentNotFound
+		}
+		return AttachmentFile{}, err
+	}
+	if attachment.PostUuid != postID || attachment.Type != ATTACHMENT_FILE {
+		return AttachmentFile{}, ErrAttachmentNotFound
+	}
+
+	return AttachmentFile{
+		Key:      attachment.Path.String,
+		Filename: attachment.Name.String,
+		MimeType: attachment.MimeType.String,
+		ModTime:  time.Unix(attachment.CreatedAt, 0),
+	}, nil
+}
+
+// signedFileUrl returns the url to redirect the download to, ok is false when the storage doesn't sign urls
+func (s *Service) signedFileUrl(file AttachmentFile, disposition string) (signed string, ok bool, err error) {
+	signer, ok := s.storage.(uploads.Signer)
+	if !ok {
+		return "", false, nil
+	}
+
+	signed, err = signer.SignedUrl(file.Key, SIGNED_URL_EXPIRATION, url.Values{
+		"response-content-disposition": {disposition},
+		"response-content-type":        {file.MimeType},
+	})
+	return signed, true, err
+}
+
+// AddFileAttachment stores the uploaded file and attaches it to the post
+func (s *Service) AddFileAttachment(ctx context.Context, courseID, postID string, fileHeader *multipart.FileHeader) (AttachmentResponse, error) {
+	post, err := s.getAttachablePost(ctx, courseID, postID)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	mime, err := uploads.CheckFile(fileHeader)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	err = uploads.CheckQuota(ctx, s.q, courseID, fileHeader.Size)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+	defer src.Close()
+
+	attachmentID := uuid.New().String()
+	name := attachmentID + uploads.MIME_TO_EXT[mime]
+
+	err = s.storage.Save(ctx, attachmentsDir(courseID)+"/"+name, src, fileHeader.Size, mime)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	err = s.q.CreateAttachment(ctx, db.CreateAttachmentParams{
+		Uuid:      attachmentID,
+		PostUuid:  postID,
+		Type:      ATTACHMENT_FILE,
+		Name:      sql.NullString{String: filepath.Base(fileHeader.Filename), Valid: true},
+		Path:      sql.NullString{String: attachmentsDir(courseID) + "/" + name, Valid: true},
+		MimeType:  sql.NullString{String: mime, Valid: true},
+		ByteSize:  sql.NullInt64{Int64: fileHeader.Size, Valid: true},
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		s.removeAttachmentFile(ctx, attachmentsDir(courseID)+"/"+name)
+		return AttachmentResponse{}, err
+	}
+
+	return s.attachmentAdded(ctx, courseID, post, attachmentID)
+}
+
+// AddLinkAttachment attaches a material or a quiz of the course to the post
+func (s *Service) AddLinkAttachment(ctx context.Context, courseID, postID string, link AttachmentLink) (AttachmentResponse, error) {
+	post, err := s.getAttachablePost(ctx, courseID, postID)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	err = s.checkAttachmentLink(ctx, courseID, link)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	attachmentID := uuid.New().String()
+
+	err = s.createLinkAttachment(ctx, postID, attachmentID, link)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	return s.attachmentAdded(ctx, courseID, post, attachmentID)
+}
+
+func (s *Service) createLinkAttachment(ctx context.Context, postID, attachmentID string, link AttachmentLink) error {
+	params := db.CreateAttachmentParams{
+		Uuid:      attachmentID,
+		PostUuid:  postID,
+		Type:      ATTACHMENT_MATERIAL,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	if link.QuizID != "" {
+		params.Type = ATTACHMENT_QUIZ
+		params.QuizUuid = sql.NullString{String: link.QuizID, Valid: true}
+	} else {
+		params.MaterialUuid = sql.NullString{String: link.MaterialID, Valid: true}
+	}
+
+	return s.q.CreateAttachment(ctx, params)
+}
+
+// the linked material or quiz has to exist in the same course
+func (s *Service) checkAttachmentLink(ctx context.Context, courseID string, link AttachmentLink) error {
+	if (link.MaterialID == "") == (link.QuizID == "") {
+		return ErrInvalidAttachment
+	}
+
+	if link.MaterialID != "" {
+		material, err := s.q.GetMaterial(ctx, link.MaterialID)
+		if err != nil {
+			if utils.IsNoRowsError(err) {
+				return ErrLinkedMaterialNotFound
+			}
+			return err
+		}
+		if material.CourseUuid != courseID {
+			return ErrLinkedMaterialNotFound
+		}
+		return nil
+	}
+
+	quizCourseID, err := s.q.GetCourseOfQuiz(ctx, link.QuizID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return ErrLinkedQuizNotFound
+		}
+		return err
+	}
+	if quizCourseID != courseID {
+		return ErrLinkedQuizNotFound
+	}
+	return nil
+}
+
+// returns the new attachment and tells the clients about the changed post
+func (s *Service) attachmentAdded(ctx context.Context, courseID string, post db.FeedPost, attachmentID string) (AttachmentResponse, error) {
+	resp, err := s.postChanged(ctx, courseID, post)
+	if err != nil {
+		return AttachmentResponse{}, err
+	}
+
+	for _, attachment := range resp.Attachments {
+		if attachment.UUID == attachmentID {
+			return attachment, nil
+		}
+	}
+	return AttachmentResponse{}, ErrAttachmentNotFound
+}
+
+func (s *Service) DeleteAttachment(ctx context.Context, courseID, postID, attachmentID string) error {
+	post, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return err
+	}
+
+	attachment, err := s.q.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return ErrAttachmentNotFound
+		}
+		return err
+	}
+	if attachment.PostUuid != postID {
+		return ErrAttachmentNotFound
+	}
+
+	err = s.q.DeleteAttachment(ctx, attachmentID)
+	if err != nil {
+		return err
+	}
+
+	if attachment.Path.Valid {
+		s.removeAttachmentFile(ctx, attachment.Path.String)
+	}
+
+	_, err = s.postChanged(ctx, courseID, post)
+	return err
+}
+
+// the post has to be a manual post of the course with room for another attachment
+func (s *Service) getAttachablePost(ctx context.Context, courseID, postID string) (db.FeedPost, error) {
+	post, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return db.FeedPost{}, err
+	}
+
+	if post.Type != "manual" {
+		return db.FeedPost{}, ErrPostNotAttachable
+	}
+
+	count, err := s.q.CountAttachmentsOfPost(ctx, postID)
+	if err != nil {
+		return db.FeedPost{}, err
+	}
+	if count >= int64(MAX_POST_ATTACHMENTS) {
+		return db.FeedPost{}, &utils.ErrBadRequest{Message: fmt.Sprintf("post can have at most %v attachments", MAX_POST_ATTACHMENTS)}
+	}
+
+	return post, nil
+}
+
+// sends the changed post to the clients when they can see it, returns it whole
+func (s *Service) postChanged(ctx context.Context, courseID string, post db.FeedPost) (FeedPostResponse, error) {
+	posts, err := s.postsToResponses(ctx, []db.FeedPost{post}, 0)
+	if err != nil {
+		return FeedPostResponse{}, err
+	}
+
+	if isPostVisible(post, time.Now().Unix()) {
+		s.broadcast(courseID, FeedEvent{Name: EVENT_POST_UPDATED, Data: posts[0]})
+	}
+
+	return posts[0], nil
+}
+
+// fills in the attachments of the posts
+func (s *Service) addAttachments(ctx context.Context, posts []FeedPostResponse) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(posts))
+	byID := make(map[string]*FeedPostResponse, len(posts))
+	for i := range posts {
+		ids = append(ids, posts[i].UUID)
+		byID[posts[i].UUID] = &posts[i]
+
+		posts[i].Attachments = []AttachmentResponse{}
+	}
+
+	rows, err := s.q.ListAttachmentsOfPosts(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		post := byID[row.PostUuid]
+		post.Attachments = append(post.Attachments, dbAttachmentToAttachment(row))
+	}
+
+	return nil
+}
+
+// removes the attached files of the post, the rows are deleted with the post
+func (s *Service) removeAttachmentFiles(ctx context.Context, postID string) error {
+	paths, err := s.q.ListAttachmentPathsOfPost(ctx, postID)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		s.removeAttachmentFile(ctx, path.String)
+	}
+	return nil
+}
+
+// a file that can't be removed is only logged, the attachment is gone either way
+func (s *Service) removeAttachmentFile(ctx context.Context, path string) {
+	err := s.storage.Remove(ctx, path)
+	if err != nil {
+		fmt.Println("failed to remove attached file:", err)
+	}
+}
@@ -0,0 +1,183 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+)
+
+//* brokers pass the feed events between the instances of the server,
+// every instance hands the events it creates to the broker and gets back the events of all instances (including its own)
+// numbered by one shared sequence, which is what makes Last-Event-ID work no matter which instance a client reconnects to
+
+type Broker interface {
+	// assigns the event its id and delivers it to the subscribers of every instance
+	Publish(ctx context.Context, courseID string, event FeedEvent) error
+
+	// starts delivering the published events to deliver, in the order of their ids,
+	// returns the id of the last event published before the start
+	Start(deliver func(courseID string, event FeedEvent)) (int64, error)
+
+	Close() error
+}
+
+//* MemoryBroker - a single instance of the server, events are delivered right away
+
+type MemoryBroker struct {
+	mux     sync.Mutex
+	lastID  int64
+	deliver func(courseID string, event FeedEvent)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	// starting from the current time keeps the ids growing across restarts,
+	// so an id from before a restart is recognized as too old to resume from
+	return &MemoryBroker{lastID: time.Now().UnixMicro()}
+}
+
+func (b *MemoryBroker) Start(deliver func(courseID string, event FeedEvent)) (int64, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.deliver = deliver
+	return b.lastID, nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, courseID string, event FeedEvent) error {
+	// the lock is held while delivering, otherwise two events could reach the clients in the wrong order
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+
+	if b.deliver != nil {
+		b.deliver(courseID, event)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+//* SQLiteBroker - several instances sharing one db file, events are written to the feed_event table
+// and every instance polls it, the autoincrement ids give the shared order (sqlite has a single writer at a time)
+
+// how often the instances look for new events, it's also the longest delay of an event
+var BROKER_POLL_INTERVAL = 250 * time.Millisecond
+
+// events older than this are deleted from the table, instances that were down longer than this skip them
+var BROKER_EVENT_RETENTION = 10 * time.Minute
+
+// max events read by one poll, the rest is read by the next one right away
+var BROKER_POLL_BATCH = 500
+
+type SQLiteBroker struct {
+	q *db.Queries
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewSQLiteBroker(queries *db.Queries) *SQLiteBroker {
+	return &SQLiteBroker{
+		q:    queries,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (b *SQLiteBroker) Start(deliver func(courseID string, event FeedEvent)) (int64, error) {
+	lastID, err := b.q.GetLastFeedEventId(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	b.started = true
+	go b.poll(lastID, deliver)
+
+	return lastID, nil
+}
+
+func (b *SQLiteBroker) Publish(ctx context.Context, courseID string, event FeedEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.q.CreateFeedEvent(ctx, db.CreateFeedEventParams{
+		CourseUuid: courseID,
+		Name:       event.Name,
+		Data:       string(data),
+		CreatedAt:  time.Now().Unix(),
+	})
+	return err
+}
+
+func (b *SQLiteBroker) poll(lastID int64, deliver func(courseID string, event FeedEvent)) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(BROKER_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	lastCleanup := time.Now()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx := context.Background()
+
+		for {
+			events, err := b.q.ListFeedEventsAfter(ctx, db.ListFeedEventsAfterParams{
+				ID:    lastID,
+				Limit: int64(BROKER_POLL_BATCH),
+			})
+			if err != nil {
+				fmt.Println("failed to poll feed events:", err)
+				break
+			}
+
+			for _, e := range events {
+				deliver(e.CourseUuid, FeedEvent{
+					ID:   e.ID,
+					Name: e.Name,
+					Data: json.RawMessage(e.Data),
+				})
+				lastID = e.ID
+			}
+
+			if len(events) < BROKER_POLL_BATCH {
+				break
+			}
+		}
+
+		// every instance cleans up, deleting the same rows twice doesn't hurt
+		if time.Since(lastCleanup) > BROKER_EVENT_RETENTION {
+			err := b.q.DeleteFeedEventsBefore(ctx, time.Now().Add(-BROKER_EVENT_RETENTION).Unix())
+			if err != nil {
+				fmt.Println("failed to delete old feed events:", err)
+			}
+			lastCleanup = time.Now()
+		}
+	}
+}
+
+func (b *SQLiteBroker) Close() error {
+	if !b.started {
+		return nil
+	}
+
+	close(b.stop)
+	<-b.done
+	return nil
+}
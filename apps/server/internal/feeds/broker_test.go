@@ -0,0 +1,113 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tourbackend/internal/database"
+)
+
+type delivered struct {
+	courseID string
+	event    FeedEvent
+}
+
+// two instances of the server, each with its own connection to the same db file
+func newSharedBrokers(t *testing.T) (*SQLiteBroker, *SQLiteBroker) {
+	interval := BROKER_POLL_INTERVAL
+	BROKER_POLL_INTERVAL = 10 * time.Millisecond
+	t.Cleanup(func() { BROKER_POLL_INTERVAL = interval })
+
+	t.Setenv("PATH_TO_DB", filepath.Join(t.TempDir(), "test.db"))
+	connA, queriesA := database.Initialize(true)
+	connB, queriesB := database.Initialize(false)
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+
+	a, b := NewSQLiteBroker(queriesA), NewSQLiteBroker(queriesB)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func startBroker(t *testing.T, b *SQLiteBroker) (int64, chan delivered) {
+	events := make(chan delivered, 16)
+	lastID, err := b.Start(func(courseID string, event FeedEvent) {
+		events <- delivered{courseID, event}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lastID, events
+}
+
+func receive(t *testing.T, events chan delivered) delivered {
+	select {
+	case d := <-events:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event was delivered")
+		return delivered{}
+	}
+}
+
+func TestSQLiteBrokerDeliversEventsToOtherInstance(t *testing.T) {
+	a, b := newSharedBrokers(t)
+	ctx := context.Background()
+
+	// an event from before the start is not delivered, Start only reports its id
+	err := a.Publish(ctx, "course-0", FeedEvent{Name: EVENT_POST_DELETED, Data: PostDeletedEvent{UUID: "old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastA, eventsA := startBroker(t, a)
+	lastB, eventsB := startBroker(t, b)
+	if lastA == 0 || lastA != lastB {
+		t.Fatalf("last ids at the start: %d and %d, want the same non zero id", lastA, lastB)
+	}
+
+	for _, uuid := range []string{"post-1", "post-2"} {
+		err := a.Publish(ctx, "course-1", FeedEvent{Name: EVENT_POST_DELETED, Data: PostDeletedEvent{UUID: uuid}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// both instances get both events, in the same order and with the same ids
+	for i, uuid := range []string{"post-1", "post-2"} {
+		gotB := receive(t, eventsB)
+		gotA := receive(t, eventsA)
+
+		if gotB.courseID != "course-1" || gotB.event.Name != EVENT_POST_DELETED {
+			t.Errorf("event %d on the other instance: course %q name %q", i, gotB.courseID, gotB.event.Name)
+		}
+		if want := lastB + int64(i) + 1; gotB.event.ID != want {
+			t.Errorf("event %d on the other instance: id %d, want %d", i, gotB.event.ID, want)
+		}
+		if gotA.event.ID != gotB.event.ID {
+			t.Errorf("event %d: id %d on the publishing instance, %d on the other one", i, gotA.event.ID, gotB.event.ID)
+		}
+
+		var data PostDeletedEvent
+		if err := json.Unmarshal(gotB.event.Data.(json.RawMessage), &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.UUID != uuid {
+			t.Errorf("event %d on the other instance: uuid %q, want %q", i, data.UUID, uuid)
+		}
+	}
+
+	select {
+	case d := <-eventsB:
+		t.Errorf("unexpected event %+v", d)
+	case <-time.After(5 * BROKER_POLL_INTERVAL):
+	}
+}
@@ -0,0 +1,302 @@
+package feeds
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+//* comments and reactions of the users on the manual posts of the lecturers,
+// students can interact with the posts of open courses only, lecturers (admins) with all of them and they moderate the comments
+
+// the only reactions accepted
+var REACTION_EMOJIS = []string{"👍", "❤️", "😂", "😮", "😢", "🎉"}
+
+// max length of a comment in characters
+var MAX_COMMENT_LENGTH = 2000
+
+// ListComments returns the comments of the post oldest first, the replies are in the same list and point to their parent,
+// students get hidden comments without the message
+func (s *Service) ListComments(ctx context.Context, courseID, postID string, isAdmin bool) ([]CommentResponse, error) {
+	_, err := s.getInteractivePost(ctx, courseID, postID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.q.ListCommentsOfPost(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make([]CommentResponse, 0, len(rows))
+	for _, row := range rows {
+		comments = append(comments, dbCommentToComment(db.GetCommentRow(row), isAdmin))
+	}
+
+	return comments, nil
+}
+
+func (s *Service) CreateComment(ctx context.Context, courseID, postID string, userID int, isAdmin bool, comment NewComment) (CommentResponse, error) {
+	post, err := s.getInteractivePost(ctx, courseID, postID, isAdmin)
+	if err != nil {
+		return CommentResponse{}, err
+	}
+
+	if post.IsLocked && !isAdmin {
+		return CommentResponse{}, ErrThreadLocked
+	}
+
+	message := strings.TrimSpace(comment.Message)
+	if message == "" {
+		return CommentResponse{}, ErrCommentEmpty
+	}
+	if utf8.RuneCountInString(message) > MAX_COMMENT_LENGTH {
+		return CommentResponse{}, &utils.ErrBadRequest{Message: fmt.Sprintf("comment can have at most %v characters", MAX_COMMENT_LENGTH)}
+	}
+
+	var parentID sql.NullString
+	if comment.ParentID != nil && *comment.ParentID != "" {
+		parent, err := s.q.GetComment(ctx, *comment.ParentID)
+		if err != nil {
+			if utils.IsNoRowsError(err) {
+				return CommentResponse{}, ErrInvalidParent
+			}
+			return CommentResponse{}, err
+		}
+
+		// replies can't be replied to, that keeps the threads one level deep
+		if parent.PostUuid != postID || parent.ParentUuid.Valid {
+			return CommentResponse{}, ErrInvalidParent
+		}
+
+		parentID = sql.NullString{String: parent.Uuid, Valid: true}
+	}
+
+	commentID := uuid.New().String()
+
+	err = s.q.CreateComment(ctx, db.CreateCommentParams{
+		Uuid:       commentID,
+		PostUuid:   postID,
+		ParentUuid: parentID,
+		UserID:     int64(userID),
+		Message:    message,
+		CreatedAt:  time.Now().Unix(),
+	})
+	if err != nil {
+		return CommentResponse{}, err
+	}
+
+	row, err := s.q.GetComment(ctx, commentID)
+	if err != nil {
+		return CommentResponse{}, err
+	}
+
+	resp := dbCommentToComment(row, isAdmin)
+
+	s.broadcast(courseID, FeedEvent{Name: EVENT_COMMENT_CREATED, Data: resp})
+
+	return resp, nil
+}
+
+// SetCommentHidden hides or shows again a comment, only for lecturers
+func (s *Service) SetCommentHidden(ctx context.Context, courseID, postID, commentID string, hidden bool) (CommentResponse, error) {
+	row, err := s.getCommentOfPost(ctx, courseID, postID, commentID)
+	if err != nil {
+		return CommentResponse{}, err
+	}
+
+	err = s.q.SetCommentHidden(ctx, db.SetCommentHiddenParams{
+		IsHidden: hidden,
+		Uuid:     commentID,
+	})
+	if err != nil {
+		return CommentResponse{}, err
+	}
+
+	row.IsHidden = hidden
+
+	// the stream is read by students too, so the event never carries the message of a hidden comment
+	s.broadcast(courseID, FeedEvent{Name: EVENT_COMMENT_UPDATED, Data: dbCommentToComment(row, false)})
+
+	return dbCommentToComment(row, true), nil
+}
+
+// DeleteComment deletes the comment with its replies, students can delete only their own comments
+func (s *Service) DeleteComment(ctx context.Context, courseID, postID, commentID string, userID int, isAdmin bool) error {
+	row, err := s.getCommentOfPost(ctx, courseID, postID, commentID)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		if int(row.UserID) != userID {
+			return ErrNotCommentAuthor
+		}
+
+		// the post may have been hidden or the course closed in the meantime
+		_, err := s.getInteractivePost(ctx, courseID, postID, isAdmin)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the replies are deleted by the foreign key (ON DELETE CASCADE)
+	err = s.q.DeleteComment(ctx, commentID)
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(courseID, FeedEvent{Name: EVENT_COMMENT_DELETED, Data: CommentDeletedEvent{UUID: commentID, PostUUID: postID}})
+
+	return nil
+}
+
+// SetReaction adds or removes one reaction of the user and returns the new counts of the post
+func (s *Service) SetReaction(ctx context.Context, courseID, postID string, userID int, isAdmin bool, emoji string, on bool) (map[string]int, error) {
+	if !slices.Contains(REACTION_EMOJIS, emoji) {
+		return nil, &utils.ErrBadRequest{Message: "reaction must be one of " + strings.Join(REACTION_EMOJIS, " ")}
+	}
+
+	_, err := s.getInteractivePost(ctx, courseID, postID, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if on {
+		err = s.q.AddReaction(ctx, db.AddReactionParams{
+			PostUuid:  postID,
+			UserID:    int64(userID),
+			Emoji:     emoji,
+			CreatedAt: time.Now().Unix(),
+		})
+	} else {
+		err = s.q.RemoveReaction(ctx, db.RemoveReactionParams{
+			PostUuid: postID,
+			UserID:   int64(userID),
+			Emoji:    emoji,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	posts := []FeedPostResponse{{UUID: postID}}
+	err = s.addActivity(ctx, posts, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.broadcast(courseID, FeedEvent{Name: EVENT_REACTIONS_CHANGED, Data: ReactionsChangedEvent{
+		PostUUID:  postID,
+		Reactions: posts[0].Reactions,
+	}})
+
+	return posts[0].Reactions, nil
+}
+
+// fills in the reaction and comment counts of the posts, and the reactions of the user when userID is not 0
+func (s *Service) addActivity(ctx context.Context, posts []FeedPostResponse, userID int) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(posts))
+	byID := make(map[string]*FeedPostResponse, len(posts))
+	for i := range posts {
+		ids = append(ids, posts[i].UUID)
+		byID[posts[i].UUID] = &posts[i]
+
+		posts[i].Reactions = map[string]int{}
+		posts[i].CommentCount = 0
+	}
+
+	reactions, err := s.q.CountReactionsOfPosts(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, r := range reactions {
+		byID[r.PostUuid].Reactions[r.Emoji] = int(r.ReactionCount)
+	}
+
+	comments, err := s.q.CountCommentsOfPosts(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		byID[c.PostUuid].CommentCount = int(c.CommentCount)
+	}
+
+	if userID == 0 {
+		return nil
+	}
+
+	own, err := s.q.ListReactionsOfUser(ctx, db.ListReactionsOfUserParams{
+		UserID:    int64(userID),
+		PostUuids: ids,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].MyReactions = []string{}
+	}
+	for _, r := range own {
+		byID[r.PostUuid].MyReactions = append(byID[r.PostUuid].MyReactions, r.Emoji)
+	}
+
+	return nil
+}
+
+// the post has to be a visible manual post, and students can interact only with the posts of open courses
+func (s *Service) getInteractivePost(ctx context.Context, courseID, postID string, isAdmin bool) (db.FeedPost, error) {
+	post, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return db.FeedPost{}, err
+	}
+
+	if !isAdmin {
+		if !isPostVisible(post, time.Now().Unix()) {
+			return db.FeedPost{}, ErrPostNotFound
+		}
+
+		if err := s.CheckCourseVisible(ctx, courseID, isAdmin); err != nil {
+			return db.FeedPost{}, err
+		}
+	}
+
+	if post.Type != "manual" {
+		return db.FeedPost{}, ErrPostNotInteractive
+	}
+
+	return post, nil
+}
+
+func (s *Service) getCommentOfPost(ctx context.Context, courseID, postID, commentID string) (db.GetCommentRow, error) {
+	_, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return db.GetCommentRow{}, err
+	}
+
+	row, err := s.q.GetComment(ctx, commentID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.GetCommentRow{}, ErrCommentNotFound
+		}
+		return db.GetCommentRow{}, err
+	}
+
+	if row.PostUuid != postID {
+		return db.GetCommentRow{}, ErrCommentNotFound
+	}
+
+	return row, nil
+}
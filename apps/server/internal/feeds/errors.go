@@ -1 +1,29 @@
 package feeds
+
+import "errors"
+
+var (
+	ErrPostNotFound   = errors.New("post not found")
+	ErrCourseNotFound = errors.New("course not found")
+	ErrInvalidCursor  = errors.New("invalid cursor")
+	ErrInvalidType    = errors.New("type must be manual or system")
+	ErrInvalidDate    = errors.New("from and to must be dates (2006-01-02) or times (2006-01-02T15:04:05Z)")
+
+	ErrInvalidTime          = errors.New("publishAt and expiresAt must be times (2006-01-02T15:04:05Z)")
+	ErrExpiresBeforePublish = errors.New("post must expire after it's published")
+	ErrPostAlreadyPublished = errors.New("post is already published, its publish time can't be changed")
+
+	ErrCommentNotFound    = errors.New("comment not found")
+	ErrCommentEmpty       = errors.New("comment can't be empty")
+	ErrInvalidParent      = errors.New("comments can only reply to top level comments of the same post")
+	ErrThreadLocked       = errors.New("comments of this post are locked")
+	ErrNotCommentAuthor   = errors.New("only the author or a lecturer can delete the comment")
+	ErrPostNotInteractive = errors.New("only manual posts can be commented on and reacted to")
+	ErrCourseNotOpen      = errors.New("course is not open")
+
+	ErrAttachmentNotFound     = errors.New("attachment not found")
+	ErrInvalidAttachment      = errors.New("attachment must link either a material (materialId) or a quiz (quizId)")
+	ErrLinkedMaterialNotFound = errors.New("material not found in this course")
+	ErrLinkedQuizNotFound     = errors.New("quiz not found in this course")
+	ErrPostNotAttachable      = errors.New("only manual posts can have attachments")
+)
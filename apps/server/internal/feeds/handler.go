@@ -1,15 +1,22 @@
 package feeds
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	db "tourbackend/internal/database/gen"
 	"tourbackend/internal/handlers"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 )
 
@@ -27,16 +34,105 @@ func NewHandler(pathToStatic string, service *Service, queries *db.Queries, isDe
 	}
 }
 
-// GET /courses/{courseId}/feed
+type ListFeedRequest struct {
+	Type   *string `query:"type"`
+	From   string  `query:"from"`
+	To     string  `query:"to"`
+	Search *string `query:"search"`
+
+	// scheduled and expired posts too, only for admins
+	IncludeHidden bool `query:"includeHidden"`
+
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+}
+
+// GET /courses/{courseId}/feed?type=&from=&to=&search=&includeHidden=&cursor=&limit=
+// the body stays an array of posts as in the spec, the cursor of the next page is sent in the X-Next-Cursor header
+// (and as a Link header), it's missing on the last page - and when neither cursor nor limit is given, all posts are returned
 func (h *Handler) GetCourseFeed(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
 	courseID := c.Param("courseId")
 
-	feed, err := h.service.GetFeed(c.Request().Context(), courseID)
+	var req ListFeedRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid query parameters")
+	}
+
+	filter := FeedFilter{
+		IncludeHidden: req.IncludeHidden && r.User != nil && r.User.IsAdmin,
+
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	}
+
+	if r.User != nil {
+		filter.ViewerID = r.User.ID
+	}
+
+	if req.Type != nil && *req.Type != "" {
+		if *req.Type != "manual" && *req.Type != "system" {
+			return r.Error(http.StatusBadRequest, ErrInvalidType.Error())
+		}
+		filter.Type = req.Type
+	}
+
+	if req.Search != nil && strings.TrimSpace(*req.Search) != "" {
+		filter.Search = req.Search
+	}
+
+	var ok bool
+	if filter.From, ok = parseFeedDate(req.From, false); !ok {
+		return r.Error(http.StatusBadRequest, ErrInvalidDate.Error())
+	}
+	if filter.To, ok = parseFeedDate(req.To, true); !ok {
+		return r.Error(http.StatusBadRequest, ErrInvalidDate.Error())
+	}
+
+	if err := h.service.CheckCourseVisible(r.Ctx, courseID, r.User != nil && r.User.IsAdmin); err != nil {
+		return h.visibilityError(r, err)
+	}
+
+	page, err := h.service.ListFeed(r.Ctx, courseID, filter)
 	if err != nil {
+		if err == ErrInvalidCursor {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, feed)
+	if page.NextCursor != "" {
+		next := *c.Request().URL
+		query := next.Query()
+		query.Set("cursor", page.NextCursor)
+		next.RawQuery = query.Encode()
+
+		c.Response().Header().Set("X-Next-Cursor", page.NextCursor)
+		c.Response().Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+
+	return c.JSON(http.StatusOK, page.Posts)
+}
+
+// accepts a time (RFC 3339) or a date, a date in "to" means the whole day is included
+func parseFeedDate(value string, endOfDay bool) (*time.Time, bool) {
+	if value == "" {
+		return nil, true
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, true
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, true
 }
 
 // POST /courses/{courseId}/feed
@@ -49,8 +145,42 @@ func (h *Handler) CreateFeedPost(c echo.Context) error {
 
 	fmt.Println("creating post: ", req.Message)
 
-	post, err := h.service.CreateManualPost(c.Request().Context(), courseID, req.Message)
+	publishAt, err := parsePostTime(req.PublishAt)
 	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+
+	expiresAt, err := parsePostTime(req.ExpiresAt)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+
+	links := make([]AttachmentLink, 0, len(req.Attachments))
+	for _, a := range req.Attachments {
+		link, err := attachmentLink(a)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		links = append(links, link)
+	}
+
+	post, err := h.service.CreateManualPost(c.Request().Context(), courseID, NewPost{
+		Message:     req.Message,
+		Pinned:      req.Pinned,
+		PublishAt:   publishAt,
+		ExpiresAt:   expiresAt,
+		Attachments: links,
+	})
+	if err != nil {
+		if err == ErrExpiresBeforePublish || err == ErrLinkedMaterialNotFound || err == ErrLinkedQuizNotFound {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+
+		var ebr *utils.ErrBadRequest
+		if errors.As(err, &ebr) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": ebr.Error()})
+		}
+
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
@@ -69,8 +199,33 @@ func (h *Handler) UpdateFeedPost(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
-	post, err := h.service.UpdatePost(c.Request().Context(), courseID, postID, req.Message)
+	changes := PostChanges{
+		Message: req.Message,
+		Pinned:  req.Pinned,
+		Locked:  req.Locked,
+	}
+
+	var err error
+	if changes.PublishAt, err = parsePostTime(req.PublishAt); err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	if req.ExpiresAt != nil && *req.ExpiresAt == "" {
+		changes.ClearExpiry = true
+	} else if changes.ExpiresAt, err = parsePostTime(req.ExpiresAt); err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	post, err := h.service.UpdatePost(c.Request().Context(), courseID, postID, changes)
 	if err != nil {
+		if err == ErrPostNotFound {
+			return r.Error(http.StatusNotFound, err.Error())
+		}
+
+		if err == ErrExpiresBeforePublish || err == ErrPostAlreadyPublished {
+			return r.Error(http.StatusBadRequest, err.Error())
+		}
+
 		var ebr *utils.ErrBadRequest
 		if errors.As(err, &ebr) {
 			return r.Error(http.StatusBadRequest, ebr.Error())
@@ -82,61 +237,382 @@ func (h *Handler) UpdateFeedPost(c echo.Context) error {
 	return c.JSON(http.StatusOK, post)
 }
 
+func parsePostTime(value *string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil, ErrInvalidTime
+	}
+	return &t, nil
+}
+
 // DELETE /courses/{courseId}/feed/{postId}
 func (h *Handler) DeleteFeedPost(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
 	courseID := c.Param("courseId")
 	postID := c.Param("postId")
 
 	err := h.service.DeletePost(c.Request().Context(), courseID, postID)
 	if err != nil {
+		if err == ErrPostNotFound {
+			return r.Error(http.StatusNotFound, err.Error())
+		}
+
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
+func attachmentLink(req AttachmentLinkRequest) (AttachmentLink, error) {
+	switch {
+	case req.Type == ATTACHMENT_MATERIAL && req.MaterialID != nil && *req.MaterialID != "":
+		return AttachmentLink{MaterialID: *req.MaterialID}, nil
+	case req.Type == ATTACHMENT_QUIZ && req.QuizID != nil && *req.QuizID != "":
+		return AttachmentLink{QuizID: *req.QuizID}, nil
+	}
+	return AttachmentLink{}, ErrInvalidAttachment
+}
+
+// maps the errors of attachments to responses
+func (h *Handler) attachmentError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrPostNotFound, ErrAttachmentNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrInvalidAttachment, ErrLinkedMaterialNotFound, ErrLinkedQuizNotFound, ErrPostNotAttachable:
+		return r.Error(http.StatusBadRequest, err.Error())
+	case uploads.ErrFileTooBig:
+		return r.Error(http.StatusBadRequest, "file is too big")
+	case uploads.ErrQuotaExceeded:
+		return r.Error(http.StatusRequestEntityTooLarge, err.Error())
+	}
+
+	if errors.Is(err, uploads.ErrFileTypeForbidden) {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+
+	return r.ServerError(err)
+}
+
+// POST /courses/{courseId}/feed/{postId}/attachments
+// a multipart form with the file, or json linking a material or a quiz of the course
+func (h *Handler) AddAttachment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	courseID := c.Param("courseId")
+	postID := c.Param("postId")
+
+	var attachment AttachmentResponse
+	var err error
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		file, ferr := c.FormFile("file")
+		if ferr != nil {
+			return r.Error(http.StatusBadRequest, "file is required")
+		}
+
+		attachment, err = h.service.AddFileAttachment(r.Ctx, courseID, postID, file)
+	} else {
+		var req AttachmentLinkRequest
+		if err := c.Bind(&req); err != nil {
+			return r.Error(http.StatusBadRequest, "invalid request body")
+		}
+
+		link, lerr := attachmentLink(req)
+		if lerr != nil {
+			return r.Error(http.StatusBadRequest, lerr.Error())
+		}
+
+		attachment, err = h.service.AddLinkAttachment(r.Ctx, courseID, postID, link)
+	}
+	if err != nil {
+		return h.attachmentError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, attachment)
+}
+
+// DELETE /courses/{courseId}/feed/{postId}/attachments/{attachmentId}
+func (h *Handler) DeleteAttachment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	err := h.service.DeleteAttachment(r.Ctx, c.Param("courseId"), c.Param("postId"), c.Param("attachmentId"))
+	if err != nil {
+		return h.attachmentError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GET /courses/{courseId}/feed/{postId}/attachments/{attachmentId}/file
+// the file is shown in the browser, with ?download it's saved instead
+func (h *Handler) DownloadAttachment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	isAdmin := r.User != nil && r.User.IsAdmin
+
+	file, err := h.service.GetAttachmentFile(r.Ctx, c.Param("courseId"), c.Param("postId"), c.Param("attachmentId"), isAdmin)
+	if err != nil {
+		if err == ErrCourseNotOpen {
+			return r.Error(http.StatusForbidden, err.Error())
+		}
+		return h.attachmentError(r, err)
+	}
+
+	disposition := "inline"
+	if c.QueryParams().Has("download") {
+		disposition = "attachment"
+	}
+	disposition = mime.FormatMediaType(disposition, map[string]string{"filename": file.Filename})
+
+	header := c.Response().Header()
+	// the response depends on the user, it must not be kept by shared caches
+	header.Set("Cache-Control", "private, no-cache")
+
+	signed, ok, err := h.service.signedFileUrl(file, disposition)
+	if err != nil {
+		return r.ServerError(err)
+	}
+	if ok {
+		header.Set("Cache-Control", "no-store")
+		return c.Redirect(http.StatusFound, signed)
+	}
+
+	content, err := h.service.storage.Open(r.Ctx, file.Key)
+	if err != nil {
+		if err == uploads.ErrFileNotFound {
+			return r.Error(http.StatusNotFound, "the attached file is missing")
+		}
+		return r.ServerError(err)
+	}
+	defer content.Close()
+
+	header.Set("Content-Type", file.MimeType)
+	header.Set("Content-Disposition", disposition)
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), "", file.ModTime, seeker)
+		return nil
+	}
+
+	header.Set("Accept-Ranges", "none")
+	return c.Stream(http.StatusOK, file.MimeType, content)
+}
+
+// the course doesn't exist or its feed isn't visible to the user
+func (h *Handler) visibilityError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrCourseNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrCourseNotOpen:
+		return r.Error(http.StatusForbidden, err.Error())
+	}
+	return r.ServerError(err)
+}
+
+// maps the errors of comments and reactions to responses
+func (h *Handler) commentError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrPostNotFound, ErrCommentNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrCommentEmpty, ErrInvalidParent, ErrPostNotInteractive:
+		return r.Error(http.StatusBadRequest, err.Error())
+	case ErrThreadLocked, ErrNotCommentAuthor, ErrCourseNotOpen:
+		return r.Error(http.StatusForbidden, err.Error())
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+
+	return r.ServerError(err)
+}
+
+// GET /courses/{courseId}/feed/{postId}/comments
+func (h *Handler) ListComments(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	isAdmin := r.User != nil && r.User.IsAdmin
+
+	comments, err := h.service.ListComments(r.Ctx, c.Param("courseId"), c.Param("postId"), isAdmin)
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, comments)
+}
+
+// POST /courses/{courseId}/feed/{postId}/comments
+func (h *Handler) CreateComment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req CreateCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	comment, err := h.service.CreateComment(r.Ctx, c.Param("courseId"), c.Param("postId"), r.User.ID, r.User.IsAdmin, NewComment{
+		Message:  req.Message,
+		ParentID: req.ParentID,
+	})
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// PATCH /courses/{courseId}/feed/{postId}/comments/{commentId}
+// hides or shows again the comment
+func (h *Handler) ModerateComment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req ModerateCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	comment, err := h.service.SetCommentHidden(r.Ctx, c.Param("courseId"), c.Param("postId"), c.Param("commentId"), req.Hidden)
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, comment)
+}
+
+// DELETE /courses/{courseId}/feed/{postId}/comments/{commentId}
+func (h *Handler) DeleteComment(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	err := h.service.DeleteComment(r.Ctx, c.Param("courseId"), c.Param("postId"), c.Param("commentId"), r.User.ID, r.User.IsAdmin)
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PUT /courses/{courseId}/feed/{postId}/reactions
+func (h *Handler) AddReaction(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req ReactionRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	reactions, err := h.service.SetReaction(r.Ctx, c.Param("courseId"), c.Param("postId"), r.User.ID, r.User.IsAdmin, req.Emoji, true)
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, reactions)
+}
+
+// DELETE /courses/{courseId}/feed/{postId}/reactions?emoji=
+func (h *Handler) RemoveReaction(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	reactions, err := h.service.SetReaction(r.Ctx, c.Param("courseId"), c.Param("postId"), r.User.ID, r.User.IsAdmin, c.QueryParam("emoji"), false)
+	if err != nil {
+		return h.commentError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, reactions)
+}
+
+// how often a comment is sent over an idle stream, keeps proxies from closing the connection
+var HEARTBEAT_INTERVAL = 15 * time.Second
+
+// how long the browser waits before reconnecting a dropped stream (sent as the retry field)
+var RECONNECT_DELAY = 3 * time.Second
+
 // GET /courses/{courseId}/feed/stream
+// a reconnecting client sends the id of the last event it got in the Last-Event-ID header
+// (or the lastEventId query parameter) and first gets the events it missed
 func (h *Handler) StreamFeed(c echo.Context) error {
+	r := h.NewReqCtx(c)
 
 	// fmt.Println("in streamFeed")
 
 	courseID := c.Param("courseId")
 
-	// 1. Set SSE Headers
+	if err := h.service.CheckCourseVisible(r.Ctx, courseID, r.User != nil && r.User.IsAdmin); err != nil {
+		return h.visibilityError(r, err)
+	}
+
+	lastEventIDString := cmp.Or(c.Request().Header.Get("Last-Event-ID"), c.QueryParam("lastEventId"))
+
+	var lastEventID int64
+	if lastEventIDString != "" {
+		var err error
+		lastEventID, err = strconv.ParseInt(lastEventIDString, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Last-Event-ID must be a number"})
+		}
+	}
+
+	// 1. Flush keeps the connection open
+	flusher, ok := c.Response().Writer.(http.Flusher)
+	if !ok {
+		return c.JSON(http.StatusInternalServerError, "Streaming not supported")
+	}
+
+	// 2. Set SSE Headers
 	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
 	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
 	c.Response().Header().Set(echo.HeaderConnection, "keep-alive")
+	c.Response().Header().Set("X-Accel-Buffering", "no") // nginx would otherwise buffer the stream
 	c.Response().WriteHeader(http.StatusOK)
 
-	// 2. Subscribe to the service
-	msgChan := h.service.StreamFeed(c.Request().Context(), courseID)
+	// 3. Subscribe to the service
+	missed, msgChan := h.service.StreamFeed(c.Request().Context(), courseID, lastEventID)
 
 	// Ensure cleanup when client disconnects
 	defer h.service.EndStream(courseID, msgChan)
 
-	// 3. Listen for events or context cancellation
-	// Flush keeps the connection open
-	flusher, ok := c.Response().Writer.(http.Flusher)
-	if !ok {
-		return c.JSON(http.StatusInternalServerError, "Streaming not supported")
-	}
+	// Send an initial comment to establish connection and tell the browser how long to wait before reconnecting
+	fmt.Fprintf(c.Response(), ": connected\n")
+	fmt.Fprintf(c.Response(), "retry: %d\n\n", RECONNECT_DELAY.Milliseconds())
 
-	// Send an initial ping or comment to establish connection (optional)
-	fmt.Fprintf(c.Response(), ": connected\n\n")
+	for _, event := range missed {
+		writeEvent(c.Response(), event)
+	}
 	flusher.Flush()
 
+	heartbeat := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	// 4. Listen for events or context cancellation
 	for {
 		select {
-		case post := <-msgChan:
-			// fmt.Println("sending msg")
-			// Format:
-			// event: new_post
-			// data: {...json...}
-			//
-
-			data, _ := json.Marshal(post)
-			fmt.Fprintf(c.Response(), "event: new_post\n")
-			fmt.Fprintf(c.Response(), "data: %s\n\n", data)
+		case event, ok := <-msgChan:
+			if !ok {
+				// the client couldn't keep up and was dropped by the service, the browser reconnects with Last-Event-ID
+				return nil
+			}
+
+			// presence is only sent over the websocket
+			if event.Name == EVENT_PRESENCE {
+				continue
+			}
+
+			writeEvent(c.Response(), event)
+			flusher.Flush()
+
+		case <-heartbeat.C:
+			fmt.Fprintf(c.Response(), ": heartbeat\n\n")
 			flusher.Flush()
 
 		case <-c.Request().Context().Done():
@@ -145,3 +621,21 @@ func (h *Handler) StreamFeed(c echo.Context) error {
 		}
 	}
 }
+
+// Format:
+// id: 1737000000000001
+// event: post_created
+// data: {...json...}
+func writeEvent(w io.Writer, event FeedEvent) {
+	data, _ := json.Marshal(event.Data)
+
+	fmt.Fprintf(w, "id: %d\n", event.ID)
+	fmt.Fprintf(w, "event: %s\n", event.Name)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+
+	if event.Name == EVENT_POST_CREATED {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+		fmt.Fprintf(w, "event: %s\n", EVENT_LEGACY_NEW_POST)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+}
@@ -1,7 +1,10 @@
 package feeds
 
 import (
+	"time"
+
 	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 )
 
@@ -10,30 +13,320 @@ type FeedPostResponse struct {
 	Type      string `json:"type"` // "manual" or "auto"
 	Message   string `json:"message"`
 	Edited    bool   `json:"edited"`
+	Pinned    bool   `json:"pinned"`
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
+
+	// publishAt is in the future and published false for scheduled posts, only lecturers see those
+	PublishAt string  `json:"publishAt"`
+	Published bool    `json:"published"`
+	ExpiresAt *string `json:"expiresAt"`
+
+	// no new comments from students
+	Locked       bool           `json:"locked"`
+	CommentCount int            `json:"commentCount"`
+	Reactions    map[string]int `json:"reactions"` // count of each emoji
+	// reactions of the logged in user, only when listing the feed
+	MyReactions []string `json:"myReactions,omitempty"`
+
+	Attachments []AttachmentResponse `json:"attachments"`
 }
 
-type InfoFeedPostResponse struct {
-	Type string `json:"type"` // "info"
+// a file uploaded to the post or a link to a material or quiz of the course, the fields of the other types are left out
+type AttachmentResponse struct {
+	UUID string `json:"uuid"`
+	Type string `json:"type"` // "file", "material" or "quiz"
+	Name string `json:"name"` // file name, name of the material or title of the quiz
+
+	// file - relative to the server (/api/static/...), material - the file or the link of the material, quiz - missing
+	Url *string `json:"url,omitempty"`
+
+	MimeType  *string `json:"mimeType,omitempty"`
+	SizeBytes *int64  `json:"sizeBytes,omitempty"`
+
+	MaterialUUID *string `json:"materialUuid,omitempty"`
+	MaterialType *string `json:"materialType,omitempty"` // "file" or "url"
+	QuizUUID     *string `json:"quizUuid,omitempty"`
 }
 
-type CreatePostRequest struct {
+type CommentAuthor struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type CommentResponse struct {
+	UUID       string        `json:"uuid"`
+	PostUUID   string        `json:"postUuid"`
+	ParentUUID *string       `json:"parentUuid"` // set for replies
+	Author     CommentAuthor `json:"author"`
+	Message    string        `json:"message"` // empty for students when hidden
+	Hidden     bool          `json:"hidden"`
+	CreatedAt  string        `json:"createdAt"`
+}
+
+//* events sent to the clients of the SSE stream and the websocket, the name of the event says which payload the data contains:
+//  post_created   - FeedPostResponse
+//  post_updated   - FeedPostResponse
+//  post_deleted   - PostDeletedEvent
+//  course_changed - CourseChangedEvent (the course, its modules, materials or quizzes changed - reload it)
+//  resync         - ResyncEvent (the events missed since Last-Event-ID are not known anymore - reload the feed and the course)
+//  quiz_submitted - QuizSubmittedEvent
+//  comment_created   - CommentResponse
+//  comment_updated   - CommentResponse (hidden or shown again by a lecturer, the message of hidden comments is left out)
+//  comment_deleted   - CommentDeletedEvent (the replies of the comment are deleted with it)
+//  reactions_changed - ReactionsChangedEvent
+//  presence       - PresenceEvent (websocket only, who is connected to the course right now)
+// every event except presence has an id, a reconnecting client sends the last one in the Last-Event-ID header to get the events it missed
+
+const (
+	EVENT_POST_CREATED   = "post_created"
+	EVENT_POST_UPDATED   = "post_updated"
+	EVENT_POST_DELETED   = "post_deleted"
+	EVENT_COURSE_CHANGED = "course_changed"
+	EVENT_RESYNC         = "resync"
+	EVENT_QUIZ_SUBMITTED = "quiz_submitted"
+	EVENT_PRESENCE       = "presence"
+
+	EVENT_COMMENT_CREATED   = "comment_created"
+	EVENT_COMMENT_UPDATED   = "comment_updated"
+	EVENT_COMMENT_DELETED   = "comment_deleted"
+	EVENT_REACTIONS_CHANGED = "reactions_changed"
+)
+
+// the websocket clients choose which of these channels of a course they want,
+// every event belongs to one of them
+const (
+	CHANNEL_FEED     = "feed"
+	CHANNEL_QUIZ     = "quiz"
+	CHANNEL_PRESENCE = "presence"
+)
+
+func eventChannel(eventName string) string {
+	switch eventName {
+	case EVENT_QUIZ_SUBMITTED:
+		return CHANNEL_QUIZ
+	case EVENT_PRESENCE:
+		return CHANNEL_PRESENCE
+	}
+	return CHANNEL_FEED
+}
+
+// clients written against the original spec listen for new_post, it's sent along with post_created
+const EVENT_LEGACY_NEW_POST = "new_post"
+
+type FeedEvent struct {
+	ID   int64
+	Name string
+	Data any
+}
+
+type PostDeletedEvent struct {
+	UUID string `json:"uuid"`
+}
+
+type CourseChangedEvent struct {
 	Message string `json:"message"`
 }
 
+type ResyncEvent struct{}
+
+type CommentDeletedEvent struct {
+	UUID     string `json:"uuid"`
+	PostUUID string `json:"postUuid"`
+}
+
+type ReactionsChangedEvent struct {
+	PostUUID  string         `json:"postUuid"`
+	Reactions map[string]int `json:"reactions"`
+}
+
+// who submitted is left out on purpose, every student of the course gets the event
+type QuizSubmittedEvent struct {
+	QuizUUID string `json:"quizUuid"`
+	Score    int    `json:"score"`
+	MaxScore int    `json:"maxScore"`
+}
+
+type PresenceUser struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+type PresenceEvent struct {
+	Users []PresenceUser `json:"users"`
+}
+
+// filter of the posts of a course, nil fields don't filter
+type FeedFilter struct {
+	Type   *string // "manual" or "system"
+	From   *time.Time
+	To     *time.Time // exclusive
+	Search *string
+
+	// scheduled and expired posts too, for the lecturers
+	IncludeHidden bool
+
+	// the logged in user whose reactions are listed in MyReactions, 0 for none
+	ViewerID int
+
+	Cursor string // NextCursor of the previous page, empty for the first page
+	Limit  int
+}
+
+type FeedPage struct {
+	Posts []FeedPostResponse
+
+	// empty on the last page
+	NextCursor string
+}
+
+type CreatePostRequest struct {
+	Message   string  `json:"message"`
+	Pinned    bool    `json:"pinned"`
+	PublishAt *string `json:"publishAt"` // missing or in the past - published right away
+	ExpiresAt *string `json:"expiresAt"`
+
+	// links to materials and quizzes, files are uploaded to the created post
+	Attachments []AttachmentLinkRequest `json:"attachments"`
+}
+
+type AttachmentLinkRequest struct {
+	Type       string  `json:"type"` // "material" or "quiz"
+	MaterialID *string `json:"materialId"`
+	QuizID     *string `json:"quizId"`
+}
+
+// nil fields are left as they are
 type UpdatePostRequest struct {
-	Message string `json:"message"`
-	Edited  bool   `json:"edited"` // Often ignored in logic, but present in spec
+	Message   *string `json:"message"`
+	Edited    bool    `json:"edited"` // Often ignored in logic, but present in spec
+	Pinned    *bool   `json:"pinned"`
+	PublishAt *string `json:"publishAt"` // only for posts that are not published yet
+	ExpiresAt *string `json:"expiresAt"` // an empty string removes the expiry
+	Locked    *bool   `json:"locked"`
+}
+
+type NewPost struct {
+	Message     string
+	Pinned      bool
+	PublishAt   *time.Time
+	ExpiresAt   *time.Time
+	Attachments []AttachmentLink
+}
+
+// link to a material or a quiz of the course, exactly one of the ids is set
+type AttachmentLink struct {
+	MaterialID string
+	QuizID     string
+}
+
+// nil fields are left as they are
+type PostChanges struct {
+	Message     *string
+	Pinned      *bool
+	PublishAt   *time.Time
+	ExpiresAt   *time.Time
+	ClearExpiry bool
+	Locked      *bool
+}
+
+type CreateCommentRequest struct {
+	Message  string  `json:"message"`
+	ParentID *string `json:"parentId"` // a top level comment of the same post, for replies
+}
+
+type NewComment struct {
+	Message  string
+	ParentID *string
+}
+
+type ModerateCommentRequest struct {
+	Hidden bool `json:"hidden"`
+}
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
 }
 
 func dbFeedPostToFeedPost(dbFeedPost db.FeedPost) FeedPostResponse {
-	return FeedPostResponse{
+	post := FeedPostResponse{
 		UUID:      dbFeedPost.Uuid,
 		Type:      dbFeedPost.Type,
 		Message:   dbFeedPost.Message,
 		Edited:    dbFeedPost.IsEdited,
+		Pinned:    dbFeedPost.IsPinned,
 		CreatedAt: utils.UnixToIso(dbFeedPost.CreatedAt),
 		UpdatedAt: utils.UnixToIso(dbFeedPost.UpdatedAt),
+		PublishAt: utils.UnixToIso(dbFeedPost.PublishAt),
+		Published: dbFeedPost.IsPublished,
+		Locked:    dbFeedPost.IsLocked,
+		Reactions: map[string]int{},
+
+		Attachments: []AttachmentResponse{},
+	}
+
+	if dbFeedPost.ExpiresAt.Valid {
+		expiresAt := utils.UnixToIso(dbFeedPost.ExpiresAt.Int64)
+		post.ExpiresAt = &expiresAt
 	}
+
+	return post
+}
+
+func dbCommentToComment(row db.GetCommentRow, withHidden bool) CommentResponse {
+	comment := CommentResponse{
+		UUID:     row.Uuid,
+		PostUUID: row.PostUuid,
+		Author: CommentAuthor{
+			ID:        int(row.UserID),
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+		},
+		Message:   row.Message,
+		Hidden:    row.IsHidden,
+		CreatedAt: utils.UnixToIso(row.CreatedAt),
+	}
+
+	if row.ParentUuid.Valid {
+		comment.ParentUUID = &row.ParentUuid.String
+	}
+
+	if row.IsHidden && !withHidden {
+		comment.Message = ""
+	}
+
+	return comment
+}
+
+// the urls of files are asked from the storage, linked file materials are downloaded through the server
+func dbAttachmentToAttachment(row db.ListAttachmentsOfPostsRow) AttachmentResponse {
+	attachment := AttachmentResponse{
+		UUID: row.Uuid,
+		Type: row.Type,
+	}
+
+	switch row.Type {
+	case ATTACHMENT_FILE:
+		attachment.Name = row.Name.String
+		url := uploads.AttachmentFileUrl(row.PostCourseUuid, row.PostUuid, row.Uuid)
+		attachment.Url = &url
+		attachment.MimeType = &row.MimeType.String
+		attachment.SizeBytes = &row.ByteSize.Int64
+	case ATTACHMENT_MATERIAL:
+		attachment.Name = row.MaterialName.String
+		url := row.MaterialUrl.String
+		if row.MaterialType.String == "file" {
+			url = uploads.MaterialFileUrl(row.MaterialCourseUuid.String, row.MaterialUuid.String)
+		}
+		attachment.Url = &url
+		attachment.MaterialUUID = &row.MaterialUuid.String
+		attachment.MaterialType = &row.MaterialType.String
+	case ATTACHMENT_QUIZ:
+		attachment.Name = row.QuizTitle.String
+		attachment.QuizUUID = &row.QuizUuid.String
+	}
+
+	return attachment
 }
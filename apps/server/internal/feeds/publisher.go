@@ -0,0 +1,98 @@
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+)
+
+//* the publisher publishes the scheduled posts once their time comes and hides the expired ones,
+// the clients of the stream get post_created and post_deleted like for posts created and deleted by hand
+
+// how often the publisher looks for due posts, it's also the longest delay of a scheduled post
+var PUBLISHER_INTERVAL = 5 * time.Second
+
+type Publisher struct {
+	service *Service
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewPublisher(service *Service) *Publisher {
+	return &Publisher{
+		service: service,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *Publisher) Start() {
+	p.started = true
+	go p.run()
+}
+
+func (p *Publisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(PUBLISHER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		// posts that became due while the server was down are published right after the start
+		p.service.publishDuePosts(context.Background())
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Publisher) Close() error {
+	if !p.started {
+		return nil
+	}
+
+	close(p.stop)
+	<-p.done
+	return nil
+}
+
+// marking the posts and getting them back is one statement, so with several instances each post is broadcast once
+func (s *Service) publishDuePosts(ctx context.Context) {
+	now := time.Now().Unix()
+
+	published, err := s.q.PublishDuePosts(ctx, now)
+	if err != nil {
+		fmt.Println("failed to publish scheduled posts:", err)
+	}
+
+	for _, post := range published {
+		// when the server was down the post may have expired already
+		if !isPostVisible(post, now) {
+			continue
+		}
+
+		// the attachments and reactions were added while the post was scheduled
+		posts, err := s.postsToResponses(ctx, []db.FeedPost{post}, 0)
+		if err != nil {
+			fmt.Println("failed to load published post:", err)
+			continue
+		}
+		s.broadcast(post.CourseUuid, FeedEvent{Name: EVENT_POST_CREATED, Data: posts[0]})
+	}
+
+	expired, err := s.q.ExpireDuePosts(ctx, now)
+	if err != nil {
+		fmt.Println("failed to expire posts:", err)
+	}
+
+	for _, post := range expired {
+		s.broadcast(post.CourseUuid, FeedEvent{Name: EVENT_POST_DELETED, Data: PostDeletedEvent{UUID: post.Uuid}})
+	}
+}
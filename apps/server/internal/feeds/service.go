@@ -2,51 +2,149 @@ package feeds
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/uploads"
 	"tourbackend/internal/utils"
 
 	"github.com/google/uuid"
 )
 
+// how many of the latest events of each course are kept for clients resuming with Last-Event-ID
+var REPLAY_BUFFER_SIZE = 100
+
+// events waiting to be written to one client, a client that falls this far behind is disconnected
+// and catches up from the replay buffer after reconnecting
+var CLIENT_BUFFER_SIZE = 64
+
+// default and max number of posts in one page of the feed, the page size is the default
+// only when a cursor is given - a request without a cursor and a limit gets all the posts as before there were pages
+var FEED_PAGE_SIZE = 20
+var MAX_FEED_PAGE_SIZE = 100
+
 type Service struct {
-	q          *db.Queries
-	staticPath string
+	q       *db.Queries
+	storage uploads.Storage
 
 	// SSE: Mutex for thread-safe access to clients map
 	// Map key is courseID, value is a list of channels for connected clients
 	clientsMux sync.RWMutex
-	clients    map[string][]chan FeedPostResponse
+	clients    map[string][]chan FeedEvent
+
+	// passes the events between the instances of the server and numbers them
+	broker Broker
+
+	// id of the last event before this instance started and of the last delivered event, guarded by clientsMux
+	startEventID int64
+	lastEventID  int64
+
+	// latest events of each course, oldest first, and the id of the newest event dropped from them,
+	// guarded by clientsMux
+	history        map[string][]FeedEvent
+	trimmedEventID map[string]int64
+
+	// users connected to each course through the websocket of this instance, guarded by clientsMux
+	presence map[string]map[int]*presenceEntry
+
+	// get the events broadcast by this instance, added before the server starts
+	hooks []EventHook
+}
+
+// EventHook is called with every event this instance broadcasts (not with the events of the other instances),
+// it's called synchronously so it should only queue the event
+type EventHook func(courseID string, event FeedEvent)
+
+type presenceEntry struct {
+	user        PresenceUser
+	connections int // the same user can be connected from several tabs
 }
 
-func NewService(queries *db.Queries, staticPath string) *Service {
-	return &Service{
-		q:          queries,
-		staticPath: staticPath,
-		clients:    make(map[string][]chan FeedPostResponse),
+func NewService(queries *db.Queries, storage uploads.Storage, broker Broker) (*Service, error) {
+	s := &Service{
+		q:       queries,
+		storage: storage,
+		clients: make(map[string][]chan FeedEvent),
+
+		broker:         broker,
+		history:        make(map[string][]FeedEvent),
+		trimmedEventID: make(map[string]int64),
+		presence:       make(map[string]map[int]*presenceEntry),
 	}
+
+	// hold the lock so that no event is delivered before the start id is set
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+
+	startEventID, err := broker.Start(s.deliver)
+	if err != nil {
+		return nil, err
+	}
+
+	s.startEventID = startEventID
+	s.lastEventID = startEventID
+
+	return s, nil
 }
 
 // --- SSE Helpers ---
 
-// subscribe adds a client channel to a specific course
-func (s *Service) subscribe(courseID string) chan FeedPostResponse {
+// subscribe adds a client channel to a specific course,
+// when lastEventID is not 0 the events the client missed since then are returned as well
+// (or a single resync event when they are no longer in the replay buffer)
+func (s *Service) subscribe(courseID string, lastEventID int64) ([]FeedEvent, chan FeedEvent) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
-	ch := make(chan FeedPostResponse, 10) // Buffer to prevent blocking
+	ch := make(chan FeedEvent, CLIENT_BUFFER_SIZE) // Buffer to prevent blocking
 	s.clients[courseID] = append(s.clients[courseID], ch)
-	return ch
+
+	if lastEventID == 0 {
+		return nil, ch
+	}
+
+	return s.missedEvents(courseID, lastEventID), ch
+}
+
+// expects clientsMux to be held
+func (s *Service) missedEvents(courseID string, lastEventID int64) []FeedEvent {
+
+	// ids before the start come from before a restart and ids after the last one were not issued by this server,
+	// in both cases it's unknown what the client missed - same as when the missed events were already dropped from the buffer
+	if lastEventID < s.startEventID || lastEventID > s.lastEventID || lastEventID < s.trimmedEventID[courseID] {
+		return []FeedEvent{s.resyncEvent()}
+	}
+
+	missed := make([]FeedEvent, 0)
+	for _, event := range s.history[courseID] {
+		if event.ID > lastEventID {
+			missed = append(missed, event)
+		}
+	}
+	return missed
+}
+
+func (s *Service) resyncEvent() FeedEvent {
+	return FeedEvent{ID: s.lastEventID, Name: EVENT_RESYNC, Data: ResyncEvent{}}
 }
 
 // unsubscribe removes a client channel
-func (s *Service) unsubscribe(courseID string, ch chan FeedPostResponse) {
+func (s *Service) unsubscribe(courseID string, ch chan FeedEvent) {
 	s.clientsMux.Lock()
 	defer s.clientsMux.Unlock()
 
+	s.removeClient(courseID, ch)
+}
+
+// expects clientsMux to be held for writing
+func (s *Service) removeClient(courseID string, ch chan FeedEvent) {
 	channels := s.clients[courseID]
 	for i, c := range channels {
 		if c == ch {
@@ -58,61 +156,310 @@ func (s *Service) unsubscribe(courseID string, ch chan FeedPostResponse) {
 	}
 }
 
-// broadcast sends a new post to all clients listening to that course
-func (s *Service) broadcast(courseID string, post FeedPostResponse) {
-	s.clientsMux.RLock()
-	defer s.clientsMux.RUnlock()
-
+// broadcast hands the event to the broker which delivers it to the clients of all instances
+func (s *Service) broadcast(courseID string, event FeedEvent) {
 	// fmt.Println("broadcasting!")
 
-	for _, ch := range s.clients[courseID] {
+	err := s.broker.Publish(context.Background(), courseID, event)
+	if err != nil {
+		fmt.Println("failed to publish feed event:", err)
+	}
+
+	for _, hook := range s.hooks {
+		hook(courseID, event)
+	}
+}
+
+// AddEventHook registers a hook for the broadcast events, it's not safe to call once the server runs
+func (s *Service) AddEventHook(hook EventHook) {
+	s.hooks = append(s.hooks, hook)
+}
+
+// deliver keeps the numbered event for replay and sends it to all clients of this instance listening to that course
+func (s *Service) deliver(courseID string, event FeedEvent) {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+
+	s.lastEventID = event.ID
+
+	history := append(s.history[courseID], event)
+	if len(history) > REPLAY_BUFFER_SIZE {
+		dropped := len(history) - REPLAY_BUFFER_SIZE
+		s.trimmedEventID[courseID] = history[dropped-1].ID
+		history = append([]FeedEvent(nil), history[dropped:]...)
+	}
+	s.history[courseID] = history
+
+	s.sendToClients(courseID, event)
+}
+
+// expects clientsMux to be held for writing
+func (s *Service) sendToClients(courseID string, event FeedEvent) {
+	// iterate over a copy, lagging clients are removed from the slice along the way
+	for _, ch := range append([]chan FeedEvent(nil), s.clients[courseID]...) {
 
 		select {
-		case ch <- post:
+		case ch <- event:
 			// fmt.Println("chose this one?")
 		default:
-			// Skip if channel is full to prevent blocking the whole server
+			// the client can't keep up, blocking here would block the whole server and skipping the event would lose it,
+			// so the client is disconnected and resumes from the replay buffer with Last-Event-ID
+			s.removeClient(courseID, ch)
 		}
 	}
 }
 
+// --- Presence ---
+// presence is only known to the instance the users are connected to, it's not passed through the broker
+
+// JoinPresence marks the user as connected to the course and tells the other clients of the course
+func (s *Service) JoinPresence(courseID string, user PresenceUser) {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+
+	users, ok := s.presence[courseID]
+	if !ok {
+		users = make(map[int]*presenceEntry)
+		s.presence[courseID] = users
+	}
+
+	entry, ok := users[user.ID]
+	if !ok {
+		entry = &presenceEntry{user: user}
+		users[user.ID] = entry
+	}
+	entry.connections++
+
+	s.sendToClients(courseID, s.presenceEvent(courseID))
+}
+
+func (s *Service) LeavePresence(courseID string, userID int) {
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+
+	entry, ok := s.presence[courseID][userID]
+	if !ok {
+		return
+	}
+
+	entry.connections--
+	if entry.connections <= 0 {
+		delete(s.presence[courseID], userID)
+	}
+	if len(s.presence[courseID]) == 0 {
+		delete(s.presence, courseID)
+	}
+
+	s.sendToClients(courseID, s.presenceEvent(courseID))
+}
+
+// expects clientsMux to be held
+func (s *Service) presenceEvent(courseID string) FeedEvent {
+	users := make([]PresenceUser, 0, len(s.presence[courseID]))
+	for _, entry := range s.presence[courseID] {
+		users = append(users, entry.user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return FeedEvent{Name: EVENT_PRESENCE, Data: PresenceEvent{Users: users}}
+}
+
 // --- DB Logic ---
 
-func (s *Service) GetFeed(ctx context.Context, courseID string) ([]FeedPostResponse, error) {
-	posts, err := s.q.GetPostsByCourse(ctx, courseID)
+// ListFeed returns one page of the posts of the course, pinned first and then the newest, matching the filter,
+// or all of them when the filter has neither a cursor nor a limit
+func (s *Service) ListFeed(ctx context.Context, courseID string, filter FeedFilter) (FeedPage, error) {
+
+	paginated := filter.Cursor != "" || filter.Limit > 0
+	if filter.Limit <= 0 {
+		filter.Limit = FEED_PAGE_SIZE
+	}
+	filter.Limit = min(filter.Limit, MAX_FEED_PAGE_SIZE)
+
+	params := db.ListPostsOfCourseParams{
+		CourseUuid:    courseID,
+		IncludeHidden: filter.IncludeHidden,
+		Now:           time.Now().Unix(),
+		Type:          utils.ToSqlNullString(filter.Type),
+		// a negative limit is no limit in sqlite
+		Limit: -1,
+	}
+	if paginated {
+		// one more than the page to know whether there is a next page
+		params.Limit = int64(filter.Limit + 1)
+	}
+
+	if filter.From != nil {
+		params.From = sql.NullInt64{Int64: filter.From.Unix(), Valid: true}
+	}
+	if filter.To != nil {
+		params.To = sql.NullInt64{Int64: filter.To.Unix(), Valid: true}
+	}
+
+	if filter.Search != nil {
+		search := ftsQuery(*filter.Search)
+		if search != "" {
+			params.Search = sql.NullString{String: search, Valid: true}
+		}
+	}
+
+	if filter.Cursor != "" {
+		pinned, publishAt, postID, err := decodeFeedCursor(filter.Cursor)
+		if err != nil {
+			return FeedPage{}, err
+		}
+		params.CursorPinned = sql.NullBool{Bool: pinned, Valid: true}
+		params.CursorPublishAt = sql.NullInt64{Int64: publishAt, Valid: true}
+		params.CursorUuid = sql.NullString{String: postID, Valid: true}
+	}
+
+	posts, err := s.q.ListPostsOfCourse(ctx, params)
 	if err != nil {
 		fmt.Println(err)
-		return nil, err
+		return FeedPage{}, err
+	}
+
+	page := FeedPage{Posts: make([]FeedPostResponse, 0, len(posts))}
+
+	if paginated && len(posts) > filter.Limit {
+		posts = posts[:filter.Limit]
+		last := posts[len(posts)-1]
+		page.NextCursor = encodeFeedCursor(last.IsPinned, last.PublishAt, last.Uuid)
 	}
 
-	response := make([]FeedPostResponse, 0, 5)
+	page.Posts, err = s.postsToResponses(ctx, posts, filter.ViewerID)
+	if err != nil {
+		return FeedPage{}, err
+	}
+
+	return page, nil
+}
+
+// converts the posts with their attachments, reactions and comment counts, the reactions of the viewer are added when it's not 0
+func (s *Service) postsToResponses(ctx context.Context, posts []db.FeedPost, viewerID int) ([]FeedPostResponse, error) {
+	resp := make([]FeedPostResponse, 0, len(posts))
 	for _, p := range posts {
-		response = append(response, dbFeedPostToFeedPost(p))
+		resp = append(resp, dbFeedPostToFeedPost(p))
+	}
+
+	err := s.addAttachments(ctx, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.addActivity(ctx, resp, viewerID)
+	if err != nil {
+		return nil, err
 	}
 
-	// fmt.Println(response)
-	return response, nil
+	return resp, nil
 }
 
-func (s *Service) CreateManualPost(ctx context.Context, courseID string, message string) (FeedPostResponse, error) {
-	now := time.Now().Unix()
+// the cursor points right after the last post of a page, it's opaque to the clients
+func encodeFeedCursor(pinned bool, publishAt int64, postID string) string {
+	cursor := fmt.Sprintf("%v:%v:%v", pinned, publishAt, postID)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeFeedCursor(cursor string) (bool, int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return false, 0, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(data), ":", 3)
+	if len(parts) != 3 {
+		return false, 0, "", ErrInvalidCursor
+	}
+
+	pinned, err := strconv.ParseBool(parts[0])
+	if err != nil {
+		return false, 0, "", ErrInvalidCursor
+	}
+
+	publishAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, 0, "", ErrInvalidCursor
+	}
+
+	return pinned, publishAt, parts[2], nil
+}
+
+// turns what the user typed into an fts5 query - every word has to be in the message, the last one can be a prefix,
+// the words are quoted so characters with a meaning in the fts5 syntax can't make the query invalid
+func ftsQuery(search string) string {
+	words := strings.Fields(search)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// CreateManualPost stores the post of a lecturer, a post with PublishAt in the future is published later by the publisher
+func (s *Service) CreateManualPost(ctx context.Context, courseID string, post NewPost) (FeedPostResponse, error) {
+	now := time.Now()
+
+	publishAt := now
+	if post.PublishAt != nil && post.PublishAt.After(now) {
+		publishAt = *post.PublishAt
+	}
+
+	if post.ExpiresAt != nil && !post.ExpiresAt.After(publishAt) {
+		return FeedPostResponse{}, ErrExpiresBeforePublish
+	}
+
+	if len(post.Attachments) > MAX_POST_ATTACHMENTS {
+		return FeedPostResponse{}, &utils.ErrBadRequest{Message: fmt.Sprintf("post can have at most %v attachments", MAX_POST_ATTACHMENTS)}
+	}
+
+	// the links are checked before anything is stored
+	for _, link := range post.Attachments {
+		err := s.checkAttachmentLink(ctx, courseID, link)
+		if err != nil {
+			return FeedPostResponse{}, err
+		}
+	}
+
 	newPost, err := s.q.CreatePost(ctx, db.CreatePostParams{
-		Uuid:       uuid.New().String(),
-		CourseUuid: courseID,
-		Type:       "manual",
-		Message:    message,
-		IsEdited:   false,
-		CreatedAt:  now,
-		UpdatedAt:  now,
+		Uuid:        uuid.New().String(),
+		CourseUuid:  courseID,
+		Type:        "manual",
+		Message:     post.Message,
+		IsEdited:    false,
+		IsPinned:    post.Pinned,
+		CreatedAt:   now.Unix(),
+		UpdatedAt:   now.Unix(),
+		PublishAt:   publishAt.Unix(),
+		IsPublished: !publishAt.After(now),
+		ExpiresAt:   timeToSqlNullInt64(post.ExpiresAt),
+		IsExpired:   false,
 	})
 	if err != nil {
 		fmt.Println(err)
 		return FeedPostResponse{}, err
 	}
 
-	resp := dbFeedPostToFeedPost(newPost)
+	for _, link := range post.Attachments {
+		err := s.createLinkAttachment(ctx, newPost.Uuid, uuid.New().String(), link)
+		if err != nil {
+			return FeedPostResponse{}, err
+		}
+	}
+
+	posts, err := s.postsToResponses(ctx, []db.FeedPost{newPost}, 0)
+	if err != nil {
+		return FeedPostResponse{}, err
+	}
+	resp := posts[0]
 
-	go s.broadcast(courseID, resp)
+	// scheduled posts are broadcast once they are published
+	if newPost.IsPublished {
+		s.broadcast(courseID, FeedEvent{Name: EVENT_POST_CREATED, Data: resp})
+	}
 
 	return resp, nil
 }
@@ -120,13 +467,15 @@ func (s *Service) CreateManualPost(ctx context.Context, courseID string, message
 func (s *Service) CreateAutomaticPost(message string, courseId string, ctx context.Context) (FeedPostResponse, error) {
 	now := time.Now().Unix()
 	newPost, err := s.q.CreatePost(ctx, db.CreatePostParams{
-		Uuid:       uuid.New().String(),
-		CourseUuid: courseId,
-		Type:       "system",
-		Message:    message,
-		IsEdited:   false,
-		CreatedAt:  now,
-		UpdatedAt:  now,
+		Uuid:        uuid.New().String(),
+		CourseUuid:  courseId,
+		Type:        "system",
+		Message:     message,
+		IsEdited:    false,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		PublishAt:   now,
+		IsPublished: true,
 	})
 	if err != nil {
 		fmt.Println(err)
@@ -135,59 +484,192 @@ func (s *Service) CreateAutomaticPost(message string, courseId string, ctx conte
 
 	resp := dbFeedPostToFeedPost(newPost)
 
-	go s.broadcast(courseId, resp)
+	s.broadcast(courseId, FeedEvent{Name: EVENT_POST_CREATED, Data: resp})
 
 	return resp, nil
 }
 
-// special type of post signifing to frontend to reload the course -- mainly for small changes that don't deserve to appear to users in the feed on frontend but still need to be visible elsewhere - like module order changes
-func (s *Service) CreateInfoPost(message string, courseId string, ctx context.Context) (FeedPostResponse, error) {
-
-	resp := FeedPostResponse{
-		Type:    "info",
-		Message: message,
-	}
-
-	go s.broadcast(courseId, resp)
+// tells the clients to reload the course -- mainly for small changes that don't deserve to appear to users in the feed on frontend but still need to be visible elsewhere - like module order changes
+func (s *Service) BroadcastCourseChanged(message string, courseId string) {
+	s.broadcast(courseId, FeedEvent{Name: EVENT_COURSE_CHANGED, Data: CourseChangedEvent{Message: message}})
+}
 
-	return resp, nil
+// tells the clients that a quiz of the course got a new attempt
+func (s *Service) BroadcastQuizSubmitted(quizId string, score int, maxScore int, courseId string) {
+	s.broadcast(courseId, FeedEvent{Name: EVENT_QUIZ_SUBMITTED, Data: QuizSubmittedEvent{
+		QuizUUID: quizId,
+		Score:    score,
+		MaxScore: maxScore,
+	}})
 }
 
-func (s *Service) UpdatePost(ctx context.Context, courseID, postID, message string) (FeedPostResponse, error) {
+func (s *Service) UpdatePost(ctx context.Context, courseID, postID string, changes PostChanges) (FeedPostResponse, error) {
+
+	post, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return FeedPostResponse{}, err
+	}
 
-	post, err := s.q.GetPost(ctx, postID)
 	if post.Type != "manual" {
 		return FeedPostResponse{}, &utils.ErrBadRequest{Message: "only manual questions can be edited"}
 	}
 
-	updatedPost, err := s.q.UpdatePost(ctx, db.UpdatePostParams{
-		Message:   message,
-		Uuid:      postID,
-		UpdatedAt: time.Now().Unix(),
-	})
+	now := time.Now()
+	wasVisible := isPostVisible(post, now.Unix())
+
+	params := db.UpdatePostParams{
+		Uuid:        postID,
+		Message:     post.Message,
+		IsEdited:    post.IsEdited,
+		IsPinned:    post.IsPinned,
+		IsLocked:    post.IsLocked,
+		UpdatedAt:   now.Unix(),
+		PublishAt:   post.PublishAt,
+		IsPublished: post.IsPublished,
+		ExpiresAt:   post.ExpiresAt,
+	}
+
+	if changes.Message != nil && *changes.Message != post.Message {
+		params.Message = *changes.Message
+		params.IsEdited = true
+	}
+
+	if changes.Pinned != nil {
+		params.IsPinned = *changes.Pinned
+	}
+
+	if changes.Locked != nil {
+		params.IsLocked = *changes.Locked
+	}
+
+	if changes.PublishAt != nil {
+		if post.IsPublished {
+			return FeedPostResponse{}, ErrPostAlreadyPublished
+		}
+
+		// a time in the past publishes the post right away
+		params.PublishAt = max(changes.PublishAt.Unix(), now.Unix())
+		params.IsPublished = params.PublishAt == now.Unix()
+	}
+
+	if changes.ClearExpiry {
+		params.ExpiresAt = sql.NullInt64{}
+	} else if changes.ExpiresAt != nil {
+		params.ExpiresAt = timeToSqlNullInt64(changes.ExpiresAt)
+	}
+
+	if params.ExpiresAt.Valid && params.ExpiresAt.Int64 <= params.PublishAt {
+		return FeedPostResponse{}, ErrExpiresBeforePublish
+	}
+
+	// the clients are told right here, the publisher only handles posts that expire later on their own
+	params.IsExpired = params.ExpiresAt.Valid && params.ExpiresAt.Int64 <= now.Unix()
+
+	updatedPost, err := s.q.UpdatePost(ctx, params)
 	if err != nil {
 		fmt.Println(err)
 		return FeedPostResponse{}, err
 	}
 
-	resp := dbFeedPostToFeedPost(updatedPost)
-
-	go s.broadcast(courseID, resp)
+	// the post is sent whole, with its attachments, reactions and comments
+	posts, err := s.postsToResponses(ctx, []db.FeedPost{updatedPost}, 0)
+	if err != nil {
+		return FeedPostResponse{}, err
+	}
+	resp := posts[0]
+
+	// the clients only know the visible posts, a post that becomes visible is new to them
+	// and a post that gets hidden is gone for them
+	isVisible := isPostVisible(updatedPost, now.Unix())
+	switch {
+	case wasVisible && isVisible:
+		s.broadcast(courseID, FeedEvent{Name: EVENT_POST_UPDATED, Data: resp})
+	case !wasVisible && isVisible:
+		s.broadcast(courseID, FeedEvent{Name: EVENT_POST_CREATED, Data: resp})
+	case wasVisible && !isVisible:
+		s.broadcast(courseID, FeedEvent{Name: EVENT_POST_DELETED, Data: PostDeletedEvent{UUID: postID}})
+	}
 
 	return resp, nil
 }
 
+// published and not expired
+func isPostVisible(post db.FeedPost, now int64) bool {
+	return post.IsPublished && (!post.ExpiresAt.Valid || post.ExpiresAt.Int64 > now)
+}
+
+func timeToSqlNullInt64(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
 func (s *Service) DeletePost(ctx context.Context, courseID, postID string) error {
-	return s.q.DeletePost(ctx, postID)
+	_, err := s.getPostOfCourse(ctx, courseID, postID)
+	if err != nil {
+		return err
+	}
+
+	err = s.removeAttachmentFiles(ctx, postID)
+	if err != nil {
+		return err
+	}
+
+	err = s.q.DeletePost(ctx, postID)
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(courseID, FeedEvent{Name: EVENT_POST_DELETED, Data: PostDeletedEvent{UUID: postID}})
+
+	return nil
+}
+
+// returns ErrPostNotFound also when the post exists but belongs to another course
+func (s *Service) getPostOfCourse(ctx context.Context, courseID, postID string) (db.FeedPost, error) {
+	post, err := s.q.GetPost(ctx, postID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.FeedPost{}, ErrPostNotFound
+		}
+		return db.FeedPost{}, err
+	}
+
+	if post.CourseUuid != courseID {
+		return db.FeedPost{}, ErrPostNotFound
+	}
+
+	return post, nil
+}
+
+// CheckCourseVisible is the rule for the feed of the course on every transport (GET, SSE and the websocket),
+// admins see all courses, the others only the open ones that are not archived
+func (s *Service) CheckCourseVisible(ctx context.Context, courseID string, isAdmin bool) error {
+	course, err := s.q.GetCourse(ctx, courseID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return ErrCourseNotFound
+		}
+		return err
+	}
+
+	if !isCourseVisible(course, isAdmin) {
+		return ErrCourseNotOpen
+	}
+	return nil
+}
 
-	//TODO broadcast delete
+func isCourseVisible(course db.Course, isAdmin bool) bool {
+	return isAdmin || (course.State == "open" && course.Archived == 0)
 }
 
-// StreamFeed handles the connection lifecycle for SSE
-func (s *Service) StreamFeed(ctx context.Context, courseID string) chan FeedPostResponse {
-	return s.subscribe(courseID)
+// StreamFeed handles the connection lifecycle for SSE,
+// returns the events missed since lastEventID (0 for a new connection) and the channel of the following ones
+func (s *Service) StreamFeed(ctx context.Context, courseID string, lastEventID int64) ([]FeedEvent, chan FeedEvent) {
+	return s.subscribe(courseID, lastEventID)
 }
 
-func (s *Service) EndStream(courseID string, ch chan FeedPostResponse) {
+func (s *Service) EndStream(courseID string, ch chan FeedEvent) {
 	s.unsubscribe(courseID, ch)
 }
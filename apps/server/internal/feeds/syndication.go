@@ -0,0 +1,281 @@
+package feeds
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
+)
+
+//* the feed of a course as atom and rss for feed readers, with the latest visible posts,
+// open courses are public, the others need the feed token of a lecturer (see auth.FeedTokenAuth)
+
+// number of the latest posts in the atom and rss feeds
+var SYNDICATION_SIZE = 50
+
+// max length of the entry titles, the title is the start of the message
+var SYNDICATION_TITLE_LENGTH = 80
+
+type CourseFeed struct {
+	CourseID   string
+	CourseName string
+	Posts      []FeedPostResponse
+}
+
+// SyndicationFeed returns the latest visible posts of the course, non admins get only the posts of open courses
+func (s *Service) SyndicationFeed(ctx context.Context, courseID string, isAdmin bool) (CourseFeed, error) {
+	course, err := s.q.GetCourse(ctx, courseID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return CourseFeed{}, ErrCourseNotFound
+		}
+		return CourseFeed{}, err
+	}
+
+	if !isCourseVisible(course, isAdmin) {
+		return CourseFeed{}, ErrCourseNotOpen
+	}
+
+	page, err := s.ListFeed(ctx, courseID, FeedFilter{Limit: SYNDICATION_SIZE})
+	if err != nil {
+		return CourseFeed{}, err
+	}
+
+	return CourseFeed{CourseID: course.Uuid, CourseName: course.Name, Posts: page.Posts}, nil
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href  string `xml:"href,attr"`
+	Rel   string `xml:"rel,attr,omitempty"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Links     []atomLink `xml:"link"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	GUID        rssGUID        `xml:"guid"`
+	PubDate     string         `xml:"pubDate"`
+	Enclosures  []rssEnclosure `xml:"enclosure"`
+	Categories  []string       `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// GET /courses/{courseId}/feed.atom?token=
+func (h *Handler) AtomFeed(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	feed, err := h.service.SyndicationFeed(r.Ctx, c.Param("courseId"), r.User != nil && r.User.IsAdmin)
+	if err != nil {
+		return h.syndicationError(r, err)
+	}
+
+	base := c.Scheme() + "://" + c.Request().Host
+	courseUrl := base + "/courses/" + feed.CourseID
+
+	atom := atomFeed{
+		ID:    "urn:uuid:" + feed.CourseID,
+		Title: feed.CourseName,
+		Links: []atomLink{
+			{Href: courseUrl, Rel: "alternate", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, 0, len(feed.Posts)),
+	}
+
+	for _, post := range feed.Posts {
+		entry := atomEntry{
+			ID:        "urn:uuid:" + post.UUID,
+			Title:     postTitle(post),
+			Published: post.PublishAt,
+			Updated:   post.UpdatedAt,
+			Author:    atomAuthor{Name: postAuthor(post, feed.CourseName)},
+			Links:     []atomLink{{Href: courseUrl, Rel: "alternate", Type: "text/html"}},
+			Content:   atomText{Type: "text", Body: post.Message},
+		}
+
+		for _, attachment := range post.Attachments {
+			if attachment.Url == nil {
+				continue
+			}
+			link := atomLink{Href: absoluteUrl(base, *attachment.Url), Rel: "related", Title: attachment.Name}
+			if attachment.Type == ATTACHMENT_FILE {
+				link.Rel = "enclosure"
+				link.Type = *attachment.MimeType
+			}
+			entry.Links = append(entry.Links, link)
+		}
+
+		// the feed changes with its latest updated post, the times have the same format so they compare as strings
+		atom.Updated = max(atom.Updated, post.UpdatedAt)
+
+		atom.Entries = append(atom.Entries, entry)
+	}
+
+	if atom.Updated == "" {
+		atom.Updated = utils.UnixToIso(time.Now().Unix())
+	}
+
+	return writeXML(c, "application/atom+xml; charset=utf-8", atom)
+}
+
+// GET /courses/{courseId}/feed.rss?token=
+func (h *Handler) RSSFeed(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	feed, err := h.service.SyndicationFeed(r.Ctx, c.Param("courseId"), r.User != nil && r.User.IsAdmin)
+	if err != nil {
+		return h.syndicationError(r, err)
+	}
+
+	base := c.Scheme() + "://" + c.Request().Host
+	courseUrl := base + "/courses/" + feed.CourseID
+
+	rss := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feed.CourseName,
+			Link:        courseUrl,
+			Description: "Announcements of the course " + feed.CourseName,
+			Items:       make([]rssItem, 0, len(feed.Posts)),
+		},
+	}
+
+	for _, post := range feed.Posts {
+		item := rssItem{
+			Title:       postTitle(post),
+			Link:        courseUrl,
+			Description: post.Message,
+			GUID:        rssGUID{Value: "urn:uuid:" + post.UUID},
+			PubDate:     rssDate(post.PublishAt),
+			Categories:  []string{post.Type},
+		}
+
+		for _, attachment := range post.Attachments {
+			if attachment.Type != ATTACHMENT_FILE {
+				continue
+			}
+			item.Enclosures = append(item.Enclosures, rssEnclosure{
+				Url:    absoluteUrl(base, *attachment.Url),
+				Length: *attachment.SizeBytes,
+				Type:   *attachment.MimeType,
+			})
+		}
+
+		rss.Channel.Items = append(rss.Channel.Items, item)
+	}
+
+	rss.Channel.LastBuildDate = time.Now().UTC().Format(time.RFC1123Z)
+
+	return writeXML(c, "application/rss+xml; charset=utf-8", rss)
+}
+
+func (h *Handler) syndicationError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrCourseNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrCourseNotOpen:
+		return r.Error(http.StatusForbidden, "course is not open, use the link with your feed token")
+	}
+	return r.ServerError(err)
+}
+
+func writeXML(c echo.Context, contentType string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return c.Blob(http.StatusOK, contentType, append([]byte(xml.Header), data...))
+}
+
+// the first line of the message, shortened
+func postTitle(post FeedPostResponse) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(post.Message), "\n")
+	if utf8.RuneCountInString(title) > SYNDICATION_TITLE_LENGTH {
+		title = string([]rune(title)[:SYNDICATION_TITLE_LENGTH-1]) + "…"
+	}
+	return title
+}
+
+func postAuthor(post FeedPostResponse, courseName string) string {
+	if post.Type == "manual" {
+		return courseName + " lecturer"
+	}
+	return courseName
+}
+
+// the urls of uploaded files are relative to the server
+func absoluteUrl(base string, url string) string {
+	if strings.HasPrefix(url, "/") {
+		return base + url
+	}
+	return url
+}
+
+func rssDate(iso string) string {
+	t, err := time.Parse(time.RFC3339, iso)
+	if err != nil {
+		return iso
+	}
+	return t.UTC().Format(time.RFC1123Z)
+}
@@ -0,0 +1,340 @@
+package feeds
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"tourbackend/internal/handlers"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+//* one websocket per client for all of its courses, the events are the same as the ones of the SSE stream
+//
+// client -> server
+//  {"type": "subscribe", "courseId": "...", "channels": ["feed", "quiz", "presence"], "lastEventId": 123}
+//  {"type": "unsubscribe", "courseId": "..."}
+//  {"type": "ping"}
+//
+// server -> client
+//  {"type": "subscribed", "courseId": "...", "channels": [...]}
+//  {"type": "unsubscribed", "courseId": "..."}
+//  {"type": "event", "courseId": "...", "channel": "feed", "event": "post_created", "id": 124, "data": {...}}
+//  {"type": "pong"}
+//  {"type": "error", "message": "..."}
+//
+// lastEventId is optional and works like Last-Event-ID of the SSE stream, without channels all of them are subscribed,
+// only admins can subscribe to courses that are not open or are archived (see Service.CheckCourseVisible)
+
+// how long a write to the socket can take before the client is considered gone
+var WS_WRITE_TIMEOUT = 10 * time.Second
+
+// how often the server pings the client, a client that doesn't answer within two intervals is disconnected
+var WS_PING_INTERVAL = 30 * time.Second
+
+// max size of one message from the client
+var WS_MAX_MESSAGE_SIZE = int64(4096)
+
+// max courses one socket can be subscribed to at once
+var WS_MAX_SUBSCRIPTIONS = 50
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// the default origin check only allows pages from the same host, which keeps other sites from using the session cookie
+}
+
+type WSClientMessage struct {
+	Type        string   `json:"type"`
+	CourseID    string   `json:"courseId"`
+	Channels    []string `json:"channels"`
+	LastEventID int64    `json:"lastEventId"`
+}
+
+type WSServerMessage struct {
+	Type     string   `json:"type"`
+	CourseID string   `json:"courseId,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+
+	Channel string `json:"channel,omitempty"`
+	Event   string `json:"event,omitempty"`
+	ID      int64  `json:"id,omitempty"`
+	Data    any    `json:"data,omitempty"`
+
+	Message string `json:"message,omitempty"`
+}
+
+// one subscribed course of a socket
+type wsSubscription struct {
+	courseID string
+	channels []string
+	ch       chan FeedEvent
+
+	// id of the last event sent to the client, used to resume when the service drops a lagging subscription
+	lastEventID int64
+	// closed by unsubscribe so the forwarding goroutine ends
+	stop chan struct{}
+}
+
+type wsEvent struct {
+	sub   *wsSubscription
+	event FeedEvent
+	// the service closed the channel of the subscription because the client was lagging
+	dropped bool
+}
+
+// GET /ws
+func (h *Handler) WebSocket(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	if r.User == nil {
+		return r.Error(http.StatusUnauthorized, "authentication required")
+	}
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader already responded with an error
+		return nil
+	}
+	defer conn.Close()
+
+	user := PresenceUser{
+		ID:        r.User.ID,
+		FirstName: r.User.FirstName,
+		LastName:  r.User.LastName,
+	}
+
+	// reading happens in its own goroutine, all writes happen in the loop below (the connection allows only one writer)
+	commands := make(chan WSClientMessage)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go readWSMessages(conn, commands, readErr, done)
+
+	events := make(chan wsEvent)
+	subs := make(map[string]*wsSubscription)
+
+	defer func() {
+		for _, sub := range subs {
+			h.unsubscribeWS(sub, user)
+		}
+	}()
+
+	ping := time.NewTicker(WS_PING_INTERVAL)
+	defer ping.Stop()
+
+	for {
+		var msg WSServerMessage
+
+		select {
+		case cmd := <-commands:
+			msg = h.handleWSCommand(r, cmd, subs, events, user)
+
+		case e := <-events:
+			// left over from a course the client already unsubscribed from
+			if subs[e.sub.courseID] != e.sub {
+				continue
+			}
+
+			if e.dropped {
+				// resubscribe from the last sent event, the missed ones come from the replay buffer
+				h.subscribeWS(r, e.sub, e.sub.lastEventID, events)
+				continue
+			}
+
+			if !slices.Contains(e.sub.channels, eventChannel(e.event.Name)) {
+				continue
+			}
+			if e.event.ID != 0 {
+				e.sub.lastEventID = e.event.ID
+			}
+
+			msg = WSServerMessage{
+				Type:     "event",
+				CourseID: e.sub.courseID,
+				Channel:  eventChannel(e.event.Name),
+				Event:    e.event.Name,
+				ID:       e.event.ID,
+				Data:     e.event.Data,
+			}
+
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return nil
+			}
+			continue
+
+		case <-readErr:
+			// client closed the socket or stopped answering pings
+			return nil
+
+		case <-c.Request().Context().Done():
+			return nil
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+		if err := conn.WriteJSON(msg); err != nil {
+			return nil
+		}
+	}
+}
+
+func readWSMessages(conn *websocket.Conn, commands chan<- WSClientMessage, readErr chan<- error, done <-chan struct{}) {
+	conn.SetReadLimit(WS_MAX_MESSAGE_SIZE)
+
+	// every pong extends the deadline, so a client that stops answering pings is disconnected
+	conn.SetReadDeadline(time.Now().Add(2 * WS_PING_INTERVAL))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * WS_PING_INTERVAL))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			readErr <- err
+			return
+		}
+
+		var cmd WSClientMessage
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			cmd = WSClientMessage{Type: "invalid"}
+		}
+
+		select {
+		case commands <- cmd:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (h *Handler) handleWSCommand(r *handlers.RequestCtx, cmd WSClientMessage, subs map[string]*wsSubscription, events chan wsEvent, user PresenceUser) WSServerMessage {
+	switch cmd.Type {
+	case "ping":
+		return WSServerMessage{Type: "pong"}
+
+	case "subscribe":
+		if cmd.CourseID == "" {
+			return WSServerMessage{Type: "error", Message: "courseId is required"}
+		}
+
+		channels := cmd.Channels
+		if len(channels) == 0 {
+			channels = []string{CHANNEL_FEED, CHANNEL_QUIZ, CHANNEL_PRESENCE}
+		}
+		for _, channel := range channels {
+			if channel != CHANNEL_FEED && channel != CHANNEL_QUIZ && channel != CHANNEL_PRESENCE {
+				return WSServerMessage{Type: "error", Message: "unknown channel " + channel}
+			}
+		}
+
+		// subscribing again just changes the channels, the stream of events continues
+		if sub, ok := subs[cmd.CourseID]; ok {
+			h.setWSPresence(sub, channels, user)
+			sub.channels = channels
+			return WSServerMessage{Type: "subscribed", CourseID: cmd.CourseID, Channels: channels}
+		}
+
+		if len(subs) >= WS_MAX_SUBSCRIPTIONS {
+			return WSServerMessage{Type: "error", Message: "too many subscriptions"}
+		}
+
+		err := h.service.CheckCourseVisible(r.Ctx, cmd.CourseID, r.User.IsAdmin)
+		if err == ErrCourseNotFound || err == ErrCourseNotOpen {
+			return WSServerMessage{Type: "error", Message: err.Error()}
+		}
+		if err != nil {
+			return WSServerMessage{Type: "error", Message: "failed to subscribe"}
+		}
+
+		sub := &wsSubscription{courseID: cmd.CourseID}
+		subs[cmd.CourseID] = sub
+
+		// subscribe before joining the presence, so the client gets the presence event with itself in it
+		h.subscribeWS(r, sub, cmd.LastEventID, events)
+		h.setWSPresence(sub, channels, user)
+		sub.channels = channels
+
+		return WSServerMessage{Type: "subscribed", CourseID: cmd.CourseID, Channels: channels}
+
+	case "unsubscribe":
+		sub, ok := subs[cmd.CourseID]
+		if !ok {
+			return WSServerMessage{Type: "error", Message: "not subscribed to this course"}
+		}
+
+		delete(subs, cmd.CourseID)
+		h.unsubscribeWS(sub, user)
+
+		return WSServerMessage{Type: "unsubscribed", CourseID: cmd.CourseID}
+	}
+
+	return WSServerMessage{Type: "error", Message: "unknown message type"}
+}
+
+// subscribes to the course in the service and forwards the events (the missed ones first) to the events channel
+func (h *Handler) subscribeWS(r *handlers.RequestCtx, sub *wsSubscription, lastEventID int64, events chan<- wsEvent) {
+	missed, ch := h.service.StreamFeed(r.Ctx, sub.courseID, lastEventID)
+
+	sub.ch = ch
+	sub.stop = make(chan struct{})
+
+	go func(ch chan FeedEvent, stop chan struct{}) {
+		for _, event := range missed {
+			select {
+			case events <- wsEvent{sub: sub, event: event}:
+			case <-stop:
+				return
+			}
+		}
+
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					select {
+					case events <- wsEvent{sub: sub, dropped: true}:
+					case <-stop:
+					}
+					return
+				}
+
+				select {
+				case events <- wsEvent{sub: sub, event: event}:
+				case <-stop:
+					return
+				}
+
+			case <-stop:
+				return
+			}
+		}
+	}(ch, sub.stop)
+}
+
+func (h *Handler) unsubscribeWS(sub *wsSubscription, user PresenceUser) {
+	close(sub.stop)
+	h.service.EndStream(sub.courseID, sub.ch)
+
+	if slices.Contains(sub.channels, CHANNEL_PRESENCE) {
+		h.service.LeavePresence(sub.courseID, user.ID)
+	}
+}
+
+// joins or leaves the presence of the course when the presence channel is added or removed
+func (h *Handler) setWSPresence(sub *wsSubscription, channels []string, user PresenceUser) {
+	had := slices.Contains(sub.channels, CHANNEL_PRESENCE)
+	has := slices.Contains(channels, CHANNEL_PRESENCE)
+
+	if has && !had {
+		h.service.JoinPresence(sub.courseID, user)
+	}
+	if had && !has {
+		h.service.LeavePresence(sub.courseID, user.ID)
+	}
+}
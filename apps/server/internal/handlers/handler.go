@@ -31,7 +31,12 @@ type User struct {
 
 	Hash string `json:"hash"`
 
-	IsAdmin bool `json:"isAdmin"`
+	AvatarPath string `json:"avatarPath"` // relative to the static folder, empty when the user has no avatar
+
+	IsAdmin            bool `json:"isAdmin"`
+	MustChangePassword bool `json:"mustChangePassword"`
+
+	LoggedInAt int64 `json:"-"` // unix time the current session was started
 }
 
 type RequestCtx struct {
@@ -0,0 +1,403 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+//* metadata of web pages for url materials - the title, description, OpenGraph image and favicon from the head
+// of the page. The urls come from the users, so the fetcher only connects to public addresses (checked after
+// the name is resolved, for every redirect too), has a timeout and reads at most MAX_PAGE_SIZE of the page
+
+// how long the whole fetch of a page can take, redirects included
+var FETCH_TIMEOUT = 10 * time.Second
+
+// the head of the page has to be in its first MAX_PAGE_SIZE bytes
+var MAX_PAGE_SIZE = int64(1024 * 1024)
+
+var MAX_REDIRECTS = 5
+
+// lets the fetcher connect to private and local addresses, only for testing with a local server
+var ALLOW_PRIVATE_ADDRESSES = false
+
+var USER_AGENT = "Mozilla/5.0 (compatible; TourdeAppLinkPreview/1.0)"
+
+// longer texts of the page are cut
+var MAX_TITLE_LENGTH = 300
+var MAX_DESCRIPTION_LENGTH = 1000
+var MAX_URL_LENGTH = 2048
+
+var (
+	ErrBadUrl         = errors.New("url must be an http or https url with a host")
+	ErrBlockedAddress = errors.New("url points to a private or local address")
+)
+
+// the address ranges that are not private by netip but aren't public either
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, can reach the private IPv4 addresses
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// longer durations of videos are taken as wrong
+const maxDuration = 7 * 24 * 60 * 60
+
+// ISO 8601 durations of the microdata of videos (PT1H2M3S)
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)(?:\.\d+)?S)?)?$`)
+
+// Metadata of a page, the fields it doesn't have are empty
+type Metadata struct {
+	Title       string
+	Description string
+	ImageUrl    string
+	FaviconUrl  string
+
+	// seconds, only video pages have it
+	Duration int
+}
+
+type Fetcher struct {
+	client *http.Client
+}
+
+func NewFetcher() *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			if ALLOW_PRIVATE_ADDRESSES {
+				return nil
+			}
+			return CheckAddress(network, address, conn)
+		},
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Timeout: FETCH_TIMEOUT,
+			Transport: &http.Transport{
+				// no proxy from the environment, it would connect to the addresses instead of the dialer
+				Proxy:                  nil,
+				DialContext:            dialer.DialContext,
+				TLSHandshakeTimeout:    5 * time.Second,
+				ResponseHeaderTimeout:  FETCH_TIMEOUT,
+				MaxResponseHeaderBytes: 64 * 1024,
+				MaxIdleConns:           10,
+				IdleConnTimeout:        30 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > MAX_REDIRECTS {
+					return fmt.Errorf("more than %v redirects", MAX_REDIRECTS)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrBadUrl
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// CheckAddress is the Control of a net.Dialer, it runs before every connection with the resolved
+// address, so a name resolving to a private address is refused too
+func CheckAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrBlockedAddress
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublic(ip.Unmap()) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+func isPublic(ip netip.Addr) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PageUrl parses the url of a material, urls without a scheme (example.com/page) are https
+func PageUrl(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	lower := strings.ToLower(raw)
+	if (strings.HasPrefix(lower, "http:") || strings.HasPrefix(lower, "https:")) && !strings.Contains(raw, "://") {
+		return nil, ErrBadUrl
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return nil, ErrBadUrl
+	}
+	u.Fragment = ""
+	return u, nil
+}
+
+// Fetch reads the metadata of the page, errors are for pages that couldn't be loaded,
+// a page without any metadata is not an error
+func (f *Fetcher) Fetch(ctx context.Context, rawUrl string) (Metadata, error) {
+	pageUrl, err := PageUrl(rawUrl)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	resp, err := f.get(ctx, pageUrl.String(), "text/html,application/xhtml+xml;q=0.9,*/*;q=0.5")
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Metadata{}, fmt.Errorf("page returned status %v", resp.StatusCode)
+	}
+
+	// the url after the redirects, relative urls of the page are resolved against it
+	base := resp.Request.URL
+
+	var meta Metadata
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "":
+		body, err := charset.NewReader(io.LimitReader(resp.Body, MAX_PAGE_SIZE), contentType)
+		if err != nil {
+			return Metadata{}, err
+		}
+		meta = parseHead(body, base)
+	case strings.HasPrefix(mediaType, "image/"):
+		// a link straight to a picture is its own image
+		meta.ImageUrl = checkedUrl(base, base.String())
+	}
+
+	if meta.FaviconUrl == "" {
+		meta.FaviconUrl = f.defaultFavicon(ctx, base)
+	}
+	return meta, nil
+}
+
+func (f *Fetcher) get(ctx context.Context, u string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlockedAddress) {
+			return nil, ErrBlockedAddress
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// the /favicon.ico of the site when it exists, sites without it often answer with an html page
+func (f *Fetcher) defaultFavicon(ctx context.Context, base *url.URL) string {
+	favicon := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}
+
+	resp, err := f.get(ctx, favicon.String(), "image/*")
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return ""
+	}
+	return checkedUrl(resp.Request.URL, resp.Request.URL.String())
+}
+
+// parseHead reads the metadata from the head of the page, OpenGraph first, then twitter cards and the plain
+// html tags, the first of the same name wins. Video pages (og:type video.*) are read further for the duration
+// in the microdata of the body (<meta itemprop="duration">), that's where YouTube has it
+func parseHead(body io.Reader, base *url.URL) Metadata {
+	meta := map[string]string{}
+	var title strings.Builder
+	var icon, touchIcon, itemDuration string
+	inTitle, hasTitle, inBody := false, false, false
+
+	z := html.NewTokenizer(body)
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			attrs := attributes(z)
+
+			if string(name) == "meta" && strings.EqualFold(attrs["itemprop"], "duration") && itemDuration == "" {
+				itemDuration = attrs["content"]
+			}
+			if inBody {
+				done = itemDuration != ""
+				continue
+			}
+
+			switch string(name) {
+			case "title":
+				inTitle = !hasTitle
+				hasTitle = true
+			case "meta":
+				key := strings.ToLower(attrs["property"])
+				if key == "" {
+					key = strings.ToLower(attrs["name"])
+				}
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = attrs["content"]
+				}
+			case "link":
+				rels := strings.Fields(strings.ToLower(attrs["rel"]))
+				for _, rel := range rels {
+					if rel == "icon" && icon == "" {
+						icon = attrs["href"]
+					}
+					if (rel == "apple-touch-icon" || rel == "apple-touch-icon-precomposed") && touchIcon == "" {
+						touchIcon = attrs["href"]
+					}
+				}
+			case "base":
+				if href, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+					base = href
+				}
+			case "body":
+				inBody = true
+				done = !isVideoPage(meta) || itemDuration != ""
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				inBody = true
+				done = !isVideoPage(meta) || itemDuration != ""
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		}
+	}
+
+	if icon == "" {
+		icon = touchIcon
+	}
+
+	return Metadata{
+		Title:       shorten(first(meta["og:title"], meta["twitter:title"], title.String()), MAX_TITLE_LENGTH),
+		Description: shorten(first(meta["og:description"], meta["twitter:description"], meta["description"]), MAX_DESCRIPTION_LENGTH),
+		ImageUrl: checkedUrl(base, first(meta["og:image:secure_url"], meta["og:image"], meta["og:image:url"],
+			meta["twitter:image"], meta["twitter:image:src"])),
+		FaviconUrl: checkedUrl(base, icon),
+		Duration:   duration(first(meta["og:video:duration"], meta["video:duration"]), itemDuration),
+	}
+}
+
+func isVideoPage(meta map[string]string) bool {
+	return strings.HasPrefix(strings.ToLower(meta["og:type"]), "video")
+}
+
+// duration of the video from the OpenGraph tags (seconds) or the microdata (ISO 8601), 0 when unknown
+func duration(seconds string, iso string) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(seconds)); err == nil && n > 0 && n <= maxDuration {
+		return n
+	}
+
+	match := isoDuration.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(iso)))
+	if match == nil {
+		return 0
+	}
+
+	total := 0
+	for i, unit := range []int{24 * 60 * 60, 60 * 60, 60, 1} {
+		n, _ := strconv.Atoi(match[i+1])
+		total += n * unit
+		if total > maxDuration {
+			return 0
+		}
+	}
+	return total
+}
+
+func attributes(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, value, more := z.TagAttr()
+		if _, ok := attrs[string(key)]; !ok {
+			attrs[string(key)] = string(value)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// shorten collapses the whitespace and cuts the text to length characters
+func shorten(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[:length-1]) + "…"
+}
+
+// checkedUrl resolves the url against the page, only http and https urls are kept (no javascript: or data:)
+func checkedUrl(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	s := u.String()
+	if len(s) > MAX_URL_LENGTH {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,226 @@
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the pages are served by a local stand-in, which the fetcher only reaches with ALLOW_PRIVATE_ADDRESSES
+func allowPrivate(t *testing.T) {
+	ALLOW_PRIVATE_ADDRESSES = true
+	t.Cleanup(func() { ALLOW_PRIVATE_ADDRESSES = false })
+}
+
+func newSite(t *testing.T, mux *http.ServeMux) *httptest.Server {
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func servePage(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}
+}
+
+func TestFetchReadsOpenGraphBeforeHtmlTags(t *testing.T) {
+	allowPrivate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", servePage(`<!doctype html><html><head>
+		<title>Plain   title</title>
+		<meta name="description" content="plain description">
+		<meta property="og:title" content="Pottery &amp; glazes">
+		<meta property="og:description" content="How to   glaze
+			a bowl">
+		<meta property="og:image" content="/img/bowl.jpg">
+		<meta property="og:image" content="/img/second.jpg">
+		<link rel="shortcut icon" href="icons/favicon.png">
+	</head><body><title>not this one</title></body></html>`))
+	site := newSite(t, mux)
+
+	meta, err := NewFetcher().Fetch(context.Background(), site.URL+"/article")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Metadata{
+		Title:       "Pottery & glazes",
+		Description: "How to glaze a bowl",
+		ImageUrl:    site.URL + "/img/bowl.jpg",
+		FaviconUrl:  site.URL + "/icons/favicon.png",
+	}
+	if meta != want {
+		t.Errorf("metadata\n got %+v\nwant %+v", meta, want)
+	}
+}
+
+func TestFetchFallsBackToTitleAndFaviconOfSite(t *testing.T) {
+	allowPrivate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", servePage(`<html><head><base href="/docs/">
+		<title>Only a title</title>
+		<meta name="twitter:image" content="javascript:alert(1)">
+	</head><body></body></html>`))
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/x-icon")
+		w.Write([]byte{0, 0, 1, 0})
+	})
+	site := newSite(t, mux)
+
+	meta, err := NewFetcher().Fetch(context.Background(), site.URL+"/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.Title != "Only a title" {
+		t.Errorf("title %q", meta.Title)
+	}
+	// only http and https urls are kept
+	if meta.ImageUrl != "" {
+		t.Errorf("image %q, want none", meta.ImageUrl)
+	}
+	if meta.FaviconUrl != site.URL+"/favicon.ico" {
+		t.Errorf("favicon %q, want the /favicon.ico of the site", meta.FaviconUrl)
+	}
+}
+
+func TestFetchReadsDurationOfVideoPages(t *testing.T) {
+	allowPrivate(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/watch", servePage(`<html><head>
+		<meta property="og:type" content="video.other">
+		<title>Throwing a bowl</title>
+	</head><body><div itemscope><meta itemprop="duration" content="PT1H2M3S"></div></body></html>`))
+	site := newSite(t, mux)
+
+	meta, err := NewFetcher().Fetch(context.Background(), site.URL+"/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Duration != 3723 {
+		t.Errorf("duration %v, want 3723", meta.Duration)
+	}
+}
+
+func TestFetchReadsOnlyStartOfPage(t *testing.T) {
+	allowPrivate(t)
+
+	limit := MAX_PAGE_SIZE
+	MAX_PAGE_SIZE = 1024
+	t.Cleanup(func() { MAX_PAGE_SIZE = limit })
+
+	padding := "<!--" + strings.Repeat("x", 2048) + "-->"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", servePage(`<html><head><title>Short page</title></head></html>`))
+	mux.HandleFunc("/long", servePage(`<html><head>`+padding+`<title>Too far</title></head></html>`))
+	site := newSite(t, mux)
+
+	fetcher := NewFetcher()
+
+	meta, err := fetcher.Fetch(context.Background(), site.URL+"/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Short page" {
+		t.Errorf("title of the short page %q", meta.Title)
+	}
+
+	meta, err = fetcher.Fetch(context.Background(), site.URL+"/long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "" {
+		t.Errorf("title after MAX_PAGE_SIZE %q, want none", meta.Title)
+	}
+}
+
+func TestFetchFollowsRedirectsUpToLimit(t *testing.T) {
+	allowPrivate(t)
+
+	// /hop/<n> redirects to /hop/<n-1>, /hop/0 is the page
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hop/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n > 0 {
+			http.Redirect(w, r, "/hop/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		servePage(`<html><head><title>Arrived</title><meta property="og:image" content="cover.png"></head></html>`)(w, r)
+	})
+	site := newSite(t, mux)
+
+	fetcher := NewFetcher()
+
+	meta, err := fetcher.Fetch(context.Background(), site.URL+"/hop/"+strconv.Itoa(MAX_REDIRECTS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Arrived" {
+		t.Errorf("title %q", meta.Title)
+	}
+	// relative urls are resolved against the page after the redirects
+	if meta.ImageUrl != site.URL+"/hop/cover.png" {
+		t.Errorf("image %q", meta.ImageUrl)
+	}
+
+	_, err = fetcher.Fetch(context.Background(), site.URL+"/hop/"+strconv.Itoa(MAX_REDIRECTS+1))
+	if err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("fetch with too many redirects: %v", err)
+	}
+}
+
+func TestFetchRefusesLocalAddresses(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", servePage(`<html><head><title>Internal</title></head></html>`))
+	site := newSite(t, mux)
+
+	_, err := NewFetcher().Fetch(context.Background(), site.URL+"/")
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("fetch of %v: %v, want ErrBlockedAddress", site.URL, err)
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	blocked := []string{
+		"127.0.0.1:80",
+		"10.1.2.3:80",
+		"172.16.0.1:443",
+		"192.168.1.1:80",
+		"169.254.169.254:80", // cloud metadata
+		"100.64.0.1:80",
+		"0.0.0.0:80",
+		"[::1]:80",
+		"[fe80::1]:80",
+		"[fc00::1]:80",
+		"[::ffff:127.0.0.1]:80",
+		"[64:ff9b::a00:1]:80",
+		"localhost:80", // not resolved yet, only addresses are accepted
+	}
+	for _, address := range blocked {
+		if err := CheckAddress("tcp", address, nil); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("CheckAddress(%v) = %v, want ErrBlockedAddress", address, err)
+		}
+	}
+
+	public := []string{
+		"93.184.216.34:443",
+		"1.1.1.1:80",
+		"[2606:4700:4700::1111]:443",
+	}
+	for _, address := range public {
+		if err := CheckAddress("tcp", address, nil); err != nil {
+			t.Errorf("CheckAddress(%v) = %v, want nil", address, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"cmp"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+//* sending of emails (email verification), over smtp when SMTP_HOST is set, otherwise the emails are just printed
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM,
+// without SMTP_HOST the returned mailer prints the emails to stdout, which is enough when running locally
+func MailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     cmp.Or(os.Getenv("SMTP_PORT"), "587"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     cmp.Or(os.Getenv("MAIL_FROM"), "noreply@"+host),
+	}
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+type LogMailer struct{}
+
+func (LogMailer) Send(to string, subject string, body string) error {
+	fmt.Printf("email to %v: %v\n%v\n", to, subject, body)
+	return nil
+}
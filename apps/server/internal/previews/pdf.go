@@ -0,0 +1,937 @@
+package previews
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+//* a small reader of pdf files, enough to find the first page and read its text - the objects are found by scanning
+// the file for "N G obj" (so the often broken xref tables are not needed), compressed object streams are unpacked
+// only when an object reached from the first page isn't directly in the file,
+// of the stream filters only FlateDecode is supported and the text is decoded by the ToUnicode maps of the fonts,
+// encrypted files and fonts without the map give no text
+
+// limit of one unpacked stream
+const maxPdfStream = 16 * 1024 * 1024
+
+// limit of all the streams unpacked from one file
+const maxPdfUnpacked = 64 * 1024 * 1024
+
+type pdfName string
+type pdfString string
+type pdfKeyword string // operators of content streams, the closing ] and >> and keywords like endobj
+type pdfArray []any
+type pdfDict map[pdfName]any
+
+type pdfRef struct {
+	num int
+	gen int
+}
+
+type pdfStream struct {
+	dict pdfDict
+	data []byte // still encoded
+}
+
+var errPdfEnd = errors.New("unexpected end of pdf")
+var errPdfTooBig = errors.New("the streams of the pdf unpack to too much data")
+
+//* lexer of the pdf syntax, used for the file, the object streams, the content streams and the cmaps
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+
+	// the streams of the file have their data after the dict, nil in content streams and cmaps
+	file *pdfFile
+}
+
+func isPdfSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPdfDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (l *pdfLexer) peek(i int) byte {
+	if l.pos+i < len(l.data) {
+		return l.data[l.pos+i]
+	}
+	return 0
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPdfSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPdfSpace(l.data[l.pos]) && !isPdfDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// the next word when it's a non-negative integer, the position doesn't move otherwise
+func (l *pdfLexer) integer() (int, bool) {
+	start := l.pos
+	l.skipSpace()
+	word := l.word()
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 0 || strings.ContainsAny(word, "+-") {
+		l.pos = start
+		return 0, false
+	}
+	return n, true
+}
+
+// next returns the next object (numbers are float64), operators and closing brackets are pdfKeyword
+func (l *pdfLexer) next() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errPdfEnd
+	}
+
+	switch c := l.data[l.pos]; c {
+	case '/':
+		l.pos++
+		return l.name(), nil
+	case '(':
+		l.pos++
+		return l.literal(), nil
+	case '<':
+		if l.peek(1) == '<' {
+			l.pos += 2
+			return l.dict()
+		}
+		l.pos++
+		return l.hex(), nil
+	case '[':
+		l.pos++
+		return l.array()
+	case '>':
+		l.pos++
+		if l.peek(0) == '>' {
+			l.pos++
+			return pdfKeyword(">>"), nil
+		}
+		return pdfKeyword(">"), nil
+	case ']', ')', '{', '}':
+		l.pos++
+		return pdfKeyword(string(c)), nil
+	}
+
+	start := l.pos
+	word := l.word()
+	if word == "" {
+		l.pos++
+		return pdfKeyword(l.data[start:l.pos]), nil
+	}
+
+	if isPdfNumber(word) {
+		n, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return 0.0, nil
+		}
+
+		// "num gen R" is a reference
+		if num, err := strconv.Atoi(word); err == nil && num >= 0 {
+			end := l.pos
+			if gen, ok := l.integer(); ok {
+				l.skipSpace()
+				if l.peek(0) == 'R' && (l.pos+1 >= len(l.data) || isPdfSpace(l.peek(1)) || isPdfDelimiter(l.peek(1))) {
+					l.pos++
+					return pdfRef{num, gen}, nil
+				}
+			}
+			l.pos = end
+		}
+		return n, nil
+	}
+
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+func isPdfNumber(word string) bool {
+	digits := false
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c == '.' || ((c == '-' || c == '+') && i == 0):
+		default:
+			return false
+		}
+	}
+	return digits
+}
+
+func (l *pdfLexer) name() pdfName {
+	word := l.word()
+	if !strings.Contains(word, "#") {
+		return pdfName(word)
+	}
+
+	var name []byte
+	for i := 0; i < len(word); i++ {
+		if word[i] == '#' && i+2 < len(word) {
+			if b, err := strconv.ParseUint(word[i+1:i+3], 16, 8); err == nil {
+				name = append(name, byte(b))
+				i += 2
+				continue
+			}
+		}
+		name = append(name, word[i])
+	}
+	return pdfName(name)
+}
+
+func (l *pdfLexer) literal() pdfString {
+	var s []byte
+	depth := 1
+
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(s)
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return pdfString(s)
+			}
+			e := l.data[l.pos]
+			l.pos++
+
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// the line continues
+				if l.peek(0) == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				c = e
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.peek(0) >= '0' && l.peek(0) <= '7'; i++ {
+						n = n*8 + int(l.peek(0)-'0')
+						l.pos++
+					}
+					c = byte(n)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return pdfString(s)
+}
+
+func (l *pdfLexer) hex() pdfString {
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		c := l.data[l.pos]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	s := make([]byte, len(digits)/2)
+	for i := range s {
+		b, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		s[i] = byte(b)
+	}
+	return pdfString(s)
+}
+
+func (l *pdfLexer) array() (any, error) {
+	array := pdfArray{}
+	for {
+		v, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if v == pdfKeyword("]") {
+			return array, nil
+		}
+		array = append(array, v)
+	}
+}
+
+func (l *pdfLexer) dict() (any, error) {
+	dict := pdfDict{}
+	for {
+		key, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if key == pdfKeyword(">>") {
+			break
+		}
+
+		value, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if value == pdfKeyword(">>") {
+			break
+		}
+
+		// broken dicts are read as far as they make sense
+		if name, ok := key.(pdfName); ok {
+			dict[name] = value
+		}
+	}
+
+	if l.file == nil {
+		return dict, nil
+	}
+
+	// the dict of a stream is followed by its data
+	end := l.pos
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		l.pos = end
+		return dict, nil
+	}
+
+	l.pos += len("stream")
+	if l.peek(0) == '\r' {
+		l.pos++
+	}
+	if l.peek(0) == '\n' {
+		l.pos++
+	}
+
+	stream := l.file.stream(dict, l.pos)
+	l.pos += len(stream.data)
+	return stream, nil
+}
+
+//* objects of the file
+
+type objectInStream struct {
+	stream int
+	offset int
+}
+
+type pdfFile struct {
+	data []byte
+
+	offsets  map[int]int // objects in the file, the offset after "N G obj"
+	inStream map[int]objectInStream
+	streams  map[int][]byte // unpacked object streams
+
+	// object streams not unpacked yet, the last one in the file first
+	pendingStreams []int
+	// bytes unpacked from all the streams so far
+	unpacked int
+
+	objects map[int]any
+}
+
+// "N G obj" at the start of a line or after whitespace
+var pdfObjectHeader = regexp.MustCompile(`(?:^|\s)(\d{1,10})\s+\d{1,5}\s+obj\b`)
+
+var pdfRoot = regexp.MustCompile(`/Root\s+(\d{1,10})\s+\d{1,5}\s+R`)
+
+func openPdf(data []byte) *pdfFile {
+	f := &pdfFile{
+		data:     data,
+		offsets:  map[int]int{},
+		inStream: map[int]objectInStream{},
+		streams:  map[int][]byte{},
+		objects:  map[int]any{},
+	}
+
+	// the later objects are updates of the earlier ones
+	for _, m := range pdfObjectHeader.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		f.offsets[num] = m[1]
+	}
+
+	for num, offset := range f.offsets {
+		if bytes.Contains(data[offset:min(offset+512, len(data))], []byte("/ObjStm")) {
+			f.pendingStreams = append(f.pendingStreams, num)
+		}
+	}
+	// the later streams are updates of the earlier ones, they are searched first
+	slices.SortFunc(f.pendingStreams, func(a, b int) int { return f.offsets[b] - f.offsets[a] })
+	return f
+}
+
+// unpacks the next object stream, false when there is none left
+func (f *pdfFile) loadNextObjectStream() bool {
+	if len(f.pendingStreams) == 0 {
+		return false
+	}
+	num := f.pendingStreams[0]
+	f.pendingStreams = f.pendingStreams[1:]
+
+	f.loadObjectStream(num)
+	return true
+}
+
+func (f *pdfFile) loadObjectStream(num int) {
+	stream, ok := f.object(num).(pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("ObjStm") {
+		return
+	}
+
+	data, err := f.decode(stream)
+	if err != nil {
+		return
+	}
+	f.streams[num] = data
+
+	n, _ := f.resolve(stream.dict["N"]).(float64)
+	first, _ := f.resolve(stream.dict["First"]).(float64)
+
+	// pairs of the object number and its offset after First
+	l := &pdfLexer{data: data}
+	for i := 0; i < int(n); i++ {
+		objNum, ok1 := l.integer()
+		offset, ok2 := l.integer()
+		if !ok1 || !ok2 {
+			return
+		}
+		// the streams loaded before are newer
+		_, direct := f.offsets[objNum]
+		_, loaded := f.inStream[objNum]
+		if !direct && !loaded {
+			f.inStream[objNum] = objectInStream{stream: num, offset: int(first) + offset}
+		}
+	}
+}
+
+func (f *pdfFile) object(num int) any {
+	if v, ok := f.objects[num]; ok {
+		return v
+	}
+	// a reference to itself resolves to null
+	f.objects[num] = nil
+
+	var v any
+	if offset, ok := f.offsets[num]; ok {
+		l := &pdfLexer{data: f.data, pos: offset, file: f}
+		v, _ = l.next()
+	} else {
+		// the object streams are unpacked until one of them has the object
+		in, ok := f.inStream[num]
+		for !ok && f.loadNextObjectStream() {
+			in, ok = f.inStream[num]
+		}
+		if data := f.streams[in.stream]; ok && in.offset < len(data) {
+			l := &pdfLexer{data: data, pos: in.offset}
+			v, _ = l.next()
+		}
+	}
+
+	f.objects[num] = v
+	return v
+}
+
+func (f *pdfFile) resolve(v any) any {
+	for i := 0; i < 16; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = f.object(ref.num)
+	}
+	return nil
+}
+
+// the data of the stream starting at start, when its Length is wrong it ends before endstream
+func (f *pdfFile) stream(dict pdfDict, start int) pdfStream {
+	length, ok := f.resolve(dict["Length"]).(float64)
+	end := start + int(length)
+
+	if !ok || length < 0 || end > len(f.data) || !bytes.Contains(f.data[end:min(end+32, len(f.data))], []byte("endstream")) {
+		i := bytes.Index(f.data[start:], []byte("endstream"))
+		if i < 0 {
+			end = len(f.data)
+		} else {
+			end = start + i
+		}
+	}
+	return pdfStream{dict: dict, data: f.data[start:end]}
+}
+
+// decode unpacks the data of the stream, the predictors of DecodeParms are only used by xref streams and images
+func (f *pdfFile) decode(stream pdfStream) ([]byte, error) {
+	var filters []any
+	switch v := f.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{v}
+	case pdfArray:
+		filters = v
+	}
+
+	data := stream.data
+	for _, filter := range filters {
+		switch f.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+
+			limit := min(maxPdfStream, maxPdfUnpacked-f.unpacked)
+			if limit <= 0 {
+				return nil, errPdfTooBig
+			}
+
+			// a stream cut short still gives its beginning
+			out, err := io.ReadAll(io.LimitReader(r, int64(limit)))
+			f.unpacked += len(out)
+			if err != nil && len(out) == 0 {
+				return nil, err
+			}
+			data = out
+		default:
+			return nil, fmt.Errorf("unsupported filter %v", filter)
+		}
+	}
+	return data, nil
+}
+
+// the first page and its resources, they are inherited from the parents of the page
+func (f *pdfFile) firstPage() (pdfDict, pdfDict) {
+	roots := pdfRoot.FindAllSubmatch(f.data, -1)
+	if len(roots) == 0 {
+		return nil, nil
+	}
+	num, _ := strconv.Atoi(string(roots[len(roots)-1][1]))
+
+	catalog, _ := f.object(num).(pdfDict)
+	node, _ := f.resolve(catalog["Pages"]).(pdfDict)
+
+	var resources pdfDict
+	for depth := 0; node != nil && depth < 32; depth++ {
+		if r, ok := f.resolve(node["Resources"]).(pdfDict); ok {
+			resources = r
+		}
+
+		kids, _ := f.resolve(node["Kids"]).(pdfArray)
+		if node["Type"] == pdfName("Page") || len(kids) == 0 {
+			return node, resources
+		}
+		node, _ = f.resolve(kids[0]).(pdfDict)
+	}
+	return nil, nil
+}
+
+// the content streams of the page joined together
+func (f *pdfFile) pageContent(page pdfDict) []byte {
+	var parts pdfArray
+	switch v := f.resolve(page["Contents"]).(type) {
+	case pdfStream:
+		parts = pdfArray{v}
+	case pdfArray:
+		parts = v
+	}
+
+	var content []byte
+	for _, part := range parts {
+		stream, ok := f.resolve(part).(pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := f.decode(stream)
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+	return content
+}
+
+//* text of the page
+
+type pdfFont struct {
+	toUnicode map[string]string
+	codeLen   int // bytes of one character code
+
+	// glyph ids without a ToUnicode map, the text can't be read
+	unreadable bool
+}
+
+func (f *pdfFile) font(resources pdfDict, name pdfName) *pdfFont {
+	font := &pdfFont{codeLen: 1}
+
+	fonts, _ := f.resolve(resources["Font"]).(pdfDict)
+	dict, _ := f.resolve(fonts[name]).(pdfDict)
+	if dict == nil {
+		return font
+	}
+
+	if dict["Subtype"] == pdfName("Type0") {
+		font.codeLen = 2
+		font.unreadable = true
+	}
+
+	if stream, ok := f.resolve(dict["ToUnicode"]).(pdfStream); ok {
+		if data, err := f.decode(stream); err == nil {
+			toUnicode, codeLen := parseCMap(data)
+			if len(toUnicode) > 0 {
+				font.toUnicode = toUnicode
+				font.unreadable = false
+				if codeLen > 0 {
+					font.codeLen = codeLen
+				}
+			}
+		}
+	}
+	return font
+}
+
+func (font *pdfFont) text(s pdfString) string {
+	if font.unreadable {
+		return ""
+	}
+	if font.toUnicode == nil {
+		return winAnsi(s)
+	}
+
+	var text strings.Builder
+	for i := 0; i+font.codeLen <= len(s); i += font.codeLen {
+		if t, ok := font.toUnicode[string(s[i:i+font.codeLen])]; ok {
+			text.WriteString(t)
+		} else if font.codeLen == 1 {
+			text.WriteString(winAnsi(s[i : i+1]))
+		}
+	}
+	return text.String()
+}
+
+// parseCMap reads the bfchar and bfrange mappings of a ToUnicode cmap, codeLen is the length of the codes
+// of its codespace range
+func parseCMap(data []byte) (toUnicode map[string]string, codeLen int) {
+	toUnicode = map[string]string{}
+	var operands []any
+
+	l := &pdfLexer{data: data}
+	for {
+		v, err := l.next()
+		if err != nil {
+			return toUnicode, codeLen
+		}
+
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+
+		switch op {
+		case "endcodespacerange":
+			if len(operands) > 0 {
+				if lo, ok := operands[0].(pdfString); ok {
+					codeLen = len(lo)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					toUnicode[string(src)] = utf16Text(dst, 0)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+					continue
+				}
+
+				first, last := codeValue(lo), codeValue(hi)
+				if last < first || last-first > 0xffff {
+					continue
+				}
+
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					// the last character of the destination grows with the code
+					for code := first; code <= last; code++ {
+						toUnicode[codeString(code, len(lo))] = utf16Text(dst, code-first)
+					}
+				case pdfArray:
+					for j, d := range dst {
+						if s, ok := d.(pdfString); ok && first+j <= last {
+							toUnicode[codeString(first+j, len(lo))] = utf16Text(s, 0)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func codeValue(s pdfString) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		n = n<<8 | int(s[i])
+	}
+	return n
+}
+
+func codeString(code int, length int) string {
+	s := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		s[i] = byte(code)
+		code >>= 8
+	}
+	return string(s)
+}
+
+// utf16Text decodes UTF-16BE with add added to the last character
+func utf16Text(s pdfString, add int) string {
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+	}
+	if len(units) == 0 {
+		return ""
+	}
+	units[len(units)-1] += uint16(add)
+	return string(utf16.Decode(units))
+}
+
+// the characters of WinAnsiEncoding that differ from Latin-1
+var winAnsiHigh = []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ")
+
+// the fonts without a ToUnicode map mostly use the standard encodings, WinAnsi is the closest to all of them
+func winAnsi(s pdfString) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x80 && c < 0xa0 {
+			runes[i] = winAnsiHigh[c-0x80]
+		} else {
+			runes[i] = rune(c)
+		}
+	}
+	return string(runes)
+}
+
+// pageText reads the text of the content stream in the order it's drawn, images are the XObjects drawn by the page
+func (f *pdfFile) pageText(content []byte, resources pdfDict) (text string, images []pdfName) {
+	var out strings.Builder
+	var operands []any
+
+	fonts := map[pdfName]*pdfFont{}
+	font := &pdfFont{codeLen: 1}
+	lastY := math.NaN()
+
+	l := &pdfLexer{data: content}
+	for out.Len() < maxTextRead {
+		v, err := l.next()
+		if err != nil {
+			break
+		}
+
+		op, ok := v.(pdfKeyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+
+		var last any
+		if len(operands) > 0 {
+			last = operands[len(operands)-1]
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) == 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					if fonts[name] == nil {
+						fonts[name] = f.font(resources, name)
+					}
+					font = fonts[name]
+				}
+			}
+		case "Tj":
+			if s, ok := last.(pdfString); ok {
+				out.WriteString(font.text(s))
+			}
+		case "'", "\"":
+			if s, ok := last.(pdfString); ok {
+				out.WriteString(" " + font.text(s))
+			}
+		case "TJ":
+			array, _ := last.(pdfArray)
+			for _, item := range array {
+				switch item := item.(type) {
+				case pdfString:
+					out.WriteString(font.text(item))
+				case float64:
+					// a big move to the right separates words
+					if item < -200 {
+						out.WriteString(" ")
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) == 2 {
+				if ty, ok := operands[1].(float64); ok && ty != 0 {
+					out.WriteString(" ")
+				}
+			}
+		case "T*":
+			out.WriteString(" ")
+		case "Tm":
+			if len(operands) == 6 {
+				if y, ok := operands[5].(float64); ok && y != lastY {
+					out.WriteString(" ")
+					lastY = y
+				}
+			}
+		case "Do":
+			if name, ok := last.(pdfName); ok {
+				images = append(images, name)
+			}
+		case "ID":
+			// the binary data of an inline image ends with EI between whitespace
+			for l.pos < len(content) {
+				i := bytes.Index(content[l.pos:], []byte("EI"))
+				if i < 0 {
+					l.pos = len(content)
+					break
+				}
+				l.pos += i + 2
+				if isPdfSpace(content[l.pos-3]) && (l.pos >= len(content) || isPdfSpace(content[l.pos])) {
+					break
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+	return out.String(), images
+}
+
+// the picture of a scanned page, the jpeg of the first image drawn by the page
+func (f *pdfFile) pageImage(resources pdfDict, images []pdfName) []byte {
+	xobjects, _ := f.resolve(resources["XObject"]).(pdfDict)
+	for _, name := range images {
+		image, ok := f.resolve(xobjects[name]).(pdfStream)
+		if !ok || image.dict["Subtype"] != pdfName("Image") {
+			continue
+		}
+
+		filter := f.resolve(image.dict["Filter"])
+		if array, ok := filter.(pdfArray); ok && len(array) == 1 {
+			filter = f.resolve(array[0])
+		}
+		if filter == pdfName("DCTDecode") {
+			return image.data
+		}
+	}
+	return nil
+}
+
+// text decoded with a wrong encoding is mostly made of control characters and unassigned code points
+func readable(text string) bool {
+	total, good := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			good++
+		}
+	}
+	return total > 0 && good*10 >= total*9
+}
+
+// the text of the first page, scanned pages without text get their picture as the thumbnail
+func pdfPreview(src io.ReaderAt, size int64) (Preview, error) {
+	data := make([]byte, size)
+	_, err := src.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return Preview{}, err
+	}
+
+	f := openPdf(data)
+	page, resources := f.firstPage()
+	if page == nil {
+		return Preview{}, errors.New("no pages found in the pdf")
+	}
+
+	text, images := f.pageText(f.pageContent(page), resources)
+
+	var preview Preview
+	if readable(text) {
+		preview.Text = snippet(text)
+	}
+
+	if preview.Text == "" {
+		if scan := f.pageImage(resources, images); scan != nil {
+			thumbnail, err := Thumbnail(bytes.NewReader(scan))
+			if err == nil {
+				preview.Thumbnail = thumbnail
+			}
+		}
+	}
+	return preview, nil
+}
@@ -0,0 +1,157 @@
+package previews
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+//* previews of uploaded files made without any external programs - a small jpeg of pictures and the beginning
+// of the text of documents (txt, docx and the first page of pdfs), pdf pages can't be rendered in pure go
+// so only the scanned ones get a thumbnail, the picture of the scan
+
+// the longer side of thumbnails in pixels
+var THUMBNAIL_SIZE = 320
+
+// bigger pictures are not decoded, a small file can hold a huge picture that would take all the memory
+var MAX_THUMBNAIL_PIXELS = 50_000_000
+
+// characters of the preview text
+var PREVIEW_TEXT_LENGTH = 300
+
+// bigger documents get no preview, pdfs are read whole into the memory
+var MAX_PREVIEW_FILE_SIZE = int64(50 * 1024 * 1024)
+
+type Preview struct {
+	Thumbnail []byte // jpeg, nil when the file has no picture
+	Text      string // empty when the file has no text
+}
+
+// Generate makes the preview of the file of the mime type, files of other types and files without any text
+// get an empty preview, an error means the file couldn't be read
+func Generate(src io.ReaderAt, size int64, mimeType string) (preview Preview, err error) {
+	if size > MAX_PREVIEW_FILE_SIZE {
+		return Preview{}, nil
+	}
+
+	// the documents come from the users, a broken one must not take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			preview, err = Preview{}, fmt.Errorf("broken %v: %v", mimeType, r)
+		}
+	}()
+
+	switch mimeType {
+	case "image/png", "image/jpeg", "image/gif":
+		thumbnail, err := Thumbnail(io.NewSectionReader(src, 0, size))
+		return Preview{Thumbnail: thumbnail}, err
+	case "text/plain":
+		text, err := plainText(io.NewSectionReader(src, 0, size))
+		return Preview{Text: text}, err
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		text, err := docxText(src, size)
+		return Preview{Text: text}, err
+	case "application/pdf":
+		return pdfPreview(src, size)
+	}
+	return Preview{}, nil
+}
+
+// Thumbnail decodes the picture and scales it down to THUMBNAIL_SIZE, transparent parts become white
+func Thumbnail(src io.ReadSeeker) ([]byte, error) {
+	config, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MAX_THUMBNAIL_PIXELS {
+		return nil, nil
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, scaleDown(img, THUMBNAIL_SIZE), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown fits the picture into a square of the size, every pixel of the result is the average
+// of the pixels it covers, smaller pictures keep their size
+func scaleDown(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(h*size/w, 1)
+		} else {
+			dw, dh = max(w*size/h, 1), size
+		}
+	}
+
+	// sums of the covered pixels, alpha premultiplied
+	type sum struct{ r, g, b, a, n uint64 }
+	sums := make([]sum, dw*dh)
+
+	for y := 0; y < h; y++ {
+		dy := y * dh / h
+		for x := 0; x < w; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			s := &sums[dy*dw+x*dw/w]
+			s.r += uint64(r)
+			s.g += uint64(g)
+			s.b += uint64(b)
+			s.a += uint64(a)
+			s.n++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for i, s := range sums {
+		if s.n == 0 {
+			continue
+		}
+		// on a white background
+		white := (0xffff*s.n - s.a)
+		dst.SetRGBA(i%dw, i/dw, color.RGBA{
+			R: uint8((s.r + white) / s.n >> 8),
+			G: uint8((s.g + white) / s.n >> 8),
+			B: uint8((s.b + white) / s.n >> 8),
+			A: 0xff,
+		})
+	}
+	return dst
+}
+
+// snippet makes the preview text - the whitespace collapsed into single spaces and cut after PREVIEW_TEXT_LENGTH
+// characters at the end of a word
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= PREVIEW_TEXT_LENGTH {
+		return text
+	}
+
+	runes := []rune(text)[:PREVIEW_TEXT_LENGTH]
+	if i := strings.LastIndexFunc(string(runes), unicode.IsSpace); i > PREVIEW_TEXT_LENGTH/2 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,97 @@
+package previews
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// more than enough to get PREVIEW_TEXT_LENGTH characters, the rest of the file is not read
+const maxTextRead = 64 * 1024
+
+// limit of the unpacked document.xml of docx files, it's a zip and can unpack into much more than it takes
+const maxDocxXml = 20 * 1024 * 1024
+
+func plainText(src io.Reader) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(src, maxTextRead))
+	if err != nil {
+		return "", err
+	}
+
+	// the read may have ended in the middle of a character
+	for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(data); r != utf8.RuneError || size != 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+	return snippet(strings.ToValidUTF8(string(data), "")), nil
+}
+
+// the text of a docx is in the <w:t> elements of word/document.xml, paragraphs and tabs are separated by spaces
+func docxText(src io.ReaderAt, size int64) (string, error) {
+	archive, err := zip.NewReader(src, size)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range archive.File {
+		if file.Name != "word/document.xml" {
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+
+		return wordText(io.LimitReader(content, maxDocxXml))
+	}
+	return "", errors.New("docx without word/document.xml")
+}
+
+func wordText(src io.Reader) (string, error) {
+	var text strings.Builder
+	inText := false
+
+	decoder := xml.NewDecoder(src)
+	for text.Len() < maxTextRead {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// a document cut by the limit still has its beginning
+			if text.Len() > 0 {
+				break
+			}
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab", "br":
+				text.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString(" ")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+	return snippet(text.String()), nil
+}
@@ -0,0 +1,240 @@
+package uploads
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+//* the checks of uploaded files, shared by course materials and the attachments of feed posts -
+// the extension says what the file should be, the content detected from the magic bytes has to agree with it,
+// files hiding another format (polyglots) or executables are refused
+
+var (
+	ErrFileTooBig        = errors.New("file is too big")
+	ErrFileTypeForbidden = errors.New("forbidden file type")
+)
+
+// FileTypeError says why the file was refused and lists the allowed types,
+// it matches ErrFileTypeForbidden with errors.Is
+type FileTypeError struct {
+	Reason string
+}
+
+func (e *FileTypeError) Error() string {
+	return e.Reason + ", allowed types are " + strings.Join(AllowedExtensions(), ", ")
+}
+
+func (e *FileTypeError) Is(target error) bool {
+	return target == ErrFileTypeForbidden
+}
+
+// max file size in bytes
+var MAX_SIZE = int64(30 * 1024 * 1024)
+
+var ALLOWED_FILES = map[string]bool{
+	"application/pdf": true, // .pdf
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true, // .docx
+	"text/plain": true, // .txt
+	"image/png":  true, // .png
+	"image/jpeg": true, // .jpeg and .jpg
+	"image/gif":  true, // .gif
+	"video/mp4":  true, // .mp4
+	"audio/mpeg": true, // .mp3
+}
+
+var MIME_TO_EXT = map[string]string{
+	"application/pdf": ".pdf",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
+	"text/plain": ".txt",
+	"image/png":  ".png",
+	"image/jpeg": ".jpeg",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"audio/mpeg": ".mp3",
+}
+
+var EXT_TO_MIME = map[string]string{
+	".pdf":  "application/pdf",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".txt":  "text/plain",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".mp4":  "video/mp4",
+	".mp3":  "audio/mpeg",
+}
+
+// text that browsers or interpreters run, refused even though it's plain text
+var ACTIVE_TEXT_TYPES = []string{
+	"text/html",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+	"text/javascript",
+	"application/javascript",
+	"text/x-php",
+	"text/x-shellscript",
+	"text/x-python",
+	"text/x-perl",
+	"text/x-lua",
+	"text/x-tcl",
+}
+
+// markers of scripts hidden in images, browsers that sniff the content could run them
+var IMAGE_SCRIPT_MARKERS = [][]byte{
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<?php"),
+}
+
+// files inside documents that can run code when opened
+var EXECUTABLE_EXTENSIONS = []string{
+	".exe", ".dll", ".com", ".scr", ".msi", ".bat", ".cmd", ".ps1", ".vbs", ".js", ".jar", ".sh",
+}
+
+// AllowedExtensions returns the allowed extensions, sorted
+func AllowedExtensions() []string {
+	exts := make([]string, 0, len(EXT_TO_MIME))
+	for ext := range EXT_TO_MIME {
+		exts = append(exts, ext)
+	}
+	slices.Sort(exts)
+	return exts
+}
+
+// CheckFile checks the size and the type of the uploaded file, returns its mime type
+func CheckFile(file *multipart.FileHeader) (string, error) {
+	if file == nil {
+		return "", &FileTypeError{Reason: "no file was uploaded"}
+	}
+
+	if file.Size > MAX_SIZE {
+		return "", ErrFileTooBig
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	return CheckContent(src, file.Size, file.Filename)
+}
+
+// CheckContent checks that the content of the file is of the type its extension says, returns the mime type,
+// the file is rewound afterwards
+func CheckContent(src multipart.File, size int64, filename string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	expected, ok := EXT_TO_MIME[ext]
+	if !ok {
+		if ext == "" {
+			return "", &FileTypeError{Reason: "file has no extension"}
+		}
+		return "", &FileTypeError{Reason: "files of type " + ext + " are not allowed"}
+	}
+
+	detected, err := mimetype.DetectReader(src)
+	if err != nil {
+		return "", err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if !isOfType(detected, expected) {
+		return "", &FileTypeError{Reason: "content of the file is " + detectedName(detected) + ", not " + ext}
+	}
+
+	err = checkHiddenContent(src, size, expected)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return expected, nil
+}
+
+// the detected type or one of its parents has to be the expected one,
+// text has to stay plain text and not be a page or a script
+func isOfType(detected *mimetype.MIME, expected string) bool {
+	if expected == "text/plain" {
+		for m := detected; m != nil; m = m.Parent() {
+			for _, active := range ACTIVE_TEXT_TYPES {
+				if m.Is(active) {
+					return false
+				}
+			}
+		}
+	}
+
+	for m := detected; m != nil; m = m.Parent() {
+		if m.Is(expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func detectedName(detected *mimetype.MIME) string {
+	if detected.Extension() != "" {
+		return detected.Extension() + " (" + detected.String() + ")"
+	}
+	return detected.String()
+}
+
+// looks for the other format of polyglots - a zip archive appended to the file (read by zip tools and java from the end),
+// scripts in images and macros or executables in documents
+func checkHiddenContent(src multipart.File, size int64, expected string) error {
+	archive, err := zip.NewReader(src, size)
+	isArchive := err == nil && len(archive.File) > 0
+
+	if expected == EXT_TO_MIME[".docx"] {
+		if !isArchive {
+			return &FileTypeError{Reason: "document is damaged"}
+		}
+		for _, f := range archive.File {
+			name := strings.ToLower(f.Name)
+			if path.Base(name) == "vbaproject.bin" {
+				return &FileTypeError{Reason: "documents with macros are not allowed"}
+			}
+			if slices.Contains(EXECUTABLE_EXTENSIONS, path.Ext(name)) {
+				return &FileTypeError{Reason: "document contains an executable file " + path.Base(f.Name)}
+			}
+		}
+		return nil
+	}
+
+	if isArchive {
+		return &FileTypeError{Reason: "file contains a hidden zip archive"}
+	}
+
+	if strings.HasPrefix(expected, "image/") {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		content, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		content = bytes.ToLower(content)
+		for _, marker := range IMAGE_SCRIPT_MARKERS {
+			if bytes.Contains(content, marker) {
+				return &FileTypeError{Reason: "image contains a script"}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/utils"
+)
+
+//* storage quotas of courses - all files of a course (materials with their older versions, post attachments
+// and the resumable uploads in progress) have to fit into its quota, courses without their own quota have STORAGE_QUOTA
+
+// default space for the files of a course in bytes, 0 is unlimited
+var STORAGE_QUOTA = int64(0)
+
+var (
+	ErrQuotaExceeded = errors.New("the course has no space left for the file")
+	ErrUnknownCourse = errors.New("unknown course id")
+)
+
+type StorageUsage struct {
+	MaterialsBytes   int64 `json:"materialsBytes"`   // current files of the materials
+	VersionsBytes    int64 `json:"versionsBytes"`    // older versions of the material files
+	AttachmentsBytes int64 `json:"attachmentsBytes"` // files attached to posts
+	UploadsBytes     int64 `json:"uploadsBytes"`     // resumable uploads not used by a material yet
+	UsedBytes        int64 `json:"usedBytes"`
+
+	// null when the course is unlimited
+	QuotaBytes *int64 `json:"quotaBytes"`
+	FreeBytes  *int64 `json:"freeBytes"`
+}
+
+// CourseQuota returns the space for the files of the course, 0 when it's unlimited
+func CourseQuota(ctx context.Context, q *db.Queries, courseId string) (int64, error) {
+	quota, err := q.GetCourseStorageQuota(ctx, courseId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return 0, ErrUnknownCourse
+		}
+		return 0, err
+	}
+
+	if !quota.Valid {
+		return STORAGE_QUOTA, nil
+	}
+	return quota.Int64, nil
+}
+
+func CourseUsage(ctx context.Context, q *db.Queries, courseId string) (StorageUsage, error) {
+	quota, err := CourseQuota(ctx, q, courseId)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	row, err := q.GetCourseStorageUsage(ctx, courseId)
+	if err != nil {
+		return StorageUsage{}, err
+	}
+
+	usage := StorageUsage{
+		MaterialsBytes:   row.MaterialsBytes,
+		VersionsBytes:    row.VersionsBytes,
+		AttachmentsBytes: row.AttachmentsBytes,
+		UploadsBytes:     row.UploadsBytes,
+		UsedBytes:        row.MaterialsBytes + row.VersionsBytes + row.AttachmentsBytes + row.UploadsBytes,
+	}
+
+	if quota > 0 {
+		free := max(quota-usage.UsedBytes, 0)
+		usage.QuotaBytes = &quota
+		usage.FreeBytes = &free
+	}
+	return usage, nil
+}
+
+// CheckQuota returns ErrQuotaExceeded when a new file of the size doesn't fit into the quota of the course
+func CheckQuota(ctx context.Context, q *db.Queries, courseId string, size int64) error {
+	usage, err := CourseUsage(ctx, q, courseId)
+	if err != nil {
+		return err
+	}
+
+	if usage.FreeBytes != nil && size > *usage.FreeBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package uploads
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//* storage in a bucket of an S3 compatible service (AWS S3, MinIO, Garage, ...) - the objects are addressed
+// path style (<endpoint>/<bucket>/<key>) and the requests are signed with AWS signature version 4,
+// the body of uploads is not hashed (UNSIGNED-PAYLOAD) so the file can be streamed
+
+// limit of one request to the storage, uploads of big files included
+var S3_TIMEOUT = 5 * time.Minute
+
+type S3Storage struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// base of the urls of the files, e.g. a CDN in front of the bucket, the default is <endpoint>/<bucket>
+	PublicUrl string
+
+	client *http.Client
+}
+
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey, publicUrl string) *S3Storage {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	return &S3Storage{
+		Endpoint:  endpoint,
+		Region:    cmp.Or(region, "us-east-1"),
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PublicUrl: strings.TrimSuffix(cmp.Or(publicUrl, endpoint+"/"+bucket), "/"),
+		client:    &http.Client{Timeout: S3_TIMEOUT},
+	}
+}
+
+func S3StorageFromEnv() (*S3Storage, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	bucket := os.Getenv("S3_BUCKET")
+	accessKey := os.Getenv("S3_ACCESS_KEY")
+	secretKey := os.Getenv("S3_SECRET_KEY")
+
+	if endpoint == "" || bucket == "" || accessKey == "" || secretKey == "" {
+		return nil, errors.New("STORAGE=s3 needs S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY")
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %w", err)
+	}
+
+	return NewS3Storage(endpoint, os.Getenv("S3_REGION"), bucket, accessKey, secretKey, os.Getenv("S3_PUBLIC_URL")), nil
+}
+
+func (s *S3Storage) Save(ctx context.Context, key string, src io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, src)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, ErrFileNotFound
+	}
+
+	defer res.Body.Close()
+	return nil, s3Error(res)
+}
+
+func (s *S3Storage) Remove(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// deleting a missing object succeeds on S3, some of the compatible services answer 404
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+	return nil
+}
+
+// List pages through ListObjectsV2 of the bucket, up to 1000 objects are returned by one request
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	objects := []StoredObject{}
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Endpoint+"/"+uriEncode(s.Bucket), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.RawQuery = canonicalQueryString(query)
+
+		res, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err = s3Error(res)
+		} else {
+			err = xml.NewDecoder(res.Body).Decode(&body)
+		}
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range body.Contents {
+			objects = append(objects, StoredObject{
+				Key:     object.Key,
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+
+		if !body.IsTruncated || body.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = body.NextContinuationToken
+	}
+}
+
+func (s *S3Storage) Url(key string) string {
+	return s.PublicUrl + "/" + uriEncode(key)
+}
+
+// SignedUrl presigns a GET of the object (signature version 4 in the query), it's on the endpoint
+// and not on S3_PUBLIC_URL as a CDN in front of the bucket would not pass the signature through
+func (s *S3Storage) SignedUrl(key string, expires time.Duration, query url.Values) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrBadKey
+	}
+
+	u, err := url.Parse(s.Endpoint + "/" + uriEncode(s.Bucket+"/"+key))
+	if err != nil {
+		return "", err
+	}
+
+	s.presign(u, expires, query, time.Now().UTC())
+	return u.String(), nil
+}
+
+// presign adds the signature of a GET of the url to its query
+func (s *S3Storage) presign(u *url.URL, expires time.Duration, query url.Values, now time.Time) {
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+
+	params := url.Values{}
+	for k, v := range query {
+		params[k] = v
+	}
+	params.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	params.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	params.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	params.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	params.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalQuery := canonicalQueryString(params)
+	signature := s.signature(http.MethodGet, u.EscapedPath(), canonicalQuery, "host:"+u.Host+"\n", "host", now)
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func (s *S3Storage) request(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, ErrBadKey
+	}
+
+	return http.NewRequestWithContext(ctx, method, s.Endpoint+"/"+uriEncode(s.Bucket+"/"+key), body)
+}
+
+// signs and sends the request
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds the Authorization header of AWS signature version 4
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	signature := s.signature(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, now)
+
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// signature of the canonical request, the same for the Authorization header and for presigned urls,
+// the payload is always UNSIGNED-PAYLOAD
+func (s *S3Storage) signature(method, path, rawQuery, canonicalHeaders, signedHeaders string, now time.Time) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		rawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	return hex.EncodeToString(hmacSha256(s.signingKey(date), stringToSign))
+}
+
+func (s *S3Storage) signingKey(date string) []byte {
+	key := hmacSha256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSha256(key, s.Region)
+	key = hmacSha256(key, "s3")
+	return hmacSha256(key, "aws4_request")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode escapes everything except the unreserved characters and slashes the way the signature expects it
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// query sorted by the keys with the keys and values escaped like uriEncode, slashes included
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, queryEncode(k)+"="+queryEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func queryEncode(s string) string {
+	return strings.ReplaceAll(uriEncode(s), "/", "%2F")
+}
+
+// the error of the storage from its xml response
+func s3Error(res *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+
+	if xml.Unmarshal(data, &body) != nil || body.Code == "" {
+		return fmt.Errorf("storage responded with %v", res.StatusCode)
+	}
+	return fmt.Errorf("storage responded with %v: %v %v", res.StatusCode, body.Code, body.Message)
+}
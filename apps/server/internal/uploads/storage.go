@@ -0,0 +1,188 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//* storages of the uploaded files of courses (materials and post attachments) -
+// the files are saved under keys like uploads/<courseId>/materials/<materialId>.pdf and only the key is kept in the db,
+// the url of the file is asked from the storage when it's read, so changing the domain or the storage doesn't break them
+
+var (
+	ErrFileNotFound = errors.New("file not found")
+	ErrBadKey       = errors.New("invalid file key")
+)
+
+type Storage interface {
+	// Save stores the content under the key, a file with the same key is replaced
+	Save(ctx context.Context, key string, src io.Reader, size int64, contentType string) error
+	// Open returns the content of the file, ErrFileNotFound when there is none
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Remove removes the file, a missing file is not an error
+	Remove(ctx context.Context, key string) error
+	// Url returns where the file is downloaded from, urls starting with / are relative to the server
+	Url(key string) string
+	// List returns all files whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]StoredObject, error)
+}
+
+// a file in the storage, found by List
+type StoredObject struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Signer is implemented by storages the files can be downloaded from directly, the access to the files
+// is checked by the server which then redirects to a short-lived signed url
+type Signer interface {
+	// SignedUrl returns the url of the file valid for the given time, query holds extra parameters
+	// of the response (e.g. response-content-disposition)
+	SignedUrl(key string, expires time.Duration, query url.Values) (string, error)
+}
+
+// reads STORAGE (local or s3), the s3 storage is configured by S3_ENDPOINT, S3_REGION, S3_BUCKET,
+// S3_ACCESS_KEY, S3_SECRET_KEY and S3_PUBLIC_URL, without STORAGE the files are stored in the static folder
+func StorageFromEnv(staticPath string) (Storage, error) {
+	switch strings.ToLower(os.Getenv("STORAGE")) {
+	case "", "local":
+		return &LocalStorage{Root: staticPath}, nil
+	case "s3":
+		return S3StorageFromEnv()
+	}
+	return nil, fmt.Errorf("unknown STORAGE %q, use local or s3", os.Getenv("STORAGE"))
+}
+
+// StorageKey returns the key of a stored file, materials created before the storages kept the absolute url
+// of the file in the static folder, the key is the part after STATIC_URL_PREFIX
+func StorageKey(url string) string {
+	if _, key, found := strings.Cut(url, STATIC_URL_PREFIX); found {
+		return key
+	}
+	return url
+}
+
+// AbsoluteUrl prefixes the urls relative to the server with its scheme and host
+func AbsoluteUrl(scheme string, host string, url string) string {
+	if !strings.HasPrefix(url, "/") {
+		return url
+	}
+	return scheme + "://" + host + url
+}
+
+//* local storage - the files are in the static folder served under STATIC_URL_PREFIX
+
+type LocalStorage struct {
+	Root string
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", ErrBadKey
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStorage) Save(ctx context.Context, key string, src io.Reader, size int64, contentType string) error {
+	if _, err := s.path(key); err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(filepath.FromSlash(key))
+	return SaveFile(s.Root, dir, name, src)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFileNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStorage) Remove(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// the folders of removed materials and courses are removed too once they are empty,
+	// removing a folder with files in it fails and stops it
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.Root) && strings.HasPrefix(dir, filepath.Clean(s.Root)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) Url(key string) string {
+	return STATIC_URL_PREFIX + key
+}
+
+// the files of the prefix are found by walking the folder the prefix is in, a missing folder has no files
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]StoredObject, error) {
+	dir := path.Dir(prefix + "x")
+	root, err := s.path(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []StoredObject{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		objects = append(objects, StoredObject{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return objects, err
+}
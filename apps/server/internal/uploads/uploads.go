@@ -0,0 +1,108 @@
+package uploads
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+//* helpers for storing uploaded files in the static folder, shared by materials and user avatars
+
+// prefix under which the static folder is reachable from the outside (the /api part is stripped by the proxy)
+var STATIC_URL_PREFIX = "/api/static/"
+
+// prefix of the api seen from the outside, the downloads of material files go through it
+var API_URL_PREFIX = "/api"
+
+// detects the mime type from the content of the file, the reader is rewound afterwards
+func DetectMimeType(src io.ReadSeeker) (string, error) {
+
+	m, err := mimetype.DetectReader(src)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return m.String(), nil
+}
+
+// saves the file to <staticPath>/<dir>/<name>, creating the folders when they don't exist
+func SaveFile(staticPath string, dir string, name string, src io.Reader) error {
+
+	pathToFolder := filepath.Join(staticPath, dir)
+
+	err := os.MkdirAll(pathToFolder, 0755)
+	if err != nil {
+		fmt.Println("failed to create upload folder, wrong path", err)
+		return err
+	}
+
+	dst, err := os.Create(filepath.Join(pathToFolder, name))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removes all files in <staticPath>/<dir> whose name starts with prefix,
+// a missing folder is not an error - there is nothing to remove
+func RemoveFilesWithPrefix(staticPath string, dir string, prefix string) error {
+
+	pathToFolder := filepath.Join(staticPath, dir)
+
+	entries, err := os.ReadDir(pathToFolder)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if strings.HasPrefix(name, prefix) {
+			err := os.Remove(filepath.Join(pathToFolder, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// public url of a file stored in the static folder, path is relative to the static folder
+func FileUrl(scheme string, host string, path string) string {
+	return scheme + "://" + host + STATIC_URL_PREFIX + path
+}
+
+// url of the access-controlled download of a material file, relative to the server
+func MaterialFileUrl(courseId string, materialId string) string {
+	return API_URL_PREFIX + "/courses/" + courseId + "/materials/" + materialId + "/file"
+}
+
+// url of the access-controlled download of a file attached to a feed post, relative to the server
+func AttachmentFileUrl(courseId string, postId string, attachmentId string) string {
+	return API_URL_PREFIX + "/courses/" + courseId + "/feed/" + postId + "/attachments/" + attachmentId + "/file"
+}
+
+// url of the thumbnail of a material file, it's access-controlled the same way as the file
+func MaterialThumbnailUrl(courseId string, materialId string) string {
+	return API_URL_PREFIX + "/courses/" + courseId + "/materials/" + materialId + "/thumbnail"
+}
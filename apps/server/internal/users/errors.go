@@ -0,0 +1,9 @@
+package users
+
+import "errors"
+
+var (
+	ErrUserNotFound     = errors.New("unknown user id")
+	ErrEmailInUse       = errors.New("email already in use")
+	ErrSelfModification = errors.New("admins can't demote, deactivate or delete themselves")
+)
@@ -0,0 +1,315 @@
+package users
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* admin only endpoints for managing users
+
+type Handler struct {
+	*handlers.Handler
+	service *Service
+}
+
+func NewHandler(service *Service, queries *db.Queries, isDeployed bool) *Handler {
+	return &Handler{
+		handlers.NewHandler(queries, isDeployed),
+		service,
+	}
+}
+
+// reads the :userId path param, returns false if it's not a number
+func userIdParam(r *handlers.RequestCtx) (int64, bool) {
+	userId, err := strconv.ParseInt(r.Echo.Param("userId"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return userId, true
+}
+
+// translates the signal errors of the service to responses
+func (h *Handler) serviceError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrUserNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrEmailInUse:
+		return r.Error(http.StatusBadRequest, err.Error())
+	case ErrSelfModification:
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+	return r.ServerError(err)
+}
+
+type ListUsersRequest struct {
+	Search      *string `query:"search"`
+	IsAdmin     *bool   `query:"isAdmin"`
+	Deactivated *bool   `query:"deactivated"`
+
+	Limit  int `query:"limit"`
+	Offset int `query:"offset"`
+}
+
+// GET /users?search=&isAdmin=&deactivated=&limit=&offset=
+func (h *Handler) ListUsers(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req ListUsersRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid query parameters")
+	}
+
+	if req.Search != nil && *req.Search == "" {
+		req.Search = nil
+	}
+
+	users, err := h.service.ListUsers(ListUsersFilter{
+		Search:      req.Search,
+		IsAdmin:     req.IsAdmin,
+		Deactivated: req.Deactivated,
+		Limit:       req.Limit,
+		Offset:      req.Offset,
+	}, r.Ctx)
+	if err != nil {
+		return r.ServerError(err)
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+// GET /users/:userId
+func (h *Handler) GetUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	user, err := h.service.GetUser(userId, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+type CreateUserRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	IsAdmin   bool   `json:"isAdmin"`
+}
+
+// POST /users
+func (h *Handler) CreateUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req CreateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.LastName = strings.TrimSpace(req.LastName)
+	req.Email = utils.NormalizeEmail(req.Email)
+
+	err := validateCreateUser(req)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.service.CreateUser(req.FirstName, req.LastName, req.Email, req.Password, req.IsAdmin, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+func validateCreateUser(req CreateUserRequest) error {
+	if err := utils.ValidateName(req.FirstName, "first name"); err != nil {
+		return err
+	}
+	if err := utils.ValidateName(req.LastName, "last name"); err != nil {
+		return err
+	}
+	if err := utils.ValidateEmail(req.Email); err != nil {
+		return err
+	}
+	return utils.ValidatePassword(req.Password, req.Email, req.FirstName, req.LastName)
+}
+
+type UpdateUserRequest struct {
+	FirstName *string `json:"firstName"`
+	LastName  *string `json:"lastName"`
+	Email     *string `json:"email"`
+}
+
+// PUT /users/:userId
+func (h *Handler) UpdateUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	var req UpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	err := validateUpdateUser(&req)
+	if err != nil {
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.service.UpdateUser(userId, req.FirstName, req.LastName, req.Email, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// normalizes the fields that are being changed and validates them
+func validateUpdateUser(req *UpdateUserRequest) error {
+	if req.FirstName != nil {
+		*req.FirstName = strings.TrimSpace(*req.FirstName)
+		if err := utils.ValidateName(*req.FirstName, "first name"); err != nil {
+			return err
+		}
+	}
+	if req.LastName != nil {
+		*req.LastName = strings.TrimSpace(*req.LastName)
+		if err := utils.ValidateName(*req.LastName, "last name"); err != nil {
+			return err
+		}
+	}
+	if req.Email != nil {
+		*req.Email = utils.NormalizeEmail(*req.Email)
+		if err := utils.ValidateEmail(*req.Email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DELETE /users/:userId
+func (h *Handler) DeleteUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	if int64(r.User.ID) == userId {
+		return h.serviceError(r, ErrSelfModification)
+	}
+
+	err := h.service.DeleteUser(userId, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type SetAdminRequest struct {
+	IsAdmin bool `json:"isAdmin"`
+}
+
+// PUT /users/:userId/admin
+func (h *Handler) SetAdmin(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	var req SetAdminRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	if int64(r.User.ID) == userId && !req.IsAdmin {
+		return h.serviceError(r, ErrSelfModification)
+	}
+
+	user, err := h.service.SetAdmin(userId, req.IsAdmin, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+type ForcePasswordResetResponse struct {
+	TemporaryPassword string `json:"temporaryPassword"`
+}
+
+// POST /users/:userId/reset-password
+func (h *Handler) ForcePasswordReset(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	password, err := h.service.ForcePasswordReset(userId, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, ForcePasswordResetResponse{TemporaryPassword: password})
+}
+
+// POST /users/:userId/deactivate
+func (h *Handler) DeactivateUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	if int64(r.User.ID) == userId {
+		return h.serviceError(r, ErrSelfModification)
+	}
+
+	user, err := h.service.DeactivateUser(userId, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// POST /users/:userId/activate
+func (h *Handler) ReactivateUser(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	userId, ok := userIdParam(r)
+	if !ok {
+		return r.Error(http.StatusBadRequest, "user id must be a number")
+	}
+
+	user, err := h.service.ReactivateUser(userId, r.Ctx)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
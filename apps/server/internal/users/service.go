@@ -0,0 +1,365 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/utils"
+)
+
+// how many users are returned by one page of ListUsers when the request doesn't say
+var DEFAULT_PAGE_SIZE = 50
+var MAX_PAGE_SIZE = 200
+
+type Service struct {
+	q *db.Queries
+}
+
+func NewService(queries *db.Queries) *Service {
+	return &Service{queries}
+}
+
+type User struct {
+	ID int `json:"id"`
+
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+
+	IsAdmin            bool `json:"isAdmin"`
+	MustChangePassword bool `json:"mustChangePassword"`
+
+	Deactivated   bool    `json:"deactivated"`
+	DeactivatedAt *string `json:"deactivatedAt"`
+}
+
+func (s *Service) toUser(dbUser db.User, isAdmin bool) User {
+	user := User{
+		ID:        int(dbUser.ID),
+		FirstName: dbUser.FirstName,
+		LastName:  dbUser.LastName,
+		Email:     dbUser.Email,
+
+		IsAdmin:            isAdmin,
+		MustChangePassword: dbUser.MustChangePassword == 1,
+
+		Deactivated: dbUser.DeactivatedAt.Valid,
+	}
+
+	if dbUser.DeactivatedAt.Valid {
+		deactivatedAt := utils.UnixToIso(dbUser.DeactivatedAt.Int64)
+		user.DeactivatedAt = &deactivatedAt
+	}
+
+	return user
+}
+
+type ListUsersFilter struct {
+	Search      *string
+	IsAdmin     *bool
+	Deactivated *bool
+
+	Limit  int
+	Offset int
+}
+
+type UserList struct {
+	Users []User `json:"users"`
+
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+func (s *Service) ListUsers(filter ListUsersFilter, ctx context.Context) (UserList, error) {
+
+	if filter.Limit <= 0 {
+		filter.Limit = DEFAULT_PAGE_SIZE
+	}
+	filter.Limit = min(filter.Limit, MAX_PAGE_SIZE)
+	filter.Offset = max(filter.Offset, 0)
+
+	search := utils.ToSqlNullString(filter.Search)
+	isAdmin := utils.ToSqlNullBool(filter.IsAdmin)
+	deactivated := utils.ToSqlNullBool(filter.Deactivated)
+
+	rows, err := s.q.ListUsers(ctx, db.ListUsersParams{
+		Search:      search,
+		IsAdmin:     isAdmin,
+		Deactivated: deactivated,
+		Limit:       int64(filter.Limit),
+		Offset:      int64(filter.Offset),
+	})
+	if err != nil {
+		return UserList{}, err
+	}
+
+	total, err := s.q.CountUsers(ctx, db.CountUsersParams{
+		Search:      search,
+		IsAdmin:     isAdmin,
+		Deactivated: deactivated,
+	})
+	if err != nil {
+		return UserList{}, err
+	}
+
+	users := make([]User, 0, len(rows))
+	for _, row := range rows {
+		users = append(users, s.toUser(db.User{
+			ID:                 row.ID,
+			FirstName:          row.FirstName,
+			LastName:           row.LastName,
+			Email:              row.Email,
+			MustChangePassword: row.MustChangePassword,
+			DeactivatedAt:      row.DeactivatedAt,
+		}, row.IsAdmin))
+	}
+
+	return UserList{
+		Users:  users,
+		Total:  int(total),
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}, nil
+}
+
+func (s *Service) GetUser(userId int64, ctx context.Context) (User, error) {
+
+	row, err := s.q.GetUser(ctx, userId)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+
+	return s.toUser(db.User{
+		ID:                 row.ID,
+		FirstName:          row.FirstName,
+		LastName:           row.LastName,
+		Email:              row.Email,
+		MustChangePassword: row.MustChangePassword,
+		DeactivatedAt:      row.DeactivatedAt,
+	}, row.IsAdmin), nil
+}
+
+func (s *Service) GetUserByEmail(email string, ctx context.Context) (User, error) {
+
+	dbUser, err := s.q.GetUserByEmail(ctx, email)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+
+	return s.GetUser(dbUser.ID, ctx)
+}
+
+func (s *Service) HasAdmin(ctx context.Context) (bool, error) {
+	count, err := s.q.CountAdmins(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *Service) CreateUser(firstName string, lastName string, email string, password string, isAdmin bool, ctx context.Context) (User, error) {
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	dbUser, err := s.q.CreateUser(ctx, db.CreateUserParams{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     email,
+		Hash:      hash,
+	})
+	if err != nil {
+		if utils.IsUniqueConstraintError(err) {
+			return User{}, ErrEmailInUse
+		}
+		return User{}, err
+	}
+
+	if isAdmin {
+		err = s.q.MakeUserAdmin(ctx, dbUser.ID)
+		if err != nil {
+			return User{}, err
+		}
+	}
+
+	return s.toUser(dbUser, isAdmin), nil
+}
+
+func (s *Service) UpdateUser(userId int64, firstName *string, lastName *string, email *string, ctx context.Context) (User, error) {
+
+	_, err := s.q.UpdateUserPartial(ctx, db.UpdateUserPartialParams{
+		ID:        userId,
+		FirstName: utils.ToSqlNullString(firstName),
+		LastName:  utils.ToSqlNullString(lastName),
+		Email:     utils.ToSqlNullString(email),
+	})
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return User{}, ErrUserNotFound
+		}
+		if utils.IsUniqueConstraintError(err) {
+			return User{}, ErrEmailInUse
+		}
+		return User{}, err
+	}
+
+	return s.GetUser(userId, ctx)
+}
+
+// deletes the user, the foreign keys (ON DELETE CASCADE) remove the sessions, the admin row,
+// the linked identities and the comments and submissions of the user with it
+func (s *Service) DeleteUser(userId int64, ctx context.Context) error {
+
+	res, err := s.q.DeleteUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *Service) SetAdmin(userId int64, isAdmin bool, ctx context.Context) (User, error) {
+
+	// check first for ErrUserNotFound, inserting an admin row for an unknown user would only fail on the foreign key
+	_, err := s.GetUser(userId, ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	if isAdmin {
+		err = s.q.MakeUserAdmin(ctx, userId)
+	} else {
+		err = s.q.RemoveUserAdmin(ctx, userId)
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.GetUser(userId, ctx)
+}
+
+// replaces the password of the user with a random one that has to be changed after the next login,
+// all sessions of the user are ended, the temporary password is returned so the admin can hand it over
+func (s *Service) ForcePasswordReset(userId int64, ctx context.Context) (string, error) {
+
+	_, err := s.GetUser(userId, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	password, err := utils.NewRandomPassword()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.SetPassword(userId, password, true, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return password, nil
+}
+
+// reports whether the password is the current password of the user
+func (s *Service) CheckPassword(userId int64, password string, ctx context.Context) bool {
+	row, err := s.q.GetUser(ctx, userId)
+	if err != nil {
+		return false
+	}
+	return utils.CheckPasswordHash(password, row.Hash)
+}
+
+// sets a new password and ends all sessions of the user
+func (s *Service) SetPassword(userId int64, password string, mustChange bool, ctx context.Context) error {
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	var mustChangePassword int64
+	if mustChange {
+		mustChangePassword = 1
+	}
+
+	err = s.q.SetUserPassword(ctx, db.SetUserPasswordParams{
+		ID:                 userId,
+		Hash:               hash,
+		MustChangePassword: mustChangePassword,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.q.InvalidateSessionsOfUser(ctx, userId)
+}
+
+// soft deactivation, the user row stays but the user can't log in and all of their sessions are ended
+func (s *Service) DeactivateUser(userId int64, ctx context.Context) (User, error) {
+
+	res, err := s.q.SetUserDeactivated(ctx, db.SetUserDeactivatedParams{
+		ID:            userId,
+		DeactivatedAt: sql.NullInt64{Int64: time.Now().Unix(), Valid: true},
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+
+	if n == 0 {
+		return User{}, ErrUserNotFound
+	}
+
+	err = s.q.InvalidateSessionsOfUser(ctx, userId)
+	if err != nil {
+		return User{}, err
+	}
+
+	return s.GetUser(userId, ctx)
+}
+
+func (s *Service) ReactivateUser(userId int64, ctx context.Context) (User, error) {
+
+	res, err := s.q.SetUserDeactivated(ctx, db.SetUserDeactivatedParams{
+		ID:            userId,
+		DeactivatedAt: sql.NullInt64{},
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return User{}, err
+	}
+
+	if n == 0 {
+		return User{}, ErrUserNotFound
+	}
+
+	return s.GetUser(userId, ctx)
+}
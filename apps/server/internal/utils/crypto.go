@@ -2,26 +2,135 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// argon2id parameters of newly created hashes (the OWASP recommended minimum),
+// hashes made with different parameters get rehashed on the next login, see NeedsRehash
+var ARGON2_MEMORY uint32 = 19 * 1024 // in KiB
+var ARGON2_TIME uint32 = 2
+var ARGON2_THREADS uint8 = 1
+var ARGON2_KEY_LENGTH uint32 = 32
+var ARGON2_SALT_LENGTH = 16
+
+// hashes are stored in the PHC string format:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
 func HashPassword(pwd string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
-	return string(bytes), err
+	salt := make([]byte, ARGON2_SALT_LENGTH)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(pwd), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, ARGON2_KEY_LENGTH)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, ARGON2_MEMORY, ARGON2_TIME, ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// accepts both argon2id hashes and the bcrypt hashes the app used before,
+// an empty or unknown hash (e.g. users created through OIDC) never matches
 func CheckPasswordHash(pwd string, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd))
-	return err == nil
+	if isBcryptHash(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pwd))
+		return err == nil
+	}
+
+	params, salt, key, ok := parseArgon2Hash(hash)
+	if !ok {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(pwd), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// reports whether the hash was made by bcrypt or with other argon2id parameters than the current ones,
+// such hashes should be replaced after a successful login while the plain password is at hand
+func NeedsRehash(hash string) bool {
+	if isBcryptHash(hash) {
+		return true
+	}
+
+	params, _, key, ok := parseArgon2Hash(hash)
+	if !ok {
+		// nothing to rehash, there is no password
+		return false
+	}
+
+	return params.memory != ARGON2_MEMORY ||
+		params.time != ARGON2_TIME ||
+		params.threads != ARGON2_THREADS ||
+		uint32(len(key)) != ARGON2_KEY_LENGTH
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, bool) {
+	var params argon2Params
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, false
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, false
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return params, nil, nil, false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, false
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, false
+	}
+
+	return params, salt, key, true
 }
 
 func NewSessionToken() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// generates a random password, used for temporary passwords handed out by admins
+func NewRandomPassword() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -10,3 +10,24 @@ func ToSqlNullString(s *string) sql.NullString {
 	}
 	return sql.NullString{String: *s, Valid: true}
 }
+
+func ToSqlNullBool(b *bool) sql.NullBool {
+	if b == nil {
+		return sql.NullBool{Bool: false, Valid: false}
+	}
+	return sql.NullBool{Bool: *b, Valid: true}
+}
+
+func ToSqlNullInt64(i *int64) sql.NullInt64 {
+	if i == nil {
+		return sql.NullInt64{Int64: 0, Valid: false}
+	}
+	return sql.NullInt64{Int64: *i, Valid: true}
+}
+
+func FromSqlNullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
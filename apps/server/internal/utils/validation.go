@@ -0,0 +1,122 @@
+package utils
+
+import (
+	_ "embed"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+//* validation of user input shared by registration, the admin user api and the cli
+
+var MIN_PASSWORD_LENGTH = 8
+var MAX_PASSWORD_LENGTH = 128
+
+var MAX_NAME_LENGTH = 64
+var MAX_EMAIL_LENGTH = 254
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// lowercased passwords that are known from leaks, loaded from the bundled list
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+func parseCommonPasswords(file string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// lowercases and trims the email so that the same address can't be registered twice with different casing
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func ValidateEmail(email string) error {
+	if email == "" {
+		return &ErrBadRequest{"email is required"}
+	}
+
+	if len(email) > MAX_EMAIL_LENGTH {
+		return &ErrBadRequest{fmt.Sprintf("email can be at most %v characters long", MAX_EMAIL_LENGTH)}
+	}
+
+	// ParseAddress also accepts things like "Name <a@b.cz>", only the bare address is allowed
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return &ErrBadRequest{"invalid email address"}
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return &ErrBadRequest{"invalid email address"}
+	}
+
+	return nil
+}
+
+// field is used in the error message, e.g. "first name"
+func ValidateName(name string, field string) error {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return &ErrBadRequest{field + " is required"}
+	}
+
+	if utf8.RuneCountInString(name) > MAX_NAME_LENGTH {
+		return &ErrBadRequest{fmt.Sprintf("%v can be at most %v characters long", field, MAX_NAME_LENGTH)}
+	}
+
+	return nil
+}
+
+// checks the password against the policy: a length limit, enough different characters,
+// not one of the bundled leaked passwords and not derived from the email or names of the user
+// (userInputs, empty strings are ignored)
+func ValidatePassword(password string, userInputs ...string) error {
+	length := utf8.RuneCountInString(password)
+
+	if length < MIN_PASSWORD_LENGTH {
+		return &ErrBadRequest{fmt.Sprintf("password must be at least %v characters long", MIN_PASSWORD_LENGTH)}
+	}
+
+	if length > MAX_PASSWORD_LENGTH {
+		return &ErrBadRequest{fmt.Sprintf("password can be at most %v characters long", MAX_PASSWORD_LENGTH)}
+	}
+
+	distinct := make(map[rune]struct{})
+	for _, r := range password {
+		distinct[r] = struct{}{}
+	}
+	if len(distinct) < 4 {
+		return &ErrBadRequest{"password is too simple, use more different characters"}
+	}
+
+	lower := strings.ToLower(password)
+
+	if _, ok := commonPasswords[lower]; ok {
+		return &ErrBadRequest{"password is too common, it appears in lists of leaked passwords"}
+	}
+
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+
+		// the part before @ is what people tend to reuse
+		if at := strings.Index(input, "@"); at > 0 {
+			input = input[:at]
+		}
+
+		if len(input) >= 3 && strings.Contains(lower, input) {
+			return &ErrBadRequest{"password can't contain your name or email"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/feeds"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/utils"
+)
+
+//* sending of the deliveries - the body is built when the event is queued, so all attempts send the same body,
+// every attempt is signed anew:
+//  X-Webhook-Id        - uuid of the delivery
+//  X-Webhook-Event     - name of the event
+//  X-Webhook-Timestamp - unix time of the attempt
+//  X-Webhook-Signature - sha256=<hex of HMAC-SHA256 of "<timestamp>.<body>" with the secret of the webhook>
+
+// deliveryBody builds the body of the delivery in the format of the webhook
+func deliveryBody(format string, payload Payload, courseName string) ([]byte, error) {
+	switch format {
+	case FORMAT_DISCORD:
+		return json.Marshal(map[string]string{"content": shorten(eventText(payload, courseName), DISCORD_MESSAGE_LENGTH)})
+	case FORMAT_SLACK:
+		return json.Marshal(map[string]string{"text": eventText(payload, courseName)})
+	}
+	return json.Marshal(payload)
+}
+
+// the event as a message for the chat apps
+func eventText(payload Payload, courseName string) string {
+	switch data := payload.Data.(type) {
+	case feeds.FeedPostResponse:
+		if payload.Event == feeds.EVENT_POST_UPDATED {
+			return "Post updated in " + courseName + ":\n" + data.Message
+		}
+		return "New post in " + courseName + ":\n" + data.Message
+	case feeds.PostDeletedEvent:
+		return "A post was deleted in " + courseName
+	case feeds.CourseChangedEvent:
+		return courseName + " changed: " + data.Message
+	case feeds.QuizSubmittedEvent:
+		return fmt.Sprintf("Quiz submitted in %v with score %v/%v", courseName, data.Score, data.MaxScore)
+	case feeds.CommentResponse:
+		if payload.Event == feeds.EVENT_COMMENT_UPDATED {
+			if data.Hidden {
+				return "A comment was hidden in " + courseName
+			}
+			return "A comment was shown again in " + courseName
+		}
+		return data.Author.FirstName + " " + data.Author.LastName + " commented in " + courseName + ":\n" + data.Message
+	case feeds.CommentDeletedEvent:
+		return "A comment was deleted in " + courseName
+	case feeds.ReactionsChangedEvent:
+		return "Reactions to a post changed in " + courseName
+	case PingEvent:
+		return "Test event from " + courseName
+	}
+	return payload.Event + " in " + courseName
+}
+
+func shorten(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[:length-1]) + "…"
+}
+
+func sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// how long a claimed delivery is left alone by the other dispatchers
+func deliveryLease() time.Duration {
+	return 2 * WEBHOOK_TIMEOUT
+}
+
+// delay after the given number of failed attempts
+func retryDelay(attempts int64) time.Duration {
+	return WEBHOOK_RETRY_DELAY << (attempts - 1)
+}
+
+// attempt sends the delivery once and records the result, when the context is cancelled (the server shuts down)
+// nothing is recorded and the delivery is sent again once its lease runs out
+func (s *Service) attempt(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery) (db.WebhookDelivery, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	result := db.FinishWebhookDeliveryAttemptParams{Uuid: delivery.Uuid}
+
+	start := time.Now()
+	res, err := s.send(ctx, webhook, delivery, body, timestamp)
+	result.DurationMs = sql.NullInt64{Int64: time.Since(start).Milliseconds(), Valid: true}
+
+	if ctx.Err() != nil {
+		return delivery, ctx.Err()
+	}
+
+	retry := true
+	if err != nil {
+		result.Error = sql.NullString{String: err.Error(), Valid: true}
+		// the url leads to a private address, sending it again won't change that
+		retry = !errors.Is(err, linkpreview.ErrBlockedAddress)
+	} else {
+		result.ResponseStatus = sql.NullInt64{Int64: int64(res.status), Valid: true}
+		result.ResponseBody = sql.NullString{String: res.body, Valid: res.body != ""}
+
+		if res.status < 200 || res.status > 299 {
+			result.Error = sql.NullString{String: fmt.Sprintf("endpoint responded with %v", res.status), Valid: true}
+			// the other client errors won't go away by sending the same body again
+			retry = res.status >= 500 || res.status == http.StatusTooManyRequests || res.status == http.StatusRequestTimeout
+		}
+	}
+
+	attempts := delivery.Attempts + 1
+	now := time.Now()
+
+	switch {
+	case !result.Error.Valid:
+		result.Status = STATUS_SUCCEEDED
+	case retry && attempts < int64(WEBHOOK_MAX_ATTEMPTS):
+		result.Status = STATUS_PENDING
+		result.NextAttemptAt = sql.NullInt64{Int64: now.Add(retryDelay(attempts)).Unix(), Valid: true}
+	default:
+		result.Status = STATUS_FAILED
+	}
+	result.UpdatedAt = now.Unix()
+
+	return s.q.FinishWebhookDeliveryAttempt(context.Background(), result)
+}
+
+type deliveryResult struct {
+	status int
+	body   string
+}
+
+func (s *Service) send(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery, body []byte, timestamp string) (deliveryResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return deliveryResult{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TdA-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Id", delivery.Uuid)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", sign(webhook.Secret, timestamp, body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return deliveryResult{}, err
+	}
+	defer res.Body.Close()
+
+	// only the start is kept, the rest is read so that the connection can be reused
+	start, _ := io.ReadAll(io.LimitReader(res.Body, int64(WEBHOOK_RESPONSE_BODY_LIMIT)))
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+
+	return deliveryResult{status: res.StatusCode, body: string(bytes.ToValidUTF8(start, []byte("�")))}, nil
+}
+
+// sendDueDeliveries claims the due deliveries in batches and sends each batch at once
+func (s *Service) sendDueDeliveries(ctx context.Context) {
+	for ctx.Err() == nil {
+		now := time.Now()
+
+		deliveries, err := s.q.ClaimDueWebhookDeliveries(ctx, db.ClaimDueWebhookDeliveriesParams{
+			LeaseUntil: now.Add(deliveryLease()).Unix(),
+			Now:        now.Unix(),
+			MaxCount:   int64(DISPATCHER_BATCH_SIZE),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("failed to claim webhook deliveries:", err)
+			}
+			return
+		}
+
+		done := make(chan struct{}, len(deliveries))
+		for _, delivery := range deliveries {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				s.sendDelivery(ctx, delivery)
+			}()
+		}
+		for range deliveries {
+			<-done
+		}
+
+		if len(deliveries) < DISPATCHER_BATCH_SIZE {
+			return
+		}
+	}
+}
+
+func (s *Service) sendDelivery(ctx context.Context, delivery db.WebhookDelivery) {
+	webhook, err := s.q.GetWebhook(ctx, delivery.WebhookUuid)
+	if err != nil {
+		// the webhook was deleted with its deliveries in the meantime
+		if !utils.IsNoRowsError(err) {
+			fmt.Println("failed to get webhook", delivery.WebhookUuid, err)
+		}
+		return
+	}
+
+	if webhook.Active != 1 && delivery.Event != EVENT_PING {
+		err = s.q.CancelWebhookDelivery(ctx, db.CancelWebhookDeliveryParams{
+			Error:     sql.NullString{String: "webhook was deactivated", Valid: true},
+			UpdatedAt: time.Now().Unix(),
+			Uuid:      delivery.Uuid,
+		})
+		if err != nil {
+			fmt.Println("failed to cancel delivery", delivery.Uuid, err)
+		}
+		return
+	}
+
+	_, err = s.attempt(ctx, webhook, delivery)
+	if err != nil && ctx.Err() == nil {
+		fmt.Println("failed to record delivery", delivery.Uuid, err)
+	}
+}
+
+func (s *Service) removeOldDeliveries(ctx context.Context) {
+	err := s.q.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-WEBHOOK_LOG_RETENTION).Unix())
+	if err != nil && ctx.Err() == nil {
+		fmt.Println("failed to remove old webhook deliveries:", err)
+	}
+}
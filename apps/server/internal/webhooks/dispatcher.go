@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+)
+
+//* the dispatcher sends the queued deliveries in the background, it's woken up by new events
+// and looks for retries that became due every DISPATCHER_INTERVAL,
+// with several instances of the server the deliveries are claimed in the db so each is sent by one of them
+
+var DISPATCHER_INTERVAL = 5 * time.Second
+
+// how many deliveries are sent at once
+var DISPATCHER_BATCH_SIZE = 20
+
+// how often the finished deliveries older than WEBHOOK_LOG_RETENTION are removed
+var DISPATCHER_CLEANUP_INTERVAL = time.Hour
+
+type Dispatcher struct {
+	service *Service
+
+	started bool
+	stop    context.CancelFunc
+	done    chan struct{}
+}
+
+func NewDispatcher(service *Service) *Dispatcher {
+	return &Dispatcher{
+		service: service,
+		done:    make(chan struct{}),
+	}
+}
+
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.started = true
+	d.stop = cancel
+	go d.run(ctx)
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(DISPATCHER_INTERVAL)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		// deliveries that became due while the server was down are sent right after the start
+		d.service.sendDueDeliveries(ctx)
+
+		if time.Since(lastCleanup) > DISPATCHER_CLEANUP_INTERVAL {
+			d.service.removeOldDeliveries(ctx)
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.service.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the dispatcher, the deliveries being sent are cancelled and sent again after the next start
+func (d *Dispatcher) Close() error {
+	if !d.started {
+		return nil
+	}
+
+	d.stop()
+	<-d.done
+	return nil
+}
@@ -0,0 +1,10 @@
+package webhooks
+
+import "errors"
+
+var (
+	ErrCourseNotFound  = errors.New("course not found")
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrBadUrl          = errors.New("url must be an absolute http or https url")
+	ErrNoEvents        = errors.New("webhook needs at least one event")
+)
@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/handlers"
+	"tourbackend/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+//* admin only endpoints for the webhooks of a course and their delivery log
+
+type Handler struct {
+	*handlers.Handler
+	service *Service
+}
+
+func NewHandler(service *Service, queries *db.Queries, isDeployed bool) *Handler {
+	return &Handler{
+		handlers.NewHandler(queries, isDeployed),
+		service,
+	}
+}
+
+// translates the signal errors of the service to responses
+func (h *Handler) serviceError(r *handlers.RequestCtx, err error) error {
+	switch err {
+	case ErrCourseNotFound, ErrWebhookNotFound:
+		return r.Error(http.StatusNotFound, err.Error())
+	case ErrBadUrl, ErrNoEvents:
+		return r.Error(http.StatusBadRequest, err.Error())
+	}
+
+	var ebr *utils.ErrBadRequest
+	if errors.As(err, &ebr) {
+		return r.Error(http.StatusBadRequest, ebr.Error())
+	}
+
+	return r.ServerError(err)
+}
+
+type WebhookRequest struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Format string   `json:"format"` // json (default), discord or slack
+	Active *bool    `json:"active"`
+}
+
+func (req WebhookRequest) input() WebhookInput {
+	return WebhookInput{Url: req.Url, Events: req.Events, Format: req.Format, Active: req.Active}
+}
+
+// GET /courses/{courseId}/webhooks
+func (h *Handler) ListWebhooks(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	webhooks, err := h.service.ListWebhooks(r.Ctx, c.Param("courseId"))
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+// POST /courses/{courseId}/webhooks
+func (h *Handler) CreateWebhook(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	webhook, err := h.service.CreateWebhook(r.Ctx, c.Param("courseId"), req.input())
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// PUT /courses/{courseId}/webhooks/{webhookId}
+func (h *Handler) UpdateWebhook(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req WebhookRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid request body")
+	}
+
+	webhook, err := h.service.UpdateWebhook(r.Ctx, c.Param("courseId"), c.Param("webhookId"), req.input())
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// POST /courses/{courseId}/webhooks/{webhookId}/secret
+func (h *Handler) RotateSecret(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	webhook, err := h.service.RotateSecret(r.Ctx, c.Param("courseId"), c.Param("webhookId"))
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, webhook)
+}
+
+// DELETE /courses/{courseId}/webhooks/{webhookId}
+func (h *Handler) DeleteWebhook(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	err := h.service.DeleteWebhook(r.Ctx, c.Param("courseId"), c.Param("webhookId"))
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type ListDeliveriesRequest struct {
+	Limit int `query:"limit"`
+}
+
+// GET /courses/{courseId}/webhooks/{webhookId}/deliveries?limit=
+func (h *Handler) ListDeliveries(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	var req ListDeliveriesRequest
+	if err := c.Bind(&req); err != nil {
+		return r.Error(http.StatusBadRequest, "invalid query parameters")
+	}
+
+	deliveries, err := h.service.ListDeliveries(r.Ctx, c.Param("courseId"), c.Param("webhookId"), req.Limit)
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// POST /courses/{courseId}/webhooks/{webhookId}/test
+// the delivery is returned with the result of the first attempt
+func (h *Handler) SendTestEvent(c echo.Context) error {
+	r := h.NewReqCtx(c)
+
+	delivery, err := h.service.SendTestEvent(r.Ctx, c.Param("courseId"), c.Param("webhookId"))
+	if err != nil {
+		return h.serviceError(r, err)
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
@@ -0,0 +1,546 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	db "tourbackend/internal/database/gen"
+	"tourbackend/internal/feeds"
+	"tourbackend/internal/linkpreview"
+	"tourbackend/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+//* outgoing webhooks of a course - the lecturer picks the feed events that are sent to the url,
+// every event becomes a delivery in the db which the dispatcher sends (and retries) in the background,
+// the deliveries are kept as the delivery log of the webhook
+
+// formats of the body, json is the signed Payload, the others are messages for chat apps
+const (
+	FORMAT_JSON    = "json"
+	FORMAT_DISCORD = "discord"
+	FORMAT_SLACK   = "slack" // Microsoft Teams incoming webhooks take the same body
+)
+
+var FORMATS = []string{FORMAT_JSON, FORMAT_DISCORD, FORMAT_SLACK}
+
+// the event sent by the test endpoint
+const EVENT_PING = "ping"
+
+// events a webhook can subscribe to, presence and resync only make sense for the connected clients
+var EVENTS = []string{
+	feeds.EVENT_POST_CREATED,
+	feeds.EVENT_POST_UPDATED,
+	feeds.EVENT_POST_DELETED,
+	feeds.EVENT_COURSE_CHANGED,
+	feeds.EVENT_QUIZ_SUBMITTED,
+	feeds.EVENT_COMMENT_CREATED,
+	feeds.EVENT_COMMENT_UPDATED,
+	feeds.EVENT_COMMENT_DELETED,
+	feeds.EVENT_REACTIONS_CHANGED,
+}
+
+const (
+	STATUS_PENDING   = "pending"
+	STATUS_SUCCEEDED = "succeeded"
+	STATUS_FAILED    = "failed"
+)
+
+var MAX_WEBHOOKS_PER_COURSE = 10
+var MAX_URL_LENGTH = 2048
+
+// how long one delivery attempt can take
+var WEBHOOK_TIMEOUT = 10 * time.Second
+
+// a delivery is tried this many times, the delay before the next attempt doubles each time
+var WEBHOOK_MAX_ATTEMPTS = 6
+var WEBHOOK_RETRY_DELAY = 30 * time.Second
+
+// finished deliveries are removed from the log after this time
+var WEBHOOK_LOG_RETENTION = 7 * 24 * time.Hour
+
+// lets the deliveries go to private and local addresses, only for testing with a local server
+var ALLOW_PRIVATE_ADDRESSES = false
+
+// how much of the response body is kept in the log
+var WEBHOOK_RESPONSE_BODY_LIMIT = 1024
+
+// discord refuses longer messages
+var DISCORD_MESSAGE_LENGTH = 2000
+
+// default and max number of deliveries in the log
+var DELIVERY_LOG_SIZE = 50
+var MAX_DELIVERY_LOG_SIZE = 200
+
+type Service struct {
+	q      *db.Queries
+	client *http.Client
+
+	// wakes up the dispatcher when a new delivery is queued
+	wake chan struct{}
+}
+
+func NewService(queries *db.Queries) *Service {
+	// the same address check as the link previews, the response of the url is shown in the delivery log
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, conn syscall.RawConn) error {
+			if ALLOW_PRIVATE_ADDRESSES {
+				return nil
+			}
+			return linkpreview.CheckAddress(network, address, conn)
+		},
+	}
+
+	return &Service{
+		q: queries,
+		client: &http.Client{
+			Timeout: WEBHOOK_TIMEOUT,
+			Transport: &http.Transport{
+				// no proxy from the environment, it would connect to the addresses instead of the dialer
+				Proxy:                 nil,
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   5 * time.Second,
+				ResponseHeaderTimeout: WEBHOOK_TIMEOUT,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       30 * time.Second,
+			},
+			// a redirect is an unsuccessful delivery, the url of the webhook should be fixed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+type WebhookResponse struct {
+	UUID      string   `json:"uuid"`
+	Url       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"` // only in the responses of create and rotate
+	Events    []string `json:"events"`
+	Format    string   `json:"format"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"createdAt"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+func dbWebhookToWebhook(webhook db.Webhook) WebhookResponse {
+	return WebhookResponse{
+		UUID:      webhook.Uuid,
+		Url:       webhook.Url,
+		Events:    webhookEvents(webhook),
+		Format:    webhook.Format,
+		Active:    webhook.Active == 1,
+		CreatedAt: utils.UnixToIso(webhook.CreatedAt),
+		UpdatedAt: utils.UnixToIso(webhook.UpdatedAt),
+	}
+}
+
+func webhookEvents(webhook db.Webhook) []string {
+	return strings.Split(webhook.Events, ",")
+}
+
+type DeliveryResponse struct {
+	UUID          string  `json:"uuid"`
+	Event         string  `json:"event"`
+	Status        string  `json:"status"` // pending, succeeded or failed
+	Attempts      int64   `json:"attempts"`
+	NextAttemptAt *string `json:"nextAttemptAt"` // for pending deliveries
+
+	// of the last attempt
+	ResponseStatus *int64  `json:"responseStatus"`
+	ResponseBody   *string `json:"responseBody"`
+	Error          *string `json:"error"`
+	DurationMs     *int64  `json:"durationMs"`
+
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt string          `json:"createdAt"`
+	UpdatedAt string          `json:"updatedAt"`
+}
+
+func dbDeliveryToDelivery(delivery db.WebhookDelivery) DeliveryResponse {
+	resp := DeliveryResponse{
+		UUID:      delivery.Uuid,
+		Event:     delivery.Event,
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		Payload:   json.RawMessage(delivery.Payload),
+		CreatedAt: utils.UnixToIso(delivery.CreatedAt),
+		UpdatedAt: utils.UnixToIso(delivery.UpdatedAt),
+	}
+
+	if delivery.Status == STATUS_PENDING && delivery.NextAttemptAt.Valid {
+		next := utils.UnixToIso(delivery.NextAttemptAt.Int64)
+		resp.NextAttemptAt = &next
+	}
+	if delivery.ResponseStatus.Valid {
+		resp.ResponseStatus = &delivery.ResponseStatus.Int64
+	}
+	if delivery.ResponseBody.Valid {
+		resp.ResponseBody = &delivery.ResponseBody.String
+	}
+	if delivery.Error.Valid {
+		resp.Error = &delivery.Error.String
+	}
+	if delivery.DurationMs.Valid {
+		resp.DurationMs = &delivery.DurationMs.Int64
+	}
+
+	return resp
+}
+
+// body of the json format, signed with the secret of the webhook
+type Payload struct {
+	ID        string `json:"id"` // uuid of the delivery, the same for all attempts
+	Event     string `json:"event"`
+	CourseID  string `json:"courseId"`
+	CreatedAt string `json:"createdAt"`
+	Data      any    `json:"data"` // the same data the clients of the feed stream get
+}
+
+type PingEvent struct {
+	Message string `json:"message"`
+}
+
+type WebhookInput struct {
+	Url    string
+	Events []string
+	Format string // json when empty
+	Active *bool  // stays the same when nil, new webhooks are active
+}
+
+func (s *Service) ListWebhooks(ctx context.Context, courseID string) ([]WebhookResponse, error) {
+	if _, err := s.getCourse(ctx, courseID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.q.ListWebhooksOfCourse(ctx, courseID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]WebhookResponse, 0, len(rows))
+	for _, row := range rows {
+		webhooks = append(webhooks, dbWebhookToWebhook(row))
+	}
+	return webhooks, nil
+}
+
+func (s *Service) CreateWebhook(ctx context.Context, courseID string, input WebhookInput) (WebhookResponse, error) {
+	if _, err := s.getCourse(ctx, courseID); err != nil {
+		return WebhookResponse{}, err
+	}
+
+	existing, err := s.q.ListWebhooksOfCourse(ctx, courseID)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+	if len(existing) >= MAX_WEBHOOKS_PER_COURSE {
+		return WebhookResponse{}, &utils.ErrBadRequest{Message: fmt.Sprintf("course can have at most %v webhooks", MAX_WEBHOOKS_PER_COURSE)}
+	}
+
+	err = validateWebhook(&input)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	secret, err := utils.NewSessionToken()
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	active := int64(1)
+	if input.Active != nil && !*input.Active {
+		active = 0
+	}
+
+	now := time.Now().Unix()
+	webhook, err := s.q.CreateWebhook(ctx, db.CreateWebhookParams{
+		Uuid:       uuid.New().String(),
+		CourseUuid: courseID,
+		Url:        input.Url,
+		Secret:     secret,
+		Events:     strings.Join(input.Events, ","),
+		Format:     input.Format,
+		Active:     active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	response := dbWebhookToWebhook(webhook)
+	response.Secret = webhook.Secret
+	return response, nil
+}
+
+func (s *Service) UpdateWebhook(ctx context.Context, courseID, webhookID string, input WebhookInput) (WebhookResponse, error) {
+	webhook, err := s.getWebhookOfCourse(ctx, courseID, webhookID)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	err = validateWebhook(&input)
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	active := webhook.Active
+	if input.Active != nil {
+		active = 0
+		if *input.Active {
+			active = 1
+		}
+	}
+
+	webhook, err = s.q.UpdateWebhook(ctx, db.UpdateWebhookParams{
+		Url:       input.Url,
+		Events:    strings.Join(input.Events, ","),
+		Format:    input.Format,
+		Active:    active,
+		UpdatedAt: time.Now().Unix(),
+		Uuid:      webhookID,
+	})
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	return dbWebhookToWebhook(webhook), nil
+}
+
+// RotateSecret replaces the secret, the pending deliveries are signed with the new one
+func (s *Service) RotateSecret(ctx context.Context, courseID, webhookID string) (WebhookResponse, error) {
+	if _, err := s.getWebhookOfCourse(ctx, courseID, webhookID); err != nil {
+		return WebhookResponse{}, err
+	}
+
+	secret, err := utils.NewSessionToken()
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	webhook, err := s.q.SetWebhookSecret(ctx, db.SetWebhookSecretParams{
+		Secret:    secret,
+		UpdatedAt: time.Now().Unix(),
+		Uuid:      webhookID,
+	})
+	if err != nil {
+		return WebhookResponse{}, err
+	}
+
+	response := dbWebhookToWebhook(webhook)
+	response.Secret = webhook.Secret
+	return response, nil
+}
+
+// DeleteWebhook deletes the webhook with its delivery log, the pending deliveries are not sent
+func (s *Service) DeleteWebhook(ctx context.Context, courseID, webhookID string) error {
+	if _, err := s.getWebhookOfCourse(ctx, courseID, webhookID); err != nil {
+		return err
+	}
+
+	return s.q.DeleteWebhook(ctx, webhookID)
+}
+
+// ListDeliveries returns the latest deliveries of the webhook, newest first
+func (s *Service) ListDeliveries(ctx context.Context, courseID, webhookID string, limit int) ([]DeliveryResponse, error) {
+	if _, err := s.getWebhookOfCourse(ctx, courseID, webhookID); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DELIVERY_LOG_SIZE
+	}
+	limit = min(limit, MAX_DELIVERY_LOG_SIZE)
+
+	rows, err := s.q.ListDeliveriesOfWebhook(ctx, db.ListDeliveriesOfWebhookParams{
+		WebhookUuid: webhookID,
+		Limit:       int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]DeliveryResponse, 0, len(rows))
+	for _, row := range rows {
+		deliveries = append(deliveries, dbDeliveryToDelivery(row))
+	}
+	return deliveries, nil
+}
+
+// SendTestEvent sends a ping event right away, also to inactive webhooks, and returns the result of the first attempt,
+// when it fails it's retried like any other delivery
+func (s *Service) SendTestEvent(ctx context.Context, courseID, webhookID string) (DeliveryResponse, error) {
+	webhook, err := s.getWebhookOfCourse(ctx, courseID, webhookID)
+	if err != nil {
+		return DeliveryResponse{}, err
+	}
+
+	course, err := s.getCourse(ctx, courseID)
+	if err != nil {
+		return DeliveryResponse{}, err
+	}
+
+	event := feeds.FeedEvent{Name: EVENT_PING, Data: PingEvent{Message: "Test event of the webhook"}}
+
+	// queued as already claimed so that the dispatcher doesn't send it at the same time
+	delivery, err := s.queueDelivery(ctx, webhook, course, event, time.Now().Add(deliveryLease()))
+	if err != nil {
+		return DeliveryResponse{}, err
+	}
+
+	delivery, err = s.attempt(ctx, webhook, delivery)
+	if err != nil {
+		return DeliveryResponse{}, err
+	}
+
+	return dbDeliveryToDelivery(delivery), nil
+}
+
+// Enqueue is the feeds.EventHook of the webhooks, it queues a delivery for every active webhook of the course
+// subscribed to the event and wakes up the dispatcher
+func (s *Service) Enqueue(courseID string, event feeds.FeedEvent) {
+	if !slices.Contains(EVENTS, event.Name) {
+		return
+	}
+
+	ctx := context.Background()
+
+	webhooks, err := s.q.ListActiveWebhooksOfCourse(ctx, courseID)
+	if err != nil {
+		fmt.Println("failed to list webhooks of course", courseID, err)
+		return
+	}
+
+	var course db.Course
+	queued := false
+
+	for _, webhook := range webhooks {
+		if !slices.Contains(webhookEvents(webhook), event.Name) {
+			continue
+		}
+
+		if course.Uuid == "" {
+			course, err = s.getCourse(ctx, courseID)
+			if err != nil {
+				fmt.Println("failed to get course of webhook", webhook.Uuid, err)
+				return
+			}
+		}
+
+		_, err = s.queueDelivery(ctx, webhook, course, event, time.Now())
+		if err != nil {
+			fmt.Println("failed to queue delivery of webhook", webhook.Uuid, err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Service) queueDelivery(ctx context.Context, webhook db.Webhook, course db.Course, event feeds.FeedEvent, sendAt time.Time) (db.WebhookDelivery, error) {
+	deliveryID := uuid.New().String()
+	now := time.Now().Unix()
+
+	body, err := deliveryBody(webhook.Format, Payload{
+		ID:        deliveryID,
+		Event:     event.Name,
+		CourseID:  course.Uuid,
+		CreatedAt: utils.UnixToIso(now),
+		Data:      event.Data,
+	}, course.Name)
+	if err != nil {
+		return db.WebhookDelivery{}, err
+	}
+
+	return s.q.CreateWebhookDelivery(ctx, db.CreateWebhookDeliveryParams{
+		Uuid:          deliveryID,
+		WebhookUuid:   webhook.Uuid,
+		Event:         event.Name,
+		Payload:       string(body),
+		NextAttemptAt: sql.NullInt64{Int64: sendAt.Unix(), Valid: true},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+}
+
+func (s *Service) getCourse(ctx context.Context, courseID string) (db.Course, error) {
+	course, err := s.q.GetCourse(ctx, courseID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.Course{}, ErrCourseNotFound
+		}
+		return db.Course{}, err
+	}
+	return course, nil
+}
+
+func (s *Service) getWebhookOfCourse(ctx context.Context, courseID, webhookID string) (db.Webhook, error) {
+	webhook, err := s.q.GetWebhook(ctx, webhookID)
+	if err != nil {
+		if utils.IsNoRowsError(err) {
+			return db.Webhook{}, ErrWebhookNotFound
+		}
+		return db.Webhook{}, err
+	}
+
+	if webhook.CourseUuid != courseID {
+		return db.Webhook{}, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// normalizes the input, the events are deduplicated and the format defaults to json
+func validateWebhook(input *WebhookInput) error {
+	input.Url = strings.TrimSpace(input.Url)
+	if len(input.Url) > MAX_URL_LENGTH {
+		return &utils.ErrBadRequest{Message: fmt.Sprintf("url can have at most %v characters", MAX_URL_LENGTH)}
+	}
+
+	u, err := url.Parse(input.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrBadUrl
+	}
+
+	if len(input.Events) == 0 {
+		return ErrNoEvents
+	}
+
+	events := make([]string, 0, len(input.Events))
+	for _, event := range input.Events {
+		if !slices.Contains(EVENTS, event) {
+			return &utils.ErrBadRequest{Message: "unknown event " + event + ", events are " + strings.Join(EVENTS, ", ")}
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	input.Events = events
+
+	if input.Format == "" {
+		input.Format = FORMAT_JSON
+	}
+	if !slices.Contains(FORMATS, input.Format) {
+		return &utils.ErrBadRequest{Message: "format must be one of " + strings.Join(FORMATS, ", ")}
+	}
+
+	return nil
+}
@@ -4,3 +4,9 @@ export function formatTime(iso: string) {
 	const formatted = date.toLocaleString();
 	return formatted;
 }
+
+export function formatSize(bytes: number) {
+	if (bytes < 1024) return `${bytes} B`;
+	if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} kB`;
+	return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+}
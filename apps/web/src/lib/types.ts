@@ -17,11 +17,20 @@ export interface Course {
 	quizzes: Quiz[];
 
 	feed: FeedPost[];
+	feedNextCursor?: string;
 
 	modules: FullModule[];
 
 	highlightedModuleId: string | null;
 	highlightedModuleMessage: string | null;
+
+	schedule?: Schedule | null;
+}
+
+// state the course or module changes to at the given time
+export interface Schedule {
+	state: 'preparation' | 'closed' | 'open';
+	at: string;
 }
 
 // Materials
@@ -30,7 +39,7 @@ export interface BaseMaterial {
 	uuid: string;
 	name: string;
 	description: string;
-	type: 'file' | 'url';
+	type: 'file' | 'url' | 'video';
 
 	timesAccessed: number;
 
@@ -43,15 +52,68 @@ export interface FileMaterial extends BaseMaterial {
 	fileUrl: string;
 	mimeType: string;
 	sizeBytes: number;
+
+	// generated by the server, null when the file has no picture or text
+	thumbnailUrl: string | null;
+	previewText: string | null;
 }
 
 export interface UrlMaterial extends BaseMaterial {
 	type: 'url';
 	url: string;
 	faviconUrl: string;
+
+	// read from the page by the server, null until it is fetched or when the page doesn't have them
+	pageTitle: string | null;
+	pageDescription: string | null;
+	imageUrl: string | null;
+	previewFetchedAt: string | null;
+}
+
+export interface VideoMaterial extends BaseMaterial {
+	type: 'video';
+	url: string;
+	provider: 'youtube' | 'vimeo' | 'mp4';
+	videoId: string | null;
+
+	// src of the iframe for youtube and vimeo, of the video element for mp4
+	embedUrl: string;
+	thumbnailUrl: string | null;
+	durationSeconds: number | null;
+	pageTitle: string | null;
 }
 
-export type Material = FileMaterial | UrlMaterial;
+export type Material = FileMaterial | UrlMaterial | VideoMaterial;
+
+// where the user stopped in a video, updatedAt is null when they haven't started it
+export interface VideoProgress {
+	materialId: string;
+	positionSeconds: number;
+	furthestSeconds: number;
+	durationSeconds: number | null;
+	completed: boolean;
+	updatedAt: string | null;
+}
+
+// result of one file of a zip import
+export interface ImportedFile {
+	file: string;
+	material?: FileMaterial;
+	error?: string;
+}
+
+export interface MaterialVersion {
+	uuid: string;
+	version: number;
+	current: boolean;
+
+	fileUrl: string;
+	mimeType: string;
+	sizeBytes: number;
+
+	createdBy: { id: number; firstName: string; lastName: string } | null;
+	createdAt: string;
+}
 
 // Quizzes
 
@@ -80,6 +142,9 @@ export interface Quiz {
 	attemptsCount: number;
 	questions: Question[];
 
+	// shown with the quiz and in the calendar of the course
+	deadline?: string | null;
+
 	moduleId: string;
 	moduleOrder: number;
 }
@@ -108,11 +173,70 @@ export interface QuizMarked {
 
 export interface FeedPost {
 	uuid: string;
-	type: 'manual' | 'system' | 'info';
+	type: 'manual' | 'system';
 	message: string;
 	edited: boolean;
+	pinned: boolean;
 	createdAt: string;
 	updatedAt: string;
+
+	// scheduled posts (published false) are only listed for lecturers
+	publishAt: string;
+	published: boolean;
+	expiresAt: string | null;
+
+	// students can't comment on locked posts
+	locked: boolean;
+	commentCount: number;
+	reactions: Record<string, number>;
+	myReactions?: string[];
+
+	attachments: FeedAttachment[];
+}
+
+// an uploaded file or a link to a material or a quiz of the course
+export interface FeedAttachment {
+	uuid: string;
+	type: 'file' | 'material' | 'quiz';
+	name: string;
+	url?: string;
+
+	mimeType?: string;
+	sizeBytes?: number;
+
+	materialUuid?: string;
+	materialType?: 'file' | 'url' | 'video';
+	quizUuid?: string;
+}
+
+export const REACTION_EMOJIS = ['👍', '❤️', '😂', '😮', '😢', '🎉'];
+
+// replies have parentUuid, hidden comments come without the message for students
+export interface FeedComment {
+	uuid: string;
+	postUuid: string;
+	parentUuid: string | null;
+	author: { id: number; firstName: string; lastName: string };
+	message: string;
+	hidden: boolean;
+	createdAt: string;
+}
+
+// payload of the comment_deleted event, the replies of the comment are deleted too
+export interface FeedCommentDeleted {
+	uuid: string;
+	postUuid: string;
+}
+
+// payload of the reactions_changed event
+export interface FeedReactionsChanged {
+	postUuid: string;
+	reactions: Record<string, number>;
+}
+
+// payload of the post_deleted event of the feed stream
+export interface FeedPostDeleted {
+	uuid: string;
 }
 
 export interface QuizOutcome {
@@ -158,6 +282,8 @@ export interface Module {
 
 	order: number;
 
+	schedule?: Schedule | null;
+
 	createdAt: string;
 	updatedAt: string;
 }
@@ -167,3 +293,45 @@ export interface FullModule extends Module {
 	items: (Quiz | Material | Heading)[];
 	newItemOrder: number;
 }
+
+// Webhooks
+
+export type WebhookEvent =
+	| 'post_created'
+	| 'post_updated'
+	| 'post_deleted'
+	| 'course_changed'
+	| 'quiz_submitted'
+	| 'comment_created'
+	| 'comment_updated'
+	| 'comment_deleted'
+	| 'reactions_changed';
+
+export interface Webhook {
+	uuid: string;
+	url: string;
+	secret?: string; // key of the X-Webhook-Signature HMAC, only returned by create and rotate
+	events: WebhookEvent[];
+	format: 'json' | 'discord' | 'slack';
+	active: boolean;
+
+	createdAt: string;
+	updatedAt: string;
+}
+
+export interface WebhookDelivery {
+	uuid: string;
+	event: WebhookEvent | 'ping';
+	status: 'pending' | 'succeeded' | 'failed';
+	attempts: number;
+	nextAttemptAt: string | null;
+
+	responseStatus: number | null;
+	responseBody: string | null;
+	error: string | null;
+	durationMs: number | null;
+
+	payload: unknown;
+	createdAt: string;
+	updatedAt: string;
+}
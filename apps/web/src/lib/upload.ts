@@ -0,0 +1,102 @@
+// resumable upload of big material files over the tus protocol of the server,
+// the url of an unfinished upload is remembered so that it continues after a reload or a lost connection
+
+// bigger files don't fit into one request
+export const SINGLE_REQUEST_LIMIT = 30 * 1024 * 1024;
+
+const CHUNK_SIZE = 8 * 1024 * 1024;
+const RETRIES = 5;
+
+const TUS_HEADERS = { 'Tus-Resumable': '1.0.0' };
+
+function storageKey(courseUuid: string, file: File) {
+	return `upload:${courseUuid}:${file.name}:${file.size}:${file.lastModified}`;
+}
+
+function base64(buffer: ArrayBuffer) {
+	return btoa(String.fromCharCode(...new Uint8Array(buffer)));
+}
+
+async function errorOf(res: Response) {
+	const data = await res.json().catch(() => ({}));
+	return data.message || 'Upload failed';
+}
+
+async function createUpload(courseUuid: string, file: File) {
+	const metadata = `filename ${btoa(unescape(encodeURIComponent(file.name)))}`;
+
+	const res = await fetch(`/api/courses/${courseUuid}/uploads`, {
+		method: 'POST',
+		headers: { ...TUS_HEADERS, 'Upload-Length': String(file.size), 'Upload-Metadata': metadata }
+	});
+	if (!res.ok) throw new Error(await errorOf(res));
+
+	return new URL(res.headers.get('Location')!, res.url).pathname;
+}
+
+// offset of the remembered upload, null when it's gone
+async function uploadOffset(url: string) {
+	const res = await fetch(url, { method: 'HEAD', headers: TUS_HEADERS });
+	if (!res.ok) return null;
+	return Number(res.headers.get('Upload-Offset'));
+}
+
+// uploads the file in chunks and returns the id of the finished upload,
+// the material is then created or updated with it instead of the file
+export async function resumableUpload(
+	courseUuid: string,
+	file: File,
+	onprogress: (percent: number) => void
+): Promise<string> {
+	const key = storageKey(courseUuid, file);
+
+	let url = localStorage.getItem(key);
+	let offset = url ? await uploadOffset(url) : null;
+
+	if (url === null || offset === null) {
+		url = await createUpload(courseUuid, file);
+		offset = 0;
+		localStorage.setItem(key, url);
+	}
+
+	let failures = 0;
+	while (offset < file.size) {
+		onprogress(Math.floor((offset / file.size) * 100));
+
+		const chunk = await file.slice(offset, offset + CHUNK_SIZE).arrayBuffer();
+		const digest = await crypto.subtle.digest('SHA-256', chunk);
+
+		const res = await fetch(url, {
+			method: 'PATCH',
+			headers: {
+				...TUS_HEADERS,
+				'Content-Type': 'application/offset+octet-stream',
+				'Upload-Offset': String(offset),
+				'Upload-Checksum': `sha256 ${base64(digest)}`
+			},
+			body: chunk
+		}).catch(() => null);
+
+		if (res?.ok) {
+			offset = Number(res.headers.get('Upload-Offset'));
+			failures = 0;
+			continue;
+		}
+
+		// a rejected file won't get better by sending it again
+		if (res && res.status >= 400 && res.status < 500 && res.status !== 409 && res.status !== 423) {
+			localStorage.removeItem(key);
+			throw new Error(await errorOf(res));
+		}
+
+		failures++;
+		if (failures > RETRIES) throw new Error('Upload failed, try it again to continue');
+
+		await new Promise((resolve) => setTimeout(resolve, 1000 * failures));
+		offset = (await uploadOffset(url)) ?? offset;
+	}
+
+	onprogress(100);
+	localStorage.removeItem(key);
+	return url.split('/').pop()!;
+}